@@ -4,44 +4,204 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"embed"
-	"encoding/base64"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"nm-webui/internal/access"
+	"nm-webui/internal/auth"
+	"nm-webui/internal/collect"
+	"nm-webui/internal/listen"
 	"nm-webui/internal/server"
+	"nm-webui/internal/tlscert"
 )
 
 //go:embed all:static
 var staticFS embed.FS
 
+// Services whose logs explain network and tunnel failures
+const (
+	defaultJournalUnits = "NetworkManager=networkmanager,openvpn*=openvpn,haxinator-bt-pan=bluetooth,haxinator-bt-agent=bluetooth,haxinator-usb-gadget=usb,haxinator-usb0-network=usb"
+	defaultLogFiles     = "/tmp/hans-plugin.log=hans,/tmp/nm-iodine-debug.log=iodine"
+)
+
 func main() {
 	// Parse command line flags
-	listen := flag.String("listen", "127.0.0.1:8080", "Address to listen on")
-	authFile := flag.String("auth-file", "", "Path to auth credentials file (user:pass)")
+	listenAddrs := flag.String("listen", "127.0.0.1:8080", "Comma-separated addresses, interface:port, unix:/path or systemd to listen on (e.g. 127.0.0.1:8080,usb0:8080)")
+	socketMode := flag.String("socket-mode", "0660", "File mode of Unix sockets")
+	socketGroup := flag.String("socket-group", "", "Group of Unix sockets")
+	socketRoles := flag.String("socket-roles", "root=admin", "Comma-separated user=role and @group=role for local users on Unix sockets")
+	accessPolicy := flag.String("access-policy", "", "JSON file of which API areas each interface may reach")
+	authFile := flag.String("auth-file", "", "Path to the users file (name:role:bcrypt-hash per line)")
+	useTLS := flag.Bool("tls", false, "Serve HTTPS with a generated or uploaded certificate")
+	tlsNames := flag.String("tls-names", "", "Extra comma-separated host names and IPs for the generated certificate")
+	clientAuth := flag.String("tls-client-auth", "off", "Client certificates: off, optional (log in with a certificate), required, or required+password")
+	httpsRedirect := flag.String("https-redirect", "", "Also listen for plain HTTP on these addresses or interfaces and redirect it to HTTPS (e.g. usb0:80)")
 	noAuth := flag.Bool("no-auth", false, "Disable authentication (for testing)")
+	sessionIdle := flag.Duration("session-idle-timeout", 2*time.Hour, "Log out web UI sessions idle this long")
+	sessionMaxAge := flag.Duration("session-max-age", 24*time.Hour, "Log out web UI sessions this long after login")
+	loginFailures := flag.Int("login-max-failures", 5, "Failed logins from one address before it is locked out")
+	loginLockout := flag.Duration("login-lockout", 15*time.Minute, "How long an address is locked out after too many failed logins")
+	totpRoles := flag.String("totp-required-roles", "", "Comma-separated roles that must use two-factor authentication (e.g. admin,operator)")
+	logPersist := flag.Bool("log-persist", false, "Keep the system log on disk as well as in memory")
+	logMaxMB := flag.Int64("log-max-mb", 2, "Size in MB at which the stored system log is rotated")
+	logFiles := flag.Int("log-max-files", 4, "Rotated system log files to keep")
+	logMaxAge := flag.Duration("log-max-age", 30*24*time.Hour, "Delete stored system log entries older than this (0 keeps them)")
+	logFlush := flag.Duration("log-flush", 30*time.Second, "How often stored system log entries are written to disk (0 writes each at once)")
+	logJournal := flag.String("log-journal", defaultJournalUnits, "journald units to show in the system log, as unit=category, comma-separated")
+	logFilesFlag := flag.String("log-files", defaultLogFiles, "Log files of other services to show in the system log, as path=category, comma-separated")
+	syslogCA := flag.String("syslog-ca", "", "PEM file of CAs a TLS syslog collector's certificate must chain to (default: the system's)")
+	syslogName := flag.String("syslog-server-name", "", "Name a TLS syslog collector's certificate must be valid for (default: the host of its address)")
+	auditOn := flag.Bool("audit", true, "Keep an audit log of state-changing requests on disk")
+	auditMaxMB := flag.Int64("audit-max-mb", 1, "Size in MB at which the audit log is rotated")
+	auditFiles := flag.Int("audit-max-files", 4, "Rotated audit log files to keep")
+	termUser := flag.String("terminal-user", "root", "User the web terminal logs in as")
+	termIdle := flag.Duration("terminal-idle-timeout", 30*time.Minute, "Close terminal sessions idle this long (0 disables)")
+	termMax := flag.Int("terminal-max-sessions", 4, "Maximum concurrent terminal sessions")
+	termRecord := flag.Bool("terminal-record", true, "Record terminal sessions in asciicast format")
+	termRecordAge := flag.Duration("terminal-record-max-age", 30*24*time.Hour, "Delete recordings older than this (0 keeps them)")
+	termRecordMB := flag.Int64("terminal-record-max-mb", 256, "Total size of recordings to keep in MB (0 is unlimited)")
 	flag.Parse()
 
+	specs, err := listen.Parse(splitList(*listenAddrs))
+	if err != nil {
+		log.Fatalf("Invalid -listen: %v", err)
+	}
+	sockMode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || sockMode > 0777 {
+		log.Fatalf("Invalid -socket-mode %q", *socketMode)
+	}
+	// Rotating with no files to keep would delete the audit log itself
+	if *auditMaxMB < 1 {
+		log.Fatalf("Invalid -audit-max-mb %d: must be at least 1", *auditMaxMB)
+	}
+	if *auditFiles < 1 {
+		log.Fatalf("Invalid -audit-max-files %d: must be at least 1", *auditFiles)
+	}
+
 	// Load or generate auth credentials
-	cfg := &server.Config{Listen: *listen}
+	cfg := &server.Config{
+		Listen:              specs,
+		TLS:                 *useTLS,
+		TLSNames:            splitList(*tlsNames),
+		SessionIdleTimeout:  *sessionIdle,
+		SessionMaxAge:       *sessionMaxAge,
+		LoginMaxFailures:    *loginFailures,
+		LoginLockout:        *loginLockout,
+		LogPersist:          *logPersist,
+		LogMaxSize:          *logMaxMB << 20,
+		LogMaxFiles:         *logFiles,
+		LogMaxAge:           *logMaxAge,
+		LogFlush:            *logFlush,
+		SyslogCA:            *syslogCA,
+		SyslogServerName:    *syslogName,
+		Audit:               *auditOn,
+		AuditMaxSize:        *auditMaxMB << 20,
+		AuditMaxFiles:       *auditFiles,
+		TerminalUser:        *termUser,
+		TerminalIdleTimeout: *termIdle,
+		TerminalMaxSessions: *termMax,
+		TerminalRecord:      *termRecord,
+		TerminalRecordAge:   *termRecordAge,
+		TerminalRecordSize:  *termRecordMB << 20,
+	}
 
 	if !*noAuth {
-		if err := loadOrGenerateAuth(cfg, *authFile); err != nil {
+		users, err := loadOrGenerateAuth(*authFile)
+		if err != nil {
 			log.Fatalf("Failed to setup authentication: %v", err)
 		}
+		roles, err := parseRoles(*totpRoles)
+		if err != nil {
+			log.Fatalf("Invalid -totp-required-roles: %v", err)
+		}
+		users.RequireTOTP(roles)
+		cfg.Users = users
+
+		// API tokens live next to the auth file; without one they last
+		// until restart
+		tokenFile := ""
+		if *authFile != "" {
+			tokenFile = filepath.Join(filepath.Dir(*authFile), "tokens.json")
+		}
+		tokens, err := auth.LoadTokens(tokenFile)
+		if err != nil {
+			log.Fatalf("Failed to load API tokens: %v", err)
+		}
+		cfg.Tokens = tokens
+
+		peers, err := auth.ParsePeerRoles(splitList(*socketRoles))
+		if err != nil {
+			log.Fatalf("Invalid -socket-roles: %v", err)
+		}
+		cfg.Peers = peers
 	} else {
 		log.Println("WARNING: Authentication disabled!")
 	}
 
+	if *accessPolicy != "" {
+		policy, err := access.Load(*accessPolicy)
+		if err != nil {
+			log.Fatalf("Invalid -access-policy: %v", err)
+		}
+		// The policy goes by the interface a connection arrived on, which
+		// only interface listeners know for sure
+		for _, spec := range specs {
+			if spec.Systemd && spec.AnyInterface() {
+				log.Fatalf("-access-policy cannot be used with %s: systemd passed a TCP socket that accepts connections on any interface; give each socket unit BindToDevice= (see nm-webui-iface@.socket) or listen on a loopback address", spec)
+			}
+			if spec.AnyInterface() {
+				log.Fatalf("-access-policy cannot be used with %s: a client could reach it through any interface's address; listen on interfaces (e.g. usb0:%s) instead", spec, spec.Port)
+			}
+		}
+		cfg.Access = policy
+	}
+
+	if *httpsRedirect != "" && !*useTLS {
+		log.Fatalf("-https-redirect requires -tls")
+	}
+	redirectSpecs, err := listen.Parse(splitList(*httpsRedirect))
+	if *httpsRedirect != "" && err != nil {
+		log.Fatalf("Invalid -https-redirect: %v", err)
+	}
+	// Redirects go to the HTTPS port of the first TCP listener; Unix and
+	// systemd sockets have no port a browser could be sent to
+	var redirectPort string
+	if *httpsRedirect != "" {
+		for _, spec := range specs {
+			if spec.Port != "" {
+				redirectPort = spec.Port
+				break
+			}
+		}
+		if redirectPort == "" {
+			log.Fatalf("-https-redirect requires a TCP -listen address or interface to redirect to")
+		}
+	}
+	mode, err := tlscert.ParseClientAuth(*clientAuth)
+	if err != nil {
+		log.Fatalf("Invalid -tls-client-auth: %v", err)
+	}
+	if mode != tlscert.ClientAuthOff && (!*useTLS || *noAuth) {
+		log.Fatalf("-tls-client-auth requires -tls and authentication")
+	}
+	cfg.TLSClientAuth = mode
+
+	if cfg.LogJournal, err = collect.ParseSources(splitList(*logJournal)); err != nil {
+		log.Fatalf("Invalid -log-journal: %v", err)
+	}
+	if cfg.LogFiles, err = collect.ParseSources(splitList(*logFilesFlag)); err != nil {
+		log.Fatalf("Invalid -log-files: %v", err)
+	}
+
 	// Create server
 	srv, err := server.New(cfg, staticFS)
 	if err != nil {
@@ -50,22 +210,74 @@ func main() {
 
 	// Create HTTP server
 	httpServer := &http.Server{
-		Addr:         cfg.Listen,
 		Handler:      srv.Handler(),
+		TLSConfig:    srv.TLSConfig(),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Log streams only end when their channel does
+	httpServer.RegisterOnShutdown(srv.Logger().EndSubscriptions)
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("Starting nm-webui on http://%s", cfg.Listen)
-		log.Printf("Username: %s", cfg.Username)
-		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		if cfg.Users != nil {
+			log.Printf("Users: %d", len(cfg.Users.List()))
+		}
+		scheme := "http"
+		if cfg.TLS {
+			scheme = "https"
+		}
+		for _, spec := range cfg.Listen {
+			if spec.Unix != "" || spec.Systemd {
+				log.Printf("Starting nm-webui on %s", spec)
+			} else {
+				log.Printf("Starting nm-webui on %s://%s", scheme, spec)
+			}
+		}
+		if cfg.TLS {
+			info := srv.TLSInfo()
+			log.Printf("Certificate (%s) SHA-256: %s", info.Source, info.Fingerprint)
+			if info.CAFingerprint != "" {
+				log.Printf("Device CA SHA-256: %s", info.CAFingerprint)
+			}
+			if mode != tlscert.ClientAuthOff {
+				log.Printf("Client certificates: %s", mode)
+			}
+		}
+		if cfg.Access != nil {
+			log.Printf("Access policy: %s", *accessPolicy)
+		}
+		if seq, head, ok := srv.AuditHead(); ok {
+			log.Printf("Audit log at entry %d, SHA-256 %s", seq, head)
+		}
+		listeners := listen.NewManager(cfg.Listen, srv.Logger())
+		if err := listeners.SetSocketPermissions(os.FileMode(sockMode), *socketGroup); err != nil {
+			log.Fatalf("Invalid -socket-group: %v", err)
+		}
+		if err := listeners.Serve(httpServer); err != http.ErrServerClosed {
 			log.Fatalf("HTTP server error: %v", err)
 		}
 	}()
 
+	// Optional plain HTTP listener that only redirects to HTTPS
+	var redirectServer *http.Server
+	if *httpsRedirect != "" {
+		redirectServer = &http.Server{
+			Handler:      server.RedirectHandler(redirectPort),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			for _, spec := range redirectSpecs {
+				log.Printf("Redirecting http://%s to HTTPS", spec)
+			}
+			if err := listen.NewManager(redirectSpecs, srv.Logger()).Serve(redirectServer); err != http.ErrServerClosed {
+				log.Fatalf("HTTP redirect server error: %v", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -78,57 +290,75 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	if cfg.Tokens != nil {
+		if err := cfg.Tokens.Flush(); err != nil {
+			log.Printf("Failed to save API tokens: %v", err)
+		}
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("Failed to save system log: %v", err)
+	}
 	log.Println("Server stopped")
 }
 
-// loadOrGenerateAuth loads credentials from file/env or generates random ones
-func loadOrGenerateAuth(cfg *server.Config, authFile string) error {
-	// Try environment variables first
+// loadOrGenerateAuth loads users from the auth file and environment, or
+// creates an admin with a random password if there are none
+func loadOrGenerateAuth(authFile string) (*auth.UserStore, error) {
+	users, err := auth.LoadUsers(authFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Environment variables add an admin that is not saved
 	if user := os.Getenv("NM_WEBUI_USER"); user != "" {
-		cfg.Username = user
-		cfg.Password = os.Getenv("NM_WEBUI_PASS")
-		if cfg.Password != "" {
-			return nil
-		}
-	}
-
-	// Try auth file
-	if authFile != "" {
-		data, err := os.ReadFile(authFile)
-		if err == nil {
-			parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
-			if len(parts) == 2 {
-				cfg.Username = parts[0]
-				cfg.Password = parts[1]
-				return nil
+		if pass := os.Getenv("NM_WEBUI_PASS"); pass != "" {
+			if err := users.SetEnvUser(user, pass); err != nil {
+				return nil, err
 			}
-		} else if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to read auth file: %w", err)
 		}
 	}
+	if !users.Empty() {
+		return users, nil
+	}
 
-	// Generate random credentials
-	cfg.Username = "admin"
-	passBytes := make([]byte, 16)
-	if _, err := rand.Read(passBytes); err != nil {
-		return fmt.Errorf("failed to generate password: %w", err)
+	// Generate random credentials. They are only printed when there is no
+	// auth file to keep them in, since such a user lasts until restart.
+	password, err := users.CreateInitialAdmin()
+	if err != nil {
+		return nil, err
+	}
+	if path := users.InitialPasswordFile(); path != "" {
+		log.Printf("Created user admin; the initial password is in %s", path)
+	} else {
+		log.Printf("Created user admin with password: %s", password)
 	}
-	cfg.Password = base64.URLEncoding.EncodeToString(passBytes)[:16]
 
-	log.Printf("Generated random password: %s", cfg.Password)
+	return users, nil
+}
 
-	// Save to auth file if specified
-	if authFile != "" {
-		dir := filepath.Dir(authFile)
-		if err := os.MkdirAll(dir, 0700); err != nil {
-			return fmt.Errorf("failed to create auth directory: %w", err)
+// parseRoles parses a comma-separated list of roles
+func parseRoles(list string) ([]auth.Role, error) {
+	var roles []auth.Role
+	for _, name := range splitList(list) {
+		role, err := auth.ParseRole(name)
+		if err != nil {
+			return nil, err
 		}
-		authData := fmt.Sprintf("%s:%s\n", cfg.Username, cfg.Password)
-		if err := os.WriteFile(authFile, []byte(authData), 0600); err != nil {
-			return fmt.Errorf("failed to write auth file: %w", err)
-		}
-		log.Printf("Saved credentials to %s", authFile)
+		roles = append(roles, role)
 	}
+	return roles, nil
+}
 
-	return nil
+// splitList splits a comma-separated flag value, dropping blanks
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -3,16 +3,32 @@
  */
 const API = {
     baseUrl: '',
-    authHeader: null,
+    csrfToken: null,
+    onUnauthorized: null,
 
     /**
-     * Initialize API with optional auth
+     * Initialize API
      */
-    init(baseUrl = '', username = '', password = '') {
+    init(baseUrl = '') {
         this.baseUrl = baseUrl;
-        if (username && password) {
-            this.authHeader = 'Basic ' + btoa(username + ':' + password);
+    },
+
+    /**
+     * Set the session's CSRF token, sent with every state-changing request
+     */
+    setCSRFToken(token) {
+        this.csrfToken = token || null;
+    },
+
+    /**
+     * Request headers including the CSRF token
+     */
+    headers(extra = {}) {
+        const headers = { ...extra };
+        if (this.csrfToken) {
+            headers['X-CSRF-Token'] = this.csrfToken;
         }
+        return headers;
     },
 
     /**
@@ -22,25 +38,26 @@ const API = {
         const url = this.baseUrl + endpoint;
         const options = {
             method,
-            headers: {
+            headers: this.headers({
                 'Content-Type': 'application/json',
-            }
+            })
         };
 
-        if (this.authHeader) {
-            options.headers['Authorization'] = this.authHeader;
-        }
-
         if (data) {
             options.body = JSON.stringify(data);
         }
 
         try {
             const response = await fetch(url, options);
+            if (response.status === 401 && endpoint !== '/api/auth/login' && this.onUnauthorized) {
+                this.onUnauthorized();
+            }
             const json = await response.json();
             
             if (!response.ok || json.ok === false) {
-                throw new Error(json.error || json.detail || 'Request failed');
+                const error = new Error(json.error || json.detail || 'Request failed');
+                error.detail = json.detail;
+                throw error;
             }
             
             // Return the data payload directly if present
@@ -68,6 +85,150 @@ const API = {
         return this.request('DELETE', endpoint, data);
     },
 
+    // ========== Auth ==========
+    async login(username, password, code = '') {
+        return this.post('/api/auth/login', { username, password, code });
+    },
+
+    async loginWithCertificate(code = '') {
+        return this.post('/api/auth/login', { certificate: true, code });
+    },
+
+    async logout() {
+        return this.post('/api/auth/logout');
+    },
+
+    async getSession() {
+        return this.get('/api/auth/session');
+    },
+
+    async getAuthSessions() {
+        return this.get('/api/auth/sessions');
+    },
+
+    async revokeAuthSession(id) {
+        return this.post('/api/auth/sessions/revoke', { id });
+    },
+
+    async changePassword(currentPassword, newPassword) {
+        return this.post('/api/auth/password', {
+            current_password: currentPassword,
+            new_password: newPassword
+        });
+    },
+
+    async getTOTPStatus() {
+        return this.get('/api/auth/totp');
+    },
+
+    async setupTOTP(password) {
+        return this.post('/api/auth/totp/setup', { password });
+    },
+
+    async enableTOTP(code) {
+        return this.post('/api/auth/totp/enable', { code });
+    },
+
+    async disableTOTP(password, code) {
+        return this.post('/api/auth/totp/disable', { password, code });
+    },
+
+    async regenerateRecoveryCodes(password) {
+        return this.post('/api/auth/totp/recovery', { password });
+    },
+
+    async getAPITokens() {
+        return this.get('/api/auth/tokens');
+    },
+
+    async createAPIToken(name, scopes, expiresDays) {
+        return this.post('/api/auth/tokens/create', { name, scopes, expires_days: expiresDays });
+    },
+
+    async revokeAPIToken(id) {
+        return this.post('/api/auth/tokens/revoke', { id });
+    },
+
+    async getUsers() {
+        return this.get('/api/auth/users');
+    },
+
+    async addUser(name, password, role) {
+        return this.post('/api/auth/users/add', { name, password, role });
+    },
+
+    async updateUser(name, changes) {
+        return this.post('/api/auth/users/update', { name, ...changes });
+    },
+
+    async deleteUser(name) {
+        return this.post('/api/auth/users/delete', { name });
+    },
+
+    // ========== HTTPS ==========
+    async getTLSInfo() {
+        return this.get('/api/tls');
+    },
+
+    async uploadTLSCert(certFile, keyFile) {
+        const formData = new FormData();
+        formData.append('certfile', certFile);
+        formData.append('keyfile', keyFile);
+
+        const response = await fetch(this.baseUrl + '/api/tls/upload', {
+            method: 'POST',
+            headers: this.headers(),
+            body: formData
+        });
+        const json = await response.json();
+
+        if (!response.ok || json.ok === false) {
+            const error = new Error(json.error || 'Upload failed');
+            error.detail = json.detail;
+            throw error;
+        }
+
+        return json.data !== undefined ? json.data : json;
+    },
+
+    async resetTLSCert() {
+        return this.post('/api/tls/reset', {});
+    },
+
+    getTLSCADownloadUrl() {
+        return this.baseUrl + '/api/tls/ca';
+    },
+
+    getTLSCRLDownloadUrl() {
+        return this.baseUrl + '/api/tls/crl';
+    },
+
+    async getClientCerts() {
+        return this.get('/api/tls/clients');
+    },
+
+    /**
+     * Issue a client certificate; resolves to the PKCS#12 bundle as a Blob
+     */
+    async issueClientCert(user, days, password, legacy = false) {
+        const response = await fetch(this.baseUrl + '/api/tls/clients/issue', {
+            method: 'POST',
+            headers: this.headers({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ user, days, password, legacy })
+        });
+        if (!response.ok) {
+            const json = await response.json().catch(() => ({}));
+            const error = new Error(json.error || 'Request failed');
+            error.detail = json.detail;
+            throw error;
+        }
+        return response.blob();
+    },
+
+    async revokeClientCert(serial) {
+        return this.post('/api/tls/clients/revoke', { serial });
+    },
+
     // ========== Status ==========
     async getStatus() {
         return this.get('/api/status');
@@ -146,15 +307,36 @@ const API = {
 
     // ========== Logs ==========
     async getSystemLogs(options = {}) {
+        const queryString = this.logParams(options).toString();
+        return this.get('/api/logs' + (queryString ? '?' + queryString : ''));
+    },
+
+    getLogStreamUrl(options = {}, after = 0) {
+        const params = this.logParams(options);
+        params.delete('limit');
+        if (after) params.set('after', after.toString());
+        return this.baseUrl + '/api/logs/stream?' + params.toString();
+    },
+
+    getLogExportUrl(options = {}, format = 'ndjson') {
+        const params = this.logParams(options);
+        params.delete('limit');
+        params.set('format', format);
+        return this.baseUrl + '/api/logs/export?' + params.toString();
+    },
+
+    logParams(options) {
         const params = new URLSearchParams();
         if (options.category) params.set('category', options.category);
         if (options.level) params.set('level', options.level);
         if (options.limit) params.set('limit', options.limit.toString());
         if (options.search) params.set('search', options.search);
+        if (options.request_id) params.set('request_id', options.request_id);
         if (options.success !== undefined) params.set('success', options.success.toString());
-        
-        const queryString = params.toString();
-        return this.get('/api/logs' + (queryString ? '?' + queryString : ''));
+        if (options.since) params.set('since', options.since);
+        if (options.until) params.set('until', options.until);
+        if (options.before) params.set('before', options.before.toString());
+        return params;
     },
 
     async getLogSettings() {
@@ -177,25 +359,45 @@ const API = {
         return this.get('/api/logs/stats');
     },
 
+    // ========== Audit Log ==========
+    async getAuditEntries(options = {}) {
+        const params = new URLSearchParams();
+        if (options.actor) params.set('actor', options.actor);
+        if (options.action) params.set('action', options.action);
+        if (options.limit) params.set('limit', options.limit.toString());
+        if (options.success !== undefined) params.set('success', options.success.toString());
+
+        const queryString = params.toString();
+        return this.get('/api/audit' + (queryString ? '?' + queryString : ''));
+    },
+
+    async verifyAuditLog() {
+        return this.get('/api/audit/verify');
+    },
+
+    getAuditDownloadUrl() {
+        return this.baseUrl + '/api/audit/download';
+    },
+
     // ========== SSH Keys ==========
     async getSSHKeys() {
         return this.get('/api/ssh/keys');
     },
 
-    async uploadSSHKey(file) {
+    async uploadSSHKey(file, passphrase = '') {
         // Special handling for file upload - can't use standard request method
         const formData = new FormData();
         formData.append('keyfile', file);
+        if (passphrase) {
+            formData.append('passphrase', passphrase);
+        }
 
         const options = {
             method: 'POST',
+            headers: this.headers(),
             body: formData
         };
 
-        if (this.authHeader) {
-            options.headers = { 'Authorization': this.authHeader };
-        }
-
         const response = await fetch(this.baseUrl + '/api/ssh/keys/upload', options);
         const json = await response.json();
         
@@ -222,6 +424,39 @@ const API = {
         return this.get(`/api/ssh/keys/public?name=${encodeURIComponent(name)}`);
     },
 
+    async uploadSSHCert(name, file) {
+        const formData = new FormData();
+        formData.append('name', name);
+        formData.append('certfile', file);
+
+        const options = {
+            method: 'POST',
+            headers: this.headers(),
+            body: formData
+        };
+
+        const response = await fetch(this.baseUrl + '/api/ssh/keys/cert/upload', options);
+        const json = await response.json();
+
+        if (!response.ok || json.ok === false) {
+            throw new Error(json.detail || json.error || 'Upload failed');
+        }
+
+        return json.data !== undefined ? json.data : json;
+    },
+
+    async deleteSSHCert(name) {
+        return this.post('/api/ssh/keys/cert/delete', { name });
+    },
+
+    async unlockSSHKey(name, passphrase) {
+        return this.post('/api/ssh/keys/unlock', { name, passphrase });
+    },
+
+    async lockSSHKey(name) {
+        return this.post('/api/ssh/keys/lock', { name });
+    },
+
     getSSHPublicKeyDownloadUrl(name) {
         return this.baseUrl + `/api/ssh/keys/download?name=${encodeURIComponent(name)}`;
     },
@@ -247,6 +482,52 @@ const API = {
         return this.post('/api/ssh/tunnels/delete', { id });
     },
 
+    async getSSHTunnelHistory(id) {
+        return this.get(`/api/ssh/tunnels/history?id=${encodeURIComponent(id)}`);
+    },
+
+    // ========== Terminal ==========
+    async getTerminalSessions() {
+        return this.get('/api/terminal/sessions');
+    },
+
+    async closeTerminalSession(id) {
+        return this.post('/api/terminal/close', { id });
+    },
+
+    async getTerminalRecordings() {
+        return this.get('/api/terminal/recordings');
+    },
+
+    getTerminalRecordingDownloadUrl(id) {
+        return this.baseUrl + `/api/terminal/recordings/download?id=${encodeURIComponent(id)}`;
+    },
+
+    /**
+     * Fetch a recording as asciicast text
+     */
+    async getTerminalRecording(id) {
+        const response = await fetch(this.getTerminalRecordingDownloadUrl(id));
+        if (!response.ok) {
+            const json = await response.json().catch(() => ({}));
+            throw new Error(json.error || 'Request failed');
+        }
+        return response.text();
+    },
+
+    // ========== SSH Phone Home ==========
+    async getPhoneHome() {
+        return this.get('/api/ssh/phonehome');
+    },
+
+    async configurePhoneHome(config) {
+        return this.post('/api/ssh/phonehome/config', config);
+    },
+
+    async reconnectPhoneHome() {
+        return this.post('/api/ssh/phonehome/reconnect', {});
+    },
+
     // ========== System ==========
     async shutdownSystem() {
         return this.post('/api/system/shutdown', {});
@@ -4,6 +4,8 @@
 import API from './api.js';
 import UI from './ui.js';
 import Icons from './icons.js';
+import Auth from './auth.js';
+import TwoFactor from './twofactor.js';
 
 // Global state
 const state = {
@@ -60,8 +62,25 @@ export function switchTab(tabId) {
  * Initialize the application
  */
 async function init() {
-    // Initialize API (no auth for now)
+    // Initialize API and check the login session
     API.init();
+    const session = await Auth.check();
+    if (!session) {
+        // The login screen reloads the page once logged in
+        return;
+    }
+    if (session.totp_enrol) {
+        // The user's role requires two-factor; nothing else works until enrolled
+        TwoFactor.showEnrol({ forced: true });
+        return;
+    }
+
+    // Drop tabs the user's role cannot use
+    for (const [id, tab] of Object.entries(tabs)) {
+        if (tab.role && !Auth.can(tab.role)) {
+            delete tabs[id];
+        }
+    }
 
     // Initialize UI
     UI.initDropdowns();
@@ -115,8 +134,8 @@ async function init() {
     updateClock();
     setInterval(updateClock, 1000);
 
-    // Setup header buttons (reboot/shutdown)
-    setupHeaderButtons();
+    // Setup header buttons (account/reboot/shutdown)
+    setupHeaderButtons(session);
 
     console.log('nm-webui initialized');
 }
@@ -208,7 +227,24 @@ export function setHostname(hostname) {
 /**
  * Setup header action buttons
  */
-function setupHeaderButtons() {
+function setupHeaderButtons(session) {
+    // Account button (hidden when authentication is disabled)
+    const accountBtn = document.getElementById('btn-account');
+    if (accountBtn) {
+        if (session.auth_disabled) {
+            accountBtn.style.display = 'none';
+        } else {
+            accountBtn.title = `Logged in as ${session.user} (${session.role})`;
+            accountBtn.addEventListener('click', () => Auth.showAccount());
+        }
+    }
+
+    // Power buttons are for admins only
+    if (!Auth.can('admin')) {
+        document.getElementById('btn-shutdown')?.remove();
+        document.getElementById('btn-reboot')?.remove();
+    }
+
     // Shutdown button
     document.getElementById('btn-shutdown')?.addEventListener('click', async () => {
         if (!confirm('Are you sure you want to shutdown the system?\n\nThe device will power off completely.')) {
@@ -0,0 +1,407 @@
+/**
+ * Auth Module - Login screen, logout and session management
+ */
+import API from './api.js';
+import UI from './ui.js';
+import Icons from './icons.js';
+import TwoFactor from './twofactor.js';
+import Tokens from './tokens.js';
+import Certificate from './certificate.js';
+
+const ROLES = ['viewer', 'operator', 'admin'];
+
+const Auth = {
+    user: null,
+    role: 'admin',
+    certLogin: false,
+
+    /**
+     * Whether the current user has at least the given role
+     */
+    can(role) {
+        return ROLES.indexOf(this.role) >= ROLES.indexOf(role);
+    },
+
+    /**
+     * Check the current session. Resolves to the session info, or null
+     * after showing the login screen.
+     */
+    async check() {
+        try {
+            const session = await API.getSession();
+            API.setCSRFToken(session.csrf_token);
+            this.user = session.auth_disabled ? null : session.user;
+            this.role = session.role || 'viewer';
+            API.onUnauthorized = () => this.showLogin('Your session has expired. Please log in again.');
+            return session;
+        } catch (err) {
+            this.showLogin();
+            return null;
+        }
+    },
+
+    /**
+     * Show the full-page login form
+     */
+    showLogin(message = '') {
+        let screen = document.getElementById('login-screen');
+        if (!screen) {
+            screen = document.createElement('div');
+            screen.id = 'login-screen';
+            screen.className = 'login-screen';
+            screen.innerHTML = `
+                <form class="card login-card" autocomplete="on">
+                    <div class="card-header">
+                        <span class="card-title">${Icons.lock} Haxinator Login</span>
+                    </div>
+                    <div class="card-body">
+                        <div class="alert alert-danger" id="login-error" style="display: none;"></div>
+                        <div class="form-group">
+                            <label class="form-label" for="login-username">Username</label>
+                            <input class="form-control" id="login-username" name="username" autocomplete="username" required>
+                        </div>
+                        <div class="form-group">
+                            <label class="form-label" for="login-password">Password</label>
+                            <input class="form-control" id="login-password" name="password" type="password" autocomplete="current-password" required>
+                        </div>
+                        <div class="form-group" id="login-code-group" style="display: none;">
+                            <label class="form-label" for="login-code">Authenticator or recovery code</label>
+                            <input class="form-control" id="login-code" name="code" autocomplete="one-time-code">
+                        </div>
+                        <button class="btn btn-primary login-submit" type="submit">Log In</button>
+                        ${location.protocol === 'https:' ? `<button class="btn login-cert" type="button">${Icons.key} Use Client Certificate</button>` : ''}
+                    </div>
+                </form>
+            `;
+            document.body.appendChild(screen);
+            screen.querySelector('form').addEventListener('submit', (e) => {
+                e.preventDefault();
+                this.submitLogin(screen);
+            });
+            screen.querySelector('.login-cert')?.addEventListener('click', () => {
+                // The certificate replaces the name and password
+                screen.querySelectorAll('[required]').forEach(input => { input.required = false; });
+                this.certLogin = true;
+                this.submitLogin(screen);
+            });
+        }
+
+        this.showLoginError(screen, message);
+        screen.querySelector('#login-username').focus();
+    },
+
+    showLoginError(screen, message) {
+        const error = screen.querySelector('#login-error');
+        error.textContent = message;
+        error.style.display = message ? '' : 'none';
+    },
+
+    async submitLogin(screen) {
+        const button = screen.querySelector('.login-submit');
+        const username = screen.querySelector('#login-username').value.trim();
+        const password = screen.querySelector('#login-password').value;
+        const codeGroup = screen.querySelector('#login-code-group');
+        const code = screen.querySelector('#login-code');
+
+        button.disabled = true;
+        try {
+            const result = this.certLogin
+                ? await API.loginWithCertificate(code.value.trim())
+                : await API.login(username, password, code.value.trim());
+            if (result.totp_required) {
+                // Password accepted; ask for the second factor
+                codeGroup.style.display = '';
+                this.showLoginError(screen, '');
+                code.focus();
+                button.disabled = false;
+                return;
+            }
+            // Reload so every tab starts with the new session
+            window.location.reload();
+        } catch (err) {
+            if (codeGroup.style.display === '') {
+                this.showLoginError(screen, err.message);
+                code.value = '';
+                code.focus();
+                button.disabled = false;
+                return;
+            }
+            this.certLogin = false;
+            this.showLoginError(screen, err.detail ? `${err.message}: ${err.detail}` : err.message);
+            screen.querySelector('#login-password').value = '';
+            screen.querySelector('#login-password').focus();
+            button.disabled = false;
+        }
+    },
+
+    async logout() {
+        try {
+            await API.logout();
+        } catch (err) {
+            console.error('Logout failed:', err);
+        }
+        window.location.reload();
+    },
+
+    /**
+     * Show the account modal with the active sessions
+     */
+    async showAccount() {
+        let sessions = [];
+        try {
+            sessions = await API.getAuthSessions();
+        } catch (err) {
+            UI.error('Failed to load sessions: ' + err.message);
+            return;
+        }
+
+        const rows = sessions.map(s => `
+            <div class="list-item">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        ${Icons.user} ${UI.escape(s.user)} <span class="text-muted">${UI.escape(s.remote)}</span>
+                        ${s.current ? '<span class="badge badge-success">This session</span>' : ''}
+                    </div>
+                    <div class="list-item-meta">
+                        <span class="text-muted" title="${UI.escape(s.user_agent)}">
+                            Logged in ${UI.escape(new Date(s.created).toLocaleString())} · last seen ${UI.escape(new Date(s.last_seen).toLocaleTimeString())}
+                        </span>
+                    </div>
+                </div>
+                <div class="list-item-actions">
+                    ${s.current ? '' : `<button class="btn btn-sm btn-danger" data-revoke="${UI.escape(s.id)}" title="Revoke">${Icons.x}</button>`}
+                </div>
+            </div>
+        `).join('');
+
+        const { overlay, close } = UI.modal({
+            title: `Logged in as ${this.user} (${this.role})`,
+            content: `
+                <h4>Active Sessions</h4>
+                ${rows || UI.empty('No sessions')}
+                <h4>Two-Factor Authentication</h4>
+                <div id="account-totp"><div class="state-message loading">Loading...</div></div>
+                <h4>API Tokens</h4>
+                <div id="account-tokens"><div class="state-message loading">Loading...</div></div>
+                <h4>HTTPS Certificate</h4>
+                <div id="account-cert"><div class="state-message loading">Loading...</div></div>
+                ${this.can('admin') ? `
+                    <h4>Users</h4>
+                    <div id="account-users"><div class="state-message loading">Loading...</div></div>
+                    <form id="account-user-add" class="form-group" autocomplete="off">
+                        <label class="form-label">Add User</label>
+                        <input class="form-control" name="name" placeholder="Name" required>
+                        <input class="form-control" name="password" type="password" placeholder="Password (10+ characters)" autocomplete="new-password" required>
+                        <select class="form-control" name="role">
+                            ${ROLES.map(r => `<option value="${r}">${r}</option>`).join('')}
+                        </select>
+                        <button class="btn btn-primary" type="submit">${Icons.plus} Add</button>
+                    </form>
+                ` : ''}
+            `,
+            width: '560px',
+            buttons: [
+                { text: 'Change Password', className: 'btn', action: () => { close(); this.showChangePassword(); } },
+                { text: 'Log Out', className: 'btn btn-danger', action: () => { close(); this.logout(); } },
+                { text: 'Close', className: 'btn' }
+            ]
+        });
+
+        TwoFactor.renderSection(overlay.querySelector('#account-totp'));
+        Tokens.renderSection(overlay.querySelector('#account-tokens'));
+        Certificate.renderSection(overlay.querySelector('#account-cert'), this.can('admin'));
+        if (this.can('admin')) {
+            this.bindUsers(overlay);
+        }
+
+        overlay.addEventListener('click', async (e) => {
+            const id = e.target.closest('[data-revoke]')?.dataset.revoke;
+            if (!id) return;
+            try {
+                await API.revokeAuthSession(id);
+                e.target.closest('.list-item').remove();
+                UI.success('Session revoked');
+            } catch (err) {
+                UI.error('Failed to revoke session: ' + err.message);
+            }
+        });
+    }
+
+    /**
+     * Load the user list into the account modal and wire its controls
+     */
+    bindUsers(overlay) {
+        const container = overlay.querySelector('#account-users');
+
+        const load = async () => {
+            try {
+                const users = await API.getUsers();
+                container.innerHTML = users.map(u => `
+                    <div class="list-item" data-user="${UI.escape(u.name)}">
+                        <div class="list-item-content">
+                            <div class="list-item-title">
+                                ${Icons.user} ${UI.escape(u.name)}
+                                ${u.env ? '<span class="badge badge-muted" title="Set by NM_WEBUI_USER">env</span>' : ''}
+                                ${u.totp ? '<span class="badge badge-success" title="Two-factor enabled">2FA</span>' : ''}
+                            </div>
+                        </div>
+                        <div class="list-item-actions">
+                            <select class="form-control" data-role ${u.env ? 'disabled' : ''}>
+                                ${ROLES.map(r => `<option value="${r}" ${r === u.role ? 'selected' : ''}>${r}</option>`).join('')}
+                            </select>
+                            ${u.env ? '' : `
+                                <button class="btn btn-sm" data-password title="Reset password">${Icons.key}</button>
+                                ${u.totp ? `<button class="btn btn-sm" data-reset-totp title="Reset two-factor">${Icons.shield}</button>` : ''}
+                                <button class="btn btn-sm btn-danger" data-delete title="Delete">${Icons.trash}</button>
+                            `}
+                        </div>
+                    </div>
+                `).join('') || UI.empty('No users');
+            } catch (err) {
+                container.innerHTML = `<div class="alert alert-danger">${UI.escape(err.message)}</div>`;
+            }
+        };
+
+        container.addEventListener('change', async (e) => {
+            if (!e.target.matches('[data-role]')) return;
+            const name = e.target.closest('[data-user]').dataset.user;
+            try {
+                await API.updateUser(name, { role: e.target.value });
+                UI.success(`${name} is now ${e.target.value}`);
+            } catch (err) {
+                UI.error('Failed to change role: ' + err.message);
+            }
+            load();
+        });
+
+        container.addEventListener('click', async (e) => {
+            const name = e.target.closest('[data-user]')?.dataset.user;
+            if (!name) return;
+
+            if (e.target.closest('[data-delete]')) {
+                if (!await UI.confirm(`Delete user ${name}? Their sessions end immediately.`, 'Delete User')) return;
+                try {
+                    await API.deleteUser(name);
+                    UI.success(`Deleted ${name}`);
+                } catch (err) {
+                    UI.error('Failed to delete user: ' + err.message);
+                }
+                load();
+            } else if (e.target.closest('[data-password]')) {
+                this.showResetPassword(name);
+            } else if (e.target.closest('[data-reset-totp]')) {
+                if (!await UI.confirm(`Remove two-factor authentication for ${name}? They can set it up again after logging in with their password.`, 'Reset Two-Factor')) return;
+                try {
+                    await API.updateUser(name, { reset_totp: true });
+                    UI.success(`Two-factor reset for ${name}`);
+                } catch (err) {
+                    UI.error('Failed to reset two-factor: ' + err.message);
+                }
+                load();
+            }
+        });
+
+        const form = overlay.querySelector('#account-user-add');
+        form.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            try {
+                await API.addUser(form.name.value.trim(), form.password.value, form.role.value);
+                UI.success(`Added ${form.name.value.trim()}`);
+                form.reset();
+            } catch (err) {
+                UI.error('Failed to add user: ' + err.message);
+            }
+            load();
+        });
+
+        load();
+    },
+
+    /**
+     * Change the current user's password. Other sessions end; this one
+     * continues with a new token.
+     */
+    showChangePassword() {
+        const { overlay, close } = UI.modal({
+            title: 'Change Password',
+            content: `
+                <form id="change-password" autocomplete="on">
+                    <input type="hidden" name="username" autocomplete="username" value="${UI.escape(this.user || '')}">
+                    <div class="form-group">
+                        <label class="form-label" for="current-password">Current Password</label>
+                        <input class="form-control" id="current-password" type="password" autocomplete="current-password">
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label" for="new-password">New Password</label>
+                        <input class="form-control" id="new-password" type="password" autocomplete="new-password">
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label" for="confirm-password">Confirm New Password</label>
+                        <input class="form-control" id="confirm-password" type="password" autocomplete="new-password">
+                    </div>
+                    <p class="text-muted">At least 10 characters mixing three of lowercase, uppercase, digits and symbols, or a passphrase of 16 or more. Your other sessions will be logged out.</p>
+                </form>
+            `,
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                {
+                    text: 'Change',
+                    className: 'btn btn-primary',
+                    action: async () => {
+                        const current = overlay.querySelector('#current-password').value;
+                        const next = overlay.querySelector('#new-password').value;
+                        if (next !== overlay.querySelector('#confirm-password').value) {
+                            UI.error('The new passwords do not match');
+                            return;
+                        }
+                        try {
+                            const session = await API.changePassword(current, next);
+                            if (session?.csrf_token) {
+                                API.setCSRFToken(session.csrf_token);
+                            }
+                            UI.success('Password changed');
+                            close();
+                        } catch (err) {
+                            UI.error('Failed to change password: ' + err.message);
+                        }
+                    }
+                }
+            ]
+        });
+        overlay.querySelector('#current-password').focus();
+    },
+
+    /**
+     * Ask for a new password for a user
+     */
+    showResetPassword(name) {
+        const { overlay, close } = UI.modal({
+            title: `Reset Password for ${name}`,
+            content: `
+                <div class="form-group">
+                    <label class="form-label" for="reset-password">New Password</label>
+                    <input class="form-control" id="reset-password" type="password" autocomplete="new-password">
+                </div>
+                <p class="text-muted">The user's sessions end and they must log in again.</p>
+            `,
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                {
+                    text: 'Reset',
+                    className: 'btn btn-primary',
+                    action: async () => {
+                        try {
+                            await API.updateUser(name, { password: overlay.querySelector('#reset-password').value });
+                            UI.success(`Password reset for ${name}`);
+                            close();
+                        } catch (err) {
+                            UI.error('Failed to reset password: ' + err.message);
+                        }
+                    }
+                }
+            ]
+        });
+    }
+};
+
+export default Auth;
@@ -0,0 +1,173 @@
+/**
+ * Certificate Module - the HTTPS certificate section of the account dialog
+ */
+import API from './api.js';
+import UI from './ui.js';
+import Icons from './icons.js';
+
+const Certificate = {
+    /**
+     * Render the certificate details, and for admins upload and reset
+     */
+    async renderSection(container, admin = false) {
+        let info;
+        try {
+            info = await API.getTLSInfo();
+        } catch (err) {
+            container.innerHTML = `<div class="alert alert-danger">${UI.escape(err.message)}</div>`;
+            return;
+        }
+
+        if (!info.enabled) {
+            container.innerHTML = '<p class="text-muted">Not enabled. Start nm-webui with <code>-tls</code> to serve HTTPS.</p>';
+            return;
+        }
+
+        const generated = info.source === 'generated';
+        container.innerHTML = `
+            <p>
+                <span class="badge ${generated ? 'badge-muted' : 'badge-success'}">${generated ? 'Device certificate' : 'Uploaded'}</span>
+                <span class="text-muted">Valid until ${UI.escape(new Date(info.not_after).toLocaleDateString())} · client certificates ${UI.escape(info.client_auth)}</span>
+            </p>
+            <div class="form-group">
+                <label class="form-label">Names</label>
+                <div class="text-muted">${info.names.map(n => UI.escape(n)).join(', ')}</div>
+            </div>
+            <div class="form-group">
+                <label class="form-label">SHA-256 Fingerprint</label>
+                <code class="cert-fingerprint">${UI.escape(info.fingerprint)}</code>
+            </div>
+            ${generated ? `
+                <div class="form-group">
+                    <label class="form-label">Device CA Fingerprint</label>
+                    <code class="cert-fingerprint">${UI.escape(info.ca_fingerprint)}</code>
+                </div>
+                <a class="btn btn-sm" href="${API.getTLSCADownloadUrl()}" download>${Icons.download} Download CA</a>
+            ` : ''}
+            ${admin ? `
+                <form class="form-group" data-cert-upload>
+                    <label class="form-label">Use Your Own Certificate (PEM)</label>
+                    <label class="text-muted">Certificate and chain <input class="form-control" type="file" name="cert" accept=".crt,.pem,.cer" required></label>
+                    <label class="text-muted">Private key <input class="form-control" type="file" name="key" accept=".key,.pem" required></label>
+                    <button class="btn btn-sm btn-primary" type="submit">${Icons.upload} Upload</button>
+                    ${generated ? '' : `<button class="btn btn-sm" type="button" data-cert-reset>${Icons.refresh} Use Device Certificate</button>`}
+                </form>
+                <label class="form-label">Client Certificates</label>
+                <div data-client-certs><div class="state-message loading">Loading...</div></div>
+            ` : ''}
+        `;
+
+        const form = container.querySelector('[data-cert-upload]');
+        if (!form) return;
+        const reload = () => this.renderSection(container, admin);
+        this.renderClients(container.querySelector('[data-client-certs]'));
+
+        form.onsubmit = async (e) => {
+            e.preventDefault();
+            try {
+                await API.uploadTLSCert(form.cert.files[0], form.key.files[0]);
+                UI.success('Certificate installed; new connections use it');
+            } catch (err) {
+                UI.error('Failed to install certificate: ' + (err.detail || err.message));
+            }
+            reload();
+        };
+        container.querySelector('[data-cert-reset]')?.addEventListener('click', async () => {
+            if (!await UI.confirm('Remove the uploaded certificate and go back to the device certificate?', 'Reset Certificate')) return;
+            try {
+                await API.resetTLSCert();
+                UI.success('Using the device certificate');
+            } catch (err) {
+                UI.error('Failed to reset certificate: ' + (err.detail || err.message));
+            }
+            reload();
+        });
+    },
+
+    /**
+     * List issued client certificates with revoke, and a form to issue a
+     * new one as a PKCS#12 download
+     */
+    async renderClients(container) {
+        let certs;
+        try {
+            certs = await API.getClientCerts();
+        } catch (err) {
+            container.innerHTML = `<div class="alert alert-danger">${UI.escape(err.message)}</div>`;
+            return;
+        }
+
+        const rows = certs.map(c => `
+            <div class="list-item" data-serial="${UI.escape(c.serial)}">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        ${Icons.user} ${UI.escape(c.user)}
+                        ${c.revoked ? '<span class="badge badge-danger">Revoked</span>' : ''}
+                        <span class="text-muted">${UI.escape(c.serial.slice(0, 8))}</span>
+                    </div>
+                    <div class="list-item-meta">
+                        <span class="text-muted">
+                            Issued ${UI.escape(new Date(c.created).toLocaleDateString())} by ${UI.escape(c.issued_by)}
+                            · ${c.revoked ? `revoked ${UI.escape(new Date(c.revoked).toLocaleDateString())}` : `expires ${UI.escape(new Date(c.expires).toLocaleDateString())}`}
+                        </span>
+                    </div>
+                </div>
+                <div class="list-item-actions">
+                    ${c.revoked ? '' : `<button class="btn btn-sm btn-danger" data-client-revoke title="Revoke">${Icons.x}</button>`}
+                </div>
+            </div>
+        `).join('');
+
+        container.innerHTML = `
+            ${rows || UI.empty('No client certificates')}
+            <a class="btn btn-sm" href="${API.getTLSCRLDownloadUrl()}" download>${Icons.download} Revocation List</a>
+            <form class="form-group" data-client-issue autocomplete="off">
+                <label class="form-label">Issue Certificate</label>
+                <input class="form-control" name="user" placeholder="User" required>
+                <select class="form-control" name="days">
+                    <option value="30">30 days</option>
+                    <option value="365" selected>1 year</option>
+                    <option value="1095">3 years</option>
+                </select>
+                <input class="form-control" name="password" type="password" placeholder="Bundle password (8+ characters)" autocomplete="new-password" minlength="8" required>
+                <label><input type="checkbox" name="legacy"> Legacy encryption (older Windows and macOS)</label>
+                <button class="btn btn-sm btn-primary" type="submit">${Icons.plus} Issue</button>
+            </form>
+        `;
+
+        const reload = () => this.renderClients(container);
+
+        container.onclick = async (e) => {
+            if (!e.target.closest('[data-client-revoke]')) return;
+            const serial = e.target.closest('[data-serial]').dataset.serial;
+            if (!await UI.confirm('Revoke this certificate? It stops working immediately.', 'Revoke Certificate')) return;
+            try {
+                await API.revokeClientCert(serial);
+                UI.success('Certificate revoked');
+            } catch (err) {
+                UI.error('Failed to revoke certificate: ' + (err.detail || err.message));
+            }
+            reload();
+        };
+
+        const form = container.querySelector('[data-client-issue]');
+        form.onsubmit = async (e) => {
+            e.preventDefault();
+            const user = form.user.value.trim();
+            try {
+                const bundle = await API.issueClientCert(user, parseInt(form.days.value), form.password.value, form.legacy.checked);
+                const a = document.createElement('a');
+                a.href = URL.createObjectURL(bundle);
+                a.download = `${user}.p12`;
+                a.click();
+                URL.revokeObjectURL(a.href);
+                UI.success(`Issued a certificate for ${user}`);
+            } catch (err) {
+                UI.error('Failed to issue certificate: ' + (err.detail || err.message));
+            }
+            reload();
+        };
+    }
+};
+
+export default Certificate;
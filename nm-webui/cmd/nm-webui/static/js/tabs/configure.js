@@ -8,12 +8,14 @@ const ConfigureTab = {
     id: 'configure',
     label: 'Configure',
     iconName: 'settings',
+    role: 'admin',
     loaded: false,
     eventsBound: false,
     fileStatus: {},
     networkConfigs: [],
     selectedVPNProfile: '',
     selectedVPNProfiles: [],
+    authorizedKeys: [],
 
     init() {
         // Nothing async to initialize
@@ -53,6 +55,7 @@ const ConfigureTab = {
                     <span class="card-title">${Icons.key} Authorized SSH Keys</span>
                     <div class="card-actions">
                         <span class="badge" id="authorized-keys-status">No File</span>
+                        <button class="btn btn-sm" id="authorized-keys-add">${Icons.plus} Add</button>
                         <button class="btn btn-sm" id="authorized-keys-view" style="display:none">${Icons.eye} View</button>
                         <button class="btn btn-sm btn-danger" id="authorized-keys-delete" style="display:none">${Icons.trash} Delete</button>
                     </div>
@@ -73,6 +76,7 @@ const ConfigureTab = {
                         </div>
                         <input type="file" class="file-input" accept=".pub,text/plain" style="display: none;">
                     </div>
+                    <div id="authorized-keys-list" style="margin-top: 1rem;"></div>
                 </div>
             </div>
 
@@ -153,6 +157,18 @@ const ConfigureTab = {
         document.getElementById('env-view')?.addEventListener('click', () => this.viewFile('env-secrets'));
         document.getElementById('authorized-keys-view')?.addEventListener('click', () => this.viewFile('authorized-keys'));
         document.getElementById('authorized-keys-delete')?.addEventListener('click', () => this.deleteConfigFile('authorized-keys'));
+        document.getElementById('authorized-keys-add')?.addEventListener('click', () => this.showAuthorizedKeyModal());
+
+        document.getElementById('authorized-keys-list')?.addEventListener('click', (e) => {
+            const btn = e.target.closest('button');
+            const entry = this.authorizedKeys.find(k => String(k.line) === btn?.dataset.line);
+            if (!entry) return;
+            if (btn.dataset.action === 'ak-edit') {
+                this.showAuthorizedKeyModal(entry);
+            } else if (btn.dataset.action === 'ak-delete') {
+                this.deleteAuthorizedKey(entry);
+            }
+        });
         document.getElementById('vpn-view')?.addEventListener('click', () => this.viewFile('vpn', this.selectedVPNProfile));
         document.getElementById('apply-configs')?.addEventListener('click', () => this.applyConfigs());
 
@@ -239,6 +255,7 @@ const ConfigureTab = {
 
             const response = await fetch('/api/configure/upload', {
                 method: 'POST',
+                headers: API.headers(),
                 body: formData
             });
 
@@ -285,6 +302,163 @@ const ConfigureTab = {
         } catch (err) {
             console.error('Failed to load file status:', err);
         }
+        this.loadAuthorizedKeys();
+    },
+
+    async loadAuthorizedKeys() {
+        const container = document.getElementById('authorized-keys-list');
+        if (!container) return;
+
+        try {
+            const response = await fetch('/api/configure/authorized-keys');
+            const result = await response.json();
+            if (!response.ok || !result.ok) {
+                throw new Error(result.error || 'Failed to load keys');
+            }
+            this.authorizedKeys = result.data || [];
+            container.innerHTML = this.renderAuthorizedKeys();
+        } catch (err) {
+            container.innerHTML = `<div class="form-hint">Failed to load keys: ${UI.escape(err.message)}</div>`;
+        }
+    },
+
+    renderAuthorizedKeys() {
+        if (!this.authorizedKeys.length) {
+            return '';
+        }
+        return `
+            <div class="config-grid">
+                ${this.authorizedKeys.map(key => {
+                    const badges = [];
+                    if (key.from) badges.push(`from ${UI.escape(key.from)}`);
+                    if (key.command) badges.push('forced command');
+                    if (key.no_port_forwarding) badges.push('no port forwarding');
+                    (key.options || []).forEach(opt => {
+                        const name = opt.split('=')[0].toLowerCase();
+                        if (!['from', 'command', 'no-port-forwarding'].includes(name)) {
+                            badges.push(UI.escape(name));
+                        }
+                    });
+                    return `
+                        <div class="config-card">
+                            <div class="config-header">
+                                <span class="config-icon">${Icons.key}</span>
+                                <div class="config-title">
+                                    <strong>${UI.escape(key.error ? `Line ${key.line}: ${key.error}` : (key.comment || key.type))}</strong>
+                                    <small>${key.error ? '' : `${UI.escape(key.type)} • ${UI.escape(key.fingerprint)}`}</small>
+                                    ${badges.length ? `<small>${badges.map(b => `<span class="badge">${b}</span>`).join(' ')}</small>` : ''}
+                                </div>
+                                <div class="card-actions">
+                                    ${key.error ? '' : `<button class="btn btn-sm" data-action="ak-edit" data-line="${key.line}">${Icons.settings} Edit</button>`}
+                                    <button class="btn btn-sm btn-danger" data-action="ak-delete" data-line="${key.line}">${Icons.trash} Delete</button>
+                                </div>
+                            </div>
+                        </div>
+                    `;
+                }).join('')}
+            </div>
+        `;
+    },
+
+    showAuthorizedKeyModal(entry = null) {
+        UI.modal({
+            title: entry ? 'Edit Authorized Key' : 'Add Authorized Key',
+            content: `
+                <form class="form-stack" onsubmit="return false">
+                    ${entry ? `
+                        <div class="form-group">
+                            <label>Key</label>
+                            <code>${UI.escape(entry.type)} ${UI.escape(entry.fingerprint)}</code>
+                        </div>
+                    ` : `
+                        <div class="form-group">
+                            <label for="ak-key">Public Key</label>
+                            <textarea id="ak-key" class="input" rows="3" placeholder="ssh-ed25519 AAAA... user@host"></textarea>
+                        </div>
+                    `}
+                    <div class="form-group">
+                        <label for="ak-comment">Comment</label>
+                        <input type="text" id="ak-comment" class="input" value="${UI.escape(entry?.comment || '')}" placeholder="${entry ? '' : 'From the key if empty'}">
+                    </div>
+                    <div class="form-group">
+                        <label for="ak-from">Allowed Sources (from=)</label>
+                        <input type="text" id="ak-from" class="input" value="${UI.escape(entry?.from || '')}" placeholder="192.168.8.0/24,*.example.com">
+                        <small class="form-hint">Comma-separated hosts or CIDR blocks; empty allows any source</small>
+                    </div>
+                    <div class="form-group">
+                        <label for="ak-command">Forced Command (command=)</label>
+                        <input type="text" id="ak-command" class="input" value="${UI.escape(entry?.command || '')}">
+                    </div>
+                    <label class="checkbox-label">
+                        <input type="checkbox" id="ak-no-port-forwarding" ${entry?.no_port_forwarding ? 'checked' : ''}>
+                        <span>Disallow port forwarding</span>
+                    </label>
+                </form>
+            `,
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                { text: entry ? 'Save' : 'Add', className: 'btn btn-primary', action: () => this.submitAuthorizedKey(entry) }
+            ]
+        });
+    },
+
+    async submitAuthorizedKey(entry) {
+        const req = {
+            comment: document.getElementById('ak-comment').value.trim(),
+            from: document.getElementById('ak-from').value.trim(),
+            command: document.getElementById('ak-command').value.trim(),
+            no_port_forwarding: document.getElementById('ak-no-port-forwarding').checked
+        };
+        if (entry) {
+            req.line = entry.line;
+            req.fingerprint = entry.fingerprint;
+        } else {
+            req.key = document.getElementById('ak-key').value.trim();
+            if (!req.key) {
+                UI.error('Paste a public key');
+                return;
+            }
+        }
+
+        try {
+            const response = await fetch(`/api/configure/authorized-keys/${entry ? 'update' : 'add'}`, {
+                method: 'POST',
+                headers: API.headers({ 'Content-Type': 'application/json' }),
+                body: JSON.stringify(req)
+            });
+            const data = await response.json();
+            if (!response.ok || data.error) {
+                throw new Error(data.detail ? `${data.error}: ${data.detail}` : (data.error || 'Request failed'));
+            }
+            UI.closeModal();
+            UI.success(entry ? 'Authorized key updated' : 'Authorized key added');
+            this.loadFileStatus();
+        } catch (err) {
+            UI.error(err.message);
+        }
+    },
+
+    async deleteAuthorizedKey(entry) {
+        const label = entry.comment || entry.fingerprint || `line ${entry.line}`;
+        const confirmed = await UI.confirm(`Remove "${label}" from authorized_keys?`, 'Remove Key');
+        if (!confirmed) {
+            return;
+        }
+        try {
+            const response = await fetch('/api/configure/authorized-keys/delete', {
+                method: 'POST',
+                headers: API.headers({ 'Content-Type': 'application/json' }),
+                body: JSON.stringify({ line: entry.line, fingerprint: entry.fingerprint || '' })
+            });
+            const data = await response.json();
+            if (!response.ok || data.error) {
+                throw new Error(data.detail ? `${data.error}: ${data.detail}` : (data.error || 'Delete failed'));
+            }
+            UI.success('Key removed');
+            this.loadFileStatus();
+        } catch (err) {
+            UI.error('Failed to remove key: ' + err.message);
+        }
     },
 
     updateFileStatusUI() {
@@ -372,7 +546,7 @@ const ConfigureTab = {
         try {
             const response = await fetch('/api/configure/delete', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: API.headers({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ type })
             });
             const data = await response.json();
@@ -517,7 +691,7 @@ const ConfigureTab = {
 
             const response = await fetch('/api/configure/apply', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: API.headers({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ configs })
             });
 
@@ -674,7 +848,7 @@ const ConfigureTab = {
         try {
             const response = await fetch('/api/configure/delete', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: API.headers({ 'Content-Type': 'application/json' }),
                 body: JSON.stringify({ type: 'vpn', profile })
             });
             const data = await response.json();
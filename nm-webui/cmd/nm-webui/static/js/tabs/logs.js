@@ -2,6 +2,7 @@
  * Logs Tab Module - System logging and debugging
  */
 import { API, UI, Icons, registerTab } from '../app.js';
+import Auth from '../auth.js';
 
 const LogsTab = {
     id: 'logs',
@@ -10,13 +11,16 @@ const LogsTab = {
     loaded: false,
     eventsBound: false,
     entries: [],
+    nextBefore: null,
     settings: null,
     autoRefresh: false,
-    autoRefreshInterval: null,
+    stream: null,
+    renderPending: false,
     filter: {
         category: '',
         level: '',
         search: '',
+        request_id: '',
         limit: 100
     },
 
@@ -30,7 +34,7 @@ const LogsTab = {
                 <h2>System Logs</h2>
                 <div class="toolbar-spacer"></div>
                 <div class="toolbar-group">
-                    <label class="toggle" title="Auto-refresh logs">
+                    <label class="toggle" title="Show new entries as they happen">
                         <input type="checkbox" class="toggle-input" id="logs-auto-refresh">
                         <span class="toggle-track"><span class="toggle-thumb"></span></span>
                         <span class="toggle-label">Auto</span>
@@ -38,12 +42,20 @@ const LogsTab = {
                     <button class="btn btn-sm" id="logs-refresh">
                         ${Icons.refresh} Refresh
                     </button>
+                    <button class="btn btn-sm" id="logs-export">
+                        ${Icons.download} Export
+                    </button>
                     <button class="btn btn-sm" id="logs-clear">
                         ${Icons.trash} Clear
                     </button>
                     <button class="btn btn-sm" id="logs-settings">
                         ${Icons.settings} Settings
                     </button>
+                    ${Auth.can('admin') ? `
+                        <button class="btn btn-sm" id="logs-audit">
+                            ${Icons.shield} Audit
+                        </button>
+                    ` : ''}
                 </div>
             </div>
             
@@ -61,6 +73,12 @@ const LogsTab = {
                             <option value="action">action</option>
                             <option value="api">api</option>
                             <option value="ssh">ssh</option>
+                            <option value="networkmanager">NetworkManager</option>
+                            <option value="openvpn">openvpn</option>
+                            <option value="hans">hans</option>
+                            <option value="iodine">iodine</option>
+                            <option value="bluetooth">bluetooth</option>
+                            <option value="usb">usb</option>
                         </select>
                         <select id="logs-level" class="form-control" style="width: auto; height: 36px;">
                             <option value="">All Levels</option>
@@ -75,6 +93,7 @@ const LogsTab = {
                             <option value="200">Last 200</option>
                             <option value="500">Last 500</option>
                         </select>
+                        <button class="btn btn-sm" id="logs-request" title="Show all requests" style="display: none; height: 36px;"></button>
                     </div>
                 </div>
             </div>
@@ -112,7 +131,9 @@ const LogsTab = {
 
         document.getElementById('logs-refresh')?.addEventListener('click', () => this.load(true));
         document.getElementById('logs-clear')?.addEventListener('click', () => this.clearLogs());
+        document.getElementById('logs-export')?.addEventListener('click', () => this.showExport());
         document.getElementById('logs-settings')?.addEventListener('click', () => this.showSettings());
+        document.getElementById('logs-audit')?.addEventListener('click', () => this.showAudit());
         
         document.getElementById('logs-auto-refresh')?.addEventListener('change', (e) => {
             if (e.target.checked) {
@@ -142,7 +163,18 @@ const LogsTab = {
             this.load();
         });
         
+        document.getElementById('logs-request')?.addEventListener('click', () => this.filterRequest(''));
+        
         document.getElementById('logs-content')?.addEventListener('click', (e) => {
+            if (e.target.closest('#logs-more')) {
+                this.loadMore();
+                return;
+            }
+            const request = e.target.closest('[data-request-id]');
+            if (request) {
+                this.filterRequest(request.dataset.requestId);
+                return;
+            }
             const entry = e.target.closest('.log-entry');
             if (entry && !e.target.closest('button')) {
                 entry.classList.toggle('expanded');
@@ -150,6 +182,29 @@ const LogsTab = {
         });
     },
 
+    /**
+     * Show only the entries of one API request, or all again for ''
+     */
+    filterRequest(id) {
+        this.filter.request_id = id;
+        const button = document.getElementById('logs-request');
+        if (button) {
+            button.style.display = id ? '' : 'none';
+            button.innerHTML = `Request ${UI.escape(id)} ${Icons.x}`;
+        }
+        this.load();
+    },
+
+    renderRequest(id) {
+        return `
+            <div class="log-detail">
+                <span class="log-detail-label">Request:</span>
+                <code class="log-detail-value">${UI.escape(id)}</code>
+                <button class="btn btn-sm" data-request-id="${UI.escape(id)}">Show all entries</button>
+            </div>
+        `;
+    },
+
     debounceTimeout: null,
     debounceLoad() {
         clearTimeout(this.debounceTimeout);
@@ -158,17 +213,48 @@ const LogsTab = {
 
     startAutoRefresh() {
         this.autoRefresh = true;
-        this.autoRefreshInterval = setInterval(() => this.load(), 2000);
+        this.openStream();
     },
 
     stopAutoRefresh() {
         this.autoRefresh = false;
-        if (this.autoRefreshInterval) {
-            clearInterval(this.autoRefreshInterval);
-            this.autoRefreshInterval = null;
+        if (this.stream) {
+            this.stream.close();
+            this.stream = null;
         }
     },
 
+    /**
+     * Follow new entries over Server-Sent Events, from the newest one shown.
+     * The browser reconnects by itself and the server replays what was missed.
+     */
+    openStream() {
+        this.stream?.close();
+        const after = this.entries.length ? this.entries[0].id : 0;
+        this.stream = new EventSource(API.getLogStreamUrl(this.filter, after));
+
+        this.stream.onmessage = (e) => {
+            this.entries.unshift(JSON.parse(e.data));
+            if (this.entries.length > this.filter.limit) {
+                this.entries.length = this.filter.limit;
+                this.nextBefore = this.entries[this.entries.length - 1].id;
+            }
+            this.scheduleRender();
+        };
+        // Too far behind to replay everything; start over from the latest
+        this.stream.addEventListener('dropped', () => this.load());
+    },
+
+    scheduleRender() {
+        if (this.renderPending) return;
+        this.renderPending = true;
+        requestAnimationFrame(() => {
+            this.renderPending = false;
+            const container = document.getElementById('logs-content');
+            if (container) container.innerHTML = this.renderLogs();
+        });
+    },
+
     async load(showLoading = false) {
         const container = document.getElementById('logs-content');
         if (!container) return;
@@ -180,9 +266,12 @@ const LogsTab = {
         try {
             const data = await API.getSystemLogs(this.filter);
             this.entries = data.entries || [];
+            this.nextBefore = data.next_before || null;
             this.loaded = true;
 
             container.innerHTML = this.renderLogs();
+            // Filters changed, or the stream fell behind
+            if (this.autoRefresh) this.openStream();
         } catch (err) {
             container.innerHTML = `<div class="state-message">Error: ${UI.escape(err.message)}</div>`;
             if (!this.autoRefresh) {
@@ -191,6 +280,22 @@ const LogsTab = {
         }
     },
 
+    async loadMore() {
+        if (!this.nextBefore) return;
+        this.stopAutoRefresh();
+        const toggle = document.getElementById('logs-auto-refresh');
+        if (toggle) toggle.checked = false;
+
+        try {
+            const data = await API.getSystemLogs({ ...this.filter, before: this.nextBefore });
+            this.entries = this.entries.concat(data.entries || []);
+            this.nextBefore = data.next_before || null;
+            document.getElementById('logs-content').innerHTML = this.renderLogs();
+        } catch (err) {
+            UI.error('Failed to load older logs: ' + err.message);
+        }
+    },
+
     async loadStats() {
         try {
             const stats = await API.getLogStats();
@@ -217,6 +322,11 @@ const LogsTab = {
                 <span class="text-sm ${stats.errors > 0 ? 'text-danger' : 'text-secondary'}">
                     Errors: <strong>${stats.errors || 0}</strong>
                 </span>
+                ${stats.persisted ? `
+                    <span class="text-sm text-secondary">
+                        On disk: <strong>${UI.formatBytes(stats.disk_bytes || 0)}</strong>
+                    </span>
+                ` : ''}
                 <span class="text-sm text-secondary">
                     Logging: <strong class="${stats.enabled ? 'text-success' : 'text-muted'}">${stats.enabled ? 'ON' : 'OFF'}</strong>
                 </span>
@@ -234,6 +344,11 @@ const LogsTab = {
             <div class="logs-list">
                 ${this.entries.map(entry => this.renderEntry(entry)).join('')}
             </div>
+            ${this.nextBefore ? `
+                <div style="padding: var(--space-sm); text-align: center;">
+                    <button class="btn btn-sm" id="logs-more">Load older entries</button>
+                </div>
+            ` : ''}
         `;
     },
 
@@ -241,7 +356,8 @@ const LogsTab = {
         const levelClass = this.getLevelClass(entry.level);
         const time = new Date(entry.time).toLocaleTimeString();
         const date = new Date(entry.time).toLocaleDateString();
-        const hasDetails = entry.command || entry.output || entry.error || entry.extra;
+        const showRequest = entry.request_id && entry.request_id !== this.filter.request_id;
+        const hasDetails = entry.command || entry.output || entry.error || entry.extra || showRequest;
         
         return `
             <div class="log-entry ${levelClass} ${entry.success ? '' : 'error'} ${hasDetails ? 'has-details' : ''}">
@@ -249,6 +365,7 @@ const LogsTab = {
                     <span class="log-level">${entry.level}</span>
                     <span class="log-category">${UI.escape(entry.category)}</span>
                     <span class="log-action">${UI.escape(entry.action)}</span>
+                    ${entry.actor && entry.actor !== 'system' ? `<span class="log-actor" title="User">${UI.escape(entry.actor)}</span>` : ''}
                     ${entry.duration_ms ? `<span class="log-duration">${entry.duration_ms}ms</span>` : ''}
                     <span class="log-status">${entry.success ? Icons.check : Icons.x}</span>
                     <span class="log-time" title="${date}">${time}</span>
@@ -286,6 +403,7 @@ const LogsTab = {
                                 <pre class="log-detail-output">${JSON.stringify(entry.extra, null, 2)}</pre>
                             </div>
                         ` : ''}
+                        ${showRequest ? this.renderRequest(entry.request_id) : ''}
                     </div>
                 ` : ''}
             </div>
@@ -317,9 +435,213 @@ const LogsTab = {
         }
     },
 
+    showExport() {
+        const content = `
+            <form id="log-export-form">
+                <p class="text-sm text-secondary">Exports the entries matching the current filters, oldest first.</p>
+                <div class="form-group">
+                    <label class="form-label">From</label>
+                    <input type="datetime-local" class="form-control" name="since">
+                </div>
+                <div class="form-group">
+                    <label class="form-label">To</label>
+                    <input type="datetime-local" class="form-control" name="until">
+                </div>
+                <div class="form-group">
+                    <label class="form-label">Format</label>
+                    <select class="form-control" name="format">
+                        <option value="ndjson">NDJSON (one JSON entry per line)</option>
+                        <option value="csv">CSV</option>
+                    </select>
+                </div>
+            </form>
+        `;
+
+        UI.modal({
+            title: 'Export Logs',
+            content,
+            width: '400px',
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                { text: 'Download', className: 'btn btn-primary', action: () => {
+                    const form = document.getElementById('log-export-form');
+                    const time = (name) => {
+                        const value = form.querySelector(`[name="${name}"]`).value;
+                        return value ? new Date(value).toISOString().replace(/\.\d+Z$/, 'Z') : '';
+                    };
+                    const options = { ...this.filter, since: time('since'), until: time('until') };
+
+                    const a = document.createElement('a');
+                    a.href = API.getLogExportUrl(options, form.querySelector('[name="format"]').value);
+                    document.body.appendChild(a);
+                    a.click();
+                    document.body.removeChild(a);
+                    UI.closeModal();
+                } }
+            ]
+        });
+    },
+
+    // ---------- Audit log ----------
+
+    async showAudit() {
+        const content = `
+            <div class="logs-filters" style="margin-bottom: var(--space-md);">
+                <input type="text" id="audit-actor" class="form-control" placeholder="User" style="height: 36px; flex: 1;">
+                <input type="text" id="audit-action" class="form-control" placeholder="Action, e.g. connections" style="height: 36px; flex: 2;">
+                <select id="audit-success" class="form-control" style="width: auto; height: 36px;">
+                    <option value="">All Outcomes</option>
+                    <option value="true">Succeeded</option>
+                    <option value="false">Failed</option>
+                </select>
+            </div>
+            <div id="audit-verify" class="text-sm text-secondary" style="margin-bottom: var(--space-sm);"></div>
+            <div id="audit-entries" class="logs-container">${UI.loading('Loading audit log...')}</div>
+        `;
+
+        const { overlay } = UI.modal({
+            title: 'Audit Log',
+            content,
+            width: '800px',
+            buttons: [
+                { text: 'Verify', className: 'btn', action: () => this.verifyAudit() },
+                { text: 'Download', className: 'btn', action: () => {
+                    const a = document.createElement('a');
+                    a.href = API.getAuditDownloadUrl();
+                    document.body.appendChild(a);
+                    a.click();
+                    document.body.removeChild(a);
+                } },
+                { text: 'Close', className: 'btn btn-primary' }
+            ]
+        });
+
+        const filter = { limit: 200 };
+        let timeout = null;
+        const reload = () => {
+            clearTimeout(timeout);
+            timeout = setTimeout(() => this.loadAudit(filter), 300);
+        };
+        overlay.querySelector('#audit-actor').addEventListener('input', (e) => {
+            filter.actor = e.target.value.trim();
+            reload();
+        });
+        overlay.querySelector('#audit-action').addEventListener('input', (e) => {
+            filter.action = e.target.value.trim();
+            reload();
+        });
+        overlay.querySelector('#audit-success').addEventListener('change', (e) => {
+            filter.success = e.target.value === '' ? undefined : e.target.value === 'true';
+            this.loadAudit(filter);
+        });
+        overlay.querySelector('#audit-entries').addEventListener('click', (e) => {
+            const request = e.target.closest('[data-request-id]');
+            if (request) {
+                UI.closeModal();
+                this.filterRequest(request.dataset.requestId);
+                return;
+            }
+            e.target.closest('.log-entry')?.classList.toggle('expanded');
+        });
+
+        this.loadAudit(filter);
+        this.verifyAudit();
+    },
+
+    async loadAudit(filter) {
+        const container = document.getElementById('audit-entries');
+        if (!container) return;
+
+        try {
+            const data = await API.getAuditEntries(filter);
+            const entries = data.entries || [];
+            container.innerHTML = entries.length
+                ? `<div class="logs-list">${entries.map(e => this.renderAuditEntry(e)).join('')}</div>`
+                : `<div class="state-message">No audit entries found</div>`;
+        } catch (err) {
+            container.innerHTML = `<div class="state-message">Error: ${UI.escape(err.message)}</div>`;
+        }
+    },
+
+    renderAuditEntry(entry) {
+        const when = new Date(entry.time);
+        const who = entry.actor || 'anonymous';
+        const where = [entry.remote, entry.interface].filter(Boolean).join(' on ');
+
+        return `
+            <div class="log-entry ${entry.success ? 'level-info' : 'level-error error'} has-details">
+                <div class="log-entry-header">
+                    <span class="log-level">#${entry.seq}</span>
+                    <span class="log-category">${UI.escape(entry.method)}</span>
+                    <span class="log-action">${UI.escape(entry.action)}</span>
+                    <span class="log-actor" title="User">${UI.escape(who)}</span>
+                    <span class="log-duration">${entry.status}</span>
+                    <span class="log-status">${entry.success ? Icons.check : Icons.x}</span>
+                    <span class="log-time" title="${when.toLocaleDateString()}">${when.toLocaleTimeString()}</span>
+                    <span class="log-expand">${Icons.chevronDown}</span>
+                </div>
+                <div class="log-entry-details">
+                    <div class="log-detail">
+                        <span class="log-detail-label">From:</span>
+                        <code class="log-detail-value">${UI.escape(where || 'unknown')}</code>
+                    </div>
+                    ${entry.params ? `
+                        <div class="log-detail">
+                            <span class="log-detail-label">Parameters:</span>
+                            <pre class="log-detail-output">${UI.escape(JSON.stringify(entry.params, null, 2))}</pre>
+                        </div>
+                    ` : ''}
+                    ${entry.request_id ? this.renderRequest(entry.request_id) : ''}
+                    <div class="log-detail">
+                        <span class="log-detail-label">Hash:</span>
+                        <code class="log-detail-value">${UI.escape(entry.hash)}</code>
+                    </div>
+                </div>
+            </div>
+        `;
+    },
+
+    async verifyAudit() {
+        const el = document.getElementById('audit-verify');
+        if (!el) return;
+
+        try {
+            const v = await API.verifyAuditLog();
+            if (v.ok) {
+                el.innerHTML = v.entries
+                    ? `<span class="text-success">${Icons.check} Chain intact</span> · entries ${v.first_seq}–${v.last_seq} · head <code>${UI.escape(v.head.slice(0, 16))}</code>`
+                    : 'The audit log is empty';
+                if (v.warning) {
+                    el.innerHTML += ` · <span class="text-warning">${UI.escape(v.warning)}</span>`;
+                }
+            } else {
+                el.innerHTML = `<span class="text-danger">${Icons.x} ${UI.escape(v.error)}</span>`;
+            }
+        } catch (err) {
+            el.innerHTML = `<span class="text-danger">Verification failed: ${UI.escape(err.message)}</span>`;
+        }
+    },
+
+    renderSyslogStatus(status) {
+        if (!status || !status.enabled) return '';
+        const state = status.connected
+            ? '<span class="text-success">connected</span>'
+            : '<span class="text-danger">unreachable</span>';
+        const error = !status.connected && status.last_error ? ` (${UI.escape(status.last_error)})` : '';
+        return `<small class="form-hint">
+            ${state}${error} &middot; ${status.sent} sent, ${status.queued} queued, ${status.dropped} dropped
+        </small>`;
+    },
+
     async showSettings() {
         try {
-            const settings = await API.getLogSettings();
+            const [settings, stats] = await Promise.all([API.getLogSettings(), API.getLogStats()]);
+            const syslog = settings.syslog || {};
+            const level = (name, value) => `
+                <select class="form-control" name="${name}">
+                    ${['DEBUG', 'INFO', 'WARN', 'ERROR'].map((l, i) =>
+                        `<option value="${i}" ${value === i ? 'selected' : ''}>${l}</option>`).join('')}
+                </select>`;
             
             const content = `
                 <form id="log-settings-form">
@@ -356,12 +678,42 @@ const LogsTab = {
                     </div>
                     <div class="form-group">
                         <label class="form-label">Minimum Log Level</label>
-                        <select class="form-control" name="min_level">
-                            <option value="0" ${settings.min_level === 0 ? 'selected' : ''}>DEBUG</option>
-                            <option value="1" ${settings.min_level === 1 ? 'selected' : ''}>INFO</option>
-                            <option value="2" ${settings.min_level === 2 ? 'selected' : ''}>WARN</option>
-                            <option value="3" ${settings.min_level === 3 ? 'selected' : ''}>ERROR</option>
+                        ${level('min_level', settings.min_level)}
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label">Also Hide (one regular expression per line)</label>
+                        <textarea class="form-control" name="redact" rows="3"
+                            placeholder="serial=(\w+)">${UI.escape((settings.redact || []).join('\n'))}</textarea>
+                        <small class="form-hint">Passwords, keys and the values in env-secrets are always hidden. The first group, or the whole match, is replaced.</small>
+                    </div>
+
+                    <hr class="form-divider">
+
+                    <div class="form-group">
+                        <label class="toggle">
+                            <input type="checkbox" class="toggle-input" name="syslog_enabled" ${syslog.enabled ? 'checked' : ''}>
+                            <span class="toggle-track"><span class="toggle-thumb"></span></span>
+                            <span class="toggle-label">Forward to Remote Syslog</span>
+                        </label>
+                        ${this.renderSyslogStatus(stats.syslog)}
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label">Collector Address</label>
+                        <input type="text" class="form-control" name="syslog_address"
+                            value="${UI.escape(syslog.address || '')}" placeholder="logs.example.com:514">
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label">Protocol</label>
+                        <select class="form-control" name="syslog_protocol">
+                            ${['udp', 'tcp', 'tls'].map(p =>
+                                `<option value="${p}" ${(syslog.protocol || 'udp') === p ? 'selected' : ''}>${p.toUpperCase()}</option>`).join('')}
                         </select>
+                        <small class="form-hint">TLS checks the collector's certificate against the system CAs, or those given with --syslog-ca</small>
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label">Minimum Forwarded Level</label>
+                        ${level('syslog_min_level', syslog.min_level ?? 1)}
+                        <small class="form-hint">Entries are queued while the collector is unreachable</small>
                     </div>
                 </form>
             `;
@@ -380,7 +732,15 @@ const LogsTab = {
                             log_output: form.querySelector('[name="log_output"]').checked,
                             max_entries: parseInt(form.querySelector('[name="max_entries"]').value),
                             max_output_len: parseInt(form.querySelector('[name="max_output_len"]').value),
-                            min_level: parseInt(form.querySelector('[name="min_level"]').value)
+                            min_level: parseInt(form.querySelector('[name="min_level"]').value),
+                            redact: form.querySelector('[name="redact"]').value
+                                .split('\n').map(p => p.trim()).filter(Boolean),
+                            syslog: {
+                                enabled: form.querySelector('[name="syslog_enabled"]').checked,
+                                address: form.querySelector('[name="syslog_address"]').value.trim(),
+                                protocol: form.querySelector('[name="syslog_protocol"]').value,
+                                min_level: parseInt(form.querySelector('[name="syslog_min_level"]').value)
+                            }
                         };
 
                         try {
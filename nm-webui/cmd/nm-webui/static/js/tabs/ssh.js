@@ -2,6 +2,7 @@
  * SSH Tab Module - Manages SSH keys and tunnels
  */
 import { API, UI, Icons, registerTab } from '../app.js';
+import Auth from '../auth.js';
 
 const SSHTab = {
     id: 'ssh',
@@ -9,6 +10,7 @@ const SSHTab = {
     iconName: 'key',
     keys: [],
     tunnels: [],
+    phoneHome: null,
     keysLoaded: false,
     tunnelsLoaded: false,
     autoRefreshInterval: null,
@@ -34,6 +36,7 @@ const SSHTab = {
         if (!this.keysLoaded || !this.tunnelsLoaded) {
             this.loadKeys();
             this.loadTunnels();
+            this.loadPhoneHome();
         }
         this.startAutoRefresh();
     },
@@ -70,6 +73,12 @@ const SSHTab = {
                 this.handleTunnelAction(action, id);
                 return;
             }
+
+            const phoneAction = e.target.closest('[data-phone-action]');
+            if (phoneAction) {
+                this.handlePhoneHomeAction(phoneAction.dataset.phoneAction);
+                return;
+            }
         });
 
         document.addEventListener('change', (e) => {
@@ -86,6 +95,7 @@ const SSHTab = {
                     <div class="ssh-tabs">
                         <button class="ssh-tab-btn active" data-ssh-tab="tunnels">${Icons.link} Tunnels</button>
                         <button class="ssh-tab-btn" data-ssh-tab="keys">${Icons.key} Keys</button>
+                        ${Auth.can('operator') ? `<button class="ssh-tab-btn" data-ssh-tab="phonehome">${Icons.globe} Phone Home</button>` : ''}
                     </div>
                     <div class="card-actions" id="ssh-tunnels-actions">
                         <button class="btn btn-sm" data-tunnel-action="refresh">${Icons.refresh} Refresh</button>
@@ -99,6 +109,11 @@ const SSHTab = {
                         </label>
                         <button class="btn btn-sm btn-primary" data-key-action="generate">${Icons.plus} Generate</button>
                     </div>
+                    <div class="card-actions" id="ssh-phonehome-actions" style="display: none;">
+                        <button class="btn btn-sm" data-phone-action="refresh">${Icons.refresh} Refresh</button>
+                        <button class="btn btn-sm" data-phone-action="reconnect">${Icons.rotateCw} Reconnect</button>
+                        <button class="btn btn-sm btn-primary" data-phone-action="configure">${Icons.settings} Configure</button>
+                    </div>
                 </div>
                 <div class="card-body">
                     <div id="ssh-tunnels-panel">
@@ -111,6 +126,11 @@ const SSHTab = {
                             ${UI.loading('Loading keys...')}
                         </div>
                     </div>
+                    <div id="ssh-phonehome-panel" style="display: none;">
+                        <div id="phonehome-status">
+                            ${UI.loading('Loading phone-home status...')}
+                        </div>
+                    </div>
                 </div>
             </div>
         `;
@@ -121,10 +141,10 @@ const SSHTab = {
             btn.classList.toggle('active', btn.dataset.sshTab === tab);
         });
 
-        document.getElementById('ssh-tunnels-panel').style.display = tab === 'tunnels' ? '' : 'none';
-        document.getElementById('ssh-keys-panel').style.display = tab === 'keys' ? '' : 'none';
-        document.getElementById('ssh-tunnels-actions').style.display = tab === 'tunnels' ? '' : 'none';
-        document.getElementById('ssh-keys-actions').style.display = tab === 'keys' ? '' : 'none';
+        ['tunnels', 'keys', 'phonehome'].forEach(name => {
+            document.getElementById(`ssh-${name}-panel`).style.display = tab === name ? '' : 'none';
+            document.getElementById(`ssh-${name}-actions`).style.display = tab === name ? '' : 'none';
+        });
     },
 
     // ========== Keys ==========
@@ -142,6 +162,7 @@ const SSHTab = {
             this.keys = data.keys || [];
             this.keysLoaded = true;
             container.innerHTML = this.renderKeys();
+            this.warnExpiringCerts();
         } catch (err) {
             container.innerHTML = `<div class="state-message state-error">Error: ${UI.escape(err.message)}</div>`;
             UI.error('Failed to load keys: ' + err.message);
@@ -161,11 +182,31 @@ const SSHTab = {
                         ${UI.escape(key.name)}
                     </div>
                     <div class="list-item-meta">
-                        <span class="badge">${UI.escape(key.type || 'unknown')}</span>
+                        <span class="badge">${UI.escape(key.type || 'unknown')}${key.bits ? ' ' + key.bits : ''}</span>
                         ${key.has_pub_key ? '<span class="badge badge-success">Has .pub</span>' : ''}
+                        ${key.encrypted ? `<span class="badge ${key.unlocked ? 'badge-success' : 'badge-warning'}">${key.unlocked ? 'Unlocked' : 'Locked'}</span>` : ''}
                     </div>
+                    ${key.fingerprint ? `<div class="list-item-meta"><span class="tunnel-mapping">${UI.escape(key.fingerprint)}</span></div>` : ''}
+                    ${key.certificate ? `
+                        <div class="list-item-meta">
+                            ${this.certBadge(key.certificate)}
+                            <span class="text-muted">${UI.escape(key.certificate.principals.join(', ') || 'any principal')}</span>
+                        </div>
+                    ` : ''}
                 </div>
                 <div class="list-item-actions">
+                    <button class="btn btn-sm" data-key-action="cert" data-name="${UI.escape(key.name)}" title="Certificate">
+                        ${Icons.shield}
+                    </button>
+                    ${key.encrypted ? (key.unlocked ? `
+                        <button class="btn btn-sm" data-key-action="lock" data-name="${UI.escape(key.name)}" title="Forget passphrase">
+                            ${Icons.lock}
+                        </button>
+                    ` : `
+                        <button class="btn btn-sm btn-warning" data-key-action="unlock" data-name="${UI.escape(key.name)}" title="Unlock with passphrase">
+                            ${Icons.unlock}
+                        </button>
+                    `) : ''}
                     ${key.has_pub_key ? `
                         <button class="btn btn-sm" data-key-action="download" data-name="${UI.escape(key.name)}" title="Download public key">
                             ${Icons.download}
@@ -199,18 +240,36 @@ const SSHTab = {
             case 'copy':
                 await this.copyPublicKey(name);
                 break;
+            case 'unlock':
+                await this.unlockKey(name);
+                break;
+            case 'cert':
+                this.showCertModal(name);
+                break;
+            case 'lock':
+                await this.lockKey(name);
+                break;
         }
     },
 
-    async uploadKey(file) {
+    async uploadKey(file, passphrase = '') {
         if (!file) return;
 
         UI.showSpinner('Uploading key...');
         try {
-            await API.uploadSSHKey(file);
-            UI.success('Key uploaded: ' + file.name);
+            const key = await API.uploadSSHKey(file, passphrase);
+            UI.success('Key uploaded: ' + key.name);
             await this.loadKeys(true);
         } catch (err) {
+            if (err.message === 'Passphrase required') {
+                // Encrypted .ppk/PKCS#8 keys must be decrypted to be converted
+                UI.hideSpinner();
+                const retry = prompt(`"${file.name}" is encrypted. Enter its passphrase:`);
+                if (retry) {
+                    await this.uploadKey(file, retry);
+                }
+                return;
+            }
             UI.error('Upload failed: ' + err.message);
         } finally {
             UI.hideSpinner();
@@ -219,6 +278,124 @@ const SSHTab = {
         }
     },
 
+    certBadge(cert) {
+        switch (cert.status) {
+            case 'expired':
+                return '<span class="badge badge-danger">Cert expired</span>';
+            case 'not_yet_valid':
+                return '<span class="badge badge-warning">Cert not yet valid</span>';
+            case 'expiring':
+                return `<span class="badge badge-warning">Cert expires in ${this.formatDuration(cert.expires_in)}</span>`;
+            default:
+                return cert.valid_before
+                    ? `<span class="badge badge-success">Cert valid for ${this.formatDuration(cert.expires_in)}</span>`
+                    : '<span class="badge badge-success">Cert</span>';
+        }
+    },
+
+    warnExpiringCerts() {
+        this.certWarned = this.certWarned || {};
+        this.keys.forEach(key => {
+            const cert = key.certificate;
+            if (!cert || !['expiring', 'expired'].includes(cert.status)) return;
+            const id = `${key.name}:${cert.valid_before}:${cert.status}`;
+            if (this.certWarned[id]) return;
+            this.certWarned[id] = true;
+            UI.warning(cert.status === 'expired'
+                ? `Certificate for "${key.name}" has expired - tunnels using it cannot connect`
+                : `Certificate for "${key.name}" expires in ${this.formatDuration(cert.expires_in)}`);
+        });
+    },
+
+    showCertModal(name) {
+        const key = this.keys.find(k => k.name === name);
+        const cert = key?.certificate;
+        const formatTime = (t) => t ? new Date(t).toLocaleString() : 'forever';
+
+        UI.modal({
+            title: `Certificate: ${name}`,
+            content: cert ? `
+                <div class="form-stack">
+                    <div>${this.certBadge(cert)}</div>
+                    <p><strong>Key ID:</strong> ${UI.escape(cert.key_id)} (serial ${cert.serial})</p>
+                    <p><strong>Principals:</strong> ${UI.escape(cert.principals.join(', ') || 'any')}</p>
+                    <p><strong>Valid:</strong> ${UI.escape(formatTime(cert.valid_after))} → ${UI.escape(formatTime(cert.valid_before))}</p>
+                    <p><strong>CA:</strong> <code>${UI.escape(cert.ca_fingerprint)}</code></p>
+                    <div class="form-group">
+                        <label for="cert-file">Replace with a renewed certificate</label>
+                        <input type="file" id="cert-file" class="input" accept=".pub,text/plain">
+                    </div>
+                </div>
+            ` : `
+                <div class="form-stack">
+                    <p>Upload an OpenSSH user certificate (<code>${UI.escape(name)}-cert.pub</code>) signed for this key. Tunnels using the key will authenticate with it.</p>
+                    <div class="form-group">
+                        <input type="file" id="cert-file" class="input" accept=".pub,text/plain">
+                    </div>
+                </div>
+            `,
+            buttons: [
+                ...(cert ? [{ text: 'Remove', className: 'btn btn-danger', action: () => this.deleteCert(name) }] : []),
+                { text: 'Cancel', className: 'btn' },
+                { text: 'Upload', className: 'btn btn-primary', action: () => this.submitCert(name) }
+            ]
+        });
+    },
+
+    async submitCert(name) {
+        const file = document.getElementById('cert-file')?.files[0];
+        if (!file) {
+            UI.error('Choose a certificate file');
+            return;
+        }
+
+        try {
+            const cert = await API.uploadSSHCert(name, file);
+            UI.closeModal();
+            UI.success(`Certificate ${cert.key_id} installed for ${name}`);
+            await this.loadKeys(true);
+        } catch (err) {
+            UI.error('Upload failed: ' + err.message);
+        }
+    },
+
+    async deleteCert(name) {
+        try {
+            await API.deleteSSHCert(name);
+            UI.closeModal();
+            UI.success('Certificate removed');
+            await this.loadKeys(true);
+        } catch (err) {
+            UI.error('Remove failed: ' + err.message);
+        }
+    },
+
+    async unlockKey(name) {
+        const passphrase = prompt(`Passphrase for "${name}":`);
+        if (!passphrase) return;
+
+        UI.showSpinner('Unlocking...');
+        try {
+            await API.unlockSSHKey(name, passphrase);
+            UI.success('Key unlocked until the service restarts');
+            await this.loadKeys(true);
+        } catch (err) {
+            UI.error('Unlock failed: ' + err.message);
+        } finally {
+            UI.hideSpinner();
+        }
+    },
+
+    async lockKey(name) {
+        try {
+            await API.lockSSHKey(name);
+            UI.success('Key locked');
+            await this.loadKeys(true);
+        } catch (err) {
+            UI.error('Lock failed: ' + err.message);
+        }
+    },
+
     async deleteKey(name) {
         if (!confirm(`Delete key "${name}"? This cannot be undone.`)) return;
 
@@ -368,8 +545,9 @@ const SSHTab = {
 
         return this.tunnels.map(tunnel => {
             const isRunning = tunnel.status === 'running';
-            const statusIcon = isRunning ? Icons.checkCircle : Icons.circle;
-            const statusClass = isRunning ? 'text-success' : 'text-muted';
+            const isConnecting = tunnel.status === 'connecting';
+            const statusIcon = isRunning ? Icons.checkCircle : (isConnecting ? Icons.loader : Icons.circle);
+            const statusClass = isRunning ? 'text-success' : (isConnecting ? 'text-warning' : 'text-muted');
 
             let mapping = '';
             switch (tunnel.fwd) {
@@ -385,9 +563,15 @@ const SSHTab = {
             }
 
             const fwdTypeLabel = { L: 'Local', R: 'Remote', D: 'SOCKS' }[tunnel.fwd] || tunnel.fwd;
+            const cert = tunnel.auth === 'key' ? this.keys.find(k => k.name === tunnel.key)?.certificate : null;
+
+            const via = (tunnel.jumps || []).map(j => `${j.user}@${j.host}`);
+            if (tunnel.proxy) {
+                via.unshift(`${tunnel.proxy.type}://${tunnel.proxy.host}:${tunnel.proxy.port}`);
+            }
 
             return `
-                <div class="list-item ${isRunning ? '' : 'list-item-muted'}">
+                <div class="list-item ${isRunning || isConnecting ? '' : 'list-item-muted'}">
                     <div class="list-item-content">
                         <div class="list-item-title">
                             <span class="status-indicator ${statusClass}">${statusIcon}</span>
@@ -396,11 +580,17 @@ const SSHTab = {
                         <div class="list-item-meta">
                             <span class="badge">${fwdTypeLabel}</span>
                             <span class="tunnel-mapping">${mapping}</span>
-                            ${tunnel.pid ? `<span class="badge badge-muted">PID: ${tunnel.pid}</span>` : ''}
+                            ${via.length ? `<span class="badge badge-muted">via ${UI.escape(via.join(' → '))}</span>` : ''}
+                            ${cert && cert.status !== 'valid' ? this.certBadge(cert) : ''}
                         </div>
+                        ${tunnel.stats ? `<div class="list-item-meta">${this.renderTunnelStats(tunnel.stats)}</div>` : ''}
+                        ${tunnel.last_error && !isRunning ? `<div class="list-item-meta text-danger">${UI.escape(tunnel.last_error)}</div>` : ''}
                     </div>
                     <div class="list-item-actions">
-                        ${isRunning ? `
+                        <button class="btn btn-sm" data-tunnel-action="stats" data-id="${tunnel.id}" title="Statistics">
+                            ${Icons.activity}
+                        </button>
+                        ${isRunning || isConnecting ? `
                             <button class="btn btn-sm btn-warning" data-tunnel-action="stop" data-id="${tunnel.id}">
                                 ${Icons.stop} Stop
                             </button>
@@ -418,6 +608,90 @@ const SSHTab = {
         }).join('');
     },
 
+    renderTunnelStats(stats) {
+        const parts = [
+            `↓ ${UI.formatBytes(stats.bytes_in)}`,
+            `↑ ${UI.formatBytes(stats.bytes_out)}`,
+            `${stats.active_conns} active / ${stats.total_conns} total`
+        ];
+        if (stats.uptime_seconds) parts.push(`up ${this.formatDuration(stats.uptime_seconds)}`);
+        if (stats.keepalive_rtt_ms) parts.push(`RTT ${stats.keepalive_rtt_ms.toFixed(1)} ms`);
+        if (stats.reconnects) parts.push(`${stats.reconnects} reconnects`);
+        return `<span class="text-muted">${parts.map(p => UI.escape(p)).join(' · ')}</span>`;
+    },
+
+    formatDuration(seconds) {
+        const days = Math.floor(seconds / 86400);
+        const hours = Math.floor((seconds % 86400) / 3600);
+        const minutes = Math.floor((seconds % 3600) / 60);
+        if (days > 0) return `${days}d ${hours}h`;
+        if (hours > 0) return `${hours}h ${minutes}m`;
+        if (minutes > 0) return `${minutes}m`;
+        return `${seconds}s`;
+    },
+
+    async showTunnelStats(id) {
+        const tunnel = this.tunnels.find(t => t.id === id);
+        if (!tunnel) return;
+
+        let samples = [];
+        try {
+            const data = await API.getSSHTunnelHistory(id);
+            samples = data.samples || [];
+        } catch (err) {
+            UI.error('Failed to load history: ' + err.message);
+            return;
+        }
+
+        const stats = tunnel.stats || {};
+        const conns = stats.connections || [];
+        const connRows = conns.map(c => `
+            <tr>
+                <td><code>${UI.escape(c.peer)}</code></td>
+                <td><code>${UI.escape(c.dest)}</code></td>
+                <td>${UI.formatBytes(c.bytes_in)} / ${UI.formatBytes(c.bytes_out)}</td>
+                <td>${this.formatDuration(Math.max(0, Math.floor(Date.now() / 1000) - c.since))}</td>
+            </tr>
+        `).join('');
+
+        // Most recent samples first, limited to keep the modal readable
+        const historyRows = samples.slice(-30).reverse().map(s => `
+            <tr>
+                <td>${new Date(s.time * 1000).toLocaleTimeString()}</td>
+                <td>${UI.escape(s.status)}</td>
+                <td>${UI.formatBytes(Math.round(s.rate_in))}/s</td>
+                <td>${UI.formatBytes(Math.round(s.rate_out))}/s</td>
+                <td>${s.active_conns}</td>
+                <td>${s.keepalive_rtt_ms ? s.keepalive_rtt_ms.toFixed(1) + ' ms' : '—'}</td>
+            </tr>
+        `).join('');
+
+        UI.modal({
+            title: `Tunnel ${tunnel.user}@${tunnel.host}`,
+            width: '720px',
+            content: `
+                <p>${this.renderTunnelStats(stats)}</p>
+                <h4>Active Connections</h4>
+                ${conns.length ? `
+                    <table class="table">
+                        <thead><tr><th>Peer</th><th>Destination</th><th>In / Out</th><th>Age</th></tr></thead>
+                        <tbody>${connRows}</tbody>
+                    </table>
+                ` : '<div class="state-message">No active connections</div>'}
+                <h4>History</h4>
+                ${samples.length ? `
+                    <table class="table">
+                        <thead><tr><th>Time</th><th>Status</th><th>In</th><th>Out</th><th>Conns</th><th>RTT</th></tr></thead>
+                        <tbody>${historyRows}</tbody>
+                    </table>
+                ` : '<div class="state-message">No samples recorded yet</div>'}
+            `,
+            buttons: [
+                { text: 'Close', className: 'btn' }
+            ]
+        });
+    },
+
     async handleTunnelAction(action, id) {
         switch (action) {
             case 'refresh':
@@ -435,6 +709,15 @@ const SSHTab = {
             case 'delete':
                 await this.deleteTunnel(id);
                 break;
+            case 'stats':
+                await this.showTunnelStats(id);
+                break;
+            case 'add-jump':
+                this.addJumpRow();
+                break;
+            case 'remove-jump':
+                document.querySelector(`.tun-jump[data-jump="${id}"]`)?.remove();
+                break;
         }
     },
 
@@ -520,6 +803,47 @@ const SSHTab = {
                         <input type="password" id="tun-password" class="input">
                     </div>
                     
+                    <hr class="form-divider">
+
+                    <div class="form-group">
+                        <label>Jump Hosts</label>
+                        <div id="tun-jumps"></div>
+                        <button type="button" class="btn btn-sm" data-tunnel-action="add-jump">${Icons.plus} Add Jump Host</button>
+                        <small class="form-hint">Bastions are connected in order before the remote host</small>
+                    </div>
+
+                    <div class="form-group">
+                        <label for="tun-proxy-type">Upstream Proxy</label>
+                        <select id="tun-proxy-type" class="select">
+                            <option value="">None</option>
+                            <option value="http">HTTP CONNECT</option>
+                            <option value="socks5">SOCKS5</option>
+                        </select>
+                    </div>
+
+                    <div id="tun-proxy-fields" style="display: none;">
+                        <div class="form-row">
+                            <div class="form-group flex-2">
+                                <label for="tun-proxy-host">Proxy Host</label>
+                                <input type="text" id="tun-proxy-host" class="input" placeholder="127.0.0.1">
+                            </div>
+                            <div class="form-group flex-1">
+                                <label for="tun-proxy-port">Proxy Port</label>
+                                <input type="number" id="tun-proxy-port" class="input" min="1" max="65535">
+                            </div>
+                        </div>
+                        <div class="form-row">
+                            <div class="form-group flex-1">
+                                <label for="tun-proxy-user">Proxy User</label>
+                                <input type="text" id="tun-proxy-user" class="input" placeholder="optional">
+                            </div>
+                            <div class="form-group flex-1">
+                                <label for="tun-proxy-password">Proxy Password</label>
+                                <input type="password" id="tun-proxy-password" class="input">
+                            </div>
+                        </div>
+                    </div>
+
                     <hr class="form-divider">
                     
                     <div class="form-group">
@@ -569,10 +893,76 @@ const SSHTab = {
             document.getElementById('target-fields').style.display = 
                 e.target.value === 'D' ? 'none' : '';
         });
+
+        document.getElementById('tun-proxy-type').addEventListener('change', (e) => {
+            document.getElementById('tun-proxy-fields').style.display =
+                e.target.value ? '' : 'none';
+        });
+
+        this.jumpCounter = 0;
+    },
+
+    addJumpRow() {
+        const container = document.getElementById('tun-jumps');
+        if (!container) return;
+
+        const idx = ++this.jumpCounter;
+        const keyOptions = this.keys.length
+            ? this.keys.map(k => `<option value="${UI.escape(k.name)}">${UI.escape(k.name)}</option>`).join('')
+            : '<option value="">No keys available</option>';
+
+        const row = document.createElement('div');
+        row.className = 'tun-jump';
+        row.dataset.jump = idx;
+        row.innerHTML = `
+            <div class="form-row">
+                <div class="form-group flex-1">
+                    <input type="text" class="input" data-field="user" placeholder="user" value="root">
+                </div>
+                <div class="form-group flex-2">
+                    <input type="text" class="input" data-field="host" placeholder="bastion.example.com">
+                </div>
+                <div class="form-group flex-1">
+                    <input type="number" class="input" data-field="port" value="22" min="1" max="65535">
+                </div>
+            </div>
+            <div class="form-row">
+                <div class="form-group flex-1">
+                    <select class="select" data-field="auth">
+                        <option value="key">SSH Key</option>
+                        <option value="password">Password</option>
+                    </select>
+                </div>
+                <div class="form-group flex-2">
+                    <select class="select" data-field="key">${keyOptions}</select>
+                    <input type="password" class="input" data-field="password" placeholder="Password" style="display: none;">
+                </div>
+                <button type="button" class="btn btn-sm btn-danger" data-tunnel-action="remove-jump" data-id="${idx}">${Icons.trash}</button>
+            </div>
+        `;
+        row.querySelector('[data-field="auth"]').addEventListener('change', (e) => {
+            row.querySelector('[data-field="key"]').style.display = e.target.value === 'key' ? '' : 'none';
+            row.querySelector('[data-field="password"]').style.display = e.target.value === 'password' ? '' : 'none';
+        });
+        container.appendChild(row);
+    },
+
+    collectJumps() {
+        return Array.from(document.querySelectorAll('.tun-jump')).map(row => {
+            const field = name => row.querySelector(`[data-field="${name}"]`).value.trim();
+            const jump = {
+                host: field('host'),
+                port: parseInt(field('port')) || 22,
+                user: field('user'),
+                auth: field('auth')
+            };
+            if (jump.auth === 'key') { jump.key = field('key'); } else { jump.password = row.querySelector('[data-field="password"]').value; }
+            return jump;
+        });
     },
 
-    async populateKeySelect() {
-        const select = document.getElementById('tun-key');
+    async populateKeySelect(selectId = 'tun-key', selected = '') {
+        const select = document.getElementById(selectId);
         if (!select) return;
 
         try {
@@ -583,7 +973,7 @@ const SSHTab = {
                 select.innerHTML = '<option value="">No keys available - upload or generate one</option>';
             } else {
                 select.innerHTML = keys.map(k => 
-                    `<option value="${UI.escape(k.name)}">${UI.escape(k.name)}</option>`
+                    `<option value="${UI.escape(k.name)}" ${k.name === selected ? 'selected' : ''}>${UI.escape(k.name)}</option>`
                 ).join('');
             }
         } catch (err) {
@@ -610,8 +1000,26 @@ const SSHTab = {
         if (auth === 'password' && !password) { UI.error('Password is required'); return; }
         if (fwd !== 'D' && !rport) { UI.error('Target port is required for Local/Remote forwarding'); return; }
 
+        const jumps = this.collectJumps();
+        if (jumps.some(j => !j.host || !j.user)) { UI.error('Every jump host needs a host and user'); return; }
+
+        const proxyType = document.getElementById('tun-proxy-type').value;
+        const proxyHost = document.getElementById('tun-proxy-host').value.trim();
+        const proxyPort = parseInt(document.getElementById('tun-proxy-port').value) || 0;
+        if (proxyType && (!proxyHost || !proxyPort)) { UI.error('Proxy host and port are required'); return; }
+
         const config = { host, port, user, auth, fwd, lport, rhost, rport };
         if (auth === 'key') { config.key = key; } else { config.password = password; }
+        if (jumps.length) { config.jumps = jumps; }
+        if (proxyType) {
+            config.proxy = {
+                type: proxyType,
+                host: proxyHost,
+                port: proxyPort,
+                user: document.getElementById('tun-proxy-user').value.trim(),
+                password: document.getElementById('tun-proxy-password').value
+            };
+        }
 
         UI.closeModal();
         UI.showSpinner('Creating tunnel...');
@@ -627,12 +1035,206 @@ const SSHTab = {
         }
     },
 
+    // ========== Phone Home ==========
+
+    async loadPhoneHome() {
+        const container = document.getElementById('phonehome-status');
+        // The phone-home target, user and key are not shown to viewers
+        if (!container || !Auth.can('operator')) return;
+
+        try {
+            this.phoneHome = await API.getPhoneHome();
+            container.innerHTML = this.renderPhoneHome();
+        } catch (err) {
+            container.innerHTML = `<div class="state-message state-error">Error: ${UI.escape(err.message)}</div>`;
+        }
+    },
+
+    renderPhoneHome() {
+        const { config, status } = this.phoneHome;
+        if (!config.host) {
+            return '<div class="state-message">Phone-home is not configured. Click "Configure" to keep a reverse channel to your server.</div>';
+        }
+
+        const isConnected = status.state === 'connected';
+        const isConnecting = status.state === 'connecting';
+        const statusIcon = isConnected ? Icons.checkCircle : (isConnecting ? Icons.loader : Icons.circle);
+        const statusClass = isConnected ? 'text-success' : (isConnecting ? 'text-warning' : 'text-muted');
+
+        const uplinks = (status.uplinks || []).map(u => `
+            <div class="list-item">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        <span class="status-indicator ${u.last_error ? 'text-danger' : 'text-success'}">${u.last_error ? Icons.x : Icons.check}</span>
+                        ${UI.escape(u.name)}
+                        ${status.uplink === u.name ? '<span class="badge badge-success">active</span>' : ''}
+                    </div>
+                    ${u.last_error ? `<div class="list-item-meta text-danger">${UI.escape(u.last_error)}</div>` : ''}
+                </div>
+            </div>
+        `).join('');
+
+        return `
+            <div class="list-item ${isConnected || isConnecting ? '' : 'list-item-muted'}">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        <span class="status-indicator ${statusClass}">${statusIcon}</span>
+                        ${UI.escape(config.user)}@${UI.escape(config.host)}:${config.port}
+                        <span class="badge">${UI.escape(status.state)}</span>
+                        ${status.uplink ? `<span class="badge badge-muted">via ${UI.escape(status.uplink)}</span>` : ''}
+                    </div>
+                    ${(status.forwards || []).map(f => `<div class="list-item-meta"><span class="tunnel-mapping">${UI.escape(f)}</span></div>`).join('')}
+                    ${config.host_key ? `<div class="list-item-meta text-muted">Host key ${UI.escape(config.host_key)}</div>` : ''}
+                    ${status.stats ? `<div class="list-item-meta">${this.renderTunnelStats(status.stats)}</div>` : ''}
+                    ${status.last_error ? `<div class="list-item-meta text-danger">${UI.escape(status.last_error)}</div>` : ''}
+                </div>
+            </div>
+            ${uplinks ? `<h4>Uplinks</h4><div class="list-group">${uplinks}</div>` : ''}
+        `;
+    },
+
+    async handlePhoneHomeAction(action) {
+        switch (action) {
+            case 'refresh':
+                await this.loadPhoneHome();
+                break;
+            case 'configure':
+                this.showPhoneHomeModal();
+                break;
+            case 'reconnect':
+                UI.showSpinner('Reconnecting...');
+                try {
+                    await API.reconnectPhoneHome();
+                    UI.success('Reconnecting phone-home channel');
+                    await this.loadPhoneHome();
+                } catch (err) {
+                    UI.error('Reconnect failed: ' + err.message);
+                } finally {
+                    UI.hideSpinner();
+                }
+                break;
+        }
+    },
+
+    showPhoneHomeModal() {
+        const config = (this.phoneHome && this.phoneHome.config) || {};
+
+        UI.modal({
+            title: 'Configure Phone Home',
+            content: `
+                <form id="phonehome-form" class="form-stack">
+                    <div class="form-group">
+                        <label class="checkbox-label">
+                            <input type="checkbox" id="ph-enabled" ${config.enabled ? 'checked' : ''}>
+                            Keep the channel connected
+                        </label>
+                    </div>
+                    <div class="form-row">
+                        <div class="form-group flex-2">
+                            <label for="ph-host">Rendezvous Server</label>
+                            <input type="text" id="ph-host" class="input" placeholder="example.com" value="${UI.escape(config.host || '')}">
+                        </div>
+                        <div class="form-group flex-1">
+                            <label for="ph-port">SSH Port</label>
+                            <input type="number" id="ph-port" class="input" min="1" max="65535" value="${config.port || 22}">
+                        </div>
+                    </div>
+                    <div class="form-row">
+                        <div class="form-group flex-1">
+                            <label for="ph-user">SSH User</label>
+                            <input type="text" id="ph-user" class="input" value="${UI.escape(config.user || '')}">
+                        </div>
+                        <div class="form-group flex-1">
+                            <label for="ph-key">Key File</label>
+                            <select id="ph-key" class="select"></select>
+                        </div>
+                    </div>
+
+                    <hr class="form-divider">
+
+                    <div class="form-row">
+                        <div class="form-group flex-1">
+                            <label for="ph-web-port">Web UI Port</label>
+                            <input type="number" id="ph-web-port" class="input" min="0" max="65535" value="${config.web_port || ''}" placeholder="off">
+                        </div>
+                        <div class="form-group flex-1">
+                            <label for="ph-ssh-port">SSH Port</label>
+                            <input type="number" id="ph-ssh-port" class="input" min="0" max="65535" value="${config.ssh_port || ''}" placeholder="off">
+                        </div>
+                        <div class="form-group flex-1">
+                            <label for="ph-local-ssh-port">Local sshd</label>
+                            <input type="number" id="ph-local-ssh-port" class="input" min="1" max="65535" value="${config.local_ssh_port || 22}">
+                        </div>
+                    </div>
+                    <small class="form-hint">Ports opened on the server and forwarded back to this device</small>
+                    <div class="form-group">
+                        <label for="ph-remote-bind">Server Bind Address</label>
+                        <input type="text" id="ph-remote-bind" class="input" value="${UI.escape(config.remote_bind || '127.0.0.1')}">
+                        <small class="form-hint">127.0.0.1 keeps the ports reachable only from the server itself</small>
+                    </div>
+                    <div class="form-group">
+                        <label for="ph-uplinks">Uplinks</label>
+                        <input type="text" id="ph-uplinks" class="input" placeholder="all interfaces" value="${UI.escape((config.uplinks || []).join(', '))}">
+                        <small class="form-hint">Interfaces to try in order, e.g. <code>default, wlan0, dns0, tun0</code>. Leave empty to try every uplink.</small>
+                    </div>
+                    <div class="form-group">
+                        <label for="ph-host-key">Pinned Host Key</label>
+                        <input type="text" id="ph-host-key" class="input" placeholder="learned on first connect" value="${UI.escape(config.host_key || '')}">
+                    </div>
+                </form>
+            `,
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                { text: 'Save', className: 'btn btn-primary', action: () => this.submitPhoneHome() }
+            ]
+        });
+
+        this.populateKeySelect('ph-key', config.key);
+    },
+
+    async submitPhoneHome() {
+        const value = id => document.getElementById(id).value.trim();
+        const config = {
+            enabled: document.getElementById('ph-enabled').checked,
+            host: value('ph-host'),
+            port: parseInt(value('ph-port')) || 22,
+            user: value('ph-user'),
+            key: value('ph-key'),
+            web_port: parseInt(value('ph-web-port')) || 0,
+            ssh_port: parseInt(value('ph-ssh-port')) || 0,
+            local_ssh_port: parseInt(value('ph-local-ssh-port')) || 22,
+            remote_bind: value('ph-remote-bind'),
+            uplinks: value('ph-uplinks').split(/[\s,]+/).filter(Boolean),
+            host_key: value('ph-host-key')
+        };
+
+        if (config.enabled) {
+            if (!config.host || !config.user) { UI.error('Server and user are required'); return; }
+            if (!config.key) { UI.error('Please select a key file'); return; }
+            if (!config.web_port && !config.ssh_port) { UI.error('Forward at least the web UI or SSH port'); return; }
+        }
+
+        UI.closeModal();
+        UI.showSpinner('Saving...');
+
+        try {
+            await API.configurePhoneHome(config);
+            UI.success(config.enabled ? 'Phone-home enabled' : 'Phone-home saved');
+            await this.loadPhoneHome();
+        } catch (err) {
+            UI.error('Failed to configure phone-home: ' + err.message);
+        } finally {
+            UI.hideSpinner();
+        }
+    },
+
     startAutoRefresh() {
         this.stopAutoRefresh();
         this.autoRefreshInterval = setInterval(() => {
             const sshTab = document.getElementById('ssh-tab');
             if (sshTab && !sshTab.hidden) {
                 this.loadTunnels(false);
+                this.loadPhoneHome();
             }
         }, 10000);
     },
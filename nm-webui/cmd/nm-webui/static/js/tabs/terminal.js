@@ -1,19 +1,24 @@
 /**
  * Terminal Tab Module
- * Embeds Shell In A Box (shellinabox) in an iframe
+ * PTY-backed shells served by nm-webui over WebSocket, and replay of
+ * recorded sessions
  */
-import { UI, Icons, registerTab } from '../app.js';
+import { API, UI, Icons, registerTab } from '../app.js';
+import VTerminal from '../vt.js';
 
 const TerminalTab = {
     id: 'terminal',
     label: 'Terminal',
     iconName: 'terminal',
-    terminalUrl: null,
+    role: 'admin',
     eventsBound: false,
+    sessions: [],
+    activeSession: null,
+    nextSessionNum: 1,
+    resizeObserver: null,
 
     init() {
-        const host = window.location.hostname;
-        this.terminalUrl = `https://${host}:4200`;
+        // Sessions are opened on first activation
     },
 
     render() {
@@ -22,38 +27,23 @@ const TerminalTab = {
                 <div class="card-header">
                     <span class="card-title">${Icons.terminal} Web Terminal</span>
                     <div class="card-actions">
-                        <button class="btn btn-sm" id="terminal-refresh" title="Refresh Terminal">
-                            ${Icons.refresh} Refresh
+                        <div class="terminal-session-tabs" id="terminal-session-tabs"></div>
+                        <button class="btn btn-sm" id="terminal-new" title="New Session">
+                            ${Icons.plus} New
                         </button>
-                        <button class="btn btn-sm" id="terminal-popout" title="Open in New Window">
-                            ${Icons.externalLink} Pop Out
+                        <button class="btn btn-sm" id="terminal-sessions" title="Open Sessions">
+                            ${Icons.activity}
+                        </button>
+                        <button class="btn btn-sm" id="terminal-recordings" title="Recordings">
+                            ${Icons.play} Recordings
                         </button>
                     </div>
                 </div>
-                <div class="card-body terminal-container">
-                    <iframe 
-                        id="terminal-iframe" 
-                        src="" 
-                        loading="lazy"
-                        allow="clipboard-read; clipboard-write"
-                    ></iframe>
-                    <div class="terminal-overlay" id="terminal-overlay">
-                        <div class="terminal-message">
-                            <div class="terminal-icon">${Icons.terminal}</div>
-                            <h3>Web Terminal</h3>
-                            <p>Shell In A Box uses HTTPS with a self-signed certificate.</p>
-                            <p class="terminal-hint" style="margin-bottom: var(--space-md);">
-                                First, click below to accept the certificate, then return here.
-                            </p>
-                            <div style="display: flex; gap: var(--space-sm); justify-content: center; flex-wrap: wrap;">
-                                <a class="btn btn-primary" id="terminal-accept" href="" target="_blank">
-                                    ${Icons.lock} Accept Certificate
-                                </a>
-                                <button class="btn btn-success" id="terminal-connect">
-                                    ${Icons.play} Connect
-                                </button>
-                            </div>
-                        </div>
+                <div class="card-body terminal-container" id="terminal-panes">
+                    <div class="terminal-message" id="terminal-empty" style="display: none;">
+                        <div class="terminal-icon">${Icons.terminal}</div>
+                        <h3>No open sessions</h3>
+                        <p>Click <strong>New</strong> to start a shell.</p>
                     </div>
                 </div>
             </div>
@@ -62,13 +52,15 @@ const TerminalTab = {
 
     onActivate() {
         this.bindEvents();
+        if (!this.sessions.length) {
+            this.newSession();
+        } else {
+            this.showSession(this.activeSession);
+        }
     },
 
     onDeactivate() {
-        const iframe = document.getElementById('terminal-iframe');
-        if (iframe) {
-            iframe.src = '';
-        }
+        // Sessions keep running in the background until closed
     },
 
     bindEvents() {
@@ -77,52 +69,349 @@ const TerminalTab = {
         }
         this.eventsBound = true;
 
-        const acceptLink = document.getElementById('terminal-accept');
-        if (acceptLink) {
-            acceptLink.href = this.terminalUrl;
-        }
+        document.getElementById('terminal-new')?.addEventListener('click', () => this.newSession());
+        document.getElementById('terminal-sessions')?.addEventListener('click', () => this.showSessionsModal());
+        document.getElementById('terminal-recordings')?.addEventListener('click', () => this.showRecordingsModal());
+
+        document.getElementById('terminal-session-tabs')?.addEventListener('click', (e) => {
+            const btn = e.target.closest('button');
+            const session = this.sessions.find(s => String(s.num) === btn?.dataset.session);
+            if (!session) return;
+            if (btn.dataset.action === 'close') {
+                this.closeSession(session);
+            } else {
+                this.showSession(session);
+            }
+        });
 
-        document.getElementById('terminal-connect')?.addEventListener('click', () => this.connect());
-        document.getElementById('terminal-refresh')?.addEventListener('click', () => this.refresh());
-        document.getElementById('terminal-popout')?.addEventListener('click', () => this.popout());
+        const panes = document.getElementById('terminal-panes');
+        this.resizeObserver = new ResizeObserver(() => this.activeSession?.term.fit());
+        this.resizeObserver.observe(panes);
     },
 
-    connect() {
-        const iframe = document.getElementById('terminal-iframe');
-        const overlay = document.getElementById('terminal-overlay');
-        
-        if (iframe && this.terminalUrl) {
-            iframe.src = this.terminalUrl;
-            
-            setTimeout(() => {
-                if (overlay) {
-                    overlay.style.display = 'none';
-                }
-            }, 1000);
+    newSession() {
+        const panes = document.getElementById('terminal-panes');
+        const el = document.createElement('div');
+        el.className = 'terminal-pane';
+        panes.appendChild(el);
+
+        const session = {
+            num: this.nextSessionNum++,
+            el,
+            ws: null,
+            open: false,
+            decoder: new TextDecoder(),
+            encoder: new TextEncoder()
+        };
+        session.term = new VTerminal(el, {
+            onData: (data) => {
+                if (session.open) session.ws.send(session.encoder.encode(data));
+            },
+            onResize: (cols, rows) => {
+                if (session.open) session.ws.send(JSON.stringify({ type: 'resize', cols, rows }));
+            }
+        });
 
-            iframe.onerror = () => {
-                if (overlay) {
-                    overlay.style.display = 'flex';
+        this.sessions.push(session);
+        this.showSession(session);
+        this.connect(session);
+    },
+
+    connect(session) {
+        const { term } = session;
+        const proto = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        const url = `${proto}//${window.location.host}/api/terminal/ws?cols=${term.cols}&rows=${term.rows}`;
+
+        const ws = new WebSocket(url);
+        ws.binaryType = 'arraybuffer';
+        session.ws = ws;
+
+        ws.onopen = () => {
+            session.open = true;
+            this.renderSessionTabs();
+        };
+
+        ws.onmessage = (e) => {
+            if (typeof e.data !== 'string') {
+                term.write(session.decoder.decode(e.data, { stream: true }));
+                return;
+            }
+            try {
+                const msg = JSON.parse(e.data);
+                if (msg.type === 'exit') {
+                    term.write(`\r\n[Process exited with code ${msg.code}]\r\n`);
+                } else if (msg.type === 'closed') {
+                    term.write(`\r\n[Session closed: ${msg.reason}]\r\n`);
                 }
-                UI.error('Failed to connect to terminal service');
-            };
+            } catch (err) {
+                console.error('Invalid terminal message:', err);
+            }
+        };
+
+        ws.onclose = () => {
+            if (!session.open) {
+                term.write('[Could not start a session - the maximum number may be open]\r\n');
+            }
+            session.open = false;
+            session.ended = true;
+            this.renderSessionTabs();
+        };
+    },
+
+    showSession(session) {
+        if (!session) {
+            this.renderSessionTabs();
+            return;
         }
+        this.activeSession = session;
+        this.sessions.forEach(s => {
+            s.el.style.display = s === session ? '' : 'none';
+        });
+        this.renderSessionTabs();
+        session.term.fit();
+        session.term.focus();
     },
 
-    refresh() {
-        const iframe = document.getElementById('terminal-iframe');
-        if (iframe && iframe.src) {
-            iframe.src = iframe.src;
-            UI.success('Terminal refreshed');
-        } else {
-            this.connect();
+    closeSession(session) {
+        if (session.ws && session.ws.readyState <= WebSocket.OPEN) {
+            session.ws.close();
+        }
+        session.term.dispose();
+        session.el.remove();
+        this.sessions = this.sessions.filter(s => s !== session);
+        if (this.activeSession === session) {
+            this.activeSession = this.sessions[this.sessions.length - 1] || null;
+        }
+        this.showSession(this.activeSession);
+    },
+
+    renderSessionTabs() {
+        const tabs = document.getElementById('terminal-session-tabs');
+        const empty = document.getElementById('terminal-empty');
+        if (!tabs) return;
+
+        if (empty) {
+            empty.style.display = this.sessions.length ? 'none' : '';
+        }
+        tabs.innerHTML = this.sessions.map(s => `
+            <span class="terminal-session-tab ${s === this.activeSession ? 'active' : ''} ${s.ended ? 'ended' : ''}">
+                <button class="btn btn-sm" data-session="${s.num}">${s.ended ? Icons.x : Icons.terminal} ${s.num}</button>
+                <button class="btn btn-sm" data-session="${s.num}" data-action="close" title="Close session">&times;</button>
+            </span>
+        `).join('');
+    },
+
+    async showSessionsModal() {
+        let sessions = [];
+        try {
+            sessions = await API.getTerminalSessions();
+        } catch (err) {
+            UI.error('Failed to load sessions: ' + err.message);
+            return;
+        }
+
+        const rows = sessions.map(s => `
+            <div class="list-item">
+                <div class="list-item-content">
+                    <div class="list-item-title">${Icons.terminal} ${UI.escape(s.user)} <span class="text-muted">${UI.escape(s.remote)}</span></div>
+                    <div class="list-item-meta">
+                        <span class="text-muted">Started ${UI.escape(new Date(s.started).toLocaleString())} · last input ${UI.escape(new Date(s.last_active).toLocaleTimeString())} · ${s.cols}×${s.rows}</span>
+                    </div>
+                </div>
+                <div class="list-item-actions">
+                    <button class="btn btn-sm btn-danger" data-terminal-close="${UI.escape(s.id)}" title="Close session">${Icons.x}</button>
+                </div>
+            </div>
+        `).join('');
+
+        const { overlay } = UI.modal({
+            title: 'Open Terminal Sessions',
+            content: rows || UI.empty('No open sessions'),
+            width: '560px',
+            buttons: [{ text: 'Close', className: 'btn' }]
+        });
+
+        overlay.addEventListener('click', async (e) => {
+            const id = e.target.closest('[data-terminal-close]')?.dataset.terminalClose;
+            if (!id) return;
+            try {
+                await API.closeTerminalSession(id);
+                e.target.closest('.list-item').remove();
+                UI.success('Session closed');
+            } catch (err) {
+                UI.error('Failed to close session: ' + err.message);
+            }
+        });
+    },
+
+    // ---------- Recordings ----------
+
+    async showRecordingsModal() {
+        let recordings = [];
+        try {
+            recordings = await API.getTerminalRecordings();
+        } catch (err) {
+            UI.error('Failed to load recordings: ' + err.message);
+            return;
         }
+
+        const rows = recordings.map(r => `
+            <div class="list-item" data-recording="${UI.escape(r.id)}">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        ${Icons.terminal} ${UI.escape(new Date(r.started).toLocaleString())}
+                        ${r.active ? '<span class="badge badge-success">Live</span>' : ''}
+                    </div>
+                    <div class="list-item-meta">
+                        <span class="text-muted">
+                            ${UI.escape(r.auth_user || 'anonymous')} from ${UI.escape(r.remote)} as ${UI.escape(r.user)}
+                            · ${this.formatDuration(r.duration)} · ${UI.formatBytes(r.size)}
+                        </span>
+                    </div>
+                </div>
+                <div class="list-item-actions">
+                    <button class="btn btn-sm" data-action="play" title="Replay">${Icons.play}</button>
+                    <button class="btn btn-sm" data-action="download" title="Download (.cast)">${Icons.download}</button>
+                </div>
+            </div>
+        `).join('');
+
+        const { overlay, close } = UI.modal({
+            title: 'Terminal Recordings',
+            content: rows || UI.empty('No recordings'),
+            width: '640px',
+            buttons: [{ text: 'Close', className: 'btn' }]
+        });
+
+        overlay.addEventListener('click', async (e) => {
+            const btn = e.target.closest('[data-action]');
+            const item = e.target.closest('[data-recording]');
+            if (!btn || !item) return;
+            const rec = recordings.find(r => r.id === item.dataset.recording);
+
+            if (btn.dataset.action === 'play') {
+                close();
+                this.playRecording(rec);
+            } else if (btn.dataset.action === 'download') {
+                const a = document.createElement('a');
+                a.href = API.getTerminalRecordingDownloadUrl(rec.id);
+                a.download = rec.id + '.cast';
+                document.body.appendChild(a);
+                a.click();
+                document.body.removeChild(a);
+            }
+        });
     },
 
-    popout() {
-        if (this.terminalUrl) {
-            window.open(this.terminalUrl, '_blank', 'width=800,height=600');
+    async playRecording(rec) {
+        let header, events;
+        try {
+            const lines = (await API.getTerminalRecording(rec.id)).split('\n').filter(l => l.trim());
+            header = JSON.parse(lines[0]);
+            events = lines.slice(1).map(l => {
+                try { return JSON.parse(l); } catch { return null; }
+            }).filter(ev => Array.isArray(ev));
+        } catch (err) {
+            UI.error('Failed to load recording: ' + err.message);
+            return;
         }
+
+        const content = `
+            <div class="terminal-player-controls">
+                <button class="btn btn-sm" data-player="toggle">${Icons.stop} Pause</button>
+                <button class="btn btn-sm" data-player="restart">${Icons.rotateCw} Restart</button>
+                <select class="form-control" data-player="speed">
+                    <option value="1">1×</option>
+                    <option value="2">2×</option>
+                    <option value="4">4×</option>
+                    <option value="8">8×</option>
+                </select>
+                <span class="text-muted" data-player="time"></span>
+            </div>
+            <div class="terminal-player"></div>
+        `;
+
+        const player = { term: null, idx: 0, timer: null, paused: false, speed: 1 };
+        const { overlay } = UI.modal({
+            title: `Recording: ${new Date(rec.started).toLocaleString()} (${rec.auth_user || 'anonymous'} from ${rec.remote})`,
+            content,
+            width: '960px',
+            onClose: () => {
+                clearTimeout(player.timer);
+                player.term?.dispose();
+            }
+        });
+
+        const screen = overlay.querySelector('.terminal-player');
+        const toggleBtn = overlay.querySelector('[data-player="toggle"]');
+        const timeEl = overlay.querySelector('[data-player="time"]');
+        const total = events.length ? events[events.length - 1][0] : 0;
+        // Long pauses are shortened so replays do not stall on idle time
+        const maxGap = 2;
+
+        const start = () => {
+            clearTimeout(player.timer);
+            player.term?.dispose();
+            player.term = new VTerminal(screen);
+            player.term.resize(header.width || 80, header.height || 24);
+            player.idx = 0;
+            step();
+        };
+
+        const step = () => {
+            if (player.paused) return;
+            const ev = events[player.idx];
+            if (!ev) {
+                toggleBtn.innerHTML = `${Icons.play} Play`;
+                player.paused = true;
+                player.idx = 0;
+                return;
+            }
+            const [time, code, data] = ev;
+            if (code === 'o') {
+                player.term.write(data);
+            } else if (code === 'r') {
+                const [cols, rows] = String(data).split('x').map(Number);
+                if (cols && rows) player.term.resize(cols, rows);
+            }
+            timeEl.textContent = `${this.formatDuration(time)} / ${this.formatDuration(total)}`;
+
+            player.idx++;
+            const next = events[player.idx];
+            const gap = next ? Math.min(next[0] - time, maxGap) : 0;
+            player.timer = setTimeout(step, Math.max(0, gap) * 1000 / player.speed);
+        };
+
+        overlay.addEventListener('click', (e) => {
+            const action = e.target.closest('[data-player]')?.dataset.player;
+            if (action === 'restart') {
+                player.paused = false;
+                toggleBtn.innerHTML = `${Icons.stop} Pause`;
+                start();
+            } else if (action === 'toggle') {
+                player.paused = !player.paused;
+                toggleBtn.innerHTML = player.paused ? `${Icons.play} Play` : `${Icons.stop} Pause`;
+                if (!player.paused) {
+                    if (player.idx === 0) start();
+                    else step();
+                } else {
+                    clearTimeout(player.timer);
+                }
+            }
+        });
+        overlay.querySelector('[data-player="speed"]').addEventListener('change', (e) => {
+            player.speed = Number(e.target.value) || 1;
+        });
+
+        start();
+    },
+
+    formatDuration(seconds) {
+        seconds = Math.max(0, Math.floor(seconds || 0));
+        const h = Math.floor(seconds / 3600);
+        const m = Math.floor((seconds % 3600) / 60);
+        const s = String(seconds % 60).padStart(2, '0');
+        return h ? `${h}:${String(m).padStart(2, '0')}:${s}` : `${m}:${s}`;
     }
 };
 
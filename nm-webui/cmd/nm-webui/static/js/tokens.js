@@ -0,0 +1,129 @@
+/**
+ * Tokens Module - API tokens for scripts, shown in the account dialog
+ */
+import API from './api.js';
+import UI from './ui.js';
+import Icons from './icons.js';
+
+const EXPIRY_DAYS = [
+    { days: 7, label: '7 days' },
+    { days: 30, label: '30 days' },
+    { days: 90, label: '90 days' },
+    { days: 365, label: '1 year' },
+    { days: 0, label: 'Never' }
+];
+
+const Tokens = {
+    /**
+     * Render the token list and create form into the account dialog
+     */
+    async renderSection(container) {
+        let result;
+        try {
+            result = await API.getAPITokens();
+        } catch (err) {
+            container.innerHTML = `<div class="alert alert-danger">${UI.escape(err.message)}</div>`;
+            return;
+        }
+
+        const rows = result.tokens.map(t => `
+            <div class="list-item" data-token="${UI.escape(t.id)}">
+                <div class="list-item-content">
+                    <div class="list-item-title">
+                        ${Icons.key} ${UI.escape(t.name)}
+                        <span class="text-muted">${UI.escape(t.user)}</span>
+                        ${t.scopes.map(s => `<span class="badge badge-muted">${UI.escape(s)}</span>`).join(' ')}
+                    </div>
+                    <div class="list-item-meta">
+                        <span class="text-muted">
+                            ${t.expires ? `Expires ${UI.escape(new Date(t.expires).toLocaleDateString())}` : 'Never expires'}
+                            · ${t.last_used ? `last used ${UI.escape(new Date(t.last_used).toLocaleString())} from ${UI.escape(t.last_remote)}` : 'never used'}
+                        </span>
+                    </div>
+                </div>
+                <div class="list-item-actions">
+                    <button class="btn btn-sm btn-danger" data-token-revoke title="Revoke">${Icons.x}</button>
+                </div>
+            </div>
+        `).join('');
+
+        container.innerHTML = `
+            ${rows || UI.empty('No API tokens')}
+            <form class="form-group" data-token-create autocomplete="off">
+                <label class="form-label">New Token</label>
+                <input class="form-control" name="name" placeholder="Name, e.g. backup script" maxlength="64" required>
+                <div class="token-scopes">
+                    ${result.scopes.map(s => `
+                        <label><input type="checkbox" name="scope" value="${UI.escape(s)}"> ${UI.escape(s)}</label>
+                    `).join('')}
+                </div>
+                <label><input type="checkbox" name="readonly"> Read only</label>
+                <select class="form-control" name="expires">
+                    ${EXPIRY_DAYS.map(e => `<option value="${e.days}" ${e.days === 90 ? 'selected' : ''}>${e.label}</option>`).join('')}
+                </select>
+                <button class="btn btn-primary" type="submit">${Icons.plus} Create</button>
+            </form>
+        `;
+
+        const reload = () => this.renderSection(container);
+
+        container.onclick = async (e) => {
+            if (!e.target.closest('[data-token-revoke]')) return;
+            const id = e.target.closest('[data-token]').dataset.token;
+            if (!await UI.confirm('Revoke this token? Scripts using it stop working immediately.', 'Revoke Token')) return;
+            try {
+                await API.revokeAPIToken(id);
+                UI.success('Token revoked');
+            } catch (err) {
+                UI.error('Failed to revoke token: ' + err.message);
+            }
+            reload();
+        };
+
+        const form = container.querySelector('[data-token-create]');
+        form.onsubmit = async (e) => {
+            e.preventDefault();
+            const suffix = form.readonly.checked ? ':read' : '';
+            const scopes = [...form.querySelectorAll('[name=scope]:checked')].map(c => c.value + suffix);
+            if (scopes.length === 0) {
+                UI.error('Choose at least one scope');
+                return;
+            }
+            try {
+                const created = await API.createAPIToken(form.name.value.trim(), scopes, parseInt(form.expires.value));
+                this.showSecret(created, reload);
+            } catch (err) {
+                UI.error('Failed to create token: ' + (err.detail || err.message));
+            }
+        };
+    },
+
+    /**
+     * Show a new token's secret once, with copy
+     */
+    showSecret(created, onClose = null) {
+        const { overlay } = UI.modal({
+            title: `Token ${created.name}`,
+            content: `
+                <div class="alert alert-warning">Copy this token now. It won't be shown again.</div>
+                <pre class="token-secret">${UI.escape(created.token)}</pre>
+                <p class="text-muted">Send it as <code>Authorization: Bearer &lt;token&gt;</code>.</p>
+                <button class="btn btn-sm" id="token-copy">${Icons.copy} Copy</button>
+            `,
+            width: '520px',
+            buttons: [{ text: 'Done', className: 'btn btn-primary' }],
+            onClose
+        });
+
+        overlay.querySelector('#token-copy').addEventListener('click', async () => {
+            try {
+                await navigator.clipboard.writeText(created.token);
+                UI.success('Copied');
+            } catch (err) {
+                UI.error('Copy failed: ' + err.message);
+            }
+        });
+    }
+};
+
+export default Tokens;
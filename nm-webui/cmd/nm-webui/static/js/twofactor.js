@@ -0,0 +1,228 @@
+/**
+ * Two-Factor Module - TOTP enrolment, recovery codes and the account
+ * dialog's two-factor section
+ */
+import API from './api.js';
+import UI from './ui.js';
+import Icons from './icons.js';
+
+const TwoFactor = {
+    /**
+     * Walk through enrolment: confirm the password, scan the QR code,
+     * enter the first code, then save the recovery codes. A forced
+     * enrolment (required by the user's role) offers Log Out instead of
+     * Cancel and reloads the page when done.
+     */
+    showEnrol({ forced = false, onDone = null } = {}) {
+        let enrolled = false;
+        const { overlay, close } = UI.modal({
+            title: 'Set Up Two-Factor Authentication',
+            content: `
+                ${forced ? '<div class="alert alert-warning">Your role requires two-factor authentication. Set it up to continue.</div>' : ''}
+                <div id="totp-step-password">
+                    <p class="text-muted">Confirm your password to create a new authenticator secret.</p>
+                    <div class="form-group">
+                        <label class="form-label" for="totp-password">Password</label>
+                        <input class="form-control" id="totp-password" type="password" autocomplete="current-password">
+                    </div>
+                    <button class="btn btn-primary" id="totp-start">Continue</button>
+                </div>
+                <div id="totp-step-scan" style="display: none;">
+                    <p class="text-muted">Scan this code with an authenticator app, or enter the secret by hand.</p>
+                    <div class="totp-qr"><img id="totp-qr" alt="TOTP QR code"></div>
+                    <div class="form-group">
+                        <label class="form-label">Secret</label>
+                        <code class="totp-secret" id="totp-secret"></code>
+                    </div>
+                    <div class="form-group">
+                        <label class="form-label" for="totp-code">Code from the app</label>
+                        <input class="form-control" id="totp-code" inputmode="numeric" autocomplete="one-time-code" maxlength="6">
+                    </div>
+                    <button class="btn btn-primary" id="totp-enable">Enable</button>
+                </div>
+            `,
+            width: '480px',
+            buttons: [
+                forced
+                    ? { text: 'Log Out', className: 'btn btn-danger', action: () => this.logout() }
+                    : { text: 'Cancel', className: 'btn' }
+            ],
+            // A forced enrolment comes straight back until it is done
+            onClose: () => { if (forced && !enrolled) window.location.reload(); }
+        });
+
+        const password = overlay.querySelector('#totp-password');
+        password.focus();
+
+        const start = async () => {
+            try {
+                const setup = await API.setupTOTP(password.value);
+                overlay.querySelector('#totp-qr').src = setup.qr_code;
+                overlay.querySelector('#totp-secret').textContent = setup.secret.replace(/(.{4})/g, '$1 ').trim();
+                overlay.querySelector('#totp-step-password').style.display = 'none';
+                overlay.querySelector('#totp-step-scan').style.display = '';
+                overlay.querySelector('#totp-code').focus();
+            } catch (err) {
+                UI.error(err.detail || err.message);
+            }
+        };
+
+        const enable = async () => {
+            try {
+                const result = await API.enableTOTP(overlay.querySelector('#totp-code').value);
+                enrolled = true;
+                close();
+                UI.success('Two-factor authentication enabled');
+                this.showRecoveryCodes(result.recovery_codes, () => {
+                    if (forced) {
+                        window.location.reload();
+                    } else if (onDone) {
+                        onDone();
+                    }
+                });
+            } catch (err) {
+                UI.error(err.detail || err.message);
+            }
+        };
+
+        overlay.querySelector('#totp-start').addEventListener('click', start);
+        password.addEventListener('keydown', (e) => { if (e.key === 'Enter') start(); });
+        overlay.querySelector('#totp-enable').addEventListener('click', enable);
+        overlay.querySelector('#totp-code').addEventListener('keydown', (e) => { if (e.key === 'Enter') enable(); });
+    },
+
+    /**
+     * Show recovery codes once, with copy and download
+     */
+    showRecoveryCodes(codes, onClose = null) {
+        const text = codes.join('\n');
+        const { overlay } = UI.modal({
+            title: 'Recovery Codes',
+            content: `
+                <div class="alert alert-warning">Save these codes somewhere safe. Each one logs you in once if you lose your authenticator. They won't be shown again.</div>
+                <pre class="totp-recovery">${UI.escape(text)}</pre>
+                <button class="btn btn-sm" id="recovery-copy">${Icons.copy} Copy</button>
+                <button class="btn btn-sm" id="recovery-download">${Icons.download} Download</button>
+            `,
+            width: '420px',
+            buttons: [{ text: 'Done', className: 'btn btn-primary' }],
+            onClose
+        });
+
+        overlay.querySelector('#recovery-copy').addEventListener('click', async () => {
+            try {
+                await navigator.clipboard.writeText(text);
+                UI.success('Copied');
+            } catch (err) {
+                UI.error('Copy failed: ' + err.message);
+            }
+        });
+        overlay.querySelector('#recovery-download').addEventListener('click', () => {
+            const a = document.createElement('a');
+            a.href = URL.createObjectURL(new Blob([text + '\n'], { type: 'text/plain' }));
+            a.download = 'haxinator-recovery-codes.txt';
+            a.click();
+            URL.revokeObjectURL(a.href);
+        });
+    },
+
+    /**
+     * Render the two-factor status and actions into the account dialog
+     */
+    async renderSection(container) {
+        let status;
+        try {
+            status = await API.getTOTPStatus();
+        } catch (err) {
+            container.innerHTML = `<div class="alert alert-danger">${UI.escape(err.message)}</div>`;
+            return;
+        }
+
+        const reload = () => this.renderSection(container);
+        if (!status.enabled) {
+            container.innerHTML = `
+                <p class="text-muted">Not enabled${status.required ? ' (required for your role)' : ''}.</p>
+                <button class="btn btn-sm btn-primary" data-totp="enable">${Icons.shield} Set Up</button>
+            `;
+        } else {
+            container.innerHTML = `
+                <p>
+                    <span class="badge badge-success">Enabled</span>
+                    <span class="text-muted">${status.recovery_remaining} recovery code${status.recovery_remaining === 1 ? '' : 's'} left</span>
+                </p>
+                <button class="btn btn-sm" data-totp="recovery">${Icons.refresh} New Recovery Codes</button>
+                ${status.required ? '' : `<button class="btn btn-sm btn-danger" data-totp="disable">${Icons.x} Disable</button>`}
+            `;
+        }
+
+        container.onclick = (e) => {
+            const action = e.target.closest('[data-totp]')?.dataset.totp;
+            if (action === 'enable') {
+                this.showEnrol({ onDone: reload });
+            } else if (action === 'recovery') {
+                this.showConfirm('New Recovery Codes', 'Your old recovery codes stop working.', false, async (password) => {
+                    const result = await API.regenerateRecoveryCodes(password);
+                    this.showRecoveryCodes(result.recovery_codes, reload);
+                });
+            } else if (action === 'disable') {
+                this.showConfirm('Disable Two-Factor Authentication', 'Logging in will only need your password.', true, async (password, code) => {
+                    await API.disableTOTP(password, code);
+                    UI.success('Two-factor authentication disabled');
+                    reload();
+                });
+            }
+        };
+    },
+
+    /**
+     * Ask for the password (and optionally a current code) before a
+     * two-factor change
+     */
+    showConfirm(title, message, needCode, onConfirm) {
+        const { overlay, close } = UI.modal({
+            title,
+            content: `
+                <p class="text-muted">${UI.escape(message)}</p>
+                <div class="form-group">
+                    <label class="form-label" for="totp-confirm-password">Password</label>
+                    <input class="form-control" id="totp-confirm-password" type="password" autocomplete="current-password">
+                </div>
+                ${needCode ? `
+                    <div class="form-group">
+                        <label class="form-label" for="totp-confirm-code">Authenticator or recovery code</label>
+                        <input class="form-control" id="totp-confirm-code" autocomplete="one-time-code">
+                    </div>
+                ` : ''}
+            `,
+            buttons: [
+                { text: 'Cancel', className: 'btn' },
+                {
+                    text: 'Confirm',
+                    className: 'btn btn-primary',
+                    action: async () => {
+                        const password = overlay.querySelector('#totp-confirm-password').value;
+                        const code = overlay.querySelector('#totp-confirm-code')?.value || '';
+                        try {
+                            await onConfirm(password, code);
+                            close();
+                        } catch (err) {
+                            UI.error(err.detail || err.message);
+                        }
+                    }
+                }
+            ]
+        });
+        overlay.querySelector('#totp-confirm-password').focus();
+    },
+
+    async logout() {
+        try {
+            await API.logout();
+        } catch (err) {
+            console.error('Logout failed:', err);
+        }
+        window.location.reload();
+    }
+};
+
+export default TwoFactor;
@@ -0,0 +1,638 @@
+/**
+ * VT Module - Minimal VT100/xterm terminal emulator
+ * Renders a PTY stream into the DOM for the Terminal tab. Covers what
+ * shells and curses tools (nmtui, htop, vi) use: cursor movement, erase,
+ * scroll regions, SGR colors, the alternate screen and line drawing.
+ */
+
+const ESC = '\x1b';
+
+// DEC special graphics (ESC ( 0), used for box drawing
+const LINE_DRAWING = {
+    '`': '◆', a: '▒', f: '°', g: '±', j: '┘', k: '┐', l: '┌', m: '└', n: '┼',
+    o: '⎺', p: '⎻', q: '─', r: '⎼', s: '⎽', t: '├', u: '┤', v: '┴', w: '┬',
+    x: '│', y: '≤', z: '≥', '{': 'π', '|': '≠', '}': '£', '~': '·'
+};
+
+const KEYS = {
+    Enter: '\r', Backspace: '\x7f', Tab: '\t', Escape: ESC,
+    Insert: ESC + '[2~', Delete: ESC + '[3~', PageUp: ESC + '[5~', PageDown: ESC + '[6~',
+    F1: ESC + 'OP', F2: ESC + 'OQ', F3: ESC + 'OR', F4: ESC + 'OS',
+    F5: ESC + '[15~', F6: ESC + '[17~', F7: ESC + '[18~', F8: ESC + '[19~',
+    F9: ESC + '[20~', F10: ESC + '[21~', F11: ESC + '[23~', F12: ESC + '[24~'
+};
+
+const CURSOR_KEYS = { ArrowUp: 'A', ArrowDown: 'B', ArrowRight: 'C', ArrowLeft: 'D', Home: 'H', End: 'F' };
+
+const DEFAULT_ATTR = Object.freeze({ fg: null, bg: null, bold: false, dim: false, italic: false, underline: false, inverse: false, key: '' });
+
+// xterm 256-color palette entries 16-255 as CSS colors
+function color256(n) {
+    if (n < 232) {
+        const i = n - 16;
+        const level = v => (v === 0 ? 0 : 55 + v * 40);
+        return `rgb(${level(Math.floor(i / 36))},${level(Math.floor(i / 6) % 6)},${level(i % 6)})`;
+    }
+    const g = 8 + (n - 232) * 10;
+    return `rgb(${g},${g},${g})`;
+}
+
+function makeAttr(a) {
+    const attr = { ...a };
+    attr.key = [a.fg, a.bg, a.bold, a.dim, a.italic, a.underline, a.inverse].join('|');
+    return Object.freeze(attr);
+}
+
+function blankCell(attr = DEFAULT_ATTR) {
+    return { c: ' ', a: attr.bg === null && !attr.inverse ? DEFAULT_ATTR : makeAttr({ ...DEFAULT_ATTR, bg: attr.bg, inverse: attr.inverse }) };
+}
+
+function blankLine(cols, attr) {
+    return Array.from({ length: cols }, () => blankCell(attr));
+}
+
+function escapeHTML(s) {
+    return s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+}
+
+class VTerminal {
+    constructor(element, { onData = () => {}, onResize = () => {}, scrollback = 1000 } = {}) {
+        this.element = element;
+        this.onData = onData;
+        this.onResize = onResize;
+        this.maxScrollback = scrollback;
+
+        this.cols = 80;
+        this.rows = 24;
+        this.reset();
+
+        this.element.classList.add('vt');
+        this.element.innerHTML = `
+            <div class="vt-scrollback"></div>
+            <div class="vt-screen"></div>
+            <textarea class="vt-input" autocapitalize="off" autocomplete="off" autocorrect="off" spellcheck="false"></textarea>
+        `;
+        this.scrollbackEl = this.element.querySelector('.vt-scrollback');
+        this.screenEl = this.element.querySelector('.vt-screen');
+        this.input = this.element.querySelector('.vt-input');
+        this.bindInput();
+        this.buildRows();
+    }
+
+    reset() {
+        this.attr = DEFAULT_ATTR;
+        this.x = 0;
+        this.y = 0;
+        this.wrapPending = false;
+        this.saved = null;
+        this.top = 0;
+        this.bottom = this.rows - 1;
+        this.cursorVisible = true;
+        this.autowrap = true;
+        this.appCursor = false;
+        this.bracketedPaste = false;
+        this.charsets = ['B', 'B'];
+        this.charset = 0;
+        this.altLines = null;
+        this.lines = Array.from({ length: this.rows }, () => blankLine(this.cols));
+        this.state = 'ground';
+        this.params = '';
+        this.dirty = new Set(this.lines.keys());
+    }
+
+    // ---------- Output ----------
+
+    write(data) {
+        for (const ch of data) {
+            this.feed(ch);
+        }
+        this.scheduleRender();
+    }
+
+    feed(ch) {
+        switch (this.state) {
+            case 'escape':
+                return this.escape(ch);
+            case 'csi':
+                if (ch >= '@' && ch <= '~') {
+                    this.state = 'ground';
+                    return this.csi(ch);
+                }
+                this.params += ch;
+                return;
+            case 'osc':
+                // Terminated by BEL or ST (ESC \); titles are ignored
+                if (ch === '\x07') this.state = 'ground';
+                else if (ch === ESC) this.state = 'osc-esc';
+                return;
+            case 'osc-esc':
+                this.state = ch === '\\' ? 'ground' : 'osc';
+                return;
+            case 'charset':
+                this.charsets[this.params] = ch;
+                this.state = 'ground';
+                return;
+        }
+
+        const code = ch.codePointAt(0);
+        if (code < 0x20 || code === 0x7f) {
+            return this.control(ch);
+        }
+        this.print(this.charsets[this.charset] === '0' && LINE_DRAWING[ch] ? LINE_DRAWING[ch] : ch);
+    }
+
+    control(ch) {
+        switch (ch) {
+            case ESC: this.state = 'escape'; break;
+            case '\r': this.x = 0; this.wrapPending = false; break;
+            case '\n': case '\v': case '\f': this.index(); break;
+            case '\b':
+                if (this.x > 0) this.x--;
+                this.wrapPending = false;
+                break;
+            case '\t':
+                this.x = Math.min(this.cols - 1, (Math.floor(this.x / 8) + 1) * 8);
+                break;
+            case '\x0e': this.charset = 1; break;
+            case '\x0f': this.charset = 0; break;
+        }
+    }
+
+    print(ch) {
+        if (this.wrapPending) {
+            this.x = 0;
+            this.index();
+        }
+        this.lines[this.y][this.x] = { c: ch, a: this.attr };
+        this.dirty.add(this.y);
+        if (this.x === this.cols - 1) {
+            this.wrapPending = this.autowrap;
+        } else {
+            this.x++;
+        }
+    }
+
+    escape(ch) {
+        this.state = 'ground';
+        switch (ch) {
+            case '[': this.state = 'csi'; this.params = ''; break;
+            case ']': case 'P': case '_': case '^': this.state = 'osc'; break;
+            case '(': case ')': this.state = 'charset'; this.params = ch === '(' ? 0 : 1; break;
+            case '7': this.saveCursor(); break;
+            case '8': this.restoreCursor(); break;
+            case 'D': this.index(); break;
+            case 'E': this.x = 0; this.index(); break;
+            case 'M': this.reverseIndex(); break;
+            case 'c': this.reset(); this.buildRows(); break;
+        }
+    }
+
+    csi(final) {
+        const priv = this.params.startsWith('?');
+        const raw = (priv ? this.params.slice(1) : this.params).replace(/[^0-9;:]/g, '');
+        const args = raw.split(';').map(p => parseInt(p, 10));
+        const n = (i = 0, def = 1) => (Number.isNaN(args[i]) || args[i] === undefined || args[i] === 0 ? def : args[i]);
+
+        if (/[ !"$']/.test(this.params)) return; // cursor style, soft reset and similar
+
+        switch (final) {
+            case 'A': this.moveTo(this.x, Math.max(this.y - n(), this.y >= this.top ? this.top : 0)); break;
+            case 'B': this.moveTo(this.x, Math.min(this.y + n(), this.y <= this.bottom ? this.bottom : this.rows - 1)); break;
+            case 'C': case 'a': this.moveTo(this.x + n(), this.y); break;
+            case 'D': this.moveTo(this.x - n(), this.y); break;
+            case 'E': this.moveTo(0, this.y + n()); break;
+            case 'F': this.moveTo(0, this.y - n()); break;
+            case 'G': case '`': this.moveTo(n() - 1, this.y); break;
+            case 'd': this.moveTo(this.x, n() - 1); break;
+            case 'e': this.moveTo(this.x, this.y + n()); break;
+            case 'H': case 'f': this.moveTo(n(1) - 1, n(0) - 1); break;
+            case 'J': this.eraseDisplay(args[0] || 0); break;
+            case 'K': this.eraseLine(args[0] || 0); break;
+            case 'L': this.insertLines(n()); break;
+            case 'M': this.deleteLines(n()); break;
+            case '@': this.insertChars(n()); break;
+            case 'P': this.deleteChars(n()); break;
+            case 'X': this.eraseChars(n()); break;
+            case 'S': this.scrollUp(n()); break;
+            case 'T': this.scrollDown(n()); break;
+            case 'm': this.sgr(args); break;
+            case 'r':
+                this.top = n(0) - 1;
+                this.bottom = Math.min(n(1, this.rows), this.rows) - 1;
+                if (this.top >= this.bottom) { this.top = 0; this.bottom = this.rows - 1; }
+                this.moveTo(0, 0);
+                break;
+            case 's': this.saveCursor(); break;
+            case 'u': this.restoreCursor(); break;
+            case 'h': case 'l':
+                if (priv) args.forEach(mode => this.setMode(mode, final === 'h'));
+                break;
+            case 'n':
+                if (args[0] === 6) this.onData(`${ESC}[${this.y + 1};${this.x + 1}R`);
+                else if (args[0] === 5) this.onData(`${ESC}[0n`);
+                break;
+            case 'c':
+                if (!priv && !this.params.startsWith('>')) this.onData(`${ESC}[?1;2c`);
+                break;
+        }
+    }
+
+    setMode(mode, on) {
+        switch (mode) {
+            case 1: this.appCursor = on; break;
+            case 7: this.autowrap = on; break;
+            case 25: this.cursorVisible = on; this.dirty.add(this.y); break;
+            case 2004: this.bracketedPaste = on; break;
+            case 47: case 1047: case 1049:
+                if (on && !this.altLines) {
+                    if (mode === 1049) this.saveCursor();
+                    this.altLines = this.lines;
+                    this.lines = Array.from({ length: this.rows }, () => blankLine(this.cols));
+                } else if (!on && this.altLines) {
+                    this.lines = this.altLines;
+                    this.altLines = null;
+                    if (mode === 1049) this.restoreCursor();
+                }
+                this.markAllDirty();
+                break;
+        }
+    }
+
+    sgr(args) {
+        const a = { ...this.attr };
+        if (!args.length || (args.length === 1 && Number.isNaN(args[0]))) args = [0];
+        for (let i = 0; i < args.length; i++) {
+            const p = Number.isNaN(args[i]) ? 0 : args[i];
+            if (p === 0) Object.assign(a, DEFAULT_ATTR);
+            else if (p === 1) a.bold = true;
+            else if (p === 2) a.dim = true;
+            else if (p === 3) a.italic = true;
+            else if (p === 4) a.underline = true;
+            else if (p === 7) a.inverse = true;
+            else if (p === 22) { a.bold = false; a.dim = false; }
+            else if (p === 23) a.italic = false;
+            else if (p === 24) a.underline = false;
+            else if (p === 27) a.inverse = false;
+            else if (p >= 30 && p <= 37) a.fg = p - 30;
+            else if (p === 39) a.fg = null;
+            else if (p >= 40 && p <= 47) a.bg = p - 40;
+            else if (p === 49) a.bg = null;
+            else if (p >= 90 && p <= 97) a.fg = p - 90 + 8;
+            else if (p >= 100 && p <= 107) a.bg = p - 100 + 8;
+            else if (p === 38 || p === 48) {
+                let color = null;
+                if (args[i + 1] === 5) {
+                    const idx = args[i + 2];
+                    color = idx < 16 ? idx : color256(idx);
+                    i += 2;
+                } else if (args[i + 1] === 2) {
+                    color = `rgb(${args[i + 2] | 0},${args[i + 3] | 0},${args[i + 4] | 0})`;
+                    i += 4;
+                }
+                if (p === 38) a.fg = color; else a.bg = color;
+            }
+        }
+        const plain = a.fg === null && a.bg === null && !a.bold && !a.dim && !a.italic && !a.underline && !a.inverse;
+        this.attr = plain ? DEFAULT_ATTR : makeAttr(a);
+    }
+
+    moveTo(x, y) {
+        this.dirty.add(this.y);
+        this.x = Math.max(0, Math.min(this.cols - 1, x));
+        this.y = Math.max(0, Math.min(this.rows - 1, y));
+        this.wrapPending = false;
+        this.dirty.add(this.y);
+    }
+
+    saveCursor() {
+        this.saved = { x: this.x, y: this.y, attr: this.attr, charsets: [...this.charsets], charset: this.charset };
+    }
+
+    restoreCursor() {
+        if (!this.saved) return;
+        this.attr = this.saved.attr;
+        this.charsets = [...this.saved.charsets];
+        this.charset = this.saved.charset;
+        this.moveTo(this.saved.x, this.saved.y);
+    }
+
+    index() {
+        this.wrapPending = false;
+        if (this.y === this.bottom) {
+            this.scrollUp(1);
+        } else if (this.y < this.rows - 1) {
+            this.moveTo(this.x, this.y + 1);
+        }
+    }
+
+    reverseIndex() {
+        if (this.y === this.top) {
+            this.scrollDown(1);
+        } else if (this.y > 0) {
+            this.moveTo(this.x, this.y - 1);
+        }
+    }
+
+    scrollUp(count) {
+        for (let i = 0; i < count; i++) {
+            const [line] = this.lines.splice(this.top, 1);
+            this.lines.splice(this.bottom, 0, blankLine(this.cols, this.attr));
+            if (this.top === 0 && !this.altLines) this.pushScrollback(line);
+        }
+        this.markDirty(this.top, this.bottom);
+    }
+
+    scrollDown(count) {
+        for (let i = 0; i < count; i++) {
+            this.lines.splice(this.bottom, 1);
+            this.lines.splice(this.top, 0, blankLine(this.cols, this.attr));
+        }
+        this.markDirty(this.top, this.bottom);
+    }
+
+    insertLines(count) {
+        if (this.y < this.top || this.y > this.bottom) return;
+        for (let i = 0; i < count; i++) {
+            this.lines.splice(this.bottom, 1);
+            this.lines.splice(this.y, 0, blankLine(this.cols, this.attr));
+        }
+        this.markDirty(this.y, this.bottom);
+    }
+
+    deleteLines(count) {
+        if (this.y < this.top || this.y > this.bottom) return;
+        for (let i = 0; i < count; i++) {
+            this.lines.splice(this.y, 1);
+            this.lines.splice(this.bottom, 0, blankLine(this.cols, this.attr));
+        }
+        this.markDirty(this.y, this.bottom);
+    }
+
+    insertChars(count) {
+        const line = this.lines[this.y];
+        line.splice(this.x, 0, ...blankLine(Math.min(count, this.cols - this.x), this.attr));
+        line.length = this.cols;
+        this.dirty.add(this.y);
+    }
+
+    deleteChars(count) {
+        const line = this.lines[this.y];
+        const n = Math.min(count, this.cols - this.x);
+        line.splice(this.x, n);
+        line.push(...blankLine(n, this.attr));
+        this.dirty.add(this.y);
+    }
+
+    eraseChars(count) {
+        const line = this.lines[this.y];
+        for (let i = this.x; i < Math.min(this.cols, this.x + count); i++) line[i] = blankCell(this.attr);
+        this.dirty.add(this.y);
+    }
+
+    eraseLine(mode) {
+        const line = this.lines[this.y];
+        const [from, to] = mode === 0 ? [this.x, this.cols] : mode === 1 ? [0, this.x + 1] : [0, this.cols];
+        for (let i = from; i < to; i++) line[i] = blankCell(this.attr);
+        this.dirty.add(this.y);
+    }
+
+    eraseDisplay(mode) {
+        if (mode === 3) {
+            this.scrollbackEl.innerHTML = '';
+            return;
+        }
+        const [from, to] = mode === 0 ? [this.y + 1, this.rows] : mode === 1 ? [0, this.y] : [0, this.rows];
+        if (mode !== 2) this.eraseLine(mode);
+        for (let y = from; y < to; y++) this.lines[y] = blankLine(this.cols, this.attr);
+        this.markDirty(from, to - 1);
+    }
+
+    markDirty(from, to) {
+        for (let y = from; y <= to; y++) this.dirty.add(y);
+    }
+
+    markAllDirty() {
+        this.markDirty(0, this.rows - 1);
+    }
+
+    // ---------- Rendering ----------
+
+    buildRows() {
+        this.screenEl.innerHTML = '';
+        this.rowEls = this.lines.map(() => {
+            const row = document.createElement('div');
+            row.className = 'vt-row';
+            this.screenEl.appendChild(row);
+            return row;
+        });
+        this.markAllDirty();
+        this.scheduleRender();
+    }
+
+    pushScrollback(line) {
+        const row = document.createElement('div');
+        row.className = 'vt-row';
+        row.innerHTML = this.renderLine(line, -1);
+        this.scrollbackEl.appendChild(row);
+        while (this.scrollbackEl.childElementCount > this.maxScrollback) {
+            this.scrollbackEl.firstElementChild.remove();
+        }
+    }
+
+    scheduleRender() {
+        if (this.renderPending) return;
+        this.renderPending = true;
+        requestAnimationFrame(() => {
+            this.renderPending = false;
+            this.render();
+        });
+    }
+
+    render() {
+        const atBottom = this.element.scrollTop + this.element.clientHeight >= this.element.scrollHeight - 4;
+        this.dirty.add(this.y);
+        for (const y of this.dirty) {
+            if (this.rowEls[y]) {
+                this.rowEls[y].innerHTML = this.renderLine(this.lines[y], y === this.y && this.cursorVisible ? this.x : -1);
+            }
+        }
+        this.dirty.clear();
+        if (atBottom) this.element.scrollTop = this.element.scrollHeight;
+    }
+
+    renderLine(line, cursorX) {
+        let html = '';
+        let run = '';
+        let runKey = null;
+        let runAttr = null;
+        const flush = () => {
+            if (run) html += this.span(runAttr, run, false);
+            run = '';
+        };
+        line.forEach((cell, x) => {
+            if (x === cursorX) {
+                flush();
+                html += this.span(cell.a, cell.c, true);
+                runKey = null;
+                return;
+            }
+            if (cell.a.key !== runKey) {
+                flush();
+                runKey = cell.a.key;
+                runAttr = cell.a;
+            }
+            run += cell.c;
+        });
+        flush();
+        return html;
+    }
+
+    span(a, text, cursor) {
+        let fg = a.fg;
+        let bg = a.bg;
+        if (a.inverse !== cursor) {
+            [fg, bg] = [bg === null ? 'default-bg' : bg, fg === null ? 'default-fg' : fg];
+        }
+        if (a.bold && typeof fg === 'number' && fg < 8) fg += 8;
+
+        const classes = [];
+        const styles = [];
+        const color = (value, kind) => {
+            if (value === null) return;
+            if (typeof value === 'number') classes.push(`vt-${kind}-${value}`);
+            else if (value.startsWith('default')) classes.push(`vt-${kind}-${value}`);
+            else styles.push(`${kind === 'fg' ? 'color' : 'background'}:${value}`);
+        };
+        color(fg, 'fg');
+        color(bg, 'bg');
+        if (a.bold) classes.push('vt-bold');
+        if (a.dim) classes.push('vt-dim');
+        if (a.italic) classes.push('vt-italic');
+        if (a.underline) classes.push('vt-underline');
+
+        const content = escapeHTML(text);
+        if (!classes.length && !styles.length) return content;
+        return `<span${classes.length ? ` class="${classes.join(' ')}"` : ''}${styles.length ? ` style="${styles.join(';')}"` : ''}>${content}</span>`;
+    }
+
+    // ---------- Size ----------
+
+    fit() {
+        const probe = document.createElement('span');
+        probe.textContent = 'W'.repeat(10);
+        this.screenEl.appendChild(probe);
+        const rect = probe.getBoundingClientRect();
+        probe.remove();
+        if (!rect.width || !rect.height) return;
+
+        const style = getComputedStyle(this.element);
+        const width = this.element.clientWidth - parseFloat(style.paddingLeft) - parseFloat(style.paddingRight);
+        const height = this.element.clientHeight - parseFloat(style.paddingTop) - parseFloat(style.paddingBottom);
+        const cols = Math.max(20, Math.floor(width / (rect.width / 10)));
+        const rows = Math.max(5, Math.floor(height / rect.height));
+        this.resize(cols, rows);
+    }
+
+    resize(cols, rows) {
+        if (cols === this.cols && rows === this.rows) return;
+
+        const fitLines = (lines, keepCursor) => {
+            lines.forEach(line => {
+                if (line.length > cols) line.length = cols;
+                while (line.length < cols) line.push(blankCell());
+            });
+            while (lines.length > rows) {
+                // Drop from the top while the cursor stays visible, then from the bottom
+                if (keepCursor && this.y > 0) {
+                    const [line] = lines.splice(0, 1);
+                    if (!this.altLines) this.pushScrollback(line);
+                    this.y--;
+                } else {
+                    lines.pop();
+                }
+            }
+            while (lines.length < rows) lines.push(blankLine(cols));
+        };
+
+        fitLines(this.lines, true);
+        if (this.altLines) fitLines(this.altLines, false);
+
+        this.cols = cols;
+        this.rows = rows;
+        this.top = 0;
+        this.bottom = rows - 1;
+        this.x = Math.min(this.x, cols - 1);
+        this.y = Math.min(this.y, rows - 1);
+        this.wrapPending = false;
+        this.buildRows();
+        this.onResize(cols, rows);
+    }
+
+    // ---------- Input ----------
+
+    bindInput() {
+        this.element.addEventListener('mouseup', () => {
+            if (!window.getSelection().toString()) this.focus();
+        });
+
+        this.input.addEventListener('keydown', (e) => {
+            const seq = this.keySequence(e);
+            if (seq !== null) {
+                e.preventDefault();
+                this.onData(seq);
+            }
+        });
+
+        // Printable text arrives here, including IME and mobile keyboards
+        this.input.addEventListener('input', () => {
+            if (this.input.value) {
+                this.onData(this.input.value);
+                this.input.value = '';
+            }
+        });
+
+        this.input.addEventListener('paste', (e) => {
+            e.preventDefault();
+            const text = (e.clipboardData.getData('text') || '').replace(/\r?\n/g, '\r');
+            this.onData(this.bracketedPaste ? `${ESC}[200~${text}${ESC}[201~` : text);
+        });
+    }
+
+    keySequence(e) {
+        if (e.metaKey || e.isComposing) return null;
+        // Ctrl+Shift+C/V are left to the browser for copy and paste
+        if (e.ctrlKey && e.shiftKey) return null;
+
+        if (CURSOR_KEYS[e.key]) {
+            return ESC + (this.appCursor ? 'O' : '[') + CURSOR_KEYS[e.key];
+        }
+        if (KEYS[e.key]) {
+            if (e.key === 'Tab' && e.shiftKey) return ESC + '[Z';
+            return (e.altKey ? ESC : '') + KEYS[e.key];
+        }
+        if (e.key.length !== 1) return null;
+
+        if (e.ctrlKey && !e.altKey) {
+            const code = e.key.toUpperCase().charCodeAt(0);
+            if (code >= 64 && code <= 95) return String.fromCharCode(code - 64);
+            if (e.key === ' ') return '\x00';
+            if (e.key === '/') return '\x1f';
+            return null;
+        }
+        if (e.altKey && !e.ctrlKey) {
+            return ESC + e.key;
+        }
+        return null;
+    }
+
+    focus() {
+        this.input.focus({ preventScroll: true });
+    }
+
+    dispose() {
+        this.element.innerHTML = '';
+        this.element.classList.remove('vt');
+    }
+}
+
+export default VTerminal;
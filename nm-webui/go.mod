@@ -1,3 +1,10 @@
 module nm-webui
 
 go 1.21
+
+require (
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
+	rsc.io/qr v0.2.0
+	software.sslmate.com/src/go-pkcs12 v0.5.0
+)
@@ -0,0 +1,192 @@
+// Package access decides which API areas each network interface may reach
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/httputil"
+	"nm-webui/internal/listen"
+	"nm-webui/internal/logger"
+)
+
+// Level is how much of an area an interface may use
+type Level int
+
+const (
+	// Deny refuses every request
+	Deny Level = iota
+	// Read allows GET requests, logging in and logging out
+	Read
+	// Full allows everything the user's role allows
+	Full
+)
+
+var levelNames = []string{"deny", "read", "full"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// UnmarshalJSON parses "deny", "read" or "full"
+func (l *Level) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for i, name := range levelNames {
+		if s == name {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown access level %q (want deny, read or full)", s)
+}
+
+// Rules maps areas to levels; "*" covers areas not listed. Areas are the
+// API token scope areas, "auth" for logging in and accounts, and "ui" for
+// the web UI's pages.
+type Rules map[string]Level
+
+// UnmarshalJSON accepts a single level for all areas, or an object
+func (r *Rules) UnmarshalJSON(data []byte) error {
+	var all Level
+	if err := json.Unmarshal(data, &all); err == nil {
+		*r = Rules{"*": all}
+		return nil
+	}
+	var rules map[string]Level
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	*r = rules
+	return nil
+}
+
+// Policy holds access rules per interface, read from a JSON file:
+//
+//	{
+//	  "default": "deny",
+//	  "interfaces": {
+//	    "usb0": "full",
+//	    "lo": "full",
+//	    "wlan0": {"*": "read", "terminal": "deny"}
+//	  }
+//	}
+//
+// Interfaces not listed, and connections whose interface is unknown, get
+// the default, which is deny when left out.
+type Policy struct {
+	Default    Rules            `json:"default"`
+	Interfaces map[string]Rules `json:"interfaces"`
+}
+
+// Load reads a policy file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse access policy: %w", err)
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// check validates area names
+func (p *Policy) check() error {
+	areas := map[string]bool{"*": true, "auth": true, "ui": true}
+	for _, a := range auth.ScopeAreas() {
+		areas[a] = true
+	}
+	all := map[string]Rules{"default": p.Default}
+	for iface, rules := range p.Interfaces {
+		all[iface] = rules
+	}
+	for name, rules := range all {
+		for area := range rules {
+			if !areas[area] {
+				return fmt.Errorf("access policy for %s: unknown area %q", name, area)
+			}
+		}
+	}
+	return nil
+}
+
+// Level returns an interface's level for an area
+func (p *Policy) Level(iface, area string) Level {
+	rules, ok := p.Interfaces[iface]
+	if !ok || iface == "" {
+		rules = p.Default
+	}
+	if l, ok := rules[area]; ok {
+		return l
+	}
+	if l, ok := rules["*"]; ok {
+		return l
+	}
+	return Deny
+}
+
+// Allows reports whether a request from an interface is permitted
+func (p *Policy) Allows(iface string, r *http.Request) bool {
+	area := areaOf(r.URL.Path)
+	switch p.Level(iface, area) {
+	case Full:
+		return true
+	case Read:
+		if auth.ReadOnly(r) {
+			return true
+		}
+		// Read-only users still have to log in
+		return r.URL.Path == "/api/auth/login" || r.URL.Path == "/api/auth/logout"
+	default:
+		return false
+	}
+}
+
+// areaOf returns the policy area of a path
+func areaOf(path string) string {
+	if area := auth.Area(path); area != "" {
+		return area
+	}
+	if strings.HasPrefix(path, "/api/auth") {
+		return "auth"
+	}
+	if strings.HasPrefix(path, "/api/") {
+		return "*"
+	}
+	return "ui"
+}
+
+// Wrap refuses requests the policy does not allow from the interface they
+// arrived on, before authentication
+func (p *Policy) Wrap(next http.Handler, log *logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iface := listen.Interface(r)
+		if p.Allows(iface, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		level := p.Level(iface, areaOf(r.URL.Path))
+		if iface == "" {
+			iface = "an unknown interface"
+		}
+		log.Warn("access", "deny").
+			WithContext(r.Context()).
+			WithExtra("interface", iface).
+			WithExtra("remote", auth.ClientAddr(r)).
+			WithExtra("path", r.URL.Path).
+			Commit()
+		httputil.JSONError(w, http.StatusForbidden, "Not allowed from this network", "Access from "+iface+" is "+level.String()+" here")
+	})
+}
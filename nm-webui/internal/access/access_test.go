@@ -0,0 +1,142 @@
+package access
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nm-webui/internal/logger"
+)
+
+const testPolicy = `{
+  "default": "deny",
+  "interfaces": {
+    "usb0": "full",
+    "wlan0": {"*": "read", "terminal": "deny", "ui": "full"}
+  }
+}`
+
+// loadPolicy writes a policy file and loads it
+func loadPolicy(t *testing.T, content string) (*Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	p, err := loadPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if p.Interfaces["usb0"]["*"] != Full || p.Interfaces["wlan0"]["terminal"] != Deny {
+		t.Errorf("policy = %+v", p)
+	}
+
+	for _, bad := range []string{
+		`{"default": "allow"}`,
+		`{"interfaces": {"wlan0": {"users": "full"}}}`,
+		`{"default": {"auth": "read", "nope": "deny"}}`,
+		`{"interfaces": {"wlan0": 2}}`,
+		`not json`,
+	} {
+		if _, err := loadPolicy(t, bad); err == nil {
+			t.Errorf("loaded %s", bad)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var p Policy
+	if err := json.Unmarshal([]byte(testPolicy), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		iface string
+		area  string
+		want  Level
+	}{
+		{"usb0", "terminal", Full},
+		{"wlan0", "terminal", Deny},
+		{"wlan0", "status", Read},
+		{"wlan0", "ui", Full},
+		{"eth0", "status", Deny},
+		{"", "status", Deny},
+	}
+	for _, tt := range tests {
+		if got := p.Level(tt.iface, tt.area); got != tt.want {
+			t.Errorf("Level(%q, %q) = %s, want %s", tt.iface, tt.area, got, tt.want)
+		}
+	}
+
+	// Without a "*" entry, unlisted areas are denied; an empty default
+	// denies everything
+	p = Policy{Interfaces: map[string]Rules{"wlan0": {"status": Full}}}
+	if p.Level("wlan0", "logs") != Deny || p.Level("eth0", "status") != Deny {
+		t.Error("unlisted area or interface not denied")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	var p Policy
+	if err := json.Unmarshal([]byte(testPolicy), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		iface  string
+		method string
+		path   string
+		ws     bool
+		want   bool
+	}{
+		{"full interface", "usb0", "POST", "/api/configure/files", false, true},
+		{"read GET", "wlan0", "GET", "/api/status", false, true},
+		{"read POST", "wlan0", "POST", "/api/wifi/connect", false, false},
+		{"read login", "wlan0", "POST", "/api/auth/login", false, true},
+		{"read logout", "wlan0", "POST", "/api/auth/logout", false, true},
+		{"read password change", "wlan0", "POST", "/api/auth/password", false, false},
+		{"unlisted API under read", "wlan0", "GET", "/api/system/info", false, true},
+		{"denied area", "wlan0", "GET", "/api/terminal/ws", true, false},
+		{"pages", "wlan0", "GET", "/", false, true},
+		{"default", "eth0", "GET", "/", false, false},
+		{"unknown interface", "", "GET", "/api/status", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.ws {
+				r.Header.Set("Upgrade", "websocket")
+			}
+			if got := p.Allows(tt.iface, r); got != tt.want {
+				t.Errorf("Allows(%q, %s %s) = %v, want %v", tt.iface, tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	p := &Policy{Default: Rules{"*": Full, "terminal": Deny}}
+	h := p.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), logger.NewDefault())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("allowed request: status = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/terminal/ws", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("denied request: status = %d, want 403", w.Code)
+	}
+}
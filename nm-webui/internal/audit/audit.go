@@ -0,0 +1,498 @@
+// Package audit keeps a tamper-evident log of state-changing actions on
+// disk. Entries are JSON lines, each holding the SHA-256 hash of the one
+// before, so editing or removing an entry breaks the chain after it.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files in the audit directory. audit.head holds the sequence number and
+// hash of the last entry written; it is not rotated, so a log cut short
+// while nm-webui was stopped no longer matches it.
+const (
+	fileName = "audit.log"
+	headFile = "audit.head"
+)
+
+// genesis is the previous hash of the first entry ever written
+var genesis = strings.Repeat("0", 64)
+
+// hashSuffix ends every line; the hash covers the line before it
+var hashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// Entry is one audited request
+type Entry struct {
+	Seq        uint64                 `json:"seq"`
+	Time       string                 `json:"time"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	Actor      string                 `json:"actor,omitempty"`
+	Remote     string                 `json:"remote,omitempty"`
+	Interface  string                 `json:"interface,omitempty"`
+	Method     string                 `json:"method"`
+	Action     string                 `json:"action"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Status     int                    `json:"status"`
+	Success    bool                   `json:"success"`
+	DurationMs int64                  `json:"duration_ms"`
+	Prev       string                 `json:"prev"`
+	Hash       string                 `json:"hash,omitempty"`
+}
+
+// head is the content of audit.head
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log appends entries to audit.log in its directory, rotating it to
+// audit.log.1 and so on when it reaches maxSize. Every entry is synced to
+// disk, which is cheap since only state changes are recorded.
+type Log struct {
+	dir      string
+	maxSize  int64
+	maxFiles int // rotated files to keep
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	seq      uint64
+	head     string // hash of the last entry
+	headLost string // why audit.head could not be used at startup
+	cut      string // how the log fell short of audit.head at startup
+}
+
+// Open opens the audit log in dir, continuing the chain of the last entry
+func Open(dir string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxSize < 1 || maxFiles < 1 {
+		return nil, fmt.Errorf("audit log needs a size limit and at least one rotated file")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	l := &Log{dir: dir, maxSize: maxSize, maxFiles: maxFiles, head: genesis}
+
+	// The newest entry is in audit.log, or in audit.log.1 right after a
+	// rotation
+	for _, path := range []string{l.path(0), l.path(1)} {
+		last, err := lastEntry(path)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq, l.head = last.Seq, last.Hash
+			break
+		}
+	}
+
+	// audit.head wins unless it is behind, which a power cut between
+	// writing an entry and the head can cause. The chain then continues
+	// from the entry that was really last, and Verify reports the gap.
+	saved, err := l.readHead()
+	switch {
+	case os.IsNotExist(err):
+		// Expected for a new log, or one written before audit.head
+		if l.seq > 0 {
+			l.headLost = headFile + " was missing"
+		}
+	case err != nil:
+		l.headLost = err.Error()
+	case saved.Seq >= l.seq:
+		// Remembered, since entries written later could hide a log that
+		// was emptied
+		if saved.Seq > l.seq {
+			l.cut = fmt.Sprintf("log ended at entry %d at startup, but %d entries were written", l.seq, saved.Seq)
+		} else if saved.Hash != l.head {
+			l.cut = fmt.Sprintf("entry %d differed from %s at startup", l.seq, headFile)
+		}
+		l.seq, l.head = saved.Seq, saved.Hash
+	}
+
+	if err := l.openCurrent(); err != nil {
+		return nil, err
+	}
+	if err := l.writeHead(); err != nil {
+		l.f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// readHead reads audit.head
+func (l *Log) readHead() (head, error) {
+	var h head
+	data, err := os.ReadFile(filepath.Join(l.dir, headFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, err
+		}
+		return h, fmt.Errorf("failed to read %s: %w", headFile, err)
+	}
+	if err := json.Unmarshal(data, &h); err != nil || len(h.Hash) != len(genesis) {
+		return h, fmt.Errorf("%s is damaged", headFile)
+	}
+	return h, nil
+}
+
+// writeHead replaces audit.head with the last entry; the caller holds mu
+func (l *Log) writeHead() error {
+	data, err := json.Marshal(head{Seq: l.seq, Hash: l.head})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, headFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", headFile, err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", headFile, err)
+	}
+	return nil
+}
+
+// path returns audit.log for n = 0 and audit.log.n for rotated files
+func (l *Log) path(n int) string {
+	if n == 0 {
+		return filepath.Join(l.dir, fileName)
+	}
+	return filepath.Join(l.dir, fileName+"."+strconv.Itoa(n))
+}
+
+// openCurrent opens audit.log for appending; the caller holds mu
+func (l *Log) openCurrent() error {
+	f, err := os.OpenFile(l.path(0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+
+	// Finish a line torn by a power cut so the next entry starts afresh
+	if l.size > 0 {
+		last := make([]byte, 1)
+		if r, err := os.Open(l.path(0)); err == nil {
+			r.ReadAt(last, l.size-1)
+			r.Close()
+		}
+		if last[0] != '\n' {
+			n, _ := f.Write([]byte("\n"))
+			l.size += int64(n)
+		}
+	}
+	return nil
+}
+
+// Append numbers an entry, chains it to the previous one and writes it
+func (l *Log) Append(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Prev = l.head
+	e.Hash = ""
+	if e.Time == "" {
+		e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	line := append(body[:len(body)-1], `,"hash":"`+hash+`"}`+"\n"...)
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.f.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	l.size += int64(len(line))
+	l.seq, l.head = e.Seq, hash
+	return l.writeHead()
+}
+
+// rotate shifts audit.log to audit.log.1 and so on, dropping the oldest;
+// the caller holds mu
+func (l *Log) rotate() error {
+	// The current file stays open until its successor is, so a failed
+	// rotation leaves the log writable
+	os.Remove(l.path(l.maxFiles))
+	for n := l.maxFiles - 1; n >= 0; n-- {
+		if err := os.Rename(l.path(n), l.path(n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	old := l.f
+	if err := l.openCurrent(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// Head returns the sequence number and hash of the last entry. Keeping a
+// copy off the device shows later whether the log and audit.head were
+// both rewritten.
+func (l *Log) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.head
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// files lists the existing log files, oldest first; the caller holds mu
+func (l *Log) files() []string {
+	var paths []string
+	for n := l.maxFiles; n >= 0; n-- {
+		if _, err := os.Stat(l.path(n)); err == nil {
+			paths = append(paths, l.path(n))
+		}
+	}
+	return paths
+}
+
+// WriteTo writes all log files, oldest first, as one JSON lines stream
+func (l *Log) WriteTo(w io.Writer) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total int64
+	for _, path := range l.files() {
+		f, err := os.Open(path)
+		if err != nil {
+			return total, err
+		}
+		n, err := io.Copy(w, f)
+		f.Close()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Query selects entries, newest first
+type Query struct {
+	Actor   string
+	Action  string // substring of the action
+	Since   time.Time
+	Until   time.Time
+	Success *bool
+	Limit   int
+}
+
+// Entries returns the entries matching q, newest first
+func (l *Log) Entries(q Query) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []Entry
+	err := l.scan(func(e Entry, _ []byte) error {
+		if e.Seq != 0 && q.matches(e) {
+			matched = append(matched, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+	return matched, nil
+}
+
+func (q Query) matches(e Entry) bool {
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" && !strings.Contains(e.Action, q.Action) {
+		return false
+	}
+	if q.Success != nil && e.Success != *q.Success {
+		return false
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil || (!q.Since.IsZero() && t.Before(q.Since)) || (!q.Until.IsZero() && t.After(q.Until)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Verification is the result of checking the hash chain
+type Verification struct {
+	OK       bool   `json:"ok"`
+	Entries  int    `json:"entries"`
+	FirstSeq uint64 `json:"first_seq"`
+	LastSeq  uint64 `json:"last_seq"`
+	Head     string `json:"head"`
+	Anchor   string `json:"anchor"`            // previous hash of the oldest entry kept
+	Error    string `json:"error,omitempty"`   // first problem found
+	BadSeq   uint64 `json:"bad_seq,omitempty"` // entry where it was found
+	Warning  string `json:"warning,omitempty"` // audit.head was unusable at startup
+}
+
+// Verify recomputes every kept entry's hash and checks that each names the
+// one before it, and that the last one is the last written according to
+// audit.head. The oldest kept entry's predecessor may have been rotated
+// away; its hash is reported as the anchor.
+func (l *Log) Verify() Verification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v := Verification{OK: true}
+	prev := ""
+	fail := func(seq uint64, format string, args ...interface{}) error {
+		v.OK, v.BadSeq, v.Error = false, seq, fmt.Sprintf(format, args...)
+		return errStop
+	}
+	err := l.scan(func(e Entry, line []byte) error {
+		if e.Seq == 0 {
+			return fail(v.LastSeq+1, "unreadable entry after entry %d", v.LastSeq)
+		}
+		m := hashSuffix.FindSubmatchIndex(line)
+		if m == nil {
+			return fail(e.Seq, "entry %d has no hash", e.Seq)
+		}
+		body := append(append([]byte{}, line[:m[0]]...), '}')
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != e.Hash {
+			return fail(e.Seq, "entry %d was modified", e.Seq)
+		}
+		if v.Entries == 0 {
+			v.FirstSeq, v.Anchor = e.Seq, e.Prev
+		} else if e.Prev != prev || e.Seq != v.LastSeq+1 {
+			return fail(e.Seq, "entry %d does not follow entry %d", e.Seq, v.LastSeq)
+		}
+		prev = e.Hash
+		v.Entries++
+		v.LastSeq = e.Seq
+		return nil
+	})
+	if err != nil && err != errStop {
+		v.OK, v.Error = false, err.Error()
+	}
+	// Truncating the log, even to nothing, shows as a missing tail
+	if v.OK && v.Entries == 0 && l.seq > 0 {
+		v.OK, v.Error = false, fmt.Sprintf("log is empty, but %d entries were written", l.seq)
+	} else if v.OK && (v.LastSeq != l.seq || prev != l.head) {
+		v.OK, v.BadSeq, v.Error = false, v.LastSeq, fmt.Sprintf("log ends at entry %d, but %d were written", v.LastSeq, l.seq)
+	}
+	if v.OK && l.cut != "" {
+		v.OK, v.Error = false, l.cut
+	}
+	v.Head = prev
+	if l.headLost != "" {
+		v.Warning = l.headLost + " at startup, so entries removed from the end before then would go unnoticed"
+	}
+	return v
+}
+
+var errStop = fmt.Errorf("stop")
+
+// scan calls fn for every line, oldest first, with its entry; the caller
+// holds mu
+func (l *Log) scan(fn func(e Entry, line []byte) error) error {
+	for _, path := range l.files() {
+		if err := scanFile(path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFile calls fn for every line in one file
+func scanFile(path string, fn func(e Entry, line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		// An unreadable line is passed as an entry with no sequence number
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			e = Entry{}
+		}
+		if err := fn(e, line); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// lastEntry returns the last readable entry of a file, or nil if it has
+// none. A line torn by a power cut is skipped; Verify reports it.
+func lastEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var e Entry
+		if json.Unmarshal(lines[i], &e) == nil && e.Hash != "" {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
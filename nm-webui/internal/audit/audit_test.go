@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// appendN opens the log in dir and appends n entries
+func appendN(t *testing.T, dir string, n int) *Log {
+	t.Helper()
+	l, err := Open(dir, 1<<20, 2)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Append(Entry{Actor: "alice", Method: "POST", Action: "wifi/connect", Status: 200, Success: true}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return l
+}
+
+// reopen closes l and opens its directory again
+func reopen(t *testing.T, l *Log) *Log {
+	t.Helper()
+	l.Close()
+	again, err := Open(l.dir, l.maxSize, l.maxFiles)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return again
+}
+
+// cutLines keeps the first n lines of audit.log
+func cutLines(t *testing.T, dir string, n int) {
+	t.Helper()
+	path := filepath.Join(dir, fileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := os.WriteFile(path, bytes.Join(lines[:n], nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	l := appendN(t, dir, 3)
+	v := l.Verify()
+	if !v.OK || v.Entries != 3 || v.FirstSeq != 1 || v.LastSeq != 3 || v.Anchor != genesis || v.Warning != "" {
+		t.Fatalf("Verify = %+v", v)
+	}
+	seq, head := l.Head()
+	if seq != 3 || head != v.Head {
+		t.Errorf("Head = %d %s, want 3 %s", seq, head, v.Head)
+	}
+
+	// The chain continues across restarts
+	l = reopen(t, l)
+	if err := l.Append(Entry{Method: "POST", Action: "system/reboot"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.Verify(); !v.OK || v.LastSeq != 4 {
+		t.Errorf("after restart: %+v", v)
+	}
+
+	// Editing an entry breaks its hash
+	path := filepath.Join(dir, fileName)
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, bytes.Replace(data, []byte("system/reboot"), []byte("system/reload"), 1), 0600)
+	if v := l.Verify(); v.OK || v.BadSeq != 4 {
+		t.Errorf("after editing: %+v", v)
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		keep int // lines of audit.log kept while stopped
+	}{
+		{"last entry", 4},
+		{"several entries", 2},
+		{"everything", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := appendN(t, dir, 5)
+			l.Close()
+			cutLines(t, dir, tt.keep)
+
+			l, err := Open(dir, 1<<20, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if v := l.Verify(); v.OK || !strings.Contains(v.Error, "5") {
+				t.Fatalf("Verify = %+v, want the missing tail reported", v)
+			}
+			// Entries written afterwards keep showing the gap
+			if err := l.Append(Entry{Method: "POST", Action: "wifi/connect"}); err != nil {
+				t.Fatal(err)
+			}
+			if v := l.Verify(); v.OK {
+				t.Errorf("Verify after a new entry = %+v", v)
+			}
+		})
+	}
+}
+
+func TestHeadFile(t *testing.T) {
+	dir := t.TempDir()
+	l := appendN(t, dir, 2)
+
+	// A log from before audit.head is taken as it is, with a warning
+	os.Remove(filepath.Join(dir, headFile))
+	l = reopen(t, l)
+	if v := l.Verify(); !v.OK || v.Warning == "" {
+		t.Errorf("missing head: %+v", v)
+	}
+	l = reopen(t, l)
+	if v := l.Verify(); !v.OK || v.Warning != "" {
+		t.Errorf("after recreating the head: %+v", v)
+	}
+
+	// A head behind the log, as after a power cut, is caught up
+	l.Close()
+	stale := appendN(t, t.TempDir(), 1)
+	stale.Close()
+	data, _ := os.ReadFile(filepath.Join(stale.dir, headFile))
+	os.WriteFile(filepath.Join(dir, headFile), data, 0600)
+	l = appendN(t, dir, 1)
+	if v := l.Verify(); !v.OK || v.LastSeq != 3 {
+		t.Errorf("stale head: %+v", v)
+	}
+
+	os.WriteFile(filepath.Join(dir, headFile), []byte("garbage"), 0600)
+	l = reopen(t, l)
+	defer l.Close()
+	if v := l.Verify(); !v.OK || !strings.Contains(v.Warning, "damaged") {
+		t.Errorf("damaged head: %+v", v)
+	}
+}
@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/listen"
+	"nm-webui/internal/logger"
+)
+
+// maxBody is the largest request body whose parameters are recorded
+const maxBody = 64 << 10
+
+// Redacted replaces secret parameter values
+const Redacted = "[redacted]"
+
+// secretParams are parameter names, or parts of names, whose values are
+// never recorded
+var secretParams = []string{"pass", "secret", "token", "private", "psk", "totp", "code", "recovery", "credential"}
+
+type ctxKey struct{}
+
+// record is the entry being built for a request
+type record struct {
+	entry     Entry
+	start     time.Time
+	keep      bool // the caller authenticated, or Keep was called
+	committed bool
+}
+
+// SetActor names the user responsible for a request, once they are known,
+// and has it recorded
+func SetActor(r *http.Request, user string) {
+	if rec, ok := r.Context().Value(ctxKey{}).(*record); ok {
+		rec.entry.Actor = user
+		rec.keep = true
+	}
+}
+
+// Keep has a request recorded although nobody authenticated: a failed
+// login counted by the login limiter, which bounds how many one address
+// can cause, or any request when authentication is disabled
+func Keep(r *http.Request) {
+	if rec, ok := r.Context().Value(ctxKey{}).(*record); ok {
+		rec.keep = true
+	}
+}
+
+// Wrap records requests that are not read-only, whatever their outcome,
+// once SetActor or Keep has been called for them. Anonymous requests are
+// left out, so unauthenticated clients cannot fill the log. WebSocket
+// upgrades are recorded when the connection is taken over, since they last
+// until it closes.
+func (l *Log) Wrap(next http.Handler, log *logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.ReadOnly(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &record{
+			start: time.Now(),
+			entry: Entry{
+				RequestID: logger.RequestID(r.Context()),
+				Remote:    auth.ClientAddr(r),
+				Interface: listen.Interface(r),
+				Method:    r.Method,
+				Action:    strings.TrimPrefix(r.URL.Path, "/api/"),
+				Params:    params(r),
+			},
+		}
+		commit := func(status int) {
+			if rec.committed || !rec.keep {
+				return
+			}
+			rec.committed = true
+			rec.entry.Status = status
+			rec.entry.Success = status < 400
+			rec.entry.DurationMs = time.Since(rec.start).Milliseconds()
+			if err := l.Append(rec.entry); err != nil {
+				log.Error("audit", "write").
+					WithContext(r.Context()).
+					WithError(err).
+					WithExtra("action", rec.entry.Action).
+					Commit()
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK, onHijack: func() { commit(http.StatusSwitchingProtocols) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, rec)))
+		commit(sw.status)
+	})
+}
+
+// params returns a request's query and body parameters with secrets
+// redacted. Uploaded files are described, not recorded.
+func params(r *http.Request) map[string]interface{} {
+	p := make(map[string]interface{})
+	for name, values := range r.URL.Query() {
+		p[name] = strings.Join(values, ",")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case r.Body == nil || r.ContentLength == 0:
+	case mediaType == "multipart/form-data" || r.ContentLength > maxBody:
+		p["body"] = map[string]interface{}{"type": mediaType, "bytes": r.ContentLength}
+	default:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+		if err != nil || len(data) > maxBody {
+			p["body"] = map[string]interface{}{"type": mediaType, "bytes": len(data)}
+			break
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal(data, &fields) == nil {
+			for k, v := range fields {
+				p[k] = v
+			}
+		} else if len(bytes.TrimSpace(data)) > 0 {
+			p["body"] = map[string]interface{}{"type": mediaType, "bytes": len(data)}
+		}
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+	return redact(p).(map[string]interface{})
+}
+
+// redact replaces the values of secret parameters, at any depth
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if secretParam(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = redact(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = redact(val)
+		}
+	}
+	return v
+}
+
+// secretParam reports whether a parameter name holds a secret
+func secretParam(name string) bool {
+	name = strings.ToLower(name)
+	if name == "key" || name == "env" || name == "content" {
+		return true
+	}
+	for _, s := range secretParams {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusWriter captures the response status and notices WebSocket
+// hijacks
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	wrote    bool
+	onHijack func()
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the terminal take over the connection
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := hj.Hijack()
+	if err == nil {
+		w.onHijack()
+	}
+	return conn, brw, err
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nm-webui/internal/logger"
+)
+
+func TestWrapRecords(t *testing.T) {
+	l, err := Open(t.TempDir(), 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("as") {
+		case "user":
+			SetActor(r, "alice")
+		case "failed":
+			Keep(r)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}), logger.NewDefault())
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		record bool
+	}{
+		{"anonymous", http.MethodPost, "", false},
+		{"logged in", http.MethodPost, "as=user", true},
+		{"failed login", http.MethodPost, "as=failed", true},
+		{"read-only", http.MethodGet, "as=user", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := l.Head()
+			body := strings.NewReader(`{"name":"home","password":"hunter2"}`)
+			r := httptest.NewRequest(tt.method, "/api/wifi/connect?"+tt.query, body)
+			r.Header.Set("Content-Type", "application/json")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			after, _ := l.Head()
+			if got := after > before; got != tt.record {
+				t.Errorf("recorded = %v, want %v", got, tt.record)
+			}
+		})
+	}
+
+	entries, err := l.Entries(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	failed, user := entries[0], entries[1]
+	if user.Actor != "alice" || user.Status != http.StatusForbidden || user.Success || user.Action != "wifi/connect" {
+		t.Errorf("entry = %+v", user)
+	}
+	if failed.Actor != "" || failed.Status != http.StatusUnauthorized {
+		t.Errorf("failed login entry = %+v", failed)
+	}
+	if user.Params["name"] != "home" || user.Params["password"] != Redacted {
+		t.Errorf("params = %v", user.Params)
+	}
+}
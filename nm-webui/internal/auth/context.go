@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// CookieName is the session cookie
+const CookieName = "nm_webui_session"
+
+// CSRFHeader carries the session's CSRF token on state-changing requests
+const CSRFHeader = "X-CSRF-Token"
+
+type contextKey struct{}
+
+// WithSession returns a context carrying the authenticated session
+func WithSession(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, sess)
+}
+
+// FromContext returns the authenticated session, or nil
+func FromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(contextKey{}).(*Session)
+	return sess
+}
+
+// Username returns the authenticated user of a request, or "" when
+// authentication is disabled
+func Username(r *http.Request) string {
+	if sess := FromContext(r.Context()); sess != nil {
+		return sess.User
+	}
+	return ""
+}
+
+// RoleOf returns the role of the authenticated user. Without
+// authentication everyone is an admin.
+func RoleOf(r *http.Request) Role {
+	if sess := FromContext(r.Context()); sess != nil {
+		return sess.Role
+	}
+	return RoleAdmin
+}
+
+// SetCookie sets the session cookie. It is marked Secure when the request
+// arrived over TLS.
+func SetCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearCookie removes the session cookie
+func ClearCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Token returns the session token from the request cookie
+func Token(r *http.Request) string {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// ClientAddr returns the source address of a request without the port,
+// used to throttle logins
+func ClientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxFailures = 5
+	defaultLockout     = 15 * time.Minute
+)
+
+// LoginLimiter throttles failed logins per source address. After
+// maxFailures failures within the lockout period the address is locked out
+// for that period.
+type LoginLimiter struct {
+	maxFailures int
+	lockout     time.Duration
+
+	mu    sync.Mutex
+	addrs map[string]*loginFailures
+}
+
+// loginFailures tracks recent failures from one address
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// NewLoginLimiter creates a limiter
+func NewLoginLimiter(maxFailures int, lockout time.Duration) *LoginLimiter {
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailures
+	}
+	if lockout <= 0 {
+		lockout = defaultLockout
+	}
+	return &LoginLimiter{
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		addrs:       make(map[string]*loginFailures),
+	}
+}
+
+// Locked reports whether addr is locked out and for how long
+func (l *LoginLimiter) Locked(addr string) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.addrs[addr]
+	if !ok || !now.Before(f.lockedUntil) {
+		return 0, false
+	}
+	return f.lockedUntil.Sub(now), true
+}
+
+// Fail records a failed login and reports whether it locked addr out
+func (l *LoginLimiter) Fail(addr string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	f, ok := l.addrs[addr]
+	if !ok || now.Sub(f.first) > l.lockout {
+		f = &loginFailures{first: now}
+		l.addrs[addr] = f
+	}
+	f.count++
+	if f.count >= l.maxFailures {
+		f.lockedUntil = now.Add(l.lockout)
+		f.count = 0
+		f.first = now
+		return true
+	}
+	return false
+}
+
+// Success clears the failures of addr
+func (l *LoginLimiter) Success(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.addrs, addr)
+}
+
+// prune forgets addresses with no recent failures; the caller holds mu
+func (l *LoginLimiter) prune(now time.Time) {
+	for addr, f := range l.addrs {
+		if now.Sub(f.first) > l.lockout && !now.Before(f.lockedUntil) {
+			delete(l.addrs, addr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	l := NewLoginLimiter(3, 100*time.Millisecond)
+	const addr = "198.51.100.9"
+
+	for i := 1; i <= 2; i++ {
+		if l.Fail(addr) {
+			t.Fatalf("failure %d locked the address out", i)
+		}
+		if _, locked := l.Locked(addr); locked {
+			t.Fatalf("locked after %d failures", i)
+		}
+	}
+	if !l.Fail(addr) {
+		t.Fatal("third failure did not lock the address out")
+	}
+	wait, locked := l.Locked(addr)
+	if !locked || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("Locked = %v, %v", wait, locked)
+	}
+
+	// Other addresses are not affected
+	if _, locked := l.Locked("198.51.100.10"); locked {
+		t.Error("lockout applies to another address")
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	if _, locked := l.Locked(addr); locked {
+		t.Error("still locked after the lockout period")
+	}
+}
+
+func TestLoginLimiterSuccess(t *testing.T) {
+	l := NewLoginLimiter(3, time.Minute)
+	const addr = "198.51.100.9"
+
+	l.Fail(addr)
+	l.Fail(addr)
+	l.Success(addr)
+	if l.Fail(addr) || l.Fail(addr) {
+		t.Fatal("failures before a successful login still counted")
+	}
+}
+
+func TestLoginLimiterWindow(t *testing.T) {
+	l := NewLoginLimiter(3, 60*time.Millisecond)
+	const addr = "198.51.100.9"
+
+	// Failures spread wider than the lockout period do not add up
+	l.Fail(addr)
+	l.Fail(addr)
+	time.Sleep(80 * time.Millisecond)
+	if l.Fail(addr) || l.Fail(addr) {
+		t.Fatal("failures from an earlier period counted")
+	}
+	if !l.Fail(addr) {
+		t.Fatal("third failure within the period did not lock the address out")
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// PeerPrefix starts the session user of a Unix socket client, so it never
+// matches a web UI user
+const PeerPrefix = "unix:"
+
+// PeerRoles maps local users and groups to roles for clients of the Unix
+// socket, identified by the kernel's peer credentials
+type PeerRoles struct {
+	users  map[uint32]Role
+	groups map[uint32]Role
+}
+
+// ParsePeerRoles parses "user=role" and "@group=role" entries; users and
+// groups may be names or numeric IDs
+func ParsePeerRoles(entries []string) (*PeerRoles, error) {
+	p := &PeerRoles{users: make(map[uint32]Role), groups: make(map[uint32]Role)}
+	for _, entry := range entries {
+		name, roleName, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want user=role or @group=role", entry)
+		}
+		role, err := ParseRole(roleName)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+
+		if group, ok := strings.CutPrefix(name, "@"); ok {
+			gid, err := lookupID(group, true)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", entry, err)
+			}
+			p.groups[gid] = role
+			continue
+		}
+		uid, err := lookupID(name, false)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		p.users[uid] = role
+	}
+	return p, nil
+}
+
+// lookupID resolves a user or group name, or a numeric ID
+func lookupID(name string, group bool) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	var id string
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
+
+// Role returns the role of a local user: its own entry if it has one,
+// otherwise the highest role of its groups
+func (p *PeerRoles) Role(uid, gid uint32) (Role, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if role, ok := p.users[uid]; ok {
+		return role, true
+	}
+
+	gids := []uint32{gid}
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+					gids = append(gids, uint32(n))
+				}
+			}
+		}
+	}
+	best, found := Role(0), false
+	for _, id := range gids {
+		if role, ok := p.groups[id]; ok && (!found || role > best) {
+			best, found = role, true
+		}
+	}
+	return best, found
+}
+
+// PeerName names a local user for sessions and logs, e.g. "unix:root"
+func PeerName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return PeerPrefix + u.Username
+	}
+	return PeerPrefix + id
+}
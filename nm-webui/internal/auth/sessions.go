@@ -0,0 +1,242 @@
+// Package auth provides web UI sessions, login throttling and the
+// authenticated user of a request
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"nm-webui/internal/types"
+)
+
+const (
+	defaultIdleTimeout = 2 * time.Hour
+	defaultMaxAge      = 24 * time.Hour
+)
+
+// Session is a logged-in browser
+type Session struct {
+	ID        string // public identifier, safe to list
+	User      string
+	Role      Role     // looked up from the user store on each request
+	EnrolTOTP bool     // must enrol TOTP before using anything else
+	TokenID   string   // set when authenticated with an API token
+	Scopes    []string // API token scopes
+	Peer      bool     // set when authenticated by Unix socket peer credentials
+	CSRFToken string
+	Remote    string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time // absolute expiry
+}
+
+// SessionStore keeps sessions in memory, keyed by a hash of the cookie token
+type SessionStore struct {
+	idleTimeout time.Duration
+	maxAge      time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	onEnd    func(id, user string)
+}
+
+// NewSessionStore creates a session store. Sessions end after idleTimeout
+// without requests or maxAge after login, whichever comes first.
+func NewSessionStore(idleTimeout, maxAge time.Duration) *SessionStore {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	if maxAge <= 0 {
+		maxAge = defaultMaxAge
+	}
+	return &SessionStore{
+		idleTimeout: idleTimeout,
+		maxAge:      maxAge,
+		sessions:    make(map[string]*Session),
+	}
+}
+
+// Create starts a session and returns it with the cookie token
+func (s *SessionStore) Create(user, remote, userAgent string) (*Session, string) {
+	now := time.Now()
+	token := randomToken(32)
+	sess := &Session{
+		ID:        randomToken(8),
+		User:      user,
+		CSRFToken: randomToken(32),
+		Remote:    remote,
+		UserAgent: userAgent,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(s.maxAge),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	s.sessions[tokenKey(token)] = sess
+	return sess, token
+}
+
+// Get returns the session for a cookie token and records the activity
+func (s *SessionStore) Get(token string) (*Session, bool) {
+	if token == "" {
+		return nil, false
+	}
+	now := time.Now()
+	key := tokenKey(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	if s.expired(sess, now) {
+		delete(s.sessions, key)
+		return nil, false
+	}
+	sess.LastSeen = now
+	copied := *sess
+	return &copied, true
+}
+
+// OnEnd registers fn to be called when sessions are logged out or
+// revoked, so anything opened under them can be closed too. id is empty
+// when every session of user ended. Expired sessions are not reported.
+func (s *SessionStore) OnEnd(fn func(id, user string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEnd = fn
+}
+
+// Delete ends the session for a cookie token
+func (s *SessionStore) Delete(token string) {
+	key := tokenKey(token)
+
+	s.mu.Lock()
+	sess, ok := s.sessions[key]
+	delete(s.sessions, key)
+	onEnd := s.onEnd
+	s.mu.Unlock()
+
+	if ok && onEnd != nil {
+		onEnd(sess.ID, sess.User)
+	}
+}
+
+// Revoke ends a session by its public ID. A non-empty user only allows
+// revoking that user's sessions.
+func (s *SessionStore) Revoke(id, user string) error {
+	s.mu.Lock()
+	var ended *Session
+	for key, sess := range s.sessions {
+		if sess.ID == id && (user == "" || sess.User == user) {
+			delete(s.sessions, key)
+			ended = sess
+			break
+		}
+	}
+	onEnd := s.onEnd
+	s.mu.Unlock()
+
+	if ended == nil {
+		return fmt.Errorf("session not found")
+	}
+	if onEnd != nil {
+		onEnd(ended.ID, ended.User)
+	}
+	return nil
+}
+
+// RevokeUser ends all sessions of a user
+func (s *SessionStore) RevokeUser(user string) {
+	s.mu.Lock()
+	for key, sess := range s.sessions {
+		if sess.User == user {
+			delete(s.sessions, key)
+		}
+	}
+	onEnd := s.onEnd
+	s.mu.Unlock()
+
+	if onEnd != nil {
+		onEnd("", user)
+	}
+}
+
+// List returns the active sessions, newest first. current is the ID of
+// the caller's session; a non-empty user limits the list to that user.
+func (s *SessionStore) List(current, user string) []types.AuthSession {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	list := make([]types.AuthSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if user != "" && sess.User != user {
+			continue
+		}
+		list = append(list, types.AuthSession{
+			ID:        sess.ID,
+			User:      sess.User,
+			Remote:    sess.Remote,
+			UserAgent: sess.UserAgent,
+			Created:   sess.Created.Format(time.RFC3339),
+			LastSeen:  sess.LastSeen.Format(time.RFC3339),
+			Expires:   s.expiry(sess).Format(time.RFC3339),
+			Current:   sess.ID == current,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created > list[j].Created })
+	return list
+}
+
+// Expiry returns when a session ends if it stays idle
+func (s *SessionStore) Expiry(sess *Session) time.Time {
+	return s.expiry(sess)
+}
+
+func (s *SessionStore) expiry(sess *Session) time.Time {
+	idle := sess.LastSeen.Add(s.idleTimeout)
+	if idle.Before(sess.Expires) {
+		return idle
+	}
+	return sess.Expires
+}
+
+func (s *SessionStore) expired(sess *Session, now time.Time) bool {
+	return !now.Before(s.expiry(sess))
+}
+
+// prune drops expired sessions; the caller holds mu
+func (s *SessionStore) prune(now time.Time) {
+	for key, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// tokenKey hashes a cookie token so the map never holds usable tokens
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes hex-encoded
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth: crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
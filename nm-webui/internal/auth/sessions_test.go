@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionOnEnd(t *testing.T) {
+	s := NewSessionStore(0, 0)
+	type ended struct{ id, user string }
+	var got []ended
+	s.OnEnd(func(id, user string) { got = append(got, ended{id, user}) })
+
+	a, tokenA := s.Create("alice", "192.0.2.1:1000", "test")
+	b, _ := s.Create("alice", "192.0.2.1:1001", "test")
+	s.Create("bob", "192.0.2.2:1000", "test")
+
+	s.Delete(tokenA)
+	s.Delete(tokenA)
+	if err := s.Revoke(b.ID, "bob"); err == nil {
+		t.Error("revoked another user's session")
+	}
+	if err := s.Revoke(b.ID, "alice"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	s.RevokeUser("bob")
+
+	want := []ended{{a.ID, "alice"}, {b.ID, "alice"}, {"", "bob"}}
+	if len(got) != len(want) {
+		t.Fatalf("ended = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ended[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name         string
+		idle, maxAge time.Duration
+		touch        bool // request in between, which only extends the idle timeout
+		wait         time.Duration
+		valid        bool
+	}{
+		{"fresh", time.Hour, time.Hour, false, 0, true},
+		{"idle", 40 * time.Millisecond, time.Hour, false, 80 * time.Millisecond, false},
+		{"kept alive", 60 * time.Millisecond, time.Hour, true, 80 * time.Millisecond, true},
+		{"past max age", time.Hour, 60 * time.Millisecond, true, 80 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSessionStore(tt.idle, tt.maxAge)
+			_, token := s.Create("alice", "192.0.2.1:1000", "test")
+			if tt.touch {
+				time.Sleep(tt.wait / 2)
+				if _, ok := s.Get(token); !ok {
+					t.Fatal("session ended early")
+				}
+				time.Sleep(tt.wait / 2)
+			} else {
+				time.Sleep(tt.wait)
+			}
+			if _, ok := s.Get(token); ok != tt.valid {
+				t.Errorf("valid = %v, want %v", ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	s := NewSessionStore(0, 0)
+	sess, token := s.Create("alice", "192.0.2.1:1000", "test")
+	if len(token) != 64 || len(sess.CSRFToken) != 64 || sess.CSRFToken == token {
+		t.Fatalf("token %q, CSRF token %q", token, sess.CSRFToken)
+	}
+
+	// The store is keyed by a hash, so the cookie token is not kept
+	for key := range s.sessions {
+		if key == token {
+			t.Error("session map holds the cookie token")
+		}
+	}
+
+	// Callers get a copy they cannot use to change the stored session
+	got, ok := s.Get(token)
+	if !ok || got.ID != sess.ID || got.CSRFToken != sess.CSRFToken {
+		t.Fatalf("Get = %+v, %v", got, ok)
+	}
+	got.User = "mallory"
+	if again, _ := s.Get(token); again.User != "alice" {
+		t.Error("changing a returned session changed the store")
+	}
+
+	if _, ok := s.Get(""); ok {
+		t.Error("empty token found a session")
+	}
+}
+
+func TestSessionList(t *testing.T) {
+	s := NewSessionStore(0, 0)
+	a, _ := s.Create("alice", "192.0.2.1:1000", "test")
+	time.Sleep(1100 * time.Millisecond) // Created is listed to the second
+	b, _ := s.Create("alice", "192.0.2.1:1001", "test")
+	s.Create("bob", "192.0.2.2:1000", "test")
+
+	all := s.List(a.ID, "")
+	if len(all) != 3 {
+		t.Fatalf("listed %d sessions, want 3", len(all))
+	}
+	mine := s.List(a.ID, "alice")
+	if len(mine) != 2 || mine[0].ID != b.ID || mine[1].ID != a.ID {
+		t.Fatalf("alice's sessions = %+v, want newest first", mine)
+	}
+	if mine[0].Current || !mine[1].Current {
+		t.Errorf("current flags = %v, %v", mine[0].Current, mine[1].Current)
+	}
+}
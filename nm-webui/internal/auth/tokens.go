@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nm-webui/internal/types"
+)
+
+// TokenPrefix starts every API token, so they are easy to spot in scripts
+// and secret scanners
+const TokenPrefix = "nmw_"
+
+const (
+	maxTokenNameLen = 64
+	tokenSaveEvery  = time.Minute // how often last-used times are written
+)
+
+// scopeAreas maps API paths to the scope areas tokens are granted. A prefix
+// covers itself and the paths below it. Paths not listed (including
+// /api/auth) cannot be used with a token.
+var scopeAreas = []struct{ prefix, area string }{
+	{"/api/status", "status"},
+	{"/api/log", "logs"},
+	{"/api/logs", "logs"},
+	{"/api/audit", "logs"},
+	{"/api/wifi", "wifi"},
+	{"/api/connections", "network"},
+	{"/api/network", "network"},
+	{"/api/ssh/tunnels", "tunnels"},
+	{"/api/ssh/phonehome", "tunnels"},
+	{"/api/ssh/keys", "keys"},
+	{"/api/configure", "configure"},
+	{"/api/system", "system"},
+	{"/api/tls", "system"},
+	{"/api/terminal", "terminal"},
+	{"/metrics", "metrics"},
+}
+
+// ScopeAreas lists the areas a token scope can name
+func ScopeAreas() []string {
+	var areas []string
+	seen := make(map[string]bool)
+	for _, a := range scopeAreas {
+		if !seen[a.area] {
+			seen[a.area] = true
+			areas = append(areas, a.area)
+		}
+	}
+	return areas
+}
+
+// checkScope validates a scope: an area, "*" for all areas, optionally
+// with ":read" to allow only GET requests
+func checkScope(scope string) error {
+	area := strings.TrimSuffix(scope, ":read")
+	if area == "*" {
+		return nil
+	}
+	for _, a := range scopeAreas {
+		if a.area == area {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown scope %q", scope)
+}
+
+// Area returns the scope area of an API path, or "" if it has none
+func Area(path string) string {
+	for _, a := range scopeAreas {
+		if path == a.prefix || strings.HasPrefix(path, a.prefix+"/") {
+			return a.area
+		}
+	}
+	return ""
+}
+
+// ReadOnly reports whether a request only reads: a GET or HEAD that is not
+// a WebSocket upgrade, since the terminal is opened with a GET
+func ReadOnly(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return !strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// ScopeAllows reports whether scopes permit a request
+func ScopeAllows(scopes []string, r *http.Request) bool {
+	area := Area(r.URL.Path)
+	if area == "" {
+		return false
+	}
+
+	read := ReadOnly(r)
+	for _, scope := range scopes {
+		name, readOnly := strings.CutSuffix(scope, ":read")
+		if (name == area || name == "*") && (read || !readOnly) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is an API token. Only a hash of the secret is kept.
+type APIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	User       string    `json:"user"`
+	Scopes     []string  `json:"scopes"`
+	Hash       string    `json:"hash"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires,omitempty"` // zero never expires
+	LastUsed   time.Time `json:"last_used,omitempty"`
+	LastRemote string    `json:"last_remote,omitempty"`
+}
+
+// TokenStore keeps API tokens, saved as JSON next to the auth file
+type TokenStore struct {
+	path string // empty keeps tokens in memory only
+
+	mu        sync.Mutex
+	tokens    map[string]*APIToken // by hash
+	lastSaved time.Time
+}
+
+// LoadTokens reads the token file. A missing file gives an empty store.
+func LoadTokens(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path, tokens: make(map[string]*APIToken)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	var list []*APIToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+	for _, t := range list {
+		s.tokens[t.Hash] = t
+	}
+	return s, nil
+}
+
+// Create issues a token for a user and returns it with the secret, which
+// is not stored. A zero ttl never expires.
+func (s *TokenStore) Create(user, name string, scopes []string, ttl time.Duration) (types.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLen {
+		return types.APIToken{}, "", fmt.Errorf("token name must be 1-%d characters", maxTokenNameLen)
+	}
+	if len(scopes) == 0 {
+		return types.APIToken{}, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if err := checkScope(scope); err != nil {
+			return types.APIToken{}, "", err
+		}
+	}
+
+	secret := TokenPrefix + randomToken(32)
+	now := time.Now()
+	t := &APIToken{
+		ID:      randomToken(8),
+		Name:    name,
+		User:    user,
+		Scopes:  scopes,
+		Hash:    tokenKey(secret),
+		Created: now,
+	}
+	if ttl > 0 {
+		t.Expires = now.Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.Hash] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.Hash)
+		return types.APIToken{}, "", err
+	}
+	return t.info(), secret, nil
+}
+
+// Use looks up a token secret and records its use. Expired tokens are
+// removed.
+func (s *TokenStore) Use(secret, remote string) (*APIToken, bool) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return nil, false
+	}
+	key := tokenKey(secret)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[key]
+	if !ok {
+		return nil, false
+	}
+	if !t.Expires.IsZero() && now.After(t.Expires) {
+		delete(s.tokens, key)
+		s.save()
+		return nil, false
+	}
+
+	t.LastUsed = now
+	t.LastRemote = remote
+	// Last-used times are not worth a flash write on every request
+	if now.Sub(s.lastSaved) >= tokenSaveEvery {
+		s.save()
+	}
+	copied := *t
+	return &copied, true
+}
+
+// List returns tokens, newest first. A non-empty user limits the list to
+// that user's tokens.
+func (s *TokenStore) List(user string) []types.APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]types.APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if user == "" || t.User == user {
+			list = append(list, t.info())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created > list[j].Created })
+	return list
+}
+
+// Revoke deletes a token by ID. A non-empty user only allows revoking that
+// user's tokens.
+func (s *TokenStore) Revoke(id, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.tokens {
+		if t.ID == id && (user == "" || t.User == user) {
+			delete(s.tokens, key)
+			if err := s.save(); err != nil {
+				s.tokens[key] = t
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("token not found")
+}
+
+// RevokeUser deletes all tokens of a user
+func (s *TokenStore) RevokeUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.tokens {
+		if t.User == user {
+			delete(s.tokens, key)
+		}
+	}
+	return s.save()
+}
+
+// Flush writes pending last-used times
+func (s *TokenStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (t *APIToken) info() types.APIToken {
+	info := types.APIToken{
+		ID:         t.ID,
+		Name:       t.Name,
+		User:       t.User,
+		Scopes:     t.Scopes,
+		Created:    t.Created.Format(time.RFC3339),
+		LastRemote: t.LastRemote,
+	}
+	if !t.Expires.IsZero() {
+		info.Expires = t.Expires.Format(time.RFC3339)
+	}
+	if !t.LastUsed.IsZero() {
+		info.LastUsed = t.LastUsed.Format(time.RFC3339)
+	}
+	return info
+}
+
+// save writes the token file atomically; the caller holds mu
+func (s *TokenStore) save() error {
+	s.lastSaved = time.Now()
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]*APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArea(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/status", "status"},
+		{"/api/status/", "status"},
+		{"/api/statusx", ""},
+		{"/api/log", "logs"},
+		{"/api/logs", "logs"},
+		{"/api/logs/settings", "logs"},
+		{"/api/ssh/tunnels/start", "tunnels"},
+		{"/api/ssh/keys", "keys"},
+		{"/api/ssh", ""},
+		{"/api/auth/tokens", ""},
+		{"/api/auth/password", ""},
+		{"/metrics", "metrics"},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		if got := Area(tt.path); got != tt.want {
+			t.Errorf("Area(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name      string
+		scopes    []string
+		method    string
+		path      string
+		websocket bool
+		want      bool
+	}{
+		{"area", []string{"tunnels"}, "POST", "/api/ssh/tunnels/start", false, true},
+		{"other area", []string{"tunnels"}, "GET", "/api/ssh/keys", false, false},
+		{"read scope GET", []string{"status:read"}, "GET", "/api/status", false, true},
+		{"read scope HEAD", []string{"status:read"}, "HEAD", "/api/status", false, true},
+		{"read scope POST", []string{"status:read"}, "POST", "/api/status", false, false},
+		{"read scope WebSocket", []string{"terminal:read"}, "GET", "/api/terminal/ws", true, false},
+		{"full scope WebSocket", []string{"terminal"}, "GET", "/api/terminal/ws", true, true},
+		{"wildcard", []string{"*"}, "POST", "/api/configure/files", false, true},
+		{"wildcard read", []string{"*:read"}, "POST", "/api/configure/files", false, false},
+		{"wildcard read GET", []string{"*:read"}, "GET", "/api/wifi/scan", false, true},
+		{"wildcard never covers auth", []string{"*"}, "POST", "/api/auth/tokens/create", false, false},
+		{"one of several", []string{"logs:read", "wifi"}, "POST", "/api/wifi/connect", false, true},
+		{"prefix is not a match", []string{"status"}, "GET", "/api/statusx", false, false},
+		{"no scopes", nil, "GET", "/api/status", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.websocket {
+				r.Header.Set("Upgrade", "websocket")
+			}
+			if got := ScopeAllows(tt.scopes, r); got != tt.want {
+				t.Errorf("ScopeAllows(%v, %s %s) = %v, want %v", tt.scopes, tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenCreate(t *testing.T) {
+	s, err := LoadTokens("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tokenName string
+		scopes    []string
+		ok        bool
+	}{
+		{"valid", "backup", []string{"status:read", "tunnels"}, true},
+		{"wildcard", "all", []string{"*"}, true},
+		{"no name", " ", []string{"status"}, false},
+		{"long name", strings.Repeat("x", maxTokenNameLen+1), []string{"status"}, false},
+		{"no scopes", "empty", nil, false},
+		{"unknown scope", "bad", []string{"auth"}, false},
+		{"unknown read scope", "bad", []string{"users:read"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, secret, err := s.Create("alice", tt.tokenName, tt.scopes, 0)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !strings.HasPrefix(secret, TokenPrefix) {
+				t.Errorf("secret %q lacks the %s prefix", secret, TokenPrefix)
+			}
+		})
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, secret, err := s.Create("alice", "backup", []string{"status:read"}, 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, expiring, err := s.Create("alice", "short", []string{"status"}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Only a hash of the secret is written
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) || strings.Contains(string(data), expiring) {
+		t.Error("token file holds a secret")
+	}
+
+	token, ok := s.Use(secret, "192.0.2.1")
+	if !ok || token.User != "alice" || token.ID != info.ID || token.LastRemote != "192.0.2.1" {
+		t.Fatalf("Use = %+v, %v", token, ok)
+	}
+	for _, bad := range []string{"", secret + "x", strings.TrimPrefix(secret, TokenPrefix)} {
+		if _, ok := s.Use(bad, "192.0.2.1"); ok {
+			t.Errorf("accepted secret %q", bad)
+		}
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if _, ok := s.Use(expiring, "192.0.2.1"); ok {
+		t.Error("expired token accepted")
+	}
+	if list := s.List("alice"); len(list) != 1 {
+		t.Errorf("expired token still listed: %+v", list)
+	}
+
+	// Tokens and their last use survive a restart
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List("")
+	if len(list) != 1 || list[0].LastUsed == "" || list[0].LastRemote != "192.0.2.1" {
+		t.Fatalf("after reload: %+v", list)
+	}
+	if _, ok := reloaded.Use(secret, "192.0.2.2"); !ok {
+		t.Fatal("token rejected after reload")
+	}
+
+	if err := reloaded.Revoke(info.ID, "bob"); err == nil {
+		t.Error("revoked another user's token")
+	}
+	if err := reloaded.Revoke(info.ID, "alice"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, ok := reloaded.Use(secret, "192.0.2.2"); ok {
+		t.Error("revoked token accepted")
+	}
+}
+
+func TestTokenRevokeUser(t *testing.T) {
+	s, err := LoadTokens("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, alice, _ := s.Create("alice", "a", []string{"status"}, 0)
+	_, bob, _ := s.Create("bob", "b", []string{"status"}, 0)
+
+	if err := s.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Use(alice, ""); ok {
+		t.Error("token of a revoked user accepted")
+	}
+	if _, ok := s.Use(bob, ""); !ok {
+		t.Error("another user's token was revoked")
+	}
+}
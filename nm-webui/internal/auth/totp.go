@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// TOTP parameters (RFC 6238 defaults, which every authenticator app supports)
+const (
+	totpPeriod     = 30 // seconds
+	totpDigits     = 6
+	totpSkew       = 1 // steps accepted either side of now
+	totpSecretLen  = 20
+	totpIssuer     = "Haxinator"
+	recoveryCount  = 10
+	recoveryLength = 10 // characters, shown as two groups of five
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random base32 TOTP secret
+func newTOTPSecret() string {
+	b := make([]byte, totpSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth: crypto/rand failed: " + err.Error())
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// totpCode computes the code for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// verifyTOTP checks a code against the steps around now, skipping steps at
+// or before last so a code cannot be replayed. It returns the matched step.
+func verifyTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPSetup is a pending enrolment shown to the user
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"` // PNG data URL
+}
+
+// newTOTPSetup builds the provisioning URI and QR code for a secret
+func newTOTPSetup(user, secret string) (*TOTPSetup, error) {
+	account := totpIssuer + ":" + user
+	if host, err := os.Hostname(); err == nil && host != "" {
+		account += "@" + host
+	}
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", fmt.Sprint(totpDigits))
+	uri := (&url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + account, RawQuery: v.Encode()}).String()
+
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	code.Scale = 6
+
+	return &TOTPSetup{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()),
+	}, nil
+}
+
+// newRecoveryCodes returns fresh one-time recovery codes and their hashes
+func newRecoveryCodes() (codes, hashes []string) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789" // 32, no l/o/0/1
+	for i := 0; i < recoveryCount; i++ {
+		b := make([]byte, recoveryLength)
+		if _, err := rand.Read(b); err != nil {
+			panic("auth: crypto/rand failed: " + err.Error())
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		code := string(b[:recoveryLength/2]) + "-" + string(b[recoveryLength/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryHash(code))
+	}
+	return codes, hashes
+}
+
+// recoveryHash hashes a recovery code. The codes are random enough that a
+// fast hash is safe; dashes, spaces and case are ignored.
+func recoveryHash(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrInvalidCode is returned for a wrong or reused TOTP code
+var ErrInvalidCode = fmt.Errorf("invalid code")
+
+// RequireTOTP makes TOTP enrolment mandatory for the given roles
+func (s *UserStore) RequireTOTP(roles []Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range roles {
+		s.totpRoles[r] = true
+	}
+}
+
+// TOTPRequired reports whether a role must use TOTP
+func (s *UserStore) TOTPRequired(role Role) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.totpRoles[role]
+}
+
+// NeedsEnrolment reports whether a user must enrol TOTP before using the
+// web UI. Users from the environment cannot enrol and are exempt.
+func (s *UserStore) NeedsEnrolment(u *User) bool {
+	return !u.env && !u.TOTPEnabled() && s.TOTPRequired(u.Role)
+}
+
+// BeginTOTP starts enrolment with a new secret, replacing any earlier
+// unfinished one
+func (s *UserStore) BeginTOTP(name string) (*TOTPSetup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled() {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	secret := newTOTPSecret()
+	setup, err := newTOTPSetup(name, secret)
+	if err != nil {
+		return nil, err
+	}
+	s.pending[name] = secret
+	return setup, nil
+}
+
+// EnableTOTP finishes enrolment with a code from the authenticator app and
+// returns the recovery codes
+func (s *UserStore) EnableTOTP(name, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := s.pending[name]
+	if !ok {
+		return nil, fmt.Errorf("no enrolment in progress")
+	}
+	step, ok := verifyTOTP(secret, normalizeCode(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes := newRecoveryCodes()
+	u.totp, u.recovery, u.totpStep = secret, hashes, step
+	if err := s.save(); err != nil {
+		u.totp, u.recovery, u.totpStep = "", nil, 0
+		return nil, err
+	}
+	delete(s.pending, name)
+	return codes, nil
+}
+
+// DisableTOTP removes a user's second factor and recovery codes
+func (s *UserStore) DisableTOTP(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return err
+	}
+
+	totp, recovery, step := u.totp, u.recovery, u.totpStep
+	u.totp, u.recovery, u.totpStep = "", nil, 0
+	if err := s.save(); err != nil {
+		u.totp, u.recovery, u.totpStep = totp, recovery, step
+		return err
+	}
+	delete(s.pending, name)
+	return nil
+}
+
+// VerifyTOTP checks a TOTP code or, failing that, an unused recovery code,
+// which is then used up. The step of a TOTP code is saved so the code
+// cannot be replayed, even after a restart.
+func (s *UserStore) VerifyTOTP(name, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok || !u.TOTPEnabled() {
+		return false
+	}
+
+	if step, ok := verifyTOTP(u.totp, normalizeCode(code), time.Now(), u.totpStep); ok {
+		old := u.totpStep
+		u.totpStep = step
+		if err := s.save(); err != nil {
+			u.totpStep = old
+			return false
+		}
+		return true
+	}
+
+	hash := recoveryHash(code)
+	for i, h := range u.recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		old := u.recovery
+		u.recovery = append(append([]string{}, old[:i]...), old[i+1:]...)
+		if err := s.save(); err != nil {
+			// Refuse rather than allow the code to be used again
+			u.recovery = old
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// NewRecoveryCodes replaces a user's recovery codes
+func (s *UserStore) NewRecoveryCodes(name string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TOTPEnabled() {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	codes, hashes := newRecoveryCodes()
+	old := u.recovery
+	u.recovery = hashes
+	if err := s.save(); err != nil {
+		u.recovery = old
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryRemaining returns how many unused recovery codes a user has
+func (s *UserStore) RecoveryRemaining(name string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.users[name]; ok {
+		return len(u.recovery)
+	}
+	return 0
+}
+
+// normalizeCode strips the spaces some apps show inside codes
+func normalizeCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPassword = "Correct-Horse-42"
+
+// codeAt returns the TOTP code of a secret for the step offset from now
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod+offset)
+}
+
+// enrolTOTP loads a store from path with user "alice" enrolled in TOTP,
+// returning the secret and recovery codes
+func enrolTOTP(t *testing.T, path string) (*UserStore, string, []string) {
+	t.Helper()
+	s, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("alice", testPassword, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	setup, err := s.BeginTOTP("alice")
+	if err != nil {
+		t.Fatalf("BeginTOTP: %v", err)
+	}
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/") || !strings.HasPrefix(setup.QRCode, "data:image/png;base64,") {
+		t.Errorf("setup = %+v", setup)
+	}
+	codes, err := s.EnableTOTP("alice", codeAt(t, setup.Secret, 0))
+	if err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+	return s, setup.Secret, codes
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA-1 vectors, truncated to six digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	secret := newTOTPSecret()
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Unix(1_700_000_000, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		last   int64
+		ok     bool
+	}{
+		{"current", 0, 0, true},
+		{"previous", -1, 0, true},
+		{"next", 1, 0, true},
+		{"two behind", -2, 0, false},
+		{"two ahead", 2, 0, false},
+		{"already used", 0, current, false},
+		{"older than the last used", -1, current, false},
+		{"newer than the last used", 1, current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(secret, totpCode(key, current+tt.offset), now, tt.last)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := verifyTOTP(secret, code, now, 0); ok {
+			t.Errorf("accepted %q", code)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", "123456", now, 0); ok {
+		t.Error("accepted a code for an invalid secret")
+	}
+}
+
+func TestTOTPReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	s, secret, _ := enrolTOTP(t, path)
+
+	// The enrolment code is used up
+	if s.VerifyTOTP("alice", codeAt(t, secret, 0)) {
+		t.Fatal("enrolment code accepted again")
+	}
+	next := codeAt(t, secret, 1)
+	if !s.VerifyTOTP("alice", next) {
+		t.Fatal("next code rejected")
+	}
+	if s.VerifyTOTP("alice", next) {
+		t.Fatal("code replayed")
+	}
+
+	// A restart keeps the last step, so the code stays used up
+	reloaded, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.VerifyTOTP("alice", next) {
+		t.Error("code replayed after reloading the auth file")
+	}
+	if reloaded.VerifyTOTP("alice", codeAt(t, secret, 0)) {
+		t.Error("older code accepted after reloading the auth file")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	s, _, codes := enrolTOTP(t, path)
+	if len(codes) != recoveryCount || s.RecoveryRemaining("alice") != recoveryCount {
+		t.Fatalf("got %d codes, %d remaining", len(codes), s.RecoveryRemaining("alice"))
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != recoveryLength+1 || c[recoveryLength/2] != '-' || seen[c] {
+			t.Errorf("bad or repeated code %q", c)
+		}
+		seen[c] = true
+	}
+
+	// Case, dashes and spaces do not matter, and each code works once
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))
+	if !s.VerifyTOTP("alice", typed) {
+		t.Fatalf("recovery code %q rejected", typed)
+	}
+	if s.VerifyTOTP("alice", codes[0]) {
+		t.Error("recovery code used twice")
+	}
+	if n := s.RecoveryRemaining("alice"); n != recoveryCount-1 {
+		t.Errorf("remaining = %d, want %d", n, recoveryCount-1)
+	}
+
+	reloaded, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.VerifyTOTP("alice", codes[0]) {
+		t.Error("used recovery code accepted after reloading")
+	}
+	if !reloaded.VerifyTOTP("alice", codes[1]) {
+		t.Error("unused recovery code rejected after reloading")
+	}
+
+	// New codes replace all of the old ones
+	fresh, err := reloaded.NewRecoveryCodes("alice")
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if reloaded.VerifyTOTP("alice", codes[2]) {
+		t.Error("old recovery code accepted after regenerating")
+	}
+	if !reloaded.VerifyTOTP("alice", fresh[0]) {
+		t.Error("new recovery code rejected")
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	s, secret, codes := enrolTOTP(t, path)
+
+	if err := s.DisableTOTP("alice"); err != nil {
+		t.Fatalf("DisableTOTP: %v", err)
+	}
+	u, _ := s.Get("alice")
+	if u.TOTPEnabled() || s.RecoveryRemaining("alice") != 0 {
+		t.Fatal("second factor still enabled")
+	}
+	if s.VerifyTOTP("alice", codeAt(t, secret, 1)) || s.VerifyTOTP("alice", codes[0]) {
+		t.Error("old secret or recovery code accepted after disabling")
+	}
+	if _, err := s.NewRecoveryCodes("alice"); err == nil {
+		t.Error("created recovery codes without two-factor")
+	}
+
+	// Enrolling again starts from a new secret
+	setup, err := s.BeginTOTP("alice")
+	if err != nil {
+		t.Fatalf("BeginTOTP: %v", err)
+	}
+	if setup.Secret == secret {
+		t.Error("re-enrolment reused the old secret")
+	}
+	if _, err := s.EnableTOTP("alice", "000000"); err == nil {
+		t.Error("enabled with a wrong code")
+	}
+}
+
+func TestTOTPRequired(t *testing.T) {
+	s, err := LoadUsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RequireTOTP([]Role{RoleAdmin})
+	if err := s.Add("alice", testPassword, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("victor", testPassword, RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetEnvUser("root", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"alice", true},
+		{"victor", false},
+		{"root", false}, // environment users cannot enrol
+	}
+	for _, tt := range tests {
+		u, _ := s.Get(tt.user)
+		if got := s.NeedsEnrolment(u); got != tt.want {
+			t.Errorf("NeedsEnrolment(%s) = %v, want %v", tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"nm-webui/internal/types"
+)
+
+// Role is a user's permission level. Each role includes the permissions of
+// the roles below it.
+type Role int
+
+const (
+	// RoleViewer can read status and logs
+	RoleViewer Role = iota
+	// RoleOperator can also connect WiFi and start or stop tunnels
+	RoleOperator
+	// RoleAdmin can also change configuration, keys, users and power state
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseRole parses a role name
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown role %q (use viewer, operator or admin)", s)
+}
+
+const (
+	minPasswordLen  = 10
+	passphraseLen   = 16 // long passwords need not mix character classes
+	maxPasswordLen  = 72 // bcrypt ignores anything longer
+	initialAdmin    = "admin"
+	initialPassFile = "initial-password"
+)
+
+var userNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// User is a web UI account
+type User struct {
+	Name     string
+	Role     Role
+	hash     string   // bcrypt
+	totp     string   // base32 TOTP secret, empty if not enrolled
+	recovery []string // hashes of unused recovery codes
+	totpStep int64    // last TOTP step used, against replay across restarts
+	env      bool     // from NM_WEBUI_USER/NM_WEBUI_PASS, not saved
+	shadowed *User    // saved user of the same name, kept in the auth file
+}
+
+// TOTPEnabled reports whether the user has enrolled a second factor
+func (u *User) TOTPEnabled() bool {
+	return u.totp != ""
+}
+
+// UserStore holds the web UI accounts. Accounts are saved in the auth file,
+// one "name:role:hash[:totp-secret:recovery-hashes:last-step]" line per user with a
+// bcrypt password hash. Plaintext passwords and legacy "name:password"
+// lines (admins) are hashed when the file is loaded.
+type UserStore struct {
+	path string // empty keeps users in memory only
+
+	mu        sync.RWMutex
+	users     map[string]*User
+	totpRoles map[Role]bool     // roles that must enrol TOTP
+	pending   map[string]string // TOTP secrets awaiting a first code
+}
+
+// LoadUsers reads the auth file. A missing file gives an empty store.
+func LoadUsers(path string) (*UserStore, error) {
+	s := &UserStore{
+		path:      path,
+		users:     make(map[string]*User),
+		totpRoles: make(map[Role]bool),
+		pending:   make(map[string]string),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	migrate := false
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, plain, err := parseUserLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("auth file line %d: %w", n+1, err)
+		}
+		if plain != "" {
+			if u.hash, err = hashPassword(plain); err != nil {
+				return nil, err
+			}
+			migrate = true
+		}
+		s.users[u.Name] = u
+	}
+
+	if migrate {
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext passwords: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// parseUserLine parses "name:role:hash[:totp:recovery:step]" or the legacy
+// "name:password" form, returning the plaintext password of the latter.
+// The middle field is only a role when a bcrypt hash follows, since a
+// legacy password may itself contain colons.
+func parseUserLine(line string) (*User, string, error) {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return nil, "", fmt.Errorf("expected name:role:hash")
+	}
+
+	u := &User{Name: parts[0], Role: RoleAdmin}
+	secret := strings.SplitN(line, ":", 2)[1]
+	if len(parts) == 3 && isHash(parts[2]) {
+		role, err := ParseRole(parts[1])
+		if err != nil {
+			return nil, "", err
+		}
+		u.Role = role
+		secret = parts[2]
+	}
+	if secret == "" {
+		return nil, "", fmt.Errorf("empty password for %s", u.Name)
+	}
+	if isHash(secret) {
+		// bcrypt hashes have no colons, so the TOTP fields follow
+		fields := strings.Split(secret, ":")
+		u.hash = fields[0]
+		if len(fields) > 1 {
+			u.totp = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			u.recovery = strings.Split(fields[2], ",")
+		}
+		if len(fields) > 3 {
+			step, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil || step < 0 {
+				return nil, "", fmt.Errorf("invalid TOTP step for %s", u.Name)
+			}
+			u.totpStep = step
+		}
+		return u, "", nil
+	}
+	return u, secret, nil
+}
+
+// SetEnvUser adds an admin from the environment. It overrides a saved user
+// of the same name, is never written to the auth file and cannot be edited.
+func (s *UserStore) SetEnvUser(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[name] = &User{Name: name, Role: RoleAdmin, hash: hash, env: true, shadowed: s.users[name]}
+	return nil
+}
+
+// CreateInitialAdmin adds an "admin" user with a random password. With an
+// auth file the password is written to InitialPasswordFile, which is
+// removed once the password is changed; the password is returned either
+// way.
+func (s *UserStore) CreateInitialAdmin() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+
+	if err := s.Add(initialAdmin, password, RoleAdmin); err != nil {
+		return "", err
+	}
+	if path := s.InitialPasswordFile(); path != "" {
+		data := initialAdmin + ":" + password + "\n"
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			return "", fmt.Errorf("failed to write initial password: %w", err)
+		}
+	}
+	return password, nil
+}
+
+// InitialPasswordFile returns where the generated admin password is kept,
+// or "" without an auth file
+func (s *UserStore) InitialPasswordFile() string {
+	if s.path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(s.path), initialPassFile)
+}
+
+// Empty reports whether there are no users
+func (s *UserStore) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users) == 0
+}
+
+// Path returns the auth file
+func (s *UserStore) Path() string {
+	return s.path
+}
+
+// Authenticate checks a username and password
+func (s *UserStore) Authenticate(name, password string) (*User, bool) {
+	s.mu.RLock()
+	u, ok := s.users[name]
+	s.mu.RUnlock()
+
+	// Unknown users still cost a hash comparison so response times do not
+	// reveal which names exist
+	hash := dummyHash()
+	if ok {
+		hash = u.hash
+	}
+	match := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	if !ok || !match {
+		return nil, false
+	}
+	copied := *u
+	return &copied, true
+}
+
+// Get returns a user by name
+func (s *UserStore) Get(name string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return nil, false
+	}
+	copied := *u
+	return &copied, true
+}
+
+// List returns the users sorted by name
+func (s *UserStore) List() []types.WebUser {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]types.WebUser, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, types.WebUser{Name: u.Name, Role: u.Role.String(), Env: u.env, TOTP: u.TOTPEnabled()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Add creates a user
+func (s *UserStore) Add(name, password string, role Role) error {
+	if !userNameRe.MatchString(name) {
+		return fmt.Errorf("user name must be 1-32 letters, digits, '.', '_' or '-'")
+	}
+	if err := checkPassword(name, password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[name]; exists {
+		return fmt.Errorf("user %s already exists", name)
+	}
+	s.users[name] = &User{Name: name, Role: role, hash: hash}
+	if err := s.save(); err != nil {
+		delete(s.users, name)
+		return err
+	}
+	return nil
+}
+
+// SetRole changes a user's role. The last admin cannot be demoted.
+func (s *UserStore) SetRole(name string, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return err
+	}
+	if u.Role == RoleAdmin && role != RoleAdmin && s.adminCount() == 1 {
+		return fmt.Errorf("%s is the last admin", name)
+	}
+
+	old := u.Role
+	u.Role = role
+	if err := s.save(); err != nil {
+		u.Role = old
+		return err
+	}
+	return nil
+}
+
+// SetPassword replaces a user's password
+func (s *UserStore) SetPassword(name, password string) error {
+	if err := checkPassword(name, password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return err
+	}
+	return s.setHash(u, hash)
+}
+
+// ErrWrongPassword is returned by ChangePassword for a wrong current password
+var ErrWrongPassword = fmt.Errorf("current password is incorrect")
+
+// ChangePassword replaces a user's password after checking the current one
+func (s *UserStore) ChangePassword(name, current, password string) error {
+	if _, ok := s.Authenticate(name, current); !ok {
+		return ErrWrongPassword
+	}
+	if password == current {
+		return fmt.Errorf("new password must differ from the current one")
+	}
+	return s.SetPassword(name, password)
+}
+
+// setHash stores a new password hash; the caller holds mu
+func (s *UserStore) setHash(u *User, hash string) error {
+	old := u.hash
+	u.hash = hash
+	if err := s.save(); err != nil {
+		u.hash = old
+		return err
+	}
+	s.dropInitialPassword(u.Name)
+	return nil
+}
+
+// dropInitialPassword removes the generated password file once the initial
+// admin's password has changed or the admin is gone
+func (s *UserStore) dropInitialPassword(name string) {
+	if path := s.InitialPasswordFile(); path != "" && name == initialAdmin {
+		os.Remove(path)
+	}
+}
+
+// Delete removes a user. The last admin cannot be deleted.
+func (s *UserStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.editable(name)
+	if err != nil {
+		return err
+	}
+	if u.Role == RoleAdmin && s.adminCount() == 1 {
+		return fmt.Errorf("%s is the last admin", name)
+	}
+
+	delete(s.users, name)
+	if err := s.save(); err != nil {
+		s.users[name] = u
+		return err
+	}
+	delete(s.pending, name)
+	s.dropInitialPassword(name)
+	return nil
+}
+
+// editable returns a user that may be changed; the caller holds mu
+func (s *UserStore) editable(name string) (*User, error) {
+	u, ok := s.users[name]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	if u.env {
+		return nil, fmt.Errorf("%s is set by NM_WEBUI_USER and cannot be changed here", name)
+	}
+	return u, nil
+}
+
+// adminCount counts admins; the caller holds mu
+func (s *UserStore) adminCount() int {
+	n := 0
+	for _, u := range s.users {
+		if u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// save writes the auth file atomically; the caller holds mu
+func (s *UserStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var saved []*User
+	for _, u := range s.users {
+		if u.env {
+			u = u.shadowed
+		}
+		if u != nil {
+			saved = append(saved, u)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+
+	var b strings.Builder
+	b.WriteString("# nm-webui users: name:role:bcrypt-hash[:totp-secret:recovery-hashes:last-step]\n")
+	for _, u := range saved {
+		fmt.Fprintf(&b, "%s:%s:%s", u.Name, u.Role, u.hash)
+		if u.totp != "" {
+			fmt.Fprintf(&b, ":%s:%s:%d", u.totp, strings.Join(u.recovery, ","), u.totpStep)
+		}
+		b.WriteString("\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create auth directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write auth file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write auth file: %w", err)
+	}
+	return nil
+}
+
+// checkPassword applies the password strength rules: at least 10
+// characters, not containing the user name, and mixing three of lowercase,
+// uppercase, digits and symbols unless it is a 16+ character passphrase
+func checkPassword(name, password string) error {
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLen)
+	}
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLen)
+	}
+	if name != "" && strings.Contains(strings.ToLower(password), strings.ToLower(name)) {
+		return fmt.Errorf("password must not contain the user name")
+	}
+	if len(password) >= passphraseLen {
+		return nil
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < 3 {
+		return fmt.Errorf("password must mix three of lowercase, uppercase, digits and symbols, or be at least %d characters", passphraseLen)
+	}
+	return nil
+}
+
+// hashPassword hashes a password with bcrypt
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// isHash reports whether an auth file secret is a bcrypt hash
+func isHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+var (
+	dummyOnce sync.Once
+	dummy     string
+)
+
+// dummyHash returns a hash to compare against for unknown users
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		dummy, _ = hashPassword(randomToken(16))
+	})
+	return dummy
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUserLine(t *testing.T) {
+	const hash = "$2a$10$abcdefghijklmnopqrstuuABCDEFGHIJKLMNOPQRSTUVWXYZ01234"
+
+	tests := []struct {
+		line      string
+		wantName  string
+		wantRole  Role
+		wantPlain string
+		wantTOTP  string
+		wantErr   bool
+	}{
+		{"alice:admin:" + hash, "alice", RoleAdmin, "", "", false},
+		{"bob:viewer:" + hash, "bob", RoleViewer, "", "", false},
+		{"carol:operator:" + hash + ":JBSWY3DPEHPK3PXP:h1,h2", "carol", RoleOperator, "", "JBSWY3DPEHPK3PXP", false},
+		{"dave:" + hash, "dave", RoleAdmin, "", "", false},
+		{"erin:admin:" + hash + ":JBSWY3DPEHPK3PXP::56666666", "erin", RoleAdmin, "", "JBSWY3DPEHPK3PXP", false},
+
+		// Legacy name:password lines are admins, whatever the password
+		// looks like
+		{"admin:s3cret", "admin", RoleAdmin, "s3cret", "", false},
+		{"bob:viewer:xyz", "bob", RoleAdmin, "viewer:xyz", "", false},
+		{"eve:operator:" + "not-a-hash", "eve", RoleAdmin, "operator:not-a-hash", "", false},
+		{"frank:a:b:c", "frank", RoleAdmin, "a:b:c", "", false},
+
+		{"mallory:root:" + hash, "", 0, "", "", true},
+		{"nobody", "", 0, "", "", true},
+		{":admin:" + hash, "", 0, "", "", true},
+		{"grace:", "", 0, "", "", true},
+		{"heidi:admin:" + hash + ":JBSWY3DPEHPK3PXP::soon", "", 0, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			u, plain, err := parseUserLine(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUserLine: %v", err)
+			}
+			if u.Name != tt.wantName || u.Role != tt.wantRole || plain != tt.wantPlain || u.totp != tt.wantTOTP {
+				t.Errorf("got %s %s plain %q totp %q, want %s %s plain %q totp %q",
+					u.Name, u.Role, plain, u.totp, tt.wantName, tt.wantRole, tt.wantPlain, tt.wantTOTP)
+			}
+			if plain == "" && u.hash != hash {
+				t.Errorf("hash = %q", u.hash)
+			}
+		})
+	}
+}
+
+func TestLoadUsersMigratesPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth")
+	content := "# users\nadmin:Legacy-Pass-1\nbob:viewer:xyz:Pass-2\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadUsers(path)
+	if err != nil {
+		t.Fatalf("LoadUsers: %v", err)
+	}
+	if _, ok := s.Authenticate("admin", "Legacy-Pass-1"); !ok {
+		t.Error("migrated admin password does not work")
+	}
+	u, ok := s.Authenticate("bob", "viewer:xyz:Pass-2")
+	if !ok {
+		t.Fatal("a legacy password containing colons was not kept whole")
+	}
+	if u.Role != RoleAdmin {
+		t.Errorf("legacy user role = %s, want admin", u.Role)
+	}
+
+	// The file now holds hashes only, and loads the same way again
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Legacy-Pass-1") || strings.Contains(string(data), "Pass-2") {
+		t.Errorf("plaintext password left in the auth file:\n%s", data)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+		if fields := strings.SplitN(line, ":", 3); len(fields) != 3 || !isHash(fields[2]) {
+			t.Errorf("line %q is not name:role:hash", line)
+		}
+	}
+
+	again, err := LoadUsers(path)
+	if err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	if u, ok := again.Authenticate("bob", "viewer:xyz:Pass-2"); !ok || u.Role != RoleAdmin {
+		t.Error("reloaded user differs")
+	}
+}
@@ -0,0 +1,152 @@
+// Package collect feeds the logs of other services into the logger, so the
+// Logs view shows why NetworkManager or a tunnel failed next to what
+// nm-webui did: journald units through journalctl, and plain log files such
+// as the hans and iodine plugins'.
+package collect
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"nm-webui/internal/logger"
+)
+
+// maxMessage caps a collected line; the rest is cut
+const maxMessage = 2048
+
+// Restart backoff bounds for journalctl
+const (
+	restartMin = 5 * time.Second
+	restartMax = 2 * time.Minute
+)
+
+// Source is a journald unit or a log file, and the category its lines are
+// logged under
+type Source struct {
+	Name     string // unit name, which may be a glob, or absolute file path
+	Category string
+}
+
+// ParseSources parses "name=category" entries; without "=category" the
+// category is the name's base, lowercased, e.g. "openvpn" for
+// "openvpn@*.service" or "hans-plugin" for /tmp/hans-plugin.log
+func ParseSources(entries []string) ([]Source, error) {
+	var sources []Source
+	for _, entry := range entries {
+		name, category, _ := strings.Cut(entry, "=")
+		if name == "" {
+			return nil, fmt.Errorf("%q: missing name", entry)
+		}
+		if category == "" {
+			category = defaultCategory(name)
+		}
+		sources = append(sources, Source{Name: name, Category: category})
+	}
+	return sources, nil
+}
+
+// defaultCategory derives a category from a unit name or file path
+func defaultCategory(name string) string {
+	base := path.Base(name)
+	if i := strings.IndexAny(base, "@*.?["); i > 0 {
+		base = base[:i]
+	}
+	return strings.ToLower(base)
+}
+
+// Collector runs a journal follower and a file tailer per file until Stop
+type Collector struct {
+	log    *logger.Logger
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start begins collecting from the given units and files
+func Start(log *logger.Logger, units, files []Source) *Collector {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Collector{log: log, cancel: cancel}
+
+	if len(units) > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.journal(ctx, units)
+		}()
+	}
+	for _, src := range files {
+		c.wg.Add(1)
+		go func(src Source) {
+			defer c.wg.Done()
+			c.tail(ctx, src)
+		}(src)
+	}
+	return c
+}
+
+// Stop ends collection and waits for it
+func (c *Collector) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+// line is one collected log line, or several when continuation lines such
+// as a Python traceback follow it
+type line struct {
+	time    time.Time
+	level   logger.Level
+	message string
+	detail  []string // continuation lines
+	extra   map[string]interface{}
+}
+
+// commit logs a collected line. The message is the entry's action, as with
+// Logger.Msg; continuation lines become its output.
+func (c *Collector) commit(category string, l *line) {
+	msg := l.message
+	if len(msg) > maxMessage {
+		msg = msg[:maxMessage] + "..."
+	}
+	b := c.log.Log(l.level, category, msg).
+		WithTime(l.time).
+		WithSuccess(l.level < logger.ERROR)
+	if len(l.detail) > 0 {
+		b.WithOutput(strings.Join(l.detail, "\n"))
+	}
+	for k, v := range l.extra {
+		b.WithExtra(k, v)
+	}
+	b.Commit()
+}
+
+// levelOfText guesses the level of a line that does not state one
+func levelOfText(s string) logger.Level {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.Contains(lower, "error"), strings.Contains(lower, "fail"),
+		strings.Contains(lower, "traceback"), strings.Contains(lower, "exception"):
+		return logger.ERROR
+	case strings.Contains(lower, "warn"):
+		return logger.WARN
+	default:
+		return logger.INFO
+	}
+}
+
+// levelOfName parses level names used by Python logging and others
+func levelOfName(name string) (logger.Level, bool) {
+	switch strings.ToUpper(name) {
+	case "DEBUG", "TRACE":
+		return logger.DEBUG, true
+	case "INFO", "NOTICE":
+		return logger.INFO, true
+	case "WARN", "WARNING":
+		return logger.WARN, true
+	case "ERROR", "ERR", "CRITICAL", "CRIT", "FATAL", "ALERT", "EMERG":
+		return logger.ERROR, true
+	}
+	return logger.INFO, false
+}
@@ -0,0 +1,196 @@
+package collect
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"nm-webui/internal/logger"
+)
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources([]string{"NetworkManager", "openvpn@*.service", "/tmp/hans-plugin.log", "iodined=dns-tunnel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Source{
+		{"NetworkManager", "networkmanager"},
+		{"openvpn@*.service", "openvpn"},
+		{"/tmp/hans-plugin.log", "hans-plugin"},
+		{"iodined", "dns-tunnel"},
+	}
+	for i, s := range sources {
+		if s != want[i] {
+			t.Errorf("source %d = %+v, want %+v", i, s, want[i])
+		}
+	}
+	if _, err := ParseSources([]string{"=category"}); err == nil {
+		t.Error("accepted a source without a name")
+	}
+}
+
+func TestCategoryOf(t *testing.T) {
+	units := []Source{{"NetworkManager", "nm"}, {"openvpn@*.service", "openvpn"}, {"wpa_supplicant.service", "wpa"}}
+	tests := []struct {
+		unit string
+		want string
+		ok   bool
+	}{
+		{"NetworkManager.service", "nm", true},
+		{"openvpn@client.service", "openvpn", true},
+		{"wpa_supplicant.service", "wpa", true},
+		{"NetworkManager-dispatcher.service", "", false},
+		{"openvpn.service", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := categoryOf(tt.unit, units)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("categoryOf(%q) = %q, %v, want %q, %v", tt.unit, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// journalEntry builds journalctl JSON fields
+func journalEntry(t *testing.T, fields map[string]interface{}) map[string]json.RawMessage {
+	t.Helper()
+	out := make(map[string]json.RawMessage)
+	for k, v := range fields {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[k] = data
+	}
+	return out
+}
+
+func TestParseJournal(t *testing.T) {
+	units := []Source{{"NetworkManager", "nm"}, {"openvpn@*", "openvpn"}}
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		ok       bool
+		category string
+		level    logger.Level
+		message  string
+	}{
+		{
+			"NetworkManager prefix",
+			map[string]interface{}{"_SYSTEMD_UNIT": "NetworkManager.service", "PRIORITY": "4", "MESSAGE": "<warn>  [1697040000.1234] device (wlan0): link timed out"},
+			true, "nm", logger.WARN, "device (wlan0): link timed out",
+		},
+		{
+			"error priority",
+			map[string]interface{}{"_SYSTEMD_UNIT": "openvpn@client.service", "PRIORITY": "3", "MESSAGE": "TLS handshake failed"},
+			true, "openvpn", logger.ERROR, "TLS handshake failed",
+		},
+		{
+			"debug priority",
+			map[string]interface{}{"_SYSTEMD_UNIT": "NetworkManager.service", "PRIORITY": "7", "MESSAGE": "dhcp trace"},
+			true, "nm", logger.DEBUG, "dhcp trace",
+		},
+		{
+			"systemd about a unit",
+			map[string]interface{}{"_SYSTEMD_UNIT": "init.scope", "UNIT": "openvpn@client.service", "PRIORITY": "6", "MESSAGE": "Started OpenVPN."},
+			true, "openvpn", logger.INFO, "Started OpenVPN.",
+		},
+		{
+			"not valid UTF-8",
+			map[string]interface{}{"_SYSTEMD_UNIT": "NetworkManager.service", "MESSAGE": []int{'s', 's', 'i', 'd', ' ', 0xff}},
+			true, "nm", logger.INFO, "ssid ?",
+		},
+		{
+			"other unit",
+			map[string]interface{}{"_SYSTEMD_UNIT": "cron.service", "MESSAGE": "job ran"},
+			false, "", 0, "",
+		},
+		{
+			"empty message",
+			map[string]interface{}{"_SYSTEMD_UNIT": "NetworkManager.service", "MESSAGE": "  "},
+			false, "", 0, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category, l, ok := parseJournal(journalEntry(t, tt.fields), units)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if category != tt.category || l.level != tt.level || l.message != tt.message {
+				t.Errorf("got %s %s %q, want %s %s %q", category, l.level, l.message, tt.category, tt.level, tt.message)
+			}
+		})
+	}
+
+	fields := journalEntry(t, map[string]interface{}{
+		"_SYSTEMD_UNIT":        "NetworkManager.service",
+		"MESSAGE":              "hello",
+		"__REALTIME_TIMESTAMP": "1714564800123456",
+		"SYSLOG_IDENTIFIER":    "NetworkManager",
+		"_PID":                 "412",
+	})
+	_, l, _ := parseJournal(fields, units)
+	if !l.time.Equal(time.UnixMicro(1714564800123456)) {
+		t.Errorf("time = %v", l.time)
+	}
+	if l.extra["unit"] != "NetworkManager.service" || l.extra["program"] != "NetworkManager" || l.extra["pid"] != "412" {
+		t.Errorf("extra = %v", l.extra)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	log := logger.NewDefault()
+	c := &Collector{log: log}
+
+	data := "2024-05-01 12:00:00.123 [INFO] tunnel up\n" +
+		"2024-05-01 12:00:05,456 ERROR: lost server\n" +
+		"Traceback (most recent call last):\n" +
+		"  File \"hans.py\", line 3, in <module>\n" +
+		"    main()\n" +
+		"\n" +
+		"plain warning line\n" +
+		"2024-05-01 12:00:09 [DEBUG] unfinish"
+	rest := c.parseLines("hans", []byte(data))
+	if string(rest) != "2024-05-01 12:00:09 [DEBUG] unfinish" {
+		t.Errorf("rest = %q", rest)
+	}
+
+	entries := log.GetEntries(&logger.Filter{Category: "hans"})
+	want := []struct {
+		level  string
+		action string
+		output string
+	}{
+		{"WARN", "plain warning line", ""},
+		{"ERROR", "Traceback (most recent call last):", "  File \"hans.py\", line 3, in <module>\n    main()"},
+		{"ERROR", "lost server", ""},
+		{"INFO", "tunnel up", ""},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Level != w.level || e.Action != w.action || e.Output != w.output {
+			t.Errorf("entry %d = %s %q output %q, want %s %q output %q", i, e.Level, e.Action, e.Output, w.level, w.action, w.output)
+		}
+	}
+	if ts := entries[3].Time; ts.Year() != 2024 || ts.Hour() != 12 || ts.Second() != 0 {
+		t.Errorf("time of the first line = %v", ts)
+	}
+	if entries[1].Success || !entries[3].Success {
+		t.Error("success does not follow the level")
+	}
+
+	// Long lines are cut
+	c.parseLines("long", []byte(strings.Repeat("x", maxMessage+100)+"\n"))
+	if got := log.GetEntries(&logger.Filter{Category: "long"}); len(got) != 1 || len(got[0].Action) != maxMessage+3 {
+		t.Errorf("long line logged as %d bytes", len(got[0].Action))
+	}
+}
@@ -0,0 +1,127 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often log files are checked for new lines
+const pollInterval = time.Second
+
+// maxRead caps what is read from a file per poll
+const maxRead = 1 << 20
+
+// pythonLine matches Python logging's default and common formats, e.g.
+// "2024-05-01 12:00:00.123 [INFO] message" from the hans plugin
+var pythonLine = regexp.MustCompile(`^(\d{4}-\d\d-\d\d[ T]\d\d:\d\d:\d\d)(?:[.,]\d+)?\s+\[?([A-Za-z]+)\]?:?\s+(.*)$`)
+
+// tail follows a log file, starting at its end. A file that is replaced or
+// truncated is read again from the start, as is one that appears later.
+func (c *Collector) tail(ctx context.Context, src Source) {
+	var (
+		f       *os.File
+		info    os.FileInfo
+		offset  int64
+		partial []byte
+		first   = true
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		fi, err := os.Stat(src.Name)
+		switch {
+		case err != nil:
+			if f != nil {
+				f.Close()
+				f = nil
+			}
+		case f == nil || !os.SameFile(fi, info):
+			if f != nil {
+				f.Close()
+			}
+			if f, err = os.Open(src.Name); err == nil {
+				info, offset, partial = fi, 0, nil
+				if first {
+					offset = fi.Size()
+				}
+			}
+		case fi.Size() < offset:
+			offset, partial = 0, nil
+		}
+		first = false
+
+		if f != nil && fi != nil && fi.Size() > offset {
+			data := make([]byte, min(fi.Size()-offset, maxRead))
+			n, err := f.ReadAt(data, offset)
+			if err == nil || err == io.EOF {
+				offset += int64(n)
+				partial = c.parseLines(src.Category, append(partial, data[:n]...))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseLines logs the complete lines in data and returns what is left of
+// an unfinished last line. Indented lines, such as the body of a Python
+// traceback, are added to the line before.
+func (c *Collector) parseLines(category string, data []byte) []byte {
+	var pending *line
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		text := strings.TrimRight(string(data[:i]), "\r")
+		data = data[i+1:]
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		if pending != nil && (text[0] == ' ' || text[0] == '\t') {
+			pending.detail = append(pending.detail, text)
+			continue
+		}
+		if pending != nil {
+			c.commit(category, pending)
+		}
+		pending = parseFileLine(text)
+	}
+	if pending != nil {
+		c.commit(category, pending)
+	}
+	return append([]byte(nil), data...)
+}
+
+// parseFileLine reads the time and level of a log file line if it has them
+func parseFileLine(text string) *line {
+	l := &line{time: time.Now(), message: text}
+	if m := pythonLine.FindStringSubmatch(text); m != nil {
+		if level, ok := levelOfName(m[2]); ok {
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", strings.Replace(m[1], "T", " ", 1), time.Local); err == nil {
+				l.time = t
+			}
+			l.level = level
+			l.message = m[3]
+			return l
+		}
+	}
+	l.level = levelOfText(text)
+	return l
+}
@@ -0,0 +1,186 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nm-webui/internal/logger"
+)
+
+// journalctl is the command that follows the journal
+var journalctl = "journalctl"
+
+// nmPrefix starts NetworkManager's messages: "<warn>  [1697040000.1234] "
+var nmPrefix = regexp.MustCompile(`^<(\w+)>\s+\[[\d.]+\]\s*`)
+
+// journal follows the units' journal with journalctl, restarting it with
+// backoff. After a restart it continues from the last entry seen.
+func (c *Collector) journal(ctx context.Context, units []Source) {
+	cursor := ""
+	backoff := restartMin
+	for {
+		started := time.Now()
+		err := c.followJournal(ctx, units, &cursor)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			c.log.Warn("collect", "journal_unavailable").WithError(err).Commit()
+			return
+		}
+		if time.Since(started) > restartMax {
+			backoff = restartMin
+		}
+		c.log.Warn("collect", "journal_restart").
+			WithExtra("retry_in", backoff.String()).
+			WithError(err).
+			Commit()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > restartMax {
+			backoff = restartMax
+		}
+	}
+}
+
+// followJournal runs journalctl until it exits or ctx ends
+func (c *Collector) followJournal(ctx context.Context, units []Source, cursor *string) error {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	if *cursor != "" {
+		args = append(args, "--after-cursor="+*cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	for _, u := range units {
+		args = append(args, "--unit="+u.Name)
+	}
+
+	cmd := exec.CommandContext(ctx, journalctl, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		fields := make(map[string]json.RawMessage)
+		if json.Unmarshal(sc.Bytes(), &fields) != nil {
+			continue
+		}
+		if cur := field(fields, "__CURSOR"); cur != "" {
+			*cursor = cur
+		}
+		if category, l, ok := parseJournal(fields, units); ok {
+			c.commit(category, l)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return errors.New("journalctl exited")
+}
+
+// parseJournal turns a journal entry into a line of the unit's category
+func parseJournal(fields map[string]json.RawMessage, units []Source) (string, *line, bool) {
+	msg := strings.TrimSpace(field(fields, "MESSAGE"))
+	if msg == "" {
+		return "", nil, false
+	}
+
+	// Messages from systemd about a unit name it in UNIT
+	unit := field(fields, "_SYSTEMD_UNIT")
+	category, ok := categoryOf(unit, units)
+	if !ok {
+		unit = field(fields, "UNIT")
+		if category, ok = categoryOf(unit, units); !ok {
+			return "", nil, false
+		}
+	}
+
+	l := &line{
+		time:  time.Now(),
+		level: logger.INFO,
+		extra: map[string]interface{}{"unit": unit},
+	}
+	if us, err := strconv.ParseInt(field(fields, "__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		l.time = time.UnixMicro(us)
+	}
+	if prio, err := strconv.Atoi(field(fields, "PRIORITY")); err == nil {
+		switch {
+		case prio <= 3:
+			l.level = logger.ERROR
+		case prio == 4:
+			l.level = logger.WARN
+		case prio == 7:
+			l.level = logger.DEBUG
+		}
+	}
+	if m := nmPrefix.FindStringSubmatch(msg); m != nil {
+		msg = msg[len(m[0]):]
+	}
+	l.message = msg
+	if id := field(fields, "SYSLOG_IDENTIFIER"); id != "" {
+		l.extra["program"] = id
+	}
+	if pid := field(fields, "_PID"); pid != "" {
+		l.extra["pid"] = pid
+	}
+	return category, l, true
+}
+
+// categoryOf finds the source a unit belongs to; sources may be globs and
+// may leave out ".service"
+func categoryOf(unit string, units []Source) (string, bool) {
+	if unit == "" {
+		return "", false
+	}
+	for _, u := range units {
+		pattern := u.Name
+		if !strings.Contains(path.Base(pattern), ".") {
+			pattern += ".service"
+		}
+		if ok, _ := path.Match(pattern, unit); ok {
+			return u.Category, true
+		}
+	}
+	return "", false
+}
+
+// field returns a journal field as a string. Fields that are not valid
+// UTF-8 come as arrays of bytes.
+func field(fields map[string]json.RawMessage, name string) string {
+	raw, ok := fields[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var b []byte
+	var ints []int
+	if json.Unmarshal(raw, &ints) == nil {
+		for _, n := range ints {
+			b = append(b, byte(n))
+		}
+		return strings.ToValidUTF8(string(b), "?")
+	}
+	return ""
+}
@@ -0,0 +1,405 @@
+package configure
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrAuthorizedKeysLockout is returned when a change would leave no key
+// that can open an unrestricted login
+var ErrAuthorizedKeysLockout = errors.New("change would remove the last unrestricted key")
+
+// authorizedKeysMu serializes every read-modify-write of authorized_keys
+var authorizedKeysMu sync.Mutex
+
+// AuthorizedKey is a single entry of authorized_keys
+type AuthorizedKey struct {
+	Line             int      `json:"line"`
+	Type             string   `json:"type,omitempty"`
+	Fingerprint      string   `json:"fingerprint,omitempty"`
+	Comment          string   `json:"comment,omitempty"`
+	Options          []string `json:"options,omitempty"`
+	From             string   `json:"from,omitempty"`
+	Command          string   `json:"command,omitempty"`
+	NoPortForwarding bool     `json:"no_port_forwarding"`
+	Restricted       bool     `json:"restricted"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// AuthorizedKeyRequest adds, edits or deletes an entry. Line and
+// Fingerprint identify an existing entry; Key is only used when adding.
+type AuthorizedKeyRequest struct {
+	Line             int    `json:"line"`
+	Fingerprint      string `json:"fingerprint"`
+	Key              string `json:"key"`
+	Comment          string `json:"comment"`
+	From             string `json:"from"`
+	Command          string `json:"command"`
+	NoPortForwarding bool   `json:"no_port_forwarding"`
+}
+
+// ListAuthorizedKeys returns every key entry in authorized_keys. Lines that
+// cannot be parsed are returned with Error set so they can be removed.
+func (fm *FileManager) ListAuthorizedKeys() ([]AuthorizedKey, error) {
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
+	lines, err := fm.readAuthorizedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuthorizedKey{}
+	for i, line := range lines {
+		if entry, ok := parseAuthorizedKeyLine(i+1, line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// AddAuthorizedKey appends a key with the requested restrictions. Options
+// and comment already present on the pasted line are kept unless the
+// request overrides them.
+func (fm *FileManager) AddAuthorizedKey(req AuthorizedKeyRequest) (AuthorizedKey, error) {
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
+	keyLine := strings.TrimSpace(req.Key)
+	if keyLine == "" {
+		return AuthorizedKey{}, fmt.Errorf("empty key")
+	}
+	if strings.ContainsAny(keyLine, "\r\n") {
+		return AuthorizedKey{}, fmt.Errorf("only single-line keys are supported")
+	}
+	pub, comment, options, _, err := gossh.ParseAuthorizedKey([]byte(keyLine))
+	if err != nil {
+		return AuthorizedKey{}, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	if req.Comment != "" {
+		comment = req.Comment
+	}
+	if req.From == "" {
+		req.From, _ = optionValue(options, "from")
+	}
+	if req.Command == "" {
+		req.Command, _ = optionValue(options, "command")
+	}
+	if hasOption(options, "no-port-forwarding") {
+		req.NoPortForwarding = true
+	}
+	req.Comment = comment
+
+	lines, err := fm.readAuthorizedKeys()
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	fingerprint := gossh.FingerprintSHA256(pub)
+	for i, line := range lines {
+		if entry, ok := parseAuthorizedKeyLine(i+1, line); ok && entry.Fingerprint == fingerprint {
+			return AuthorizedKey{}, fmt.Errorf("key already present on line %d", entry.Line)
+		}
+	}
+
+	line, err := formatAuthorizedKey(pub, options, req)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	updated := append(trimTrailingBlank(lines), line)
+	if err := fm.writeAuthorizedKeys(lines, updated); err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	entry, _ := parseAuthorizedKeyLine(len(updated), line)
+	return entry, nil
+}
+
+// UpdateAuthorizedKey replaces the comment and restrictions of an entry.
+// Options not managed here (no-pty, environment=...) are kept.
+func (fm *FileManager) UpdateAuthorizedKey(req AuthorizedKeyRequest) (AuthorizedKey, error) {
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
+	lines, err := fm.readAuthorizedKeys()
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+	idx, err := findAuthorizedKey(lines, req.Line, req.Fingerprint)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	pub, _, options, _, err := gossh.ParseAuthorizedKey([]byte(lines[idx]))
+	if err != nil {
+		return AuthorizedKey{}, fmt.Errorf("line %d is not a valid key", req.Line)
+	}
+
+	line, err := formatAuthorizedKey(pub, options, req)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	updated := append([]string(nil), lines...)
+	updated[idx] = line
+	if err := fm.writeAuthorizedKeys(lines, updated); err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	entry, _ := parseAuthorizedKeyLine(req.Line, line)
+	return entry, nil
+}
+
+// DeleteAuthorizedKey removes a single entry
+func (fm *FileManager) DeleteAuthorizedKey(lineNo int, fingerprint string) error {
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
+	lines, err := fm.readAuthorizedKeys()
+	if err != nil {
+		return err
+	}
+	idx, err := findAuthorizedKey(lines, lineNo, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	updated := append(append([]string(nil), lines[:idx]...), lines[idx+1:]...)
+	return fm.writeAuthorizedKeys(lines, updated)
+}
+
+// clearAuthorizedKeys empties authorized_keys, unless that would remove
+// the last unrestricted key
+func (fm *FileManager) clearAuthorizedKeys() error {
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
+	lines, err := fm.readAuthorizedKeys()
+	if err != nil {
+		return err
+	}
+	return fm.writeAuthorizedKeys(lines, nil)
+}
+
+// readAuthorizedKeys returns the lines of authorized_keys, or none if the
+// file does not exist
+func (fm *FileManager) readAuthorizedKeys() ([]string, error) {
+	data, err := os.ReadFile(fm.GetFilePath(FileTypeAuthorizedKeys))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+	content := strings.TrimSuffix(string(data), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
+
+// writeAuthorizedKeys atomically replaces authorized_keys after checking
+// the new content still allows an unrestricted login
+func (fm *FileManager) writeAuthorizedKeys(before, after []string) error {
+	if countUnrestricted(before) > 0 && countUnrestricted(after) == 0 {
+		return ErrAuthorizedKeysLockout
+	}
+
+	path := fm.GetFilePath(FileTypeAuthorizedKeys)
+	if int64(len(strings.Join(after, "\n"))+1) > maxSizeMap[FileTypeAuthorizedKeys] {
+		return fmt.Errorf("authorized_keys too large (max %d bytes)", maxSizeMap[FileTypeAuthorizedKeys])
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create ssh directory: %w", err)
+	}
+
+	content := ""
+	if len(after) > 0 {
+		content = strings.Join(after, "\n") + "\n"
+	}
+
+	tmpPath := path + ".tmp." + fmt.Sprintf("%d", time.Now().UnixNano())
+	if err := os.WriteFile(tmpPath, []byte(content), 0600); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write authorized_keys: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save authorized_keys: %w", err)
+	}
+	return nil
+}
+
+// findAuthorizedKey returns the index of the entry on lineNo, refusing the
+// change if the file was edited since the caller listed it
+func findAuthorizedKey(lines []string, lineNo int, fingerprint string) (int, error) {
+	if lineNo < 1 || lineNo > len(lines) {
+		return 0, fmt.Errorf("line %d not found", lineNo)
+	}
+	entry, ok := parseAuthorizedKeyLine(lineNo, lines[lineNo-1])
+	if !ok || entry.Fingerprint != fingerprint {
+		return 0, fmt.Errorf("authorized_keys changed, reload and try again")
+	}
+	return lineNo - 1, nil
+}
+
+// parseAuthorizedKeyLine parses one line; blank lines and comments are
+// skipped
+func parseAuthorizedKeyLine(lineNo int, line string) (AuthorizedKey, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return AuthorizedKey{}, false
+	}
+
+	pub, comment, options, _, err := gossh.ParseAuthorizedKey([]byte(trimmed))
+	if err != nil {
+		return AuthorizedKey{Line: lineNo, Error: "invalid entry"}, true
+	}
+
+	entry := AuthorizedKey{
+		Line:             lineNo,
+		Type:             pub.Type(),
+		Fingerprint:      gossh.FingerprintSHA256(pub),
+		Comment:          comment,
+		Options:          options,
+		NoPortForwarding: hasOption(options, "no-port-forwarding"),
+	}
+	entry.From, _ = optionValue(options, "from")
+	entry.Command, _ = optionValue(options, "command")
+	entry.Restricted = entry.From != "" || entry.Command != "" || hasOption(options, "restrict")
+	return entry, true
+}
+
+// countUnrestricted counts valid keys without from=, command= or restrict
+func countUnrestricted(lines []string) int {
+	n := 0
+	for i, line := range lines {
+		if entry, ok := parseAuthorizedKeyLine(i+1, line); ok && entry.Error == "" && !entry.Restricted {
+			n++
+		}
+	}
+	return n
+}
+
+// formatAuthorizedKey builds an authorized_keys line. The managed options
+// are taken from req; any other option in existing is preserved.
+func formatAuthorizedKey(pub gossh.PublicKey, existing []string, req AuthorizedKeyRequest) (string, error) {
+	if err := validateFromPatterns(req.From); err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(req.Command, "\r\n\x00") || strings.HasSuffix(req.Command, `\`) {
+		return "", fmt.Errorf("invalid command")
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if strings.ContainsAny(comment, "\r\n\x00") {
+		return "", fmt.Errorf("comment must be a single line")
+	}
+
+	var options []string
+	if req.From != "" {
+		options = append(options, "from="+quoteOption(req.From))
+	}
+	if req.Command != "" {
+		options = append(options, "command="+quoteOption(req.Command))
+	}
+	if req.NoPortForwarding {
+		options = append(options, "no-port-forwarding")
+	}
+	for _, opt := range existing {
+		switch optionName(opt) {
+		case "from", "command", "no-port-forwarding":
+			continue
+		}
+		options = append(options, opt)
+	}
+
+	line := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub)))
+	if len(options) > 0 {
+		line = strings.Join(options, ",") + " " + line
+	}
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// validateFromPatterns checks a from= pattern-list: hostnames or addresses
+// with optional wildcards, CIDR blocks and ! negation
+func validateFromPatterns(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, pattern := range strings.Split(list, ",") {
+		p := strings.TrimPrefix(pattern, "!")
+		if p == "" {
+			return fmt.Errorf("invalid from pattern %q", pattern)
+		}
+		for _, ch := range p {
+			if (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') ||
+				strings.ContainsRune(".:-_*?/", ch) {
+				continue
+			}
+			return fmt.Errorf("invalid from pattern %q", pattern)
+		}
+		if strings.Contains(p, "/") {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fmt.Errorf("invalid from CIDR %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// optionName returns the lower-cased name of an option
+func optionName(opt string) string {
+	name, _, _ := strings.Cut(opt, "=")
+	return strings.ToLower(name)
+}
+
+// hasOption reports whether a flag option is set
+func hasOption(options []string, name string) bool {
+	for _, opt := range options {
+		if optionName(opt) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// optionValue returns the unquoted value of name="value"
+func optionValue(options []string, name string) (string, bool) {
+	for _, opt := range options {
+		if optionName(opt) != name {
+			continue
+		}
+		_, value, _ := strings.Cut(opt, "=")
+		value = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+		return strings.ReplaceAll(value, `\"`, `"`), true
+	}
+	return "", false
+}
+
+// quoteOption quotes an option value the way sshd parses it
+func quoteOption(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// trimTrailingBlank drops blank lines at the end so appended keys are
+// not separated from the rest
+func trimTrailingBlank(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return append([]string(nil), lines...)
+}
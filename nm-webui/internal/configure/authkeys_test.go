@@ -0,0 +1,247 @@
+package configure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// newTestFileManager returns a file manager whose authorized_keys lives in
+// a temporary directory and holds lines
+func newTestFileManager(t *testing.T, lines ...string) *FileManager {
+	t.Helper()
+	dir := t.TempDir()
+	fm := &FileManager{basePath: dir, authorizedKeys: filepath.Join(dir, ".ssh", "authorized_keys")}
+	if len(lines) > 0 {
+		if err := fm.writeAuthorizedKeys(nil, lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fm
+}
+
+// testKeyLine returns a fresh ed25519 key as an authorized_keys line
+func testKeyLine(t *testing.T, comment string) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(sshPub))) + " " + comment
+}
+
+// listKey returns the entry on lineNo
+func listKey(t *testing.T, fm *FileManager, lineNo int) AuthorizedKey {
+	t.Helper()
+	entries, err := fm.ListAuthorizedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Line == lineNo {
+			return e
+		}
+	}
+	t.Fatalf("no entry on line %d", lineNo)
+	return AuthorizedKey{}
+}
+
+func TestAuthorizedKeysLockout(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fm *FileManager, last AuthorizedKey) error
+	}{
+		{"delete", func(fm *FileManager, last AuthorizedKey) error {
+			return fm.DeleteAuthorizedKey(last.Line, last.Fingerprint)
+		}},
+		{"restrict source", func(fm *FileManager, last AuthorizedKey) error {
+			_, err := fm.UpdateAuthorizedKey(AuthorizedKeyRequest{Line: last.Line, Fingerprint: last.Fingerprint, From: "10.0.0.0/8"})
+			return err
+		}},
+		{"force command", func(fm *FileManager, last AuthorizedKey) error {
+			_, err := fm.UpdateAuthorizedKey(AuthorizedKeyRequest{Line: last.Line, Fingerprint: last.Fingerprint, Command: "/usr/bin/true"})
+			return err
+		}},
+		{"clear", func(fm *FileManager, last AuthorizedKey) error {
+			return fm.clearAuthorizedKeys()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restricted := `from="192.168.1.0/24" ` + testKeyLine(t, "backup")
+			fm := newTestFileManager(t, restricted, testKeyLine(t, "admin"))
+			last := listKey(t, fm, 2)
+
+			if err := tt.change(fm, last); !errors.Is(err, ErrAuthorizedKeysLockout) {
+				t.Fatalf("err = %v, want ErrAuthorizedKeysLockout", err)
+			}
+			if entries, _ := fm.ListAuthorizedKeys(); len(entries) != 2 || entries[1].Restricted {
+				t.Errorf("file changed after a refused change: %+v", entries)
+			}
+		})
+	}
+}
+
+func TestAuthorizedKeysLockoutAnotherKey(t *testing.T) {
+	fm := newTestFileManager(t, testKeyLine(t, "admin"), testKeyLine(t, "laptop"))
+
+	first := listKey(t, fm, 1)
+	if _, err := fm.UpdateAuthorizedKey(AuthorizedKeyRequest{Line: 1, Fingerprint: first.Fingerprint, From: "10.0.0.0/8"}); err != nil {
+		t.Fatalf("UpdateAuthorizedKey: %v", err)
+	}
+	second := listKey(t, fm, 2)
+	if err := fm.DeleteAuthorizedKey(2, second.Fingerprint); !errors.Is(err, ErrAuthorizedKeysLockout) {
+		t.Fatalf("deleting the last unrestricted key: err = %v", err)
+	}
+
+	// With no unrestricted key to begin with, nothing is locked
+	fm = newTestFileManager(t, `command="uptime" `+testKeyLine(t, "monitor"))
+	only := listKey(t, fm, 1)
+	if err := fm.DeleteAuthorizedKey(1, only.Fingerprint); err != nil {
+		t.Fatalf("DeleteAuthorizedKey: %v", err)
+	}
+}
+
+func TestAuthorizedKeysStaleFingerprint(t *testing.T) {
+	fm := newTestFileManager(t, testKeyLine(t, "admin"), testKeyLine(t, "laptop"))
+	first := listKey(t, fm, 1)
+	if err := fm.DeleteAuthorizedKey(2, first.Fingerprint); err == nil {
+		t.Fatal("deleted a line whose key does not match the fingerprint")
+	}
+	if err := fm.DeleteAuthorizedKey(3, first.Fingerprint); err == nil {
+		t.Fatal("deleted a line past the end of the file")
+	}
+}
+
+func TestAuthorizedKeyOptionsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		want    AuthorizedKey
+	}{
+		{
+			"quoted command and from",
+			`command="echo \"hi, there\"",from="10.0.0.0/8,!10.0.0.1"`,
+			AuthorizedKey{Command: `echo "hi, there"`, From: "10.0.0.0/8,!10.0.0.1", Restricted: true},
+		},
+		{
+			"unmanaged options kept",
+			`no-pty,environment="LANG=C",no-port-forwarding,from="*.example.com"`,
+			AuthorizedKey{From: "*.example.com", NoPortForwarding: true, Restricted: true},
+		},
+		{
+			"restrict",
+			`restrict`,
+			AuthorizedKey{Restricted: true},
+		},
+		{
+			"none",
+			``,
+			AuthorizedKey{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := testKeyLine(t, "user@host")
+			if tt.options != "" {
+				line = tt.options + " " + line
+			}
+			entry, ok := parseAuthorizedKeyLine(1, line)
+			if !ok || entry.Error != "" {
+				t.Fatalf("parseAuthorizedKeyLine = %+v, %v", entry, ok)
+			}
+			if entry.From != tt.want.From || entry.Command != tt.want.Command ||
+				entry.NoPortForwarding != tt.want.NoPortForwarding || entry.Restricted != tt.want.Restricted {
+				t.Errorf("parsed = %+v, want %+v", entry, tt.want)
+			}
+
+			// Formatting the parsed entry back gives the same key and options
+			pub, _, options, _, err := gossh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := formatAuthorizedKey(pub, options, AuthorizedKeyRequest{
+				Comment:          entry.Comment,
+				From:             entry.From,
+				Command:          entry.Command,
+				NoPortForwarding: entry.NoPortForwarding,
+			})
+			if err != nil {
+				t.Fatalf("formatAuthorizedKey: %v", err)
+			}
+			again, ok := parseAuthorizedKeyLine(1, out)
+			if !ok || again.Error != "" {
+				t.Fatalf("formatted line %q does not parse", out)
+			}
+			if again.Fingerprint != entry.Fingerprint || again.Comment != entry.Comment ||
+				again.From != entry.From || again.Command != entry.Command ||
+				again.NoPortForwarding != entry.NoPortForwarding || again.Restricted != entry.Restricted {
+				t.Errorf("round trip = %+v, want %+v", again, entry)
+			}
+			if len(again.Options) != len(entry.Options) {
+				t.Errorf("options = %q, want %q", again.Options, entry.Options)
+			}
+		})
+	}
+}
+
+func TestFormatAuthorizedKeyInvalid(t *testing.T) {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey([]byte(testKeyLine(t, "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  AuthorizedKeyRequest
+	}{
+		{"from with quote", AuthorizedKeyRequest{From: `10.0.0.1"`}},
+		{"from with space", AuthorizedKeyRequest{From: "10.0.0.1 evil"}},
+		{"empty from pattern", AuthorizedKeyRequest{From: "10.0.0.1,"}},
+		{"bad CIDR", AuthorizedKeyRequest{From: "10.0.0.0/33"}},
+		{"multi-line command", AuthorizedKeyRequest{Command: "true\nrm -rf /"}},
+		{"trailing backslash", AuthorizedKeyRequest{Command: `echo \`}},
+		{"multi-line comment", AuthorizedKeyRequest{Comment: "a\nb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if line, err := formatAuthorizedKey(pub, nil, tt.req); err == nil {
+				t.Errorf("formatAuthorizedKey accepted %+v: %q", tt.req, line)
+			}
+		})
+	}
+}
+
+func TestAddAuthorizedKeyKeepsPastedOptions(t *testing.T) {
+	fm := newTestFileManager(t)
+	line := `no-pty,command="backup.sh" ` + testKeyLine(t, "backup@nas")
+
+	entry, err := fm.AddAuthorizedKey(AuthorizedKeyRequest{Key: line})
+	if err != nil {
+		t.Fatalf("AddAuthorizedKey: %v", err)
+	}
+	if entry.Command != "backup.sh" || entry.Comment != "backup@nas" || !hasOption(entry.Options, "no-pty") {
+		t.Errorf("entry = %+v", entry)
+	}
+	if _, err := fm.AddAuthorizedKey(AuthorizedKeyRequest{Key: line}); err == nil {
+		t.Error("added the same key twice")
+	}
+
+	info, err := os.Stat(fm.GetFilePath(FileTypeAuthorizedKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
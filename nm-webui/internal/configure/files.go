@@ -8,6 +8,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // FileType represents the type of configuration file
@@ -51,12 +53,13 @@ type VPNFileStatus struct {
 
 // FileManager handles configuration file operations
 type FileManager struct {
-	basePath string
+	basePath       string
+	authorizedKeys string
 }
 
 // NewFileManager creates a new FileManager
 func NewFileManager(basePath string) *FileManager {
-	return &FileManager{basePath: basePath}
+	return &FileManager{basePath: basePath, authorizedKeys: authorizedKeysPath}
 }
 
 // filePathMap maps file types to their actual filenames
@@ -76,7 +79,7 @@ var maxSizeMap = map[FileType]int64{
 // GetFilePath returns the full path for a file type
 func (fm *FileManager) GetFilePath(fileType FileType) string {
 	if fileType == FileTypeAuthorizedKeys {
-		return authorizedKeysPath
+		return fm.authorizedKeys
 	}
 	filename, ok := filePathMap[fileType]
 	if !ok {
@@ -353,10 +356,13 @@ func (fm *FileManager) AppendAuthorizedKey(reader io.Reader, size int64) (bool,
 	if strings.ContainsAny(line, "\r\n") {
 		return false, fmt.Errorf("only single-line keys are supported")
 	}
-	if len(strings.Fields(line)) < 2 {
+	if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line)); err != nil {
 		return false, fmt.Errorf("invalid public key format")
 	}
 
+	authorizedKeysMu.Lock()
+	defer authorizedKeysMu.Unlock()
+
 	path := fm.GetFilePath(FileTypeAuthorizedKeys)
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0700); err != nil {
@@ -397,12 +403,16 @@ func (fm *FileManager) AppendAuthorizedKey(reader io.Reader, size int64) (bool,
 	return true, nil
 }
 
-// DeleteFile removes a configuration file
+// DeleteFile removes a configuration file. authorized_keys is emptied
+// instead, and only if that does not remove the last unrestricted key.
 func (fm *FileManager) DeleteFile(fileType FileType) error {
 	path := fm.GetFilePath(fileType)
 	if path == "" {
 		return fmt.Errorf("invalid file type")
 	}
+	if fileType == FileTypeAuthorizedKeys {
+		return fm.clearAuthorizedKeys()
+	}
 
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete file: %w", err)
@@ -2,12 +2,15 @@ package configure
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+
+	"nm-webui/internal/logger"
 )
 
 // NetworkConfigType represents a type of network configuration
@@ -38,11 +41,13 @@ type NetworkConfig struct {
 // NetworkManager handles network configuration detection and application
 type NetworkManager struct {
 	fileManager *FileManager
+	log         *logger.Logger
 }
 
-// NewNetworkManager creates a new NetworkManager
-func NewNetworkManager(fm *FileManager) *NetworkManager {
-	return &NetworkManager{fileManager: fm}
+// NewNetworkManager creates a new NetworkManager; the nmcli commands it
+// runs are logged to log, if not nil
+func NewNetworkManager(fm *FileManager, log *logger.Logger) *NetworkManager {
+	return &NetworkManager{fileManager: fm, log: log}
 }
 
 // configDefinitions defines what parameters each config type needs
@@ -207,7 +212,7 @@ type ApplyOptions struct {
 	VPNProfile string
 }
 
-func (nm *NetworkManager) ApplyConfiguration(configType NetworkConfigType, opts ApplyOptions) error {
+func (nm *NetworkManager) ApplyConfiguration(ctx context.Context, configType NetworkConfigType, opts ApplyOptions) error {
 	// Read env-secrets
 	content, err := nm.fileManager.ViewFile(FileTypeEnvSecrets)
 	if err != nil {
@@ -215,23 +220,24 @@ func (nm *NetworkManager) ApplyConfiguration(configType NetworkConfigType, opts
 	}
 
 	env := ParseEnvFile(content)
+	c := &commands{ctx: ctx, log: nm.log}
 
 	switch configType {
 	case ConfigOpenVPN:
-		return nm.applyOpenVPN(env, opts.VPNProfile)
+		return nm.applyOpenVPN(c, env, opts.VPNProfile)
 	case ConfigIodine:
-		return nm.applyIodine(env)
+		return nm.applyIodine(c, env)
 	case ConfigHans:
-		return nm.applyHans(env)
+		return nm.applyHans(c, env)
 	case ConfigWifiAP:
-		return nm.applyWifiAP(env)
+		return nm.applyWifiAP(c, env)
 	default:
 		return fmt.Errorf("unknown configuration type: %s", configType)
 	}
 }
 
 // applyOpenVPN configures OpenVPN connection
-func (nm *NetworkManager) applyOpenVPN(env map[string]string, profile string) error {
+func (nm *NetworkManager) applyOpenVPN(c *commands, env map[string]string, profile string) error {
 	if profile == "" {
 		return fmt.Errorf("vpn profile is required")
 	}
@@ -265,10 +271,10 @@ func (nm *NetworkManager) applyOpenVPN(env map[string]string, profile string) er
 
 	connectionID := "openvpn-" + profile
 	// Delete existing connection for this profile
-	exec.Command("nmcli", "connection", "delete", connectionID).Run()
+	c.run("connection", "delete", connectionID)
 
 	// Import OpenVPN config
-	out, err := exec.Command("nmcli", "connection", "import", "type", "openvpn", "file", vpnFile).CombinedOutput()
+	out, err := c.run("connection", "import", "type", "openvpn", "file", vpnFile)
 	if err != nil {
 		return fmt.Errorf("failed to import OpenVPN configuration: %s", string(out))
 	}
@@ -279,22 +285,22 @@ func (nm *NetworkManager) applyOpenVPN(env map[string]string, profile string) er
 	}
 
 	// Rename connection
-	if out, err := exec.Command("nmcli", "connection", "modify", importedName, "connection.id", connectionID).CombinedOutput(); err != nil {
+	if out, err := c.run("connection", "modify", importedName, "connection.id", connectionID); err != nil {
 		return fmt.Errorf("failed to rename OpenVPN connection: %s", string(out))
 	}
 
 	// Only set credentials if the config requires them
 	if needsCredentials {
 		// Set username
-		if out, err := exec.Command("nmcli", "connection", "modify", connectionID,
+		if out, err := c.run("connection", "modify", connectionID,
 			"+vpn.data", fmt.Sprintf("username=%s", user),
-			"+vpn.data", "password-flags=0").CombinedOutput(); err != nil {
+			"+vpn.data", "password-flags=0"); err != nil {
 			return fmt.Errorf("failed to set OpenVPN username: %s", string(out))
 		}
 
 		// Set password
-		if out, err := exec.Command("nmcli", "connection", "modify", connectionID,
-			"vpn.secrets", fmt.Sprintf("password=%s", pass)).CombinedOutput(); err != nil {
+		if out, err := c.run("connection", "modify", connectionID,
+			"vpn.secrets", fmt.Sprintf("password=%s", pass)); err != nil {
 			return fmt.Errorf("failed to set OpenVPN password: %s", string(out))
 		}
 	}
@@ -303,7 +309,7 @@ func (nm *NetworkManager) applyOpenVPN(env map[string]string, profile string) er
 }
 
 // applyIodine configures Iodine DNS tunnel
-func (nm *NetworkManager) applyIodine(env map[string]string) error {
+func (nm *NetworkManager) applyIodine(c *commands, env map[string]string) error {
 	topdomain := env["IODINE_TOPDOMAIN"]
 	nameserver := env["IODINE_NAMESERVER"]
 	password := env["IODINE_PASS"]
@@ -335,14 +341,14 @@ func (nm *NetworkManager) applyIodine(env map[string]string) error {
 	}
 
 	// Delete existing connection
-	exec.Command("nmcli", "connection", "delete", "iodine-vpn").Run()
+	c.run("connection", "delete", "iodine-vpn")
 
 	// Create iodine VPN connection
-	out, err := exec.Command("nmcli", "connection", "add",
+	out, err := c.run("connection", "add",
 		"type", "vpn",
 		"ifname", "iodine0",
 		"con-name", "iodine-vpn",
-		"vpn-type", "iodine").CombinedOutput()
+		"vpn-type", "iodine")
 	if err != nil {
 		return fmt.Errorf("failed to create Iodine connection: %s", string(out))
 	}
@@ -351,14 +357,14 @@ func (nm *NetworkManager) applyIodine(env map[string]string) error {
 	vpnData := fmt.Sprintf("topdomain = %s, nameserver = %s, password = %s, mtu = %s, lazy-mode = %s, interval = %s",
 		topdomain, nameserver, password, mtu, lazy, interval)
 
-	if out, err := exec.Command("nmcli", "connection", "modify", "iodine-vpn",
-		"vpn.data", vpnData).CombinedOutput(); err != nil {
+	if out, err := c.run("connection", "modify", "iodine-vpn",
+		"vpn.data", vpnData); err != nil {
 		return fmt.Errorf("failed to configure Iodine connection: %s", string(out))
 	}
 
 	// Set password in secrets
-	if out, err := exec.Command("nmcli", "connection", "modify", "iodine-vpn",
-		"vpn.secrets", fmt.Sprintf("password=%s", password)).CombinedOutput(); err != nil {
+	if out, err := c.run("connection", "modify", "iodine-vpn",
+		"vpn.secrets", fmt.Sprintf("password=%s", password)); err != nil {
 		return fmt.Errorf("failed to set Iodine password: %s", string(out))
 	}
 
@@ -366,7 +372,7 @@ func (nm *NetworkManager) applyIodine(env map[string]string) error {
 }
 
 // applyHans configures Hans ICMP VPN
-func (nm *NetworkManager) applyHans(env map[string]string) error {
+func (nm *NetworkManager) applyHans(c *commands, env map[string]string) error {
 	server := env["HANS_SERVER"]
 	password := env["HANS_PASSWORD"]
 
@@ -383,28 +389,28 @@ func (nm *NetworkManager) applyHans(env map[string]string) error {
 	}
 
 	// Delete existing connection
-	exec.Command("nmcli", "connection", "delete", "hans-icmp-vpn").Run()
+	c.run("connection", "delete", "hans-icmp-vpn")
 
 	// Create Hans VPN connection
-	out, err := exec.Command("nmcli", "connection", "add",
+	out, err := c.run("connection", "add",
 		"type", "vpn",
 		"con-name", "hans-icmp-vpn",
 		"ifname", "tun0",
-		"vpn-type", "org.freedesktop.NetworkManager.hans").CombinedOutput()
+		"vpn-type", "org.freedesktop.NetworkManager.hans")
 	if err != nil {
 		return fmt.Errorf("failed to create Hans connection: %s", string(out))
 	}
 
 	// Configure VPN data
 	vpnData := fmt.Sprintf("server=%s, password=%s, password-flags=1", server, password)
-	if out, err := exec.Command("nmcli", "connection", "modify", "hans-icmp-vpn",
-		"vpn.data", vpnData).CombinedOutput(); err != nil {
+	if out, err := c.run("connection", "modify", "hans-icmp-vpn",
+		"vpn.data", vpnData); err != nil {
 		return fmt.Errorf("failed to configure Hans connection: %s", string(out))
 	}
 
 	// Set never-default
-	if out, err := exec.Command("nmcli", "connection", "modify", "hans-icmp-vpn",
-		"ipv4.never-default", "true").CombinedOutput(); err != nil {
+	if out, err := c.run("connection", "modify", "hans-icmp-vpn",
+		"ipv4.never-default", "true"); err != nil {
 		return fmt.Errorf("failed to set Hans never-default setting: %s", string(out))
 	}
 
@@ -412,7 +418,7 @@ func (nm *NetworkManager) applyHans(env map[string]string) error {
 }
 
 // applyWifiAP configures WiFi Access Point
-func (nm *NetworkManager) applyWifiAP(env map[string]string) error {
+func (nm *NetworkManager) applyWifiAP(c *commands, env map[string]string) error {
 	ssid := env["WIFI_SSID"]
 	password := env["WIFI_PASSWORD"]
 
@@ -433,21 +439,21 @@ func (nm *NetworkManager) applyWifiAP(env map[string]string) error {
 	}
 
 	// Delete existing connection
-	exec.Command("nmcli", "connection", "delete", "pi_hotspot").Run()
+	c.run("connection", "delete", "pi_hotspot")
 
 	// Create WiFi AP connection
-	out, err := exec.Command("nmcli", "con", "add",
+	out, err := c.run("con", "add",
 		"type", "wifi",
 		"ifname", "wlan0",
 		"con-name", "pi_hotspot",
 		"autoconnect", "yes",
-		"ssid", ssid).CombinedOutput()
+		"ssid", ssid)
 	if err != nil {
 		return fmt.Errorf("failed to create WiFi AP connection: %s", string(out))
 	}
 
 	// Configure AP settings
-	if out, err := exec.Command("nmcli", "con", "mod", "pi_hotspot",
+	if out, err := c.run("con", "mod", "pi_hotspot",
 		"802-11-wireless.mode", "ap",
 		"802-11-wireless.band", "bg",
 		"wifi-sec.key-mgmt", "wpa-psk",
@@ -455,7 +461,7 @@ func (nm *NetworkManager) applyWifiAP(env map[string]string) error {
 		"ipv4.addresses", "192.168.4.1/24",
 		"ipv4.method", "shared",
 		"ipv4.never-default", "yes",
-		"ipv6.method", "ignore").CombinedOutput(); err != nil {
+		"ipv6.method", "ignore"); err != nil {
 		return fmt.Errorf("failed to configure WiFi AP settings: %s", string(out))
 	}
 
@@ -495,3 +501,34 @@ func ValidateConfigType(t string) (NetworkConfigType, bool) {
 		return "", false
 	}
 }
+
+// commands runs the nmcli commands of one ApplyConfiguration, logging each
+// as part of the request
+type commands struct {
+	ctx context.Context
+	log *logger.Logger
+}
+
+// run runs nmcli and returns its combined output
+func (c *commands) run(args ...string) ([]byte, error) {
+	if c.log == nil {
+		return exec.Command("nmcli", args...).CombinedOutput()
+	}
+
+	entry := c.log.Command("nmcli", "nmcli", args).WithContext(c.ctx)
+
+	out, err := exec.Command("nmcli", args...).CombinedOutput()
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	entry.WithOutput(string(out)).
+		WithError(err).
+		WithExitCode(exitCode).
+		WithSuccess(err == nil).
+		Commit()
+	return out, err
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"nm-webui/internal/audit"
+	"nm-webui/internal/httputil"
+)
+
+// AuditHandler queries and exports the audit log
+type AuditHandler struct {
+	log *audit.Log // nil when auditing is disabled
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(log *audit.Log) *AuditHandler {
+	return &AuditHandler{log: log}
+}
+
+// List handles GET /api/audit, newest first. It takes actor, action (a
+// substring), since and until (RFC 3339), success and limit.
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.log == nil {
+		httputil.JSONError(w, http.StatusNotFound, "Audit log is disabled", "Start nm-webui with -audit")
+		return
+	}
+
+	query := r.URL.Query()
+	q := audit.Query{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  100,
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		q.Limit = limit
+	}
+	if t, err := time.Parse(time.RFC3339, query.Get("since")); err == nil {
+		q.Since = t
+	}
+	if t, err := time.Parse(time.RFC3339, query.Get("until")); err == nil {
+		q.Until = t
+	}
+	if b, err := strconv.ParseBool(query.Get("success")); err == nil {
+		q.Success = &b
+	}
+
+	entries, err := h.log.Entries(q)
+	if err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to read audit log", err.Error())
+		return
+	}
+	seq, head := h.log.Head()
+	httputil.JSONOK(w, map[string]interface{}{
+		"entries":  entries,
+		"count":    len(entries),
+		"head_seq": seq,
+		"head":     head,
+	})
+}
+
+// Download handles GET /api/audit/download, the whole kept log as JSON
+// lines, oldest first
+func (h *AuditHandler) Download(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.log == nil {
+		httputil.JSONError(w, http.StatusNotFound, "Audit log is disabled", "Start nm-webui with -audit")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"nm-webui-audit-"+time.Now().Format("20060102-150405")+".jsonl\"")
+	h.log.WriteTo(w)
+}
+
+// Verify handles GET /api/audit/verify and checks the hash chain
+func (h *AuditHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.log == nil {
+		httputil.JSONError(w, http.StatusNotFound, "Audit log is disabled", "Start nm-webui with -audit")
+		return
+	}
+	httputil.JSONOK(w, h.log.Verify())
+}
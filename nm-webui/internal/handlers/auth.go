@@ -0,0 +1,687 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"nm-webui/internal/audit"
+	"nm-webui/internal/auth"
+	"nm-webui/internal/httputil"
+	"nm-webui/internal/logger"
+	"nm-webui/internal/tlscert"
+	"nm-webui/internal/types"
+)
+
+// AuthHandler handles login, logout, sessions and user management
+type AuthHandler struct {
+	users    *auth.UserStore // nil when authentication is disabled
+	tokens   *auth.TokenStore
+	sessions *auth.SessionStore
+	limiter  *auth.LoginLimiter
+	certs    *tlscert.Manager // nil when serving plain HTTP
+	log      *logger.Logger
+}
+
+// NewAuthHandler creates a new AuthHandler
+func NewAuthHandler(users *auth.UserStore, tokens *auth.TokenStore, sessions *auth.SessionStore, limiter *auth.LoginLimiter, certs *tlscert.Manager, log *logger.Logger) *AuthHandler {
+	return &AuthHandler{
+		users:    users,
+		tokens:   tokens,
+		sessions: sessions,
+		limiter:  limiter,
+		certs:    certs,
+		log:      log,
+	}
+}
+
+// Login handles POST /api/auth/login
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONOK(w, types.AuthInfo{Role: auth.RoleAdmin.String(), AuthDisabled: true})
+		return
+	}
+	if !sameOrigin(r) {
+		httputil.JSONError(w, http.StatusForbidden, "Cross-origin login refused", "")
+		return
+	}
+
+	addr := auth.ClientAddr(r)
+	if wait, locked := h.limiter.Locked(addr); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httputil.JSONError(w, http.StatusTooManyRequests, "Too many failed logins", "Try again in "+wait.Round(time.Second).String())
+		return
+	}
+
+	var req types.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	certUser, hasCert, err := h.certs.ClientUser(r)
+	if err != nil {
+		httputil.JSONError(w, http.StatusUnauthorized, "Client certificate rejected", err.Error())
+		return
+	}
+	mode := h.certs.ClientAuth()
+
+	var user *auth.User
+	if req.Certificate {
+		// The certificate stands in for the password
+		if !hasCert {
+			httputil.JSONError(w, http.StatusUnauthorized, "No client certificate", "Your browser did not send a certificate for this site")
+			return
+		}
+		if mode == tlscert.ClientAuthRequiredPassword {
+			httputil.JSONError(w, http.StatusUnauthorized, "Password required", "Log in with the password of "+certUser)
+			return
+		}
+		var ok bool
+		if user, ok = h.users.Get(certUser); !ok {
+			httputil.JSONError(w, http.StatusUnauthorized, "Certificate user does not exist", "")
+			return
+		}
+	} else {
+		var ok bool
+		user, ok = h.users.Authenticate(req.Username, req.Password)
+		if !ok {
+			audit.Keep(r)
+			locked := h.limiter.Fail(addr)
+			h.log.Warn("auth", "login_failed").
+				WithContext(r.Context()).
+				WithExtra("user", req.Username).
+				WithExtra("remote", addr).
+				WithExtra("locked_out", locked).
+				WithSuccess(false).
+				Commit()
+			httputil.JSONError(w, http.StatusUnauthorized, "Invalid username or password", "")
+			return
+		}
+		if mode.Required() && user.Name != certUser {
+			httputil.JSONError(w, http.StatusForbidden, "Client certificate is for another user", "")
+			return
+		}
+	}
+	if user.TOTPEnabled() {
+		if req.Code == "" {
+			// Ask for the second factor; the browser resubmits with it
+			httputil.JSONOK(w, types.AuthInfo{User: user.Name, TOTPRequired: true})
+			return
+		}
+		if !h.users.VerifyTOTP(user.Name, req.Code) {
+			audit.Keep(r)
+			locked := h.limiter.Fail(addr)
+			h.log.Warn("auth", "login_failed").
+				WithContext(r.Context()).
+				WithExtra("user", user.Name).
+				WithExtra("remote", addr).
+				WithExtra("reason", "totp").
+				WithExtra("locked_out", locked).
+				WithSuccess(false).
+				Commit()
+			httputil.JSONError(w, http.StatusUnauthorized, "Invalid two-factor code", "")
+			return
+		}
+	}
+	h.limiter.Success(addr)
+	audit.SetActor(r, user.Name)
+	logger.SetActor(r.Context(), user.Name)
+
+	sess, token := h.sessions.Create(user.Name, r.RemoteAddr, r.UserAgent())
+	auth.SetCookie(w, r, token, sess.Expires)
+
+	h.log.Info("auth", "login").
+		WithActor(user.Name).
+		WithContext(r.Context()).
+		WithExtra("user", sess.User).
+		WithExtra("remote", addr).
+		WithExtra("session", sess.ID).
+		WithExtra("totp", user.TOTPEnabled()).
+		WithExtra("certificate", hasCert).
+		Commit()
+
+	info := h.info(sess)
+	info.Role = user.Role.String()
+	info.TOTPEnrol = h.users.NeedsEnrolment(user)
+	httputil.JSONOK(w, info)
+}
+
+// Logout handles POST /api/auth/logout
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	if token := auth.Token(r); token != "" {
+		h.sessions.Delete(token)
+	}
+	auth.ClearCookie(w, r)
+
+	h.log.Info("auth", "logout").
+		WithContext(r.Context()).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// Session handles GET /api/auth/session and returns the caller's login
+func (h *AuthHandler) Session(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONOK(w, types.AuthInfo{Role: auth.RoleAdmin.String(), AuthDisabled: true})
+		return
+	}
+
+	httputil.JSONOK(w, h.info(auth.FromContext(r.Context())))
+}
+
+// ListSessions handles GET /api/auth/sessions. Admins see every session,
+// other users only their own.
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	current := ""
+	if sess := auth.FromContext(r.Context()); sess != nil {
+		current = sess.ID
+	}
+	httputil.JSONOK(w, h.sessions.List(current, sessionOwner(r)))
+}
+
+// RevokeSession handles POST /api/auth/sessions/revoke
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.AuthRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.sessions.Revoke(req.ID, sessionOwner(r)); err != nil {
+		httputil.JSONError(w, http.StatusNotFound, "Failed to revoke session", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "revoke_session").
+		WithContext(r.Context()).
+		WithExtra("session", req.ID).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// ChangePassword handles POST /api/auth/password. It checks the current
+// password, applies the strength rules and ends all of the user's sessions;
+// a browser caller gets a fresh session so it stays logged in.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	addr := auth.ClientAddr(r)
+	if wait, locked := h.limiter.Locked(addr); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httputil.JSONError(w, http.StatusTooManyRequests, "Too many failed logins", "Try again in "+wait.Round(time.Second).String())
+		return
+	}
+
+	var req types.PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	user := auth.Username(r)
+	if err := h.users.ChangePassword(user, req.CurrentPassword, req.NewPassword); err != nil {
+		if errors.Is(err, auth.ErrWrongPassword) {
+			// Not 401, which would send the browser to the login screen
+			h.limiter.Fail(addr)
+			h.log.Warn("auth", "change_password").
+				WithActor(user).
+				WithContext(r.Context()).
+				WithExtra("remote", addr).
+				WithError(err).
+				Commit()
+			httputil.JSONError(w, http.StatusForbidden, "Failed to change password", err.Error())
+			return
+		}
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to change password", err.Error())
+		return
+	}
+	h.limiter.Success(addr)
+	h.sessions.RevokeUser(user)
+
+	h.log.Info("auth", "change_password").
+		WithActor(user).
+		WithContext(r.Context()).
+		WithExtra("remote", addr).
+		Commit()
+
+	info := types.AuthInfo{User: user}
+	if auth.Token(r) != "" {
+		sess, token := h.sessions.Create(user, r.RemoteAddr, r.UserAgent())
+		auth.SetCookie(w, r, token, sess.Expires)
+		info = h.info(sess)
+	}
+	info.Role = auth.RoleOf(r).String()
+	httputil.JSONOK(w, info)
+}
+
+// TOTPStatus handles GET /api/auth/totp
+func (h *AuthHandler) TOTPStatus(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONOK(w, types.TOTPStatus{})
+		return
+	}
+
+	status := types.TOTPStatus{Required: h.users.TOTPRequired(auth.RoleOf(r))}
+	if user, ok := h.users.Get(auth.Username(r)); ok {
+		status.Enabled = user.TOTPEnabled()
+		status.RecoveryRemaining = h.users.RecoveryRemaining(user.Name)
+	}
+	httputil.JSONOK(w, status)
+}
+
+// SetupTOTP handles POST /api/auth/totp/setup. It checks the password and
+// returns a new secret with its provisioning QR code; enrolment completes
+// with EnableTOTP.
+func (h *AuthHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.totpRequest(w, r, true, false); !ok {
+		return
+	}
+
+	setup, err := h.users.BeginTOTP(auth.Username(r))
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to start two-factor setup", err.Error())
+		return
+	}
+	httputil.JSONOK(w, setup)
+}
+
+// EnableTOTP handles POST /api/auth/totp/enable with the first code from
+// the authenticator app, and returns the recovery codes
+func (h *AuthHandler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.totpRequest(w, r, false, false)
+	if !ok {
+		return
+	}
+
+	user := auth.Username(r)
+	codes, err := h.users.EnableTOTP(user, req.Code)
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to enable two-factor authentication", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "totp_enable").
+		WithActor(user).
+		WithContext(r.Context()).
+		Commit()
+	httputil.JSONOK(w, types.TOTPRecoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTOTP handles POST /api/auth/totp/disable. It needs the password
+// and a current code, and is refused when the user's role requires TOTP.
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users != nil && h.users.TOTPRequired(auth.RoleOf(r)) {
+		httputil.JSONError(w, http.StatusForbidden, "Two-factor authentication is required", "The "+auth.RoleOf(r).String()+" role must use it")
+		return
+	}
+	if _, ok := h.totpRequest(w, r, true, true); !ok {
+		return
+	}
+
+	user := auth.Username(r)
+	if err := h.users.DisableTOTP(user); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to disable two-factor authentication", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "totp_disable").
+		WithActor(user).
+		WithContext(r.Context()).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// RegenerateRecovery handles POST /api/auth/totp/recovery and replaces the
+// caller's recovery codes
+func (h *AuthHandler) RegenerateRecovery(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.totpRequest(w, r, true, false); !ok {
+		return
+	}
+
+	user := auth.Username(r)
+	codes, err := h.users.NewRecoveryCodes(user)
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to create recovery codes", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "totp_recovery_codes").
+		WithActor(user).
+		WithContext(r.Context()).
+		Commit()
+	httputil.JSONOK(w, types.TOTPRecoveryCodes{RecoveryCodes: codes})
+}
+
+// totpRequest decodes a two-factor change and checks the caller's password
+// and/or current code, throttling failures like logins
+func (h *AuthHandler) totpRequest(w http.ResponseWriter, r *http.Request, password, code bool) (*types.TOTPRequest, bool) {
+	if !httputil.RequirePOST(w, r) {
+		return nil, false
+	}
+	if h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return nil, false
+	}
+
+	addr := auth.ClientAddr(r)
+	if wait, locked := h.limiter.Locked(addr); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httputil.JSONError(w, http.StatusTooManyRequests, "Too many failed logins", "Try again in "+wait.Round(time.Second).String())
+		return nil, false
+	}
+
+	var req types.TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return nil, false
+	}
+
+	user := auth.Username(r)
+	if password {
+		if _, ok := h.users.Authenticate(user, req.Password); !ok {
+			h.limiter.Fail(addr)
+			httputil.JSONError(w, http.StatusForbidden, "Password is incorrect", "")
+			return nil, false
+		}
+	}
+	if code && !h.users.VerifyTOTP(user, req.Code) {
+		h.limiter.Fail(addr)
+		httputil.JSONError(w, http.StatusForbidden, "Invalid two-factor code", "")
+		return nil, false
+	}
+	return &req, true
+}
+
+// ListTokens handles GET /api/auth/tokens. Admins see every token, other
+// users only their own.
+func (h *AuthHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	tokens := []types.APIToken{}
+	if h.tokens != nil {
+		tokens = h.tokens.List(sessionOwner(r))
+	}
+	httputil.JSONOK(w, map[string]interface{}{
+		"tokens": tokens,
+		"scopes": auth.ScopeAreas(),
+	})
+}
+
+// CreateToken handles POST /api/auth/tokens/create. The token acts as the
+// caller, limited to its scopes, and its secret is only returned here.
+func (h *AuthHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.tokens == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	var req types.APITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if req.ExpiresDays < 0 {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid expiry", "expires_days must be 0 (never) or more")
+		return
+	}
+
+	user := auth.Username(r)
+	ttl := time.Duration(req.ExpiresDays) * 24 * time.Hour
+	info, secret, err := h.tokens.Create(user, req.Name, req.Scopes, ttl)
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to create token", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "create_token").
+		WithActor(user).
+		WithContext(r.Context()).
+		WithExtra("token", info.ID).
+		WithExtra("name", info.Name).
+		WithExtra("scopes", info.Scopes).
+		WithExtra("expires", info.Expires).
+		Commit()
+	httputil.JSONOK(w, types.APITokenCreated{APIToken: info, Token: secret})
+}
+
+// RevokeToken handles POST /api/auth/tokens/revoke
+func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.tokens == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	var req types.AuthRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.tokens.Revoke(req.ID, sessionOwner(r)); err != nil {
+		httputil.JSONError(w, http.StatusNotFound, "Failed to revoke token", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "revoke_token").
+		WithContext(r.Context()).
+		WithExtra("token", req.ID).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// ListUsers handles GET /api/auth/users
+func (h *AuthHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONOK(w, []types.WebUser{})
+		return
+	}
+	httputil.JSONOK(w, h.users.List())
+}
+
+// AddUser handles POST /api/auth/users/add
+func (h *AuthHandler) AddUser(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	var req types.WebUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid role", err.Error())
+		return
+	}
+
+	if err := h.users.Add(req.Name, req.Password, role); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to add user", err.Error())
+		return
+	}
+
+	h.log.Info("auth", "add_user").
+		WithContext(r.Context()).
+		WithExtra("user", req.Name).
+		WithExtra("role", role.String()).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// UpdateUser handles POST /api/auth/users/update. It changes the role
+// and/or resets the password; a new password ends the user's sessions.
+func (h *AuthHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	var req types.WebUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if req.Role == "" && req.Password == "" && !req.ResetTOTP {
+		httputil.JSONError(w, http.StatusBadRequest, "Nothing to update", "Give a role, a password or reset_totp")
+		return
+	}
+
+	if req.Role != "" {
+		role, err := auth.ParseRole(req.Role)
+		if err != nil {
+			httputil.JSONError(w, http.StatusBadRequest, "Invalid role", err.Error())
+			return
+		}
+		if err := h.users.SetRole(req.Name, role); err != nil {
+			httputil.JSONError(w, http.StatusBadRequest, "Failed to change role", err.Error())
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := h.users.SetPassword(req.Name, req.Password); err != nil {
+			httputil.JSONError(w, http.StatusBadRequest, "Failed to set password", err.Error())
+			return
+		}
+		h.sessions.RevokeUser(req.Name)
+	}
+	if req.ResetTOTP {
+		if err := h.users.DisableTOTP(req.Name); err != nil {
+			httputil.JSONError(w, http.StatusBadRequest, "Failed to reset two-factor authentication", err.Error())
+			return
+		}
+	}
+
+	h.log.Info("auth", "update_user").
+		WithContext(r.Context()).
+		WithExtra("user", req.Name).
+		WithExtra("role", req.Role).
+		WithExtra("password_reset", req.Password != "").
+		WithExtra("totp_reset", req.ResetTOTP).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// DeleteUser handles POST /api/auth/users/delete
+func (h *AuthHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Authentication is disabled", "")
+		return
+	}
+
+	var req types.WebUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.users.Delete(req.Name); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to delete user", err.Error())
+		return
+	}
+	h.sessions.RevokeUser(req.Name)
+	if err := h.certs.RevokeClientUser(req.Name); err != nil {
+		h.log.Error("auth", "revoke_certificates").
+			WithContext(r.Context()).
+			WithExtra("user", req.Name).
+			WithError(err).
+			Commit()
+	}
+	if err := h.tokens.RevokeUser(req.Name); err != nil {
+		h.log.Error("auth", "revoke_tokens").
+			WithContext(r.Context()).
+			WithExtra("user", req.Name).
+			WithError(err).
+			Commit()
+	}
+
+	h.log.Info("auth", "delete_user").
+		WithContext(r.Context()).
+		WithExtra("user", req.Name).
+		Commit()
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// info describes a session for the browser
+func (h *AuthHandler) info(sess *auth.Session) types.AuthInfo {
+	if sess == nil {
+		return types.AuthInfo{}
+	}
+	info := types.AuthInfo{User: sess.User, Role: sess.Role.String(), CSRFToken: sess.CSRFToken, TOTPEnrol: sess.EnrolTOTP}
+	if sess.ID != "" {
+		info.Expires = h.sessions.Expiry(sess).Format(time.RFC3339)
+	}
+	return info
+}
+
+// sessionOwner limits session management to the caller's own sessions
+// unless they are an admin
+func sessionOwner(r *http.Request) string {
+	if auth.RoleOf(r) >= auth.RoleAdmin {
+		return ""
+	}
+	return auth.Username(r)
+}
+
+// sameOrigin rejects requests whose Origin header names another host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
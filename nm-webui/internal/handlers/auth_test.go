@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/logger"
+	"nm-webui/internal/types"
+)
+
+const testPassword = "Correct-Horse-42"
+
+// totpCode computes the RFC 6238 code of a base32 secret for the time
+// step offset from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30+offset))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[off:off+4])&0x7fffffff)%1000000)
+}
+
+// totpRequestFor builds a request to a two-factor endpoint as user
+func totpRequestFor(method, user string, role auth.Role, req types.TOTPRequest) *http.Request {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(method, "/api/auth/totp/disable", bytes.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1000"
+	return r.WithContext(auth.WithSession(r.Context(), &auth.Session{User: user, Role: role}))
+}
+
+func TestDisableTOTPOrdering(t *testing.T) {
+	users, err := auth.LoadUsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.RequireTOTP([]auth.Role{auth.RoleAdmin})
+	h := NewAuthHandler(users, nil, auth.NewSessionStore(0, 0), auth.NewLoginLimiter(10, time.Minute), nil, logger.NewDefault())
+
+	// Admins must keep two-factor; operators may turn it off
+	secrets := make(map[string]string)
+	for _, u := range []struct {
+		name string
+		role auth.Role
+	}{{"alice", auth.RoleAdmin}, {"olive", auth.RoleOperator}} {
+		if err := users.Add(u.name, testPassword, u.role); err != nil {
+			t.Fatal(err)
+		}
+		setup, err := users.BeginTOTP(u.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Enrol with the previous step, leaving the current code unused
+		if _, err := users.EnableTOTP(u.name, totpCode(t, setup.Secret, -1)); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		secrets[u.name] = setup.Secret
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		role     auth.Role
+		password string
+		want     int
+	}{
+		{"wrong method", http.MethodGet, "olive", auth.RoleOperator, testPassword, http.StatusMethodNotAllowed},
+		{"role requires two-factor", http.MethodPost, "alice", auth.RoleAdmin, testPassword, http.StatusForbidden},
+		{"wrong password", http.MethodPost, "olive", auth.RoleOperator, "wrong", http.StatusForbidden},
+		{"allowed", http.MethodPost, "olive", auth.RoleOperator, testPassword, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every refusal comes before the code is checked, so it
+			// stays usable for the next attempt
+			code := totpCode(t, secrets[tt.user], 0)
+			w := httptest.NewRecorder()
+			h.DisableTOTP(w, totpRequestFor(tt.method, tt.user, tt.role, types.TOTPRequest{Password: tt.password, Code: code}))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	if u, _ := users.Get("olive"); u.TOTPEnabled() {
+		t.Error("two-factor still enabled for olive")
+	}
+	if u, _ := users.Get("alice"); !u.TOTPEnabled() {
+		t.Error("two-factor disabled for alice, whose role requires it")
+	}
+	if !users.VerifyTOTP("alice", totpCode(t, secrets["alice"], 0)) {
+		t.Error("a refused request used up alice's code")
+	}
+}
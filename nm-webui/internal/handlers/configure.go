@@ -2,31 +2,65 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"nm-webui/internal/configure"
 	"nm-webui/internal/httputil"
+	"nm-webui/internal/logger"
 )
 
 // ConfigureHandler handles configuration-related API requests
 type ConfigureHandler struct {
 	fileManager    *configure.FileManager
 	networkManager *configure.NetworkManager
-	logAction      func(category, action, detail string, success bool)
+	log            *logger.Logger
+	logAction      func(r *http.Request, category, action, detail string, success bool)
+
+	secretsMu sync.Mutex
+	secrets   map[string]bool // env-secrets values hidden in the log
 }
 
 // NewConfigureHandler creates a new ConfigureHandler
-func NewConfigureHandler(basePath string, logAction func(category, action, detail string, success bool)) *ConfigureHandler {
+func NewConfigureHandler(basePath string, log *logger.Logger, logAction func(r *http.Request, category, action, detail string, success bool)) *ConfigureHandler {
 	fm := configure.NewFileManager(basePath)
-	nm := configure.NewNetworkManager(fm)
-	return &ConfigureHandler{
+	nm := configure.NewNetworkManager(fm, log)
+	h := &ConfigureHandler{
 		fileManager:    fm,
 		networkManager: nm,
+		log:            log,
 		logAction:      logAction,
+		secrets:        make(map[string]bool),
+	}
+	h.hideSecrets()
+	return h
+}
+
+// hideSecrets hides the values in env-secrets wherever they show up in the
+// log. Values since replaced stay hidden, as connections may still use them.
+func (h *ConfigureHandler) hideSecrets() {
+	if h.log == nil {
+		return
+	}
+	content, err := h.fileManager.ViewFile(configure.FileTypeEnvSecrets)
+	if err != nil {
+		return
+	}
+
+	h.secretsMu.Lock()
+	defer h.secretsMu.Unlock()
+	for _, v := range configure.ParseEnvFile(content) {
+		h.secrets[v] = true
+	}
+	values := make([]string, 0, len(h.secrets))
+	for v := range h.secrets {
+		values = append(values, v)
 	}
+	h.log.SetSecrets(values)
 }
 
 // GetFileStatus returns the status of all configuration files
@@ -121,34 +155,37 @@ func (h *ConfigureHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 		profile = strings.TrimSuffix(profile, filepath.Ext(profile))
 		if err := h.fileManager.SaveVPNProfile(profile, file, header.Size); err != nil {
-			h.logAction("configure", "upload", fileType+": "+err.Error(), false)
+			h.logAction(r, "configure", "upload", fileType+": "+err.Error(), false)
 			httputil.JSONError(w, http.StatusBadRequest, "Upload failed", err.Error())
 			return
 		}
 	} else if ft == configure.FileTypeAuthorizedKeys {
 		added, err := h.fileManager.AppendAuthorizedKey(file, header.Size)
 		if err != nil {
-			h.logAction("configure", "upload", fileType+": "+err.Error(), false)
+			h.logAction(r, "configure", "upload", fileType+": "+err.Error(), false)
 			httputil.JSONError(w, http.StatusBadRequest, "Upload failed", err.Error())
 			return
 		}
 		if added {
-			h.logAction("configure", "upload", fileType+" appended", true)
+			h.logAction(r, "configure", "upload", fileType+" appended", true)
 			httputil.JSONMessage(w, "Public key added to authorized_keys")
 		} else {
-			h.logAction("configure", "upload", fileType+" duplicate", true)
+			h.logAction(r, "configure", "upload", fileType+" duplicate", true)
 			httputil.JSONMessage(w, "Public key already present")
 		}
 		return
 	} else {
 		if err := h.fileManager.SaveFile(ft, file, header.Size); err != nil {
-			h.logAction("configure", "upload", fileType+": "+err.Error(), false)
+			h.logAction(r, "configure", "upload", fileType+": "+err.Error(), false)
 			httputil.JSONError(w, http.StatusBadRequest, "Upload failed", err.Error())
 			return
 		}
+		if ft == configure.FileTypeEnvSecrets {
+			h.hideSecrets()
+		}
 	}
 
-	h.logAction("configure", "upload", fileType+" uploaded successfully", true)
+	h.logAction(r, "configure", "upload", fileType+" uploaded successfully", true)
 	httputil.JSONMessage(w, "File uploaded successfully")
 }
 
@@ -179,22 +216,118 @@ func (h *ConfigureHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err := h.fileManager.DeleteVPNProfile(req.Profile); err != nil {
-			h.logAction("configure", "delete", req.Type+": "+err.Error(), false)
+			h.logAction(r, "configure", "delete", req.Type+": "+err.Error(), false)
 			httputil.JSONError(w, http.StatusInternalServerError, "Delete failed", err.Error())
 			return
 		}
 	} else {
 		if err := h.fileManager.DeleteFile(ft); err != nil {
-			h.logAction("configure", "delete", req.Type+": "+err.Error(), false)
+			h.logAction(r, "configure", "delete", req.Type+": "+err.Error(), false)
+			if errors.Is(err, configure.ErrAuthorizedKeysLockout) {
+				h.authorizedKeyError(w, "Delete failed", err)
+				return
+			}
 			httputil.JSONError(w, http.StatusInternalServerError, "Delete failed", err.Error())
 			return
 		}
 	}
 
-	h.logAction("configure", "delete", req.Type+" deleted", true)
+	h.logAction(r, "configure", "delete", req.Type+" deleted", true)
 	httputil.JSONMessage(w, "File deleted successfully")
 }
 
+// ListAuthorizedKeys returns the entries of authorized_keys
+func (h *ConfigureHandler) ListAuthorizedKeys(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	keys, err := h.fileManager.ListAuthorizedKeys()
+	if err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to read authorized_keys", err.Error())
+		return
+	}
+	httputil.JSONOK(w, keys)
+}
+
+// AddAuthorizedKey adds a key with optional restrictions
+func (h *ConfigureHandler) AddAuthorizedKey(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req configure.AuthorizedKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	entry, err := h.fileManager.AddAuthorizedKey(req)
+	if err != nil {
+		h.logAction(r, "configure", "authorized-key-add", err.Error(), false)
+		h.authorizedKeyError(w, "Add failed", err)
+		return
+	}
+
+	h.logAction(r, "configure", "authorized-key-add", entry.Fingerprint, true)
+	httputil.JSONOK(w, entry)
+}
+
+// UpdateAuthorizedKey edits the comment and restrictions of an entry
+func (h *ConfigureHandler) UpdateAuthorizedKey(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req configure.AuthorizedKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	entry, err := h.fileManager.UpdateAuthorizedKey(req)
+	if err != nil {
+		h.logAction(r, "configure", "authorized-key-update", err.Error(), false)
+		h.authorizedKeyError(w, "Update failed", err)
+		return
+	}
+
+	h.logAction(r, "configure", "authorized-key-update", entry.Fingerprint, true)
+	httputil.JSONOK(w, entry)
+}
+
+// DeleteAuthorizedKey removes a single entry
+func (h *ConfigureHandler) DeleteAuthorizedKey(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req configure.AuthorizedKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.fileManager.DeleteAuthorizedKey(req.Line, req.Fingerprint); err != nil {
+		h.logAction(r, "configure", "authorized-key-delete", err.Error(), false)
+		h.authorizedKeyError(w, "Delete failed", err)
+		return
+	}
+
+	h.logAction(r, "configure", "authorized-key-delete", req.Fingerprint, true)
+	httputil.JSONMessage(w, "Key removed from authorized_keys")
+}
+
+// authorizedKeyError reports a failed authorized_keys change
+func (h *ConfigureHandler) authorizedKeyError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, configure.ErrAuthorizedKeysLockout) {
+		httputil.JSONError(w, http.StatusConflict, "Change would lock out SSH access",
+			"Keep at least one key without from=, command= or restrict")
+		return
+	}
+	httputil.JSONError(w, http.StatusBadRequest, msg, err.Error())
+}
+
 // GetNetworkConfigs returns detected network configurations
 func (h *ConfigureHandler) GetNetworkConfigs(w http.ResponseWriter, r *http.Request) {
 	if !httputil.RequireGET(w, r) {
@@ -239,6 +372,9 @@ func (h *ConfigureHandler) ApplyNetworkConfig(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// env-secrets may have been edited on the device
+	h.hideSecrets()
+
 	var results []string
 	var errors []string
 
@@ -249,11 +385,11 @@ func (h *ConfigureHandler) ApplyNetworkConfig(w http.ResponseWriter, r *http.Req
 			continue
 		}
 
-		if err := h.networkManager.ApplyConfiguration(ct, configure.ApplyOptions{VPNProfile: config.Profile}); err != nil {
-			h.logAction("configure", "apply", config.Type+": "+err.Error(), false)
+		if err := h.networkManager.ApplyConfiguration(r.Context(), ct, configure.ApplyOptions{VPNProfile: config.Profile}); err != nil {
+			h.logAction(r, "configure", "apply", config.Type+": "+err.Error(), false)
 			errors = append(errors, config.Type+": "+err.Error())
 		} else {
-			h.logAction("configure", "apply", config.Type+" configured successfully", true)
+			h.logAction(r, "configure", "apply", config.Type+" configured successfully", true)
 			results = append(results, config.Type+" configured successfully")
 		}
 	}
@@ -28,7 +28,7 @@ func (h *ConnectionsHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conns, err := h.nmcli.ConnectionsList()
+	conns, err := h.nmcli.WithContext(r.Context()).ConnectionsList()
 	if err != nil {
 		httputil.JSONError(w, http.StatusInternalServerError, "Failed to list connections", err.Error())
 		return
@@ -49,8 +49,8 @@ func (h *ConnectionsHandler) Activate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.ConnectionActivate(req.UUID)
-	h.addLog("connection_activate", fmt.Sprintf("UUID: %s", req.UUID), result.Success)
+	result := h.nmcli.WithContext(r.Context()).ConnectionActivate(req.UUID)
+	h.addLog(r, "connection_activate", fmt.Sprintf("UUID: %s", req.UUID), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -67,8 +67,8 @@ func (h *ConnectionsHandler) Deactivate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	result := h.nmcli.ConnectionDeactivate(req.UUID)
-	h.addLog("connection_deactivate", fmt.Sprintf("UUID: %s", req.UUID), result.Success)
+	result := h.nmcli.WithContext(r.Context()).ConnectionDeactivate(req.UUID)
+	h.addLog(r, "connection_deactivate", fmt.Sprintf("UUID: %s", req.UUID), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -89,8 +89,8 @@ func (h *ConnectionsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.ConnectionDelete(uuid)
-	h.addLog("connection_delete", fmt.Sprintf("UUID: %s", uuid), result.Success)
+	result := h.nmcli.WithContext(r.Context()).ConnectionDelete(uuid)
+	h.addLog(r, "connection_delete", fmt.Sprintf("UUID: %s", uuid), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -107,8 +107,8 @@ func (h *ConnectionsHandler) Share(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.ConnectionShare(req.UUID, req.Enable)
-	h.addLog("connection_share", fmt.Sprintf("UUID: %s, Enable: %v", req.UUID, req.Enable), result.Success)
+	result := h.nmcli.WithContext(r.Context()).ConnectionShare(req.UUID, req.Enable)
+	h.addLog(r, "connection_share", fmt.Sprintf("UUID: %s, Enable: %v", req.UUID, req.Enable), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -20,17 +22,34 @@ func NewLogsHandler(log *logger.Logger) *LogsHandler {
 	return &LogsHandler{log: log}
 }
 
-// GetLogs handles GET /api/logs
+// GetLogs handles GET /api/logs. Pages go back in time: pass next_before
+// from one response as before to get the next.
 func (h *LogsHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
 	if !httputil.RequireGET(w, r) {
 		return
 	}
 
-	// Parse query parameters for filtering
+	filter := parseLogFilter(r)
+	if filter.Limit <= 0 {
+		filter.Limit = h.log.Settings().MaxEntries
+	}
+	entries := h.log.GetEntries(filter)
+
+	resp := map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	}
+	if len(entries) == filter.Limit {
+		resp["next_before"] = entries[len(entries)-1].ID
+	}
+	httputil.JSONOK(w, resp)
+}
+
+// parseLogFilter reads the filter parameters shared by the log endpoints
+func parseLogFilter(r *http.Request) *logger.Filter {
 	query := r.URL.Query()
-	
 	filter := &logger.Filter{}
-	
+
 	if cat := query.Get("category"); cat != "" {
 		filter.Category = cat
 	}
@@ -42,6 +61,12 @@ func (h *LogsHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
 			filter.Limit = n
 		}
 	}
+	if actor := query.Get("actor"); actor != "" {
+		filter.Actor = actor
+	}
+	if id := query.Get("request_id"); id != "" {
+		filter.RequestID = id
+	}
 	if search := query.Get("search"); search != "" {
 		filter.Search = search
 	}
@@ -50,18 +75,206 @@ func (h *LogsHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
 			filter.Since = t
 		}
 	}
+	if until := query.Get("until"); until != "" {
+		if t, err := time.Parse(time.RFC3339, until); err == nil {
+			filter.Until = t
+		}
+	}
+	if before := query.Get("before"); before != "" {
+		if n, err := strconv.ParseUint(before, 10, 64); err == nil {
+			filter.Before = n
+		}
+	}
 	if success := query.Get("success"); success != "" {
 		if b, err := strconv.ParseBool(success); err == nil {
 			filter.Success = &b
 		}
 	}
+	return filter
+}
 
-	entries := h.log.GetEntries(filter)
-	
-	httputil.JSONOK(w, map[string]interface{}{
-		"entries": entries,
-		"count":   len(entries),
-	})
+// Export handles GET /api/logs/export, a download of the entries matching
+// the filter, oldest first, as NDJSON (the default) or CSV (format=csv)
+func (h *LogsHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid format", "Use ndjson or csv")
+		return
+	}
+
+	entries := h.log.GetEntries(parseLogFilter(r))
+	name := "nm-webui-logs-" + time.Now().Format("20060102-150405")
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+".csv\"")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "time", "level", "category", "action", "actor", "success", "duration_ms", "exit_code", "command", "error", "output", "extra"})
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			extra := ""
+			if e.Extra != nil {
+				if data, err := json.Marshal(e.Extra); err == nil {
+					extra = string(data)
+				}
+			}
+			cw.Write([]string{
+				strconv.FormatUint(e.ID, 10),
+				e.Time.Format(time.RFC3339Nano),
+				e.Level,
+				e.Category,
+				e.Action,
+				e.Actor,
+				strconv.FormatBool(e.Success),
+				strconv.FormatInt(e.Duration, 10),
+				strconv.Itoa(e.ExitCode),
+				e.Command,
+				e.Error,
+				e.Output,
+				extra,
+			})
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+".jsonl\"")
+	enc := json.NewEncoder(w)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := enc.Encode(entries[i]); err != nil {
+			return
+		}
+	}
+}
+
+// streamReplayMax caps the entries replayed to a reconnecting stream
+const streamReplayMax = 1000
+
+// Stream handles GET /api/logs/stream, Server-Sent Events of new entries
+// matching the filters of /api/logs. Each event's id is the entry ID; a
+// client reconnecting with Last-Event-ID (or after=<id>) first gets the
+// entries it missed. A client too slow to keep up has the entries dropped
+// from its channel read back from the log instead. Replays are capped, and
+// a "dropped" event names the range of IDs left out.
+func (h *LogsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	filter := parseLogFilter(r)
+	filter.Limit, filter.Before = 0, 0
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("after")
+	}
+	after, _ := strconv.ParseUint(lastID, 10, 64)
+
+	// Subscribe before replaying so that nothing falls in between
+	ch := h.log.Subscribe()
+	defer h.log.Unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// seen is the newest entry ID handled, matching or not
+	seen := after
+	send := func(e logger.Entry) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil
+		}
+		rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data)
+		return err
+	}
+	// catchUp sends the matching entries after seen and before an ID,
+	// oldest first
+	catchUp := func(before uint64) error {
+		f := *filter
+		f.After, f.Before, f.Limit = seen, before, streamReplayMax
+		missed := h.log.GetEntries(&f)
+		if len(missed) == streamReplayMax {
+			rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			_, err := fmt.Fprintf(w, "event: dropped\ndata: {\"after\":%d,\"before\":%d}\n\n", seen, missed[len(missed)-1].ID)
+			if err != nil {
+				return err
+			}
+		}
+		for i := len(missed) - 1; i >= 0; i-- {
+			if err := send(missed[i]); err != nil {
+				return err
+			}
+		}
+		if len(missed) > 0 && missed[0].ID > seen {
+			seen = missed[0].ID
+		}
+		return nil
+	}
+
+	if after > 0 {
+		if err := catchUp(0); err != nil {
+			return
+		}
+	}
+	rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	fmt.Fprint(w, ": connected\n\n")
+	if rc.Flush() != nil {
+		return
+	}
+
+	ping := time.NewTicker(25 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				return // shutting down
+			}
+			if e.ID <= seen {
+				continue // already replayed
+			}
+			if seen > 0 && e.ID > seen+1 {
+				if catchUp(e.ID) != nil {
+					return
+				}
+			}
+			seen = e.ID
+			if filter.Matches(e) && send(e) != nil {
+				return
+			}
+			// Entries dropped after the last one received leave no gap
+			// until the next, so look once the channel is drained
+			if len(ch) == 0 {
+				if newest := h.log.LastID(); newest > seen {
+					if catchUp(newest+1) != nil {
+						return
+					}
+					seen = newest
+				}
+			}
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
 }
 
 // GetSettings handles GET /api/logs/settings
@@ -80,13 +293,21 @@ func (h *LogsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var settings logger.Settings
+	// Fields left out of the request keep their current values
+	settings := h.log.Settings()
 	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
 		httputil.JSONError(w, http.StatusBadRequest, "Invalid request body", err.Error())
 		return
 	}
+	if err := settings.Validate(); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid settings", err.Error())
+		return
+	}
 
-	h.log.UpdateSettings(settings)
+	if err := h.log.UpdateSettings(settings); err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to save settings", err.Error())
+		return
+	}
 	httputil.JSONMessage(w, "Settings updated")
 }
 
@@ -104,7 +325,10 @@ func (h *LogsHandler) Toggle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.log.SetEnabled(req.Enabled)
+	if err := h.log.SetEnabled(req.Enabled); err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to save settings", err.Error())
+		return
+	}
 	
 	status := "disabled"
 	if req.Enabled {
@@ -119,7 +343,10 @@ func (h *LogsHandler) Clear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.log.Clear()
+	if err := h.log.Clear(); err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to clear stored logs", err.Error())
+		return
+	}
 	httputil.JSONMessage(w, "Logs cleared")
 }
 
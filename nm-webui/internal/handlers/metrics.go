@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"nm-webui/internal/httputil"
+	"nm-webui/internal/logger"
+	"nm-webui/internal/metrics"
+	"nm-webui/internal/nmcli"
+	"nm-webui/internal/ssh"
+	"nm-webui/internal/types"
+)
+
+// connectivityStates are the results of NetworkManager's connectivity check
+var connectivityStates = []string{"unknown", "none", "portal", "limited", "full"}
+
+// MetricsHandler serves /metrics for Prometheus
+type MetricsHandler struct {
+	nmcli   *nmcli.Client
+	tunnels *ssh.TunnelManager
+	log     *logger.Logger
+	started time.Time
+}
+
+// NewMetricsHandler creates a new metrics handler. client should not log:
+// a scrape every few seconds would bury the system log in nmcli commands.
+func NewMetricsHandler(client *nmcli.Client, tunnels *ssh.TunnelManager, log *logger.Logger) *MetricsHandler {
+	return &MetricsHandler{nmcli: client, tunnels: tunnels, log: log, started: time.Now()}
+}
+
+// Metrics handles GET /metrics. A reading that fails is left out, and
+// nmwebui_collector_success says which.
+func (h *MetricsHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	m := metrics.NewWriter(w)
+	h.writeSystem(m)
+	collected := []struct {
+		name string
+		ok   bool
+	}{
+		{"devices", h.writeDevices(m)},
+		{"wifi", h.writeWifi(m)},
+		{"connectivity", h.writeConnectivity(m)},
+		{"vpn", h.writeVPN(m)},
+	}
+	h.writeTunnels(m)
+	h.writeInternals(m)
+
+	m.Family("nmwebui_collector_success", metrics.Gauge, "Whether a reading succeeded in this scrape")
+	for _, c := range collected {
+		m.Sample("nmwebui_collector_success", metrics.Bool(c.ok), "collector", c.name)
+	}
+	m.Flush()
+}
+
+// writeSystem writes the figures on the dashboard's system card
+func (h *MetricsHandler) writeSystem(m *metrics.Writer) {
+	sys := nmcli.SystemInfo()
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"nmwebui_load1", "1-minute load average", sys.Load1},
+		{"nmwebui_load5", "5-minute load average", sys.Load5},
+		{"nmwebui_load15", "15-minute load average", sys.Load15},
+		{"nmwebui_memory_total_bytes", "Total memory", float64(sys.MemTotal)},
+		{"nmwebui_memory_available_bytes", "Memory available to start new programs", float64(sys.MemAvailable)},
+		{"nmwebui_disk_total_bytes", "Size of the root filesystem", float64(sys.DiskTotal)},
+		{"nmwebui_disk_free_bytes", "Space free on the root filesystem", float64(sys.DiskFree)},
+		{"nmwebui_uptime_seconds", "Time since the system booted", float64(sys.UptimeSeconds)},
+	}
+	for _, g := range gauges {
+		m.Family(g.name, metrics.Gauge, g.help)
+		m.Sample(g.name, g.value)
+	}
+}
+
+// writeDevices writes each network device's state and traffic counters
+func (h *MetricsHandler) writeDevices(m *metrics.Writer) bool {
+	devices, err := h.nmcli.DeviceStates()
+	if err != nil {
+		return false
+	}
+
+	m.Family("nmwebui_interface_info", metrics.Gauge, "Network devices, their NetworkManager state and active connection")
+	for _, d := range devices {
+		m.Sample("nmwebui_interface_info", 1, "device", d.Device, "type", d.Type, "state", d.State, "connection", d.Connection)
+	}
+	m.Family("nmwebui_interface_up", metrics.Gauge, "Whether NetworkManager has the device connected")
+	for _, d := range devices {
+		m.Sample("nmwebui_interface_up", metrics.Bool(d.State == "connected"), "device", d.Device)
+	}
+
+	type counted struct {
+		device string
+		rx, tx uint64
+	}
+	var bytes, packets, errs, dropped []counted
+	for _, d := range devices {
+		ic, err := nmcli.InterfaceCounters(d.Device)
+		if err != nil {
+			continue // not a kernel interface, e.g. a Wi-Fi P2P device
+		}
+		bytes = append(bytes, counted{d.Device, ic.RxBytes, ic.TxBytes})
+		packets = append(packets, counted{d.Device, ic.RxPackets, ic.TxPackets})
+		errs = append(errs, counted{d.Device, ic.RxErrors, ic.TxErrors})
+		dropped = append(dropped, counted{d.Device, ic.RxDropped, ic.TxDropped})
+	}
+	for _, c := range []struct {
+		name, help string
+		values     []counted
+	}{
+		{"bytes", "bytes", bytes},
+		{"packets", "packets", packets},
+		{"errors", "errors", errs},
+		{"dropped", "packets dropped", dropped},
+	} {
+		for _, dir := range []string{"Receive", "Transmit"} {
+			name := "nmwebui_interface_" + strings.ToLower(dir) + "_" + c.name + "_total"
+			m.Family(name, metrics.Counter, dir+" "+c.help+" since boot")
+			for _, v := range c.values {
+				n := v.rx
+				if dir == "Transmit" {
+					n = v.tx
+				}
+				m.Sample(name, float64(n), "device", v.device)
+			}
+		}
+	}
+	return true
+}
+
+// writeWifi writes the signal, bit rate and channel of connected WiFi
+// devices; uplink marks the one carrying the default route
+func (h *MetricsHandler) writeWifi(m *metrics.Writer) bool {
+	links, err := h.nmcli.ActiveWifi()
+	if err != nil {
+		return false
+	}
+	upstream := h.nmcli.GetUpstreamInterface()
+
+	gauges := []struct {
+		name, help string
+		value      func(i int) float64
+	}{
+		{"nmwebui_wifi_signal_percent", "Signal quality of the access point", func(i int) float64 { return float64(links[i].Signal) }},
+		{"nmwebui_wifi_bitrate_bits_per_second", "Bit rate to the access point", func(i int) float64 { return links[i].RateMbps * 1e6 }},
+		{"nmwebui_wifi_channel", "Channel of the access point", func(i int) float64 { return float64(links[i].Channel) }},
+		{"nmwebui_wifi_frequency_hertz", "Frequency of the access point", func(i int) float64 { return float64(links[i].FreqMHz) * 1e6 }},
+	}
+	for _, g := range gauges {
+		m.Family(g.name, metrics.Gauge, g.help)
+		for i, l := range links {
+			m.Sample(g.name, g.value(i), "device", l.Device, "ssid", l.SSID, "bssid", l.BSSID, "uplink", strconv.FormatBool(l.Device == upstream))
+		}
+	}
+	return true
+}
+
+// writeConnectivity writes the result of NetworkManager's last
+// connectivity check, one series per state
+func (h *MetricsHandler) writeConnectivity(m *metrics.Writer) bool {
+	state, err := h.nmcli.Connectivity()
+	if err != nil {
+		return false
+	}
+	m.Family("nmwebui_connectivity", metrics.Gauge, "NetworkManager's last connectivity check result: 1 for the current state")
+	for _, s := range connectivityStates {
+		m.Sample("nmwebui_connectivity", metrics.Bool(s == state), "state", s)
+	}
+	return true
+}
+
+// writeVPN writes whether each VPN and WireGuard connection is up
+func (h *MetricsHandler) writeVPN(m *metrics.Writer) bool {
+	conns, err := h.nmcli.ConnectionsList()
+	if err != nil {
+		return false
+	}
+	m.Family("nmwebui_vpn_up", metrics.Gauge, "Whether a VPN or WireGuard connection is active")
+	for _, c := range conns {
+		if c.Type == "vpn" || c.Type == "wireguard" {
+			m.Sample("nmwebui_vpn_up", metrics.Bool(c.Active), "connection", c.Name, "type", c.Type)
+		}
+	}
+	return true
+}
+
+// writeTunnels writes the state and traffic of each SSH tunnel
+func (h *MetricsHandler) writeTunnels(m *metrics.Writer) {
+	tunnels := h.tunnels.Snapshot()
+
+	m.Family("nmwebui_tunnel_up", metrics.Gauge, "Whether an SSH tunnel is connected")
+	for _, t := range tunnels {
+		m.Sample("nmwebui_tunnel_up", metrics.Bool(t.Status == "running"), "tunnel", t.ID, "host", t.Host)
+	}
+
+	counters := []struct {
+		name, typ, help string
+		value           func(s *types.SSHTunnelStats) float64
+	}{
+		{"nmwebui_tunnel_reconnects_total", metrics.Counter, "Times an SSH tunnel reconnected after losing its connection", func(s *types.SSHTunnelStats) float64 { return float64(s.Reconnects) }},
+		{"nmwebui_tunnel_receive_bytes_total", metrics.Counter, "Bytes received through an SSH tunnel", func(s *types.SSHTunnelStats) float64 { return float64(s.BytesIn) }},
+		{"nmwebui_tunnel_transmit_bytes_total", metrics.Counter, "Bytes sent through an SSH tunnel", func(s *types.SSHTunnelStats) float64 { return float64(s.BytesOut) }},
+		{"nmwebui_tunnel_connections", metrics.Gauge, "Connections being forwarded through an SSH tunnel", func(s *types.SSHTunnelStats) float64 { return float64(s.ActiveConns) }},
+		{"nmwebui_tunnel_keepalive_rtt_seconds", metrics.Gauge, "Round trip of the last SSH keepalive", func(s *types.SSHTunnelStats) float64 { return s.KeepaliveRTTMs / 1000 }},
+	}
+	for _, c := range counters {
+		m.Family(c.name, c.typ, c.help)
+		for _, t := range tunnels {
+			if t.Stats != nil {
+				m.Sample(c.name, c.value(t.Stats), "tunnel", t.ID, "host", t.Host)
+			}
+		}
+	}
+}
+
+// writeInternals writes the commands nm-webui ran, by category, and the
+// state of the process and its syslog forwarding
+func (h *MetricsHandler) writeInternals(m *metrics.Writer) {
+	stats := h.log.CommandStats()
+	m.Family("nmwebui_commands_total", metrics.Counter, "Commands run, by log category")
+	for _, s := range stats {
+		m.Sample("nmwebui_commands_total", float64(s.Count), "category", s.Category)
+	}
+	m.Family("nmwebui_command_failures_total", metrics.Counter, "Commands that failed, by log category")
+	for _, s := range stats {
+		m.Sample("nmwebui_command_failures_total", float64(s.Failures), "category", s.Category)
+	}
+	m.Family("nmwebui_command_duration_seconds", metrics.Histogram, "How long commands took, by log category")
+	for _, s := range stats {
+		m.Histogram("nmwebui_command_duration_seconds", logger.CommandBuckets, s.Buckets, s.Count, s.Seconds, "category", s.Category)
+	}
+
+	if st, ok := h.log.SyslogStatus(); ok {
+		m.Family("nmwebui_syslog_connected", metrics.Gauge, "Whether the remote syslog collector is connected")
+		m.Sample("nmwebui_syslog_connected", metrics.Bool(st.Connected))
+		m.Family("nmwebui_syslog_queued", metrics.Gauge, "Log entries waiting for the remote syslog collector")
+		m.Sample("nmwebui_syslog_queued", float64(st.Queued))
+		m.Family("nmwebui_syslog_sent_total", metrics.Counter, "Log entries sent to the remote syslog collector")
+		m.Sample("nmwebui_syslog_sent_total", float64(st.Sent))
+		m.Family("nmwebui_syslog_dropped_total", metrics.Counter, "Log entries dropped while the remote syslog collector was unreachable")
+		m.Sample("nmwebui_syslog_dropped_total", float64(st.Dropped))
+	}
+
+	m.Family("nmwebui_start_time_seconds", metrics.Gauge, "When nm-webui started, in seconds since the epoch")
+	m.Sample("nmwebui_start_time_seconds", float64(h.started.Unix()))
+	m.Family("nmwebui_goroutines", metrics.Gauge, "Goroutines in the nm-webui process")
+	m.Sample("nmwebui_goroutines", float64(runtime.NumGoroutine()))
+}
@@ -28,14 +28,15 @@ func (h *NetworkHandler) ListInterfaces(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	interfaces, err := h.nmcli.GetInterfaces()
+	client := h.nmcli.WithContext(r.Context())
+	interfaces, err := client.GetInterfaces()
 	if err != nil {
 		httputil.JSONError(w, http.StatusInternalServerError, "Failed to get interfaces", err.Error())
 		return
 	}
 
 	// Also get the upstream interface (the one with internet)
-	upstream := h.nmcli.GetUpstreamInterface()
+	upstream := client.GetUpstreamInterface()
 
 	result := map[string]interface{}{
 		"interfaces": interfaces,
@@ -63,18 +64,19 @@ func (h *NetworkHandler) ToggleSharing(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If no upstream specified, auto-detect
+	client := h.nmcli.WithContext(r.Context())
 	upstream := req.Upstream
 	if upstream == "" && req.Enable {
-		upstream = h.nmcli.GetUpstreamInterface()
+		upstream = client.GetUpstreamInterface()
 	}
 
-	result := h.nmcli.SetInterfaceSharing(req.Device, req.Enable, upstream)
+	result := client.SetInterfaceSharing(req.Device, req.Enable, upstream)
 	
 	action := "disabled"
 	if req.Enable {
 		action = "enabled"
 	}
-	h.addLog("network_sharing", fmt.Sprintf("Device: %s, Action: %s", req.Device, action), result.Success)
+	h.addLog(r, "network_sharing", fmt.Sprintf("Device: %s, Action: %s", req.Device, action), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -15,14 +16,16 @@ import (
 type SSHHandler struct {
 	keyManager    *ssh.KeyManager
 	tunnelManager *ssh.TunnelManager
-	logAction     func(action, detail string, success bool)
+	phoneHome     *ssh.PhoneHome
+	logAction     LogFunc
 }
 
 // NewSSHHandler creates a new SSH handler
-func NewSSHHandler(km *ssh.KeyManager, tm *ssh.TunnelManager, logAction func(string, string, bool)) *SSHHandler {
+func NewSSHHandler(km *ssh.KeyManager, tm *ssh.TunnelManager, ph *ssh.PhoneHome, logAction LogFunc) *SSHHandler {
 	return &SSHHandler{
 		keyManager:    km,
 		tunnelManager: tm,
+		phoneHome:     ph,
 		logAction:     logAction,
 	}
 }
@@ -70,14 +73,68 @@ func (h *SSHHandler) UploadKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Upload the key
-	if err := h.keyManager.Upload(header.Filename, content); err != nil {
-		httputil.JSONError(w, http.StatusInternalServerError, "Failed to save key", err.Error())
+	// Upload the key (converted to OpenSSH format if needed)
+	key, err := h.keyManager.Upload(header.Filename, content, r.FormValue("passphrase"))
+	if err != nil {
+		if errors.Is(err, ssh.ErrPassphraseRequired) {
+			httputil.JSONError(w, http.StatusBadRequest, "Passphrase required", err.Error())
+			return
+		}
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to save key", err.Error())
 		return
 	}
 
-	h.logAction("SSH: upload_key", header.Filename, true)
-	httputil.JSONOK(w, map[string]string{"file": header.Filename})
+	h.logAction(r, "SSH: upload_key", key.Name, true)
+	httputil.JSONOK(w, key)
+}
+
+// UnlockKey handles POST /api/ssh/keys/unlock
+func (h *SSHHandler) UnlockKey(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.SSHKeyUnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if req.Name == "" || req.Passphrase == "" {
+		httputil.JSONError(w, http.StatusBadRequest, "Key name and passphrase required", "")
+		return
+	}
+
+	if err := h.keyManager.Unlock(req.Name, req.Passphrase); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to unlock key", err.Error())
+		return
+	}
+
+	h.logAction(r, "SSH: unlock_key", req.Name, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// LockKey handles POST /api/ssh/keys/lock
+func (h *SSHHandler) LockKey(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.SSHKeyUnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		httputil.JSONError(w, http.StatusBadRequest, "Key name required", "")
+		return
+	}
+
+	h.keyManager.Lock(req.Name)
+
+	h.logAction(r, "SSH: lock_key", req.Name, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
 }
 
 // DeleteKey handles POST /api/ssh/keys/delete
@@ -102,7 +159,74 @@ func (h *SSHHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logAction("SSH: delete_key", req.Name, true)
+	h.logAction(r, "SSH: delete_key", req.Name, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// UploadCert handles POST /api/ssh/keys/cert/upload
+func (h *SSHHandler) UploadCert(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid form data", err.Error())
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		httputil.JSONError(w, http.StatusBadRequest, "Key name required", "")
+		return
+	}
+
+	file, _, err := r.FormFile("certfile")
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "No file uploaded", err.Error())
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, 64<<10))
+	if err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to read file", err.Error())
+		return
+	}
+
+	cert, err := h.keyManager.UploadCert(name, content)
+	if err != nil {
+		h.logAction(r, "SSH: upload_cert", name+": "+err.Error(), false)
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to save certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "SSH: upload_cert", name, true)
+	httputil.JSONOK(w, cert)
+}
+
+// DeleteCert handles POST /api/ssh/keys/cert/delete
+func (h *SSHHandler) DeleteCert(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.SSHKeyDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		httputil.JSONError(w, http.StatusBadRequest, "Key name required", "")
+		return
+	}
+
+	if err := h.keyManager.DeleteCert(req.Name); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to delete certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "SSH: delete_cert", req.Name, true)
 	httputil.JSONOK(w, map[string]bool{"success": true})
 }
 
@@ -124,7 +248,7 @@ func (h *SSHHandler) GenerateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logAction("SSH: generate_key", req.KeyName+" ("+req.KeyType+")", true)
+	h.logAction(r, "SSH: generate_key", req.KeyName+" ("+req.KeyType+")", true)
 	httputil.JSONOK(w, result)
 }
 
@@ -181,7 +305,7 @@ func (h *SSHHandler) ListTunnels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tunnels := h.tunnelManager.List()
+	tunnels := h.tunnelManager.List(r.Context())
 	httputil.JSONOK(w, types.SSHTunnelListResult{Tunnels: tunnels})
 }
 
@@ -204,14 +328,19 @@ func (h *SSHHandler) CreateTunnel(w http.ResponseWriter, r *http.Request) {
 	if req.RHost == "" {
 		req.RHost = "127.0.0.1"
 	}
+	for i := range req.Jumps {
+		if req.Jumps[i].Port == 0 {
+			req.Jumps[i].Port = 22
+		}
+	}
 
-	tunnel, err := h.tunnelManager.Create(req)
+	tunnel, err := h.tunnelManager.Create(r.Context(), req)
 	if err != nil {
 		httputil.JSONError(w, http.StatusBadRequest, "Failed to create tunnel", err.Error())
 		return
 	}
 
-	h.logAction("SSH: create_tunnel", req.User+"@"+req.Host+" ("+req.FwdType+")", true)
+	h.logAction(r, "SSH: create_tunnel", req.User+"@"+req.Host+" ("+req.FwdType+")", true)
 	httputil.JSONOK(w, tunnel)
 }
 
@@ -232,12 +361,12 @@ func (h *SSHHandler) StartTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.tunnelManager.Start(req.ID); err != nil {
+	if err := h.tunnelManager.Start(r.Context(), req.ID); err != nil {
 		httputil.JSONError(w, http.StatusBadRequest, "Failed to start tunnel", err.Error())
 		return
 	}
 
-	h.logAction("SSH: start_tunnel", req.ID, true)
+	h.logAction(r, "SSH: start_tunnel", req.ID, true)
 	httputil.JSONOK(w, map[string]bool{"success": true})
 }
 
@@ -258,12 +387,12 @@ func (h *SSHHandler) StopTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.tunnelManager.Stop(req.ID); err != nil {
+	if err := h.tunnelManager.Stop(r.Context(), req.ID); err != nil {
 		httputil.JSONError(w, http.StatusBadRequest, "Failed to stop tunnel", err.Error())
 		return
 	}
 
-	h.logAction("SSH: stop_tunnel", req.ID, true)
+	h.logAction(r, "SSH: stop_tunnel", req.ID, true)
 	httputil.JSONOK(w, map[string]bool{"success": true})
 }
 
@@ -284,12 +413,84 @@ func (h *SSHHandler) DeleteTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.tunnelManager.Delete(req.ID); err != nil {
+	if err := h.tunnelManager.Delete(r.Context(), req.ID); err != nil {
 		httputil.JSONError(w, http.StatusBadRequest, "Failed to delete tunnel", err.Error())
 		return
 	}
 
-	h.logAction("SSH: delete_tunnel", req.ID, true)
+	h.logAction(r, "SSH: delete_tunnel", req.ID, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// TunnelHistory handles GET /api/ssh/tunnels/history?id=tunnelid
+func (h *SSHHandler) TunnelHistory(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httputil.JSONError(w, http.StatusBadRequest, "Tunnel ID required", "")
+		return
+	}
+
+	samples, err := h.tunnelManager.History(id)
+	if err != nil {
+		httputil.JSONError(w, http.StatusNotFound, "Tunnel not found", err.Error())
+		return
+	}
+
+	httputil.JSONOK(w, types.SSHTunnelHistoryResult{
+		ID:       id,
+		Interval: h.tunnelManager.SampleInterval(),
+		Samples:  samples,
+	})
+}
+
+// ========== Phone Home ==========
+
+// GetPhoneHome handles GET /api/ssh/phonehome
+func (h *SSHHandler) GetPhoneHome(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	httputil.JSONOK(w, h.phoneHome.Get())
+}
+
+// ConfigurePhoneHome handles POST /api/ssh/phonehome/config
+func (h *SSHHandler) ConfigurePhoneHome(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.PhoneHomeConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.phoneHome.Configure(req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to configure phone-home", err.Error())
+		return
+	}
+
+	h.logAction(r, "SSH: configure_phonehome", req.User+"@"+req.Host, true)
+	httputil.JSONOK(w, h.phoneHome.Get())
+}
+
+// ReconnectPhoneHome handles POST /api/ssh/phonehome/reconnect
+func (h *SSHHandler) ReconnectPhoneHome(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	if err := h.phoneHome.Reconnect(); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to reconnect", err.Error())
+		return
+	}
+
+	h.logAction(r, "SSH: reconnect_phonehome", "", true)
 	httputil.JSONOK(w, map[string]bool{"success": true})
 }
 
@@ -306,7 +507,7 @@ func (h *SSHHandler) GetTunnel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tunnels := h.tunnelManager.List()
+	tunnels := h.tunnelManager.List(r.Context())
 	for _, t := range tunnels {
 		if t.ID == path {
 			httputil.JSONOK(w, t)
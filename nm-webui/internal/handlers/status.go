@@ -35,7 +35,7 @@ func (h *StatusHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := h.nmcli.GetStatus()
+	status, err := h.nmcli.WithContext(r.Context()).GetStatus()
 	if err != nil {
 		httputil.JSONError(w, http.StatusInternalServerError, "Failed to get status", err.Error())
 		return
@@ -29,13 +29,13 @@ func (h *SystemHandler) Shutdown(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Info("system", "Shutdown requested").Commit()
+	h.logger.Info("system", "Shutdown requested").WithContext(r.Context()).Commit()
 
 	// Execute shutdown command
 	cmd := exec.Command("sudo", "/sbin/poweroff")
 	err := cmd.Start()
 	if err != nil {
-		h.logger.Error("system", fmt.Sprintf("Shutdown failed: %v", err)).Commit()
+		h.logger.Error("system", fmt.Sprintf("Shutdown failed: %v", err)).WithContext(r.Context()).Commit()
 		httputil.JSONError(w, http.StatusInternalServerError, "Shutdown failed", err.Error())
 		return
 	}
@@ -50,13 +50,13 @@ func (h *SystemHandler) Reboot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Info("system", "Reboot requested").Commit()
+	h.logger.Info("system", "Reboot requested").WithContext(r.Context()).Commit()
 
 	// Execute reboot command
 	cmd := exec.Command("sudo", "/sbin/reboot")
 	err := cmd.Start()
 	if err != nil {
-		h.logger.Error("system", fmt.Sprintf("Reboot failed: %v", err)).Commit()
+		h.logger.Error("system", fmt.Sprintf("Reboot failed: %v", err)).WithContext(r.Context()).Commit()
 		httputil.JSONError(w, http.StatusInternalServerError, "Reboot failed", err.Error())
 		return
 	}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/httputil"
+	"nm-webui/internal/terminal"
+	"nm-webui/internal/types"
+)
+
+// TerminalHandler handles web terminal requests
+type TerminalHandler struct {
+	manager   *terminal.Manager
+	logAction LogFunc
+}
+
+// NewTerminalHandler creates a new TerminalHandler
+func NewTerminalHandler(m *terminal.Manager, logAction LogFunc) *TerminalHandler {
+	return &TerminalHandler{manager: m, logAction: logAction}
+}
+
+// Connect handles GET /api/terminal/ws (WebSocket)
+func (h *TerminalHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	if err := h.manager.CheckRequest(r); err != nil {
+		if errors.Is(err, terminal.ErrTooManySessions) {
+			httputil.JSONError(w, http.StatusServiceUnavailable, "Too many terminal sessions", "Close another session first")
+			return
+		}
+		httputil.JSONError(w, http.StatusBadRequest, "WebSocket handshake required", err.Error())
+		return
+	}
+
+	// Logging out or revoking the login session closes the terminal too
+	var authID string
+	if sess := auth.FromContext(r.Context()); sess != nil {
+		authID = sess.ID
+	}
+	if err := h.manager.Serve(w, r, auth.Username(r), authID); err != nil {
+		if errors.Is(err, terminal.ErrTooManySessions) {
+			httputil.JSONError(w, http.StatusServiceUnavailable, "Too many terminal sessions", "Close another session first")
+			return
+		}
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to start terminal", err.Error())
+	}
+}
+
+// ListSessions handles GET /api/terminal/sessions
+func (h *TerminalHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	httputil.JSONOK(w, h.manager.List())
+}
+
+// CloseSession handles POST /api/terminal/close
+func (h *TerminalHandler) CloseSession(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+
+	var req types.TerminalCloseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.manager.Close(req.ID); err != nil {
+		httputil.JSONError(w, http.StatusNotFound, "Failed to close session", err.Error())
+		return
+	}
+
+	h.logAction(r, "Terminal: close", req.ID, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// ListRecordings handles GET /api/terminal/recordings
+func (h *TerminalHandler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	recordings, err := h.manager.ListRecordings()
+	if err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to list recordings", err.Error())
+		return
+	}
+	httputil.JSONOK(w, recordings)
+}
+
+// DownloadRecording handles GET /api/terminal/recordings/download?id=...
+// and returns the asciicast file
+func (h *TerminalHandler) DownloadRecording(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+
+	path, err := h.manager.RecordingPath(r.URL.Query().Get("id"))
+	if err != nil {
+		httputil.JSONError(w, http.StatusNotFound, "Recording not found", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(path)+"\"")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/httputil"
+	"nm-webui/internal/tlscert"
+	"nm-webui/internal/types"
+)
+
+// TLSHandler shows and replaces the web UI's HTTPS certificate and
+// issues client certificates
+type TLSHandler struct {
+	certs     *tlscert.Manager // nil when serving plain HTTP
+	users     *auth.UserStore  // nil when authentication is disabled
+	logAction LogFunc
+}
+
+// NewTLSHandler creates a new TLS handler
+func NewTLSHandler(certs *tlscert.Manager, users *auth.UserStore, logAction LogFunc) *TLSHandler {
+	return &TLSHandler{
+		certs:     certs,
+		users:     users,
+		logAction: logAction,
+	}
+}
+
+// Info handles GET /api/tls
+func (h *TLSHandler) Info(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONOK(w, types.TLSInfo{})
+		return
+	}
+	httputil.JSONOK(w, h.certs.Info())
+}
+
+// DownloadCA handles GET /api/tls/ca. The device CA is public, so browsers
+// can fetch it to trust the device before logging in.
+func (h *TLSHandler) DownloadCA(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONError(w, http.StatusNotFound, "HTTPS is not enabled", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"haxinator-ca.crt\"")
+	w.Write(h.certs.CACertPEM())
+}
+
+// Upload handles POST /api/tls/upload with PEM "certfile" and "keyfile"
+func (h *TLSHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "HTTPS is not enabled", "Start nm-webui with -tls")
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid form data", err.Error())
+		return
+	}
+	certPEM, err := formFile(r, "certfile")
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Certificate file required", err.Error())
+		return
+	}
+	keyPEM, err := formFile(r, "keyfile")
+	if err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Key file required", err.Error())
+		return
+	}
+
+	info, err := h.certs.Upload(certPEM, keyPEM)
+	if err != nil {
+		h.logAction(r, "TLS: upload", err.Error(), false)
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to install certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "TLS: upload", info.Subject+" "+info.Fingerprint, true)
+	httputil.JSONOK(w, info)
+}
+
+// Reset handles POST /api/tls/reset, going back to the generated
+// certificate
+func (h *TLSHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "HTTPS is not enabled", "Start nm-webui with -tls")
+		return
+	}
+
+	info, err := h.certs.Reset()
+	if err != nil {
+		h.logAction(r, "TLS: reset", err.Error(), false)
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to reset certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "TLS: reset", info.Fingerprint, true)
+	httputil.JSONOK(w, info)
+}
+
+// DownloadCRL handles GET /api/tls/crl, the DER revocation list of client
+// certificates
+func (h *TLSHandler) DownloadCRL(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONError(w, http.StatusNotFound, "HTTPS is not enabled", "")
+		return
+	}
+
+	crl, err := h.certs.CRL()
+	if err != nil {
+		httputil.JSONError(w, http.StatusInternalServerError, "Failed to create CRL", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"haxinator.crl\"")
+	w.Write(crl)
+}
+
+// ListClients handles GET /api/tls/clients
+func (h *TLSHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequireGET(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONOK(w, []types.ClientCert{})
+		return
+	}
+	httputil.JSONOK(w, h.certs.ClientCerts(""))
+}
+
+// IssueClient handles POST /api/tls/clients/issue and responds with the
+// PKCS#12 bundle, which is not kept
+func (h *TLSHandler) IssueClient(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.certs == nil || h.users == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "HTTPS and authentication must be enabled", "Start nm-webui with -tls")
+		return
+	}
+
+	var req types.ClientCertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	if _, ok := h.users.Get(req.User); !ok {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to issue certificate", "no such user")
+		return
+	}
+	if req.Days == 0 {
+		req.Days = 365
+	}
+
+	validity := time.Duration(req.Days) * 24 * time.Hour
+	cert, p12, err := h.certs.IssueClient(req.User, auth.Username(r), validity, req.Password, req.Legacy)
+	if err != nil {
+		h.logAction(r, "TLS: issue_client", req.User+": "+err.Error(), false)
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to issue certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "TLS: issue_client", req.User+" serial "+cert.Serial+" until "+cert.Expires, true)
+	short := cert.Serial
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	w.Header().Set("Content-Type", "application/x-pkcs12")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+req.User+"-"+short+".p12\"")
+	w.Header().Set("Content-Length", strconv.Itoa(len(p12)))
+	w.Write(p12)
+}
+
+// RevokeClient handles POST /api/tls/clients/revoke
+func (h *TLSHandler) RevokeClient(w http.ResponseWriter, r *http.Request) {
+	if !httputil.RequirePOST(w, r) {
+		return
+	}
+	if h.certs == nil {
+		httputil.JSONError(w, http.StatusBadRequest, "HTTPS is not enabled", "Start nm-webui with -tls")
+		return
+	}
+
+	var req types.ClientCertRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	if err := h.certs.RevokeClient(req.Serial); err != nil {
+		httputil.JSONError(w, http.StatusBadRequest, "Failed to revoke certificate", err.Error())
+		return
+	}
+
+	h.logAction(r, "TLS: revoke_client", req.Serial, true)
+	httputil.JSONOK(w, map[string]bool{"success": true})
+}
+
+// formFile reads a small uploaded file
+func formFile(r *http.Request, field string) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, 64<<10))
+}
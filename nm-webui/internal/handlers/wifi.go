@@ -12,7 +12,7 @@ import (
 )
 
 // LogFunc is a function signature for logging actions
-type LogFunc func(action, detail string, success bool)
+type LogFunc func(r *http.Request, action, detail string, success bool)
 
 // WifiHandler handles WiFi-related API endpoints
 type WifiHandler struct {
@@ -34,7 +34,7 @@ func (h *WifiHandler) Scan(w http.ResponseWriter, r *http.Request) {
 	dev := r.URL.Query().Get("dev")
 	rescan := r.URL.Query().Get("rescan") != "no"
 
-	result, err := h.nmcli.WifiScan(dev, rescan)
+	result, err := h.nmcli.WithContext(r.Context()).WifiScan(dev, rescan)
 	if err != nil {
 		httputil.JSONError(w, http.StatusInternalServerError, "Failed to scan WiFi", err.Error())
 		return
@@ -69,8 +69,8 @@ func (h *WifiHandler) Connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.WifiConnect(req.Dev, req.SSID, req.Password, req.Hidden)
-	h.addLog("wifi_connect", fmt.Sprintf("SSID: %s, Device: %s", req.SSID, req.Dev), result.Success)
+	result := h.nmcli.WithContext(r.Context()).WifiConnect(req.Dev, req.SSID, req.Password, req.Hidden)
+	h.addLog(r, "wifi_connect", fmt.Sprintf("SSID: %s, Device: %s", req.SSID, req.Dev), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -87,8 +87,8 @@ func (h *WifiHandler) Disconnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.WifiDisconnect(req.SSID, req.IsHotspot)
-	h.addLog("wifi_disconnect", fmt.Sprintf("SSID: %s", req.SSID), result.Success)
+	result := h.nmcli.WithContext(r.Context()).WifiDisconnect(req.SSID, req.IsHotspot)
+	h.addLog(r, "wifi_disconnect", fmt.Sprintf("SSID: %s", req.SSID), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -105,8 +105,8 @@ func (h *WifiHandler) Forget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.WifiForget(req.SSID, req.IsHotspot)
-	h.addLog("wifi_forget", fmt.Sprintf("SSID: %s", req.SSID), result.Success)
+	result := h.nmcli.WithContext(r.Context()).WifiForget(req.SSID, req.IsHotspot)
+	h.addLog(r, "wifi_forget", fmt.Sprintf("SSID: %s", req.SSID), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -128,8 +128,8 @@ func (h *WifiHandler) SetPriority(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := h.nmcli.SetPriority(req.UUID, req.Priority)
-	h.addLog("set_priority", fmt.Sprintf("UUID: %s, Priority: %d", req.UUID, req.Priority), result.Success)
+	result := h.nmcli.WithContext(r.Context()).SetPriority(req.UUID, req.Priority)
+	h.addLog(r, "set_priority", fmt.Sprintf("UUID: %s, Priority: %d", req.UUID, req.Priority), result.Success)
 
 	httputil.JSONOK(w, result)
 }
@@ -149,11 +149,11 @@ func (h *WifiHandler) Hotspot(w http.ResponseWriter, r *http.Request) {
 	var result types.ActionResult
 
 	if req.Mode == "stop" {
-		result = h.nmcli.HotspotStop(req.Dev)
-		h.addLog("hotspot_stop", fmt.Sprintf("Device: %s", req.Dev), result.Success)
+		result = h.nmcli.WithContext(r.Context()).HotspotStop(req.Dev)
+		h.addLog(r, "hotspot_stop", fmt.Sprintf("Device: %s", req.Dev), result.Success)
 	} else {
-		result = h.nmcli.HotspotStart(req.Dev, req.SSID, req.Password, req.Band, req.Channel, req.ConName, req.IPRange, req.Persistent)
-		h.addLog("hotspot_start", fmt.Sprintf("SSID: %s, Device: %s", req.SSID, req.Dev), result.Success)
+		result = h.nmcli.WithContext(r.Context()).HotspotStart(req.Dev, req.SSID, req.Password, req.Band, req.Channel, req.ConName, req.IPRange, req.Persistent)
+		h.addLog(r, "hotspot_start", fmt.Sprintf("SSID: %s, Device: %s", req.SSID, req.Dev), result.Success)
 	}
 
 	httputil.JSONOK(w, result)
@@ -0,0 +1,249 @@
+// Package listen serves the web UI on several addresses, interfaces and
+// Unix sockets and records where each connection came from
+package listen
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UnixInterface is the interface name of Unix socket connections, for
+// access policies
+const UnixInterface = "unix"
+
+// Spec is one -listen entry: an address such as 127.0.0.1:8080, an
+// interface name and port such as usb0:8080, a Unix socket such as
+// unix:/run/nm-webui/api.sock, or systemd for sockets passed by socket
+// activation (systemd:name for those with FileDescriptorName=name)
+type Spec struct {
+	Addr      string // host:port of an address listener
+	Interface string // interface of an interface listener
+	Port      string
+	Unix      string // path of a Unix socket
+	Systemd   bool
+	Name      string // systemd socket name; empty takes them all
+}
+
+// Parse parses -listen entries. A host that is an IP address, empty or
+// "localhost" is an address; anything else names an interface.
+func Parse(entries []string) ([]Spec, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no listen address")
+	}
+	var specs []Spec
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		spec, err := parseSpec(entry)
+		if err != nil {
+			return nil, err
+		}
+		if seen[spec.String()] {
+			return nil, fmt.Errorf("%q is listed twice", entry)
+		}
+		seen[spec.String()] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// parseSpec parses one -listen entry
+func parseSpec(entry string) (Spec, error) {
+	if path, ok := strings.CutPrefix(entry, "unix:"); ok {
+		if !filepath.IsAbs(path) {
+			return Spec{}, fmt.Errorf("%q: socket path must be absolute", entry)
+		}
+		return Spec{Unix: filepath.Clean(path)}, nil
+	}
+	if entry == "systemd" {
+		return Spec{Systemd: true}, nil
+	}
+	if name, ok := strings.CutPrefix(entry, "systemd:"); ok {
+		return Spec{Systemd: true, Name: name}, nil
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		return Spec{}, fmt.Errorf("%q: %w", entry, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return Spec{}, fmt.Errorf("%q: invalid port", entry)
+	}
+
+	spec := Spec{Port: port}
+	if host == "" || host == "localhost" || net.ParseIP(host) != nil {
+		spec.Addr = entry
+	} else if len(host) > 15 || strings.ContainsAny(host, "/% ") {
+		return Spec{}, fmt.Errorf("%q: invalid interface name", entry)
+	} else {
+		spec.Interface = host
+	}
+	return spec, nil
+}
+
+func (s Spec) String() string {
+	switch {
+	case s.Interface != "":
+		return net.JoinHostPort(s.Interface, s.Port)
+	case s.Unix != "":
+		return "unix:" + s.Unix
+	case s.Systemd && s.Name != "":
+		return "systemd:" + s.Name
+	case s.Systemd:
+		return "systemd"
+	}
+	return s.Addr
+}
+
+// AnyInterface reports whether s accepts connections arriving on any
+// interface: an address listener on anything but loopback, or TCP sockets
+// from systemd that are neither on loopback nor pinned with BindToDevice=.
+// A client can then pick the interface it is attributed to by the address
+// it connects to.
+func (s Spec) AnyInterface() bool {
+	if s.Systemd {
+		return len(activatedAnyInterface(s.Name)) > 0
+	}
+	if s.Addr == "" {
+		return false
+	}
+	host, _, _ := net.SplitHostPort(s.Addr)
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// LocalAddr returns a TCP address at which the web UI is reachable from
+// this host: the first address listener or TCP socket from systemd, with
+// wildcards made loopback, or the loopback address on an interface
+// listener's port
+func LocalAddr(specs []Spec) string {
+	for _, s := range specs {
+		addr := s.Addr
+		if s.Systemd {
+			addr = activatedTCPAddr(s.Name)
+		}
+		if addr == "" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, port)
+	}
+	for _, s := range specs {
+		if s.Port != "" {
+			return net.JoinHostPort("127.0.0.1", s.Port)
+		}
+	}
+	return ""
+}
+
+// Peer is the local process at the other end of a Unix socket, as the
+// kernel reports it
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+type ctxKey struct{}
+
+// listener tags accepted connections with their interface. Interface
+// listeners know it; address listeners look up the interface holding the
+// connection's local address. Unix socket connections also carry their
+// peer's credentials.
+type listener struct {
+	net.Listener
+	iface string
+}
+
+type conn struct {
+	net.Conn
+	iface string
+	peer  *Peer // nil except on Unix sockets
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := c.(*net.UnixConn); ok {
+		// Without credentials the client still has to log in
+		peer, _ := peerCredentials(uc)
+		return &conn{Conn: c, iface: UnixInterface, peer: peer}, nil
+	}
+	iface := l.iface
+	if iface == "" {
+		iface = interfaceOf(c.LocalAddr())
+	}
+	return &conn{Conn: c, iface: iface}, nil
+}
+
+// ConnContext is an http.Server ConnContext that records the connection's
+// interface and peer for Interface and PeerOf
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if ic, ok := c.(*conn); ok {
+		return context.WithValue(ctx, ctxKey{}, ic)
+	}
+	return ctx
+}
+
+// Interface returns the interface a request arrived on, UnixInterface for
+// Unix sockets, or "" if unknown
+func Interface(r *http.Request) string {
+	if c, ok := r.Context().Value(ctxKey{}).(*conn); ok {
+		return c.iface
+	}
+	return ""
+}
+
+// PeerOf returns the credentials of a Unix socket client
+func PeerOf(r *http.Request) (*Peer, bool) {
+	c, ok := r.Context().Value(ctxKey{}).(*conn)
+	if !ok || c.peer == nil {
+		return nil, false
+	}
+	return c.peer, true
+}
+
+// interfaceOf returns the interface holding a local address. Linux accepts
+// packets for any local address on any interface, so for address listeners
+// this is where the client aimed, not necessarily where it came in.
+func interfaceOf(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	if tcp.IP.IsLoopback() {
+		return "lo"
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(tcp.IP) {
+				return ifi.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package listen
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    Spec
+		wantErr bool
+	}{
+		{"127.0.0.1:8080", Spec{Addr: "127.0.0.1:8080", Port: "8080"}, false},
+		{":8080", Spec{Addr: ":8080", Port: "8080"}, false},
+		{"localhost:80", Spec{Addr: "localhost:80", Port: "80"}, false},
+		{"[::1]:8443", Spec{Addr: "[::1]:8443", Port: "8443"}, false},
+		{"usb0:8080", Spec{Interface: "usb0", Port: "8080"}, false},
+		{"wlan0.100:443", Spec{Interface: "wlan0.100", Port: "443"}, false},
+		{"unix:/run/nm-webui/api.sock", Spec{Unix: "/run/nm-webui/api.sock"}, false},
+		{"unix:/run//nm-webui/../api.sock", Spec{Unix: "/run/api.sock"}, false},
+		{"systemd", Spec{Systemd: true}, false},
+		{"systemd:web", Spec{Systemd: true, Name: "web"}, false},
+
+		{"unix:api.sock", Spec{}, true},
+		{"8080", Spec{}, true},
+		{"usb0:http", Spec{}, true},
+		{"usb0:0", Spec{}, true},
+		{"usb0:65536", Spec{}, true},
+		{"averyveryverylongname0:80", Spec{}, true},
+		{"eth0%1:80", Spec{}, true},
+		{"eth/0:80", Spec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			specs, err := Parse([]string{tt.entry})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", specs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if specs[0] != tt.want {
+				t.Errorf("got %+v, want %+v", specs[0], tt.want)
+			}
+			// String gives back an entry that parses the same
+			again, err := Parse([]string{specs[0].String()})
+			if err != nil || again[0] != specs[0] {
+				t.Errorf("%q parses as %+v, %v", specs[0].String(), again, err)
+			}
+		})
+	}
+
+	if _, err := Parse(nil); err == nil {
+		t.Error("accepted no listen address")
+	}
+	if _, err := Parse([]string{"usb0:8080", "usb0:8080"}); err == nil {
+		t.Error("accepted a repeated entry")
+	}
+	if _, err := Parse([]string{"unix:/run/a.sock", "unix:/run/./a.sock"}); err == nil {
+		t.Error("accepted the same socket twice")
+	}
+}
+
+func TestAnyInterface(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  bool
+	}{
+		{":8080", true},
+		{"0.0.0.0:8080", true},
+		{"[::]:8080", true},
+		{"192.168.8.1:8080", true},
+		{"127.0.0.1:8080", false},
+		{"[::1]:8080", false},
+		{"localhost:8080", false},
+		{"usb0:8080", false},
+		{"unix:/run/nm-webui/api.sock", false},
+	}
+	for _, tt := range tests {
+		specs, err := Parse([]string{tt.entry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := specs[0].AnyInterface(); got != tt.want {
+			t.Errorf("AnyInterface(%s) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	tests := []struct {
+		entries []string
+		want    string
+	}{
+		{[]string{"0.0.0.0:8080"}, "127.0.0.1:8080"},
+		{[]string{":80"}, "127.0.0.1:80"},
+		{[]string{"192.168.8.1:8080"}, "192.168.8.1:8080"},
+		{[]string{"usb0:8443", "127.0.0.1:9000"}, "127.0.0.1:9000"},
+		{[]string{"unix:/run/a.sock", "usb0:8443"}, "127.0.0.1:8443"},
+		{[]string{"unix:/run/a.sock"}, ""},
+	}
+	for _, tt := range tests {
+		specs, err := Parse(tt.entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := LocalAddr(specs); got != tt.want {
+			t.Errorf("LocalAddr(%v) = %q, want %q", tt.entries, got, tt.want)
+		}
+	}
+}
+
+func TestConnInterface(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{Listener: ln}
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Address listeners look the interface up from the local address
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(ConnContext(context.Background(), c))
+	if got := Interface(r); got != "lo" {
+		t.Errorf("Interface = %q, want lo", got)
+	}
+	if _, ok := PeerOf(r); ok {
+		t.Error("TCP connection has peer credentials")
+	}
+	if got := Interface(httptest.NewRequest("GET", "/", nil)); got != "" {
+		t.Errorf("Interface of an untagged request = %q", got)
+	}
+}
+
+func TestSystemdAnyInterface(t *testing.T) {
+	loopback, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loopback.Close()
+	wildcard, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wildcard.Close()
+	pinned, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pinned.Close()
+
+	// Without LISTEN_PID nothing is picked up, so the sockets can be set
+	// by hand
+	loadActivated()
+	activationMu.Lock()
+	activated = []activatedSocket{
+		{name: "local", ln: loopback},
+		{name: "web", ln: wildcard},
+		{name: "usb0", ln: pinned, device: "usb0"},
+	}
+	activationMu.Unlock()
+	defer func() {
+		activationMu.Lock()
+		activated = nil
+		activationMu.Unlock()
+	}()
+
+	tests := []struct {
+		entry string
+		want  bool
+	}{
+		{"systemd", true},
+		{"systemd:web", true},
+		{"systemd:local", false},
+		{"systemd:usb0", false},
+		{"systemd:other", false},
+	}
+	for _, tt := range tests {
+		specs, err := Parse([]string{tt.entry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := specs[0].AnyInterface(); got != tt.want {
+			t.Errorf("AnyInterface(%s) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+
+	// Pinned sockets are attributed to their interface
+	sockets, err := takeActivated("usb0")
+	if err != nil || len(sockets) != 1 || sockets[0].device != "usb0" {
+		t.Errorf("takeActivated(usb0) = %+v, %v", sockets, err)
+	}
+}
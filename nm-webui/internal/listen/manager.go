@@ -0,0 +1,308 @@
+package listen
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/netutil"
+)
+
+// pollInterval is how often interfaces are checked for changes
+const pollInterval = 5 * time.Second
+
+// Manager serves an http.Server on a set of listen specs. Interface
+// listeners are bound to their device, so they only accept connections
+// that arrive on it whatever its addresses are, and they are opened and
+// re-bound as the interface comes and goes. Unix sockets are always plain
+// HTTP.
+type Manager struct {
+	specs     []Spec
+	logger    *logger.Logger
+	tls       bool
+	sockMode  os.FileMode
+	sockGroup int // -1 keeps the process's group
+
+	mu     sync.Mutex
+	active map[string]*bound // interface listeners by spec
+	failed map[string]string // last bind error by spec, to log it once
+	done   chan struct{}
+	errc   chan error
+}
+
+// bound is an open interface listener
+type bound struct {
+	ln    net.Listener
+	index int    // interface index the socket is bound to
+	addrs string // the interface's addresses when last checked
+}
+
+// NewManager creates a listener manager
+func NewManager(specs []Spec, log *logger.Logger) *Manager {
+	return &Manager{
+		specs:     specs,
+		logger:    log,
+		sockMode:  0660,
+		sockGroup: -1,
+		active:    make(map[string]*bound),
+		failed:    make(map[string]string),
+		done:      make(chan struct{}),
+		errc:      make(chan error, 1),
+	}
+}
+
+// SetSocketPermissions sets the mode and, if group is not empty, the group
+// of Unix sockets the manager creates. Call it before Serve.
+func (m *Manager) SetSocketPermissions(mode os.FileMode, group string) error {
+	m.sockMode = mode
+	if group == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	m.sockGroup = gid
+	return nil
+}
+
+// Serve serves srv, over TLS if it has a TLSConfig, until it is shut down.
+// Address, Unix and systemd listeners must open; interface listeners wait
+// for their interface. It returns http.ErrServerClosed after Shutdown, or the first
+// error from an address listener.
+func (m *Manager) Serve(srv *http.Server) error {
+	// Serving configures HTTP/2, which gives srv a TLSConfig. A plain
+	// listener can set HTTP/2 up first, which only enables it for TLS if
+	// the config already offers h2.
+	m.tls = srv.TLSConfig != nil
+	if m.tls && len(srv.TLSConfig.NextProtos) == 0 {
+		srv.TLSConfig = srv.TLSConfig.Clone()
+		srv.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	srv.ConnContext = ConnContext
+	var once sync.Once
+	srv.RegisterOnShutdown(func() { once.Do(func() { close(m.done) }) })
+
+	for _, spec := range m.specs {
+		var lns []*listener
+		var err error
+		switch {
+		case spec.Addr != "":
+			var ln net.Listener
+			ln, err = net.Listen("tcp", spec.Addr)
+			lns = []*listener{{Listener: ln}}
+		case spec.Unix != "":
+			var ln net.Listener
+			ln, err = m.listenUnix(spec.Unix)
+			lns = []*listener{{Listener: ln}}
+		case spec.Systemd:
+			// A socket pinned with BindToDevice= only accepts
+			// connections arriving on that interface
+			var sockets []activatedSocket
+			sockets, err = takeActivated(spec.Name)
+			for _, s := range sockets {
+				lns = append(lns, &listener{Listener: s.ln, iface: s.device})
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", spec, err)
+		}
+		for _, ln := range lns {
+			if spec.Systemd {
+				if ln.iface != "" {
+					log.Printf("Using %s socket %s on %s", spec, ln.Addr(), ln.iface)
+				} else {
+					log.Printf("Using %s socket %s", spec, ln.Addr())
+				}
+			}
+			m.serve(srv, spec, ln)
+		}
+	}
+	m.sync(srv)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return http.ErrServerClosed
+		case err := <-m.errc:
+			return err
+		case <-ticker.C:
+			m.sync(srv)
+		}
+	}
+}
+
+// listenUnix creates a Unix socket, replacing a stale one left by a
+// previous run. It is removed again when the listener closes.
+func (m *Manager) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, m.sockMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if m.sockGroup >= 0 {
+		if err := os.Chown(path, -1, m.sockGroup); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// serve runs srv on one listener
+func (m *Manager) serve(srv *http.Server, spec Spec, ln net.Listener) {
+	go func() {
+		var err error
+		if m.tls && ln.Addr().Network() == "tcp" {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err == http.ErrServerClosed {
+			return
+		}
+		if spec.Interface == "" {
+			select {
+			case m.errc <- err:
+			default:
+			}
+			return
+		}
+
+		// An interface listener closed by sync is expected; anything else
+		// drops it so the next sync binds again
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if b, ok := m.active[spec.String()]; ok && b.ln == ln {
+			delete(m.active, spec.String())
+			m.logger.Warn("network", "unlisten").
+				WithExtra("listen", spec.String()).
+				WithError(err).
+				Commit()
+		}
+	}()
+}
+
+// sync binds interface listeners whose interface appeared or was
+// re-created, closes those whose interface went away, and logs address
+// changes
+func (m *Manager) sync(srv *http.Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+
+	for _, spec := range m.specs {
+		if spec.Interface == "" {
+			continue
+		}
+		key := spec.String()
+		b := m.active[key]
+		ifi, err := net.InterfaceByName(spec.Interface)
+
+		if b != nil && (err != nil || ifi.Index != b.index) {
+			b.ln.Close()
+			delete(m.active, key)
+			b = nil
+			log.Printf("Stopped listening on %s", key)
+			m.logger.Info("network", "unlisten").
+				WithExtra("listen", key).
+				Commit()
+		}
+		if err != nil {
+			continue
+		}
+
+		addrs := interfaceAddrs(ifi)
+		if b != nil {
+			if addrs != b.addrs {
+				b.addrs = addrs
+				m.logger.Info("network", "listen_addrs").
+					WithExtra("listen", key).
+					WithExtra("addrs", addrs).
+					Commit()
+			}
+			continue
+		}
+
+		lc := net.ListenConfig{Control: netutil.BindToDevice(spec.Interface)}
+		ln, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort("", spec.Port))
+		if err != nil {
+			if m.failed[key] != err.Error() {
+				m.failed[key] = err.Error()
+				log.Printf("Failed to listen on %s: %v", key, err)
+				m.logger.Error("network", "listen").
+					WithExtra("listen", key).
+					WithError(err).
+					Commit()
+			}
+			continue
+		}
+		delete(m.failed, key)
+		m.active[key] = &bound{ln: ln, index: ifi.Index, addrs: addrs}
+		m.serve(srv, spec, &listener{Listener: ln, iface: spec.Interface})
+
+		if addrs == "" {
+			addrs = "no addresses yet"
+		}
+		log.Printf("Listening on %s (%s)", key, addrs)
+		m.logger.Info("network", "listen").
+			WithExtra("listen", key).
+			WithExtra("addrs", addrs).
+			Commit()
+	}
+}
+
+// interfaceAddrs lists an interface's addresses
+func interfaceAddrs(ifi *net.Interface) string {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return ""
+	}
+	var list []string
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			list = append(list, ipnet.IP.String())
+		}
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,133 @@
+package listen
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nm-webui/internal/netutil"
+)
+
+// listenFDsStart is the first file descriptor systemd passes
+const listenFDsStart = 3
+
+// activatedSocket is a listening socket passed by systemd
+type activatedSocket struct {
+	name   string // FileDescriptorName= of the socket unit
+	ln     net.Listener
+	device string // BindToDevice= of the socket unit
+}
+
+var (
+	activationOnce sync.Once
+	activationMu   sync.Mutex
+	activated      []activatedSocket
+	activationErr  error
+)
+
+// loadActivated picks up the sockets passed by systemd socket activation
+// (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES), once per process
+func loadActivated() {
+	activationOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+		if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n < 0 {
+			activationErr = fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+		for i := 0; i < n; i++ {
+			fd := listenFDsStart + i
+			closeOnExec(fd)
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(fd), name)
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				activationErr = fmt.Errorf("socket %d from systemd: %w", fd, err)
+				continue
+			}
+			s := activatedSocket{name: name, ln: ln}
+			if tl, ok := ln.(*net.TCPListener); ok {
+				if raw, err := tl.SyscallConn(); err == nil {
+					s.device, _ = netutil.BoundDevice(raw)
+				}
+			}
+			activated = append(activated, s)
+		}
+	})
+}
+
+// takeActivated removes and returns the sockets from systemd with a name,
+// or all of them for ""
+func takeActivated(name string) ([]activatedSocket, error) {
+	loadActivated()
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	var taken []activatedSocket
+	rest := activated[:0]
+	for _, s := range activated {
+		if name == "" || s.name == name {
+			taken = append(taken, s)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	activated = rest
+	if len(taken) == 0 {
+		if activationErr != nil {
+			return nil, activationErr
+		}
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	return taken, nil
+}
+
+// activatedTCPAddr returns the address of the first TCP socket from
+// systemd with a name, or any for ""
+func activatedTCPAddr(name string) string {
+	loadActivated()
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	for _, s := range activated {
+		if addr, ok := s.ln.Addr().(*net.TCPAddr); ok && (name == "" || s.name == name) {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// activatedAnyInterface returns the addresses of TCP sockets from systemd
+// with a name, or any for "", that accept connections on any interface:
+// those not on loopback and not pinned to a device with BindToDevice=
+func activatedAnyInterface(name string) []string {
+	loadActivated()
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	var addrs []string
+	for _, s := range activated {
+		addr, ok := s.ln.Addr().(*net.TCPAddr)
+		if !ok || (name != "" && s.name != name) || s.device != "" || addr.IP.IsLoopback() {
+			continue
+		}
+		addrs = append(addrs, addr.String())
+	}
+	return addrs
+}
@@ -0,0 +1,32 @@
+package listen
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the process at the other end of a Unix socket
+func peerCredentials(c *net.UnixConn) (*Peer, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &Peer{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
+
+// closeOnExec keeps an inherited socket from leaking into the processes
+// nm-webui starts, such as terminal shells
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package listen
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials is only supported on Linux
+func peerCredentials(c *net.UnixConn) (*Peer, error) {
+	return nil, fmt.Errorf("peer credentials are not supported on this platform")
+}
+
+// closeOnExec is only needed for systemd socket activation on Linux
+func closeOnExec(fd int) {}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"context"
+	"sync"
+)
+
+type requestKey struct{}
+
+// request identifies the API request a context belongs to
+type request struct {
+	id string
+
+	mu    sync.Mutex
+	actor string
+}
+
+// Actor returns the user making the request, or "" until it is known
+func (r *request) Actor() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.actor
+}
+
+// WithRequest returns a context for the API request with the given ID.
+// Entries built WithContext of it, or of contexts derived from it, carry
+// the ID and the request's user.
+func WithRequest(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{id: id})
+}
+
+// SetActor names the user making ctx's request, once they are known
+func SetActor(ctx context.Context, actor string) {
+	if req := requestOf(ctx); req != nil {
+		req.mu.Lock()
+		req.actor = actor
+		req.mu.Unlock()
+	}
+}
+
+// RequestID returns the ID of ctx's request, or ""
+func RequestID(ctx context.Context) string {
+	if req := requestOf(ctx); req != nil {
+		return req.id
+	}
+	return ""
+}
+
+func requestOf(ctx context.Context) *request {
+	if ctx == nil {
+		return nil
+	}
+	req, _ := ctx.Value(requestKey{}).(*request)
+	return req
+}
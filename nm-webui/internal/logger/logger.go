@@ -2,7 +2,12 @@
 package logger
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -33,12 +38,18 @@ func (l Level) String() string {
 	}
 }
 
+// SystemActor is the actor of entries not caused by a web UI user
+const SystemActor = "system"
+
 // Entry represents a single log entry
 type Entry struct {
+	ID        uint64    `json:"id"` // increasing, and kept across restarts with a store
 	Time      time.Time `json:"time"`
 	Level     string    `json:"level"`
 	Category  string    `json:"category"`  // e.g., "nmcli", "ssh", "api", "system"
 	Action    string    `json:"action"`    // e.g., "execute", "connect", "scan"
+	Actor     string    `json:"actor"`     // Web UI user, or "system" for background work
+	RequestID string    `json:"request_id,omitempty"` // API request that caused the entry
 	Command   string    `json:"command,omitempty"`   // The actual command executed
 	Output    string    `json:"output,omitempty"`    // Command output (truncated if needed)
 	Error     string    `json:"error,omitempty"`     // Error message if any
@@ -56,15 +67,36 @@ type Settings struct {
 	LogOutput    bool `json:"log_output"`    // Log command output
 	MaxOutputLen int  `json:"max_output_len"` // Max output length to store
 	MinLevel     Level `json:"min_level"`
+	Syslog       SyslogSettings `json:"syslog"`
+	Redact       []string       `json:"redact"` // extra regexps; the first group, or the match, is hidden
+}
+
+// Validate checks settings that can be wrong
+func (s Settings) Validate() error {
+	if _, err := newRedactor(s.Redact, nil); err != nil {
+		return err
+	}
+	if err := s.Syslog.Validate(); err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	return nil
 }
 
 // Logger is the main logging service
 type Logger struct {
-	mu       sync.RWMutex
-	entries  []Entry
-	settings Settings
+	mu        sync.RWMutex
+	entries   []Entry
+	settings  Settings
+	seq       uint64 // ID of the last entry
+	store     *Store // nil keeps entries in memory only
+	path      string // settings file, if any
+	syslog    *forwarder // started when forwarding is first enabled
+	syslogTLS syslogTLS
+	redact    *redactor
+	secrets   []string // values hidden wherever they appear
+	commands  commandMetrics
 	
-	// Callbacks for real-time log streaming (future SSE support)
+	// Channels of live entries for /api/logs/stream
 	subscribers []chan Entry
 	subMu       sync.RWMutex
 }
@@ -83,9 +115,14 @@ func DefaultSettings() Settings {
 
 // New creates a new Logger instance
 func New(settings Settings) *Logger {
+	redact, err := newRedactor(settings.Redact, nil)
+	if err != nil {
+		redact, _ = newRedactor(nil, nil)
+	}
 	return &Logger{
 		entries:     make([]Entry, 0, settings.MaxEntries),
 		settings:    settings,
+		redact:      redact,
 		subscribers: make([]chan Entry, 0),
 	}
 }
@@ -95,6 +132,45 @@ func NewDefault() *Logger {
 	return New(DefaultSettings())
 }
 
+// Persist keeps entries on disk as well, so they survive restarts and
+// outlive the memory buffer. IDs continue from the newest stored entry.
+func (l *Logger) Persist(cfg StoreConfig) error {
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.store = store
+	l.seq = store.LastID()
+	// Entries logged before now are renumbered after the stored ones
+	for i := range l.entries {
+		l.seq++
+		l.entries[i].ID = l.seq
+		store.add(l.entries[i])
+	}
+	return nil
+}
+
+// Close writes entries still buffered for the store to disk
+func (l *Logger) Close() error {
+	l.mu.RLock()
+	store := l.store
+	l.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+	return store.Close()
+}
+
+// LastID returns the ID of the newest entry
+func (l *Logger) LastID() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.seq
+}
+
 // Settings returns current logger settings
 func (l *Logger) Settings() Settings {
 	l.mu.RLock()
@@ -103,10 +179,11 @@ func (l *Logger) Settings() Settings {
 }
 
 // SetEnabled enables or disables logging
-func (l *Logger) SetEnabled(enabled bool) {
+func (l *Logger) SetEnabled(enabled bool) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.settings.Enabled = enabled
+	return l.saveSettings()
 }
 
 // IsEnabled returns whether logging is enabled
@@ -116,11 +193,83 @@ func (l *Logger) IsEnabled() bool {
 	return l.settings.Enabled
 }
 
-// UpdateSettings updates logger settings
-func (l *Logger) UpdateSettings(s Settings) {
+// UpdateSettings updates logger settings, saving them if there is a
+// settings file
+func (l *Logger) UpdateSettings(s Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.settings = s
+	l.redact, _ = newRedactor(s.Redact, l.secrets)
+	l.applySyslog()
+	return l.saveSettings()
+}
+
+// SetSecrets sets the values hidden wherever they appear in entries, such
+// as those in env-secrets. Numbers, yes/no and values shorter than four
+// characters are left alone.
+func (l *Logger) SetSecrets(values []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.secrets = append([]string(nil), values...)
+	// The patterns were checked when the settings were applied
+	l.redact, _ = newRedactor(l.settings.Redact, l.secrets)
+}
+
+// LoadSettings reads saved settings from path, if it exists, and saves
+// later changes there
+func (l *Logger) LoadSettings(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read log settings: %w", err)
+	}
+	s := l.settings
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to parse log settings: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("log settings: %w", err)
+	}
+	l.settings = s
+	l.redact, _ = newRedactor(s.Redact, l.secrets)
+	l.applySyslog()
+	return nil
+}
+
+// saveSettings writes the settings file; the caller holds mu
+func (l *Logger) saveSettings() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(l.path, data, 0600)
+}
+
+// applySyslog hands the syslog settings to the forwarder, starting it the
+// first time forwarding is enabled; the caller holds mu
+func (l *Logger) applySyslog() {
+	if l.syslog == nil {
+		if !l.settings.Syslog.Enabled {
+			return
+		}
+		l.syslog = newForwarder(l)
+	}
+	l.syslog.configure(l.settings.Syslog)
 }
 
 // Log adds a new log entry
@@ -132,6 +281,7 @@ func (l *Logger) Log(level Level, category, action string) *EntryBuilder {
 			Level:    level.String(),
 			Category: category,
 			Action:   action,
+			Actor:    SystemActor,
 			Success:  true,
 		},
 	}
@@ -161,7 +311,7 @@ func (l *Logger) Error(category, action string) *EntryBuilder {
 func (l *Logger) Command(category, cmd string, args []string) *EntryBuilder {
 	fullCmd := cmd
 	if len(args) > 0 {
-		fullCmd = cmd + " " + strings.Join(args, " ")
+		fullCmd = cmd + " " + strings.Join(redactArgs(args), " ")
 	}
 	
 	return l.Log(DEBUG, category, "execute").WithCommand(fullCmd)
@@ -183,6 +333,9 @@ func (l *Logger) addEntry(entry Entry) {
 		return
 	}
 	
+	// Hide secrets before anything is cut, stored or sent on
+	entry = l.redact.entry(entry)
+	
 	// Truncate output if needed
 	if !l.settings.LogOutput {
 		entry.Output = ""
@@ -190,22 +343,29 @@ func (l *Logger) addEntry(entry Entry) {
 		entry.Output = entry.Output[:l.settings.MaxOutputLen] + "\n... [truncated]"
 	}
 	
-	// Mask sensitive data in commands
-	if l.settings.LogCommands {
-		entry.Command = maskSensitive(entry.Command)
-	} else {
+	if !l.settings.LogCommands {
 		entry.Command = "[hidden]"
 	}
 	
 	// Add entry
+	l.seq++
+	entry.ID = l.seq
 	l.entries = append(l.entries, entry)
+	if l.store != nil {
+		l.store.add(entry)
+	}
 	
 	// Trim if over capacity
 	if len(l.entries) > l.settings.MaxEntries {
 		l.entries = l.entries[len(l.entries)-l.settings.MaxEntries:]
 	}
+	syslog := l.syslog
 	
 	l.mu.Unlock()
+
+	if syslog != nil {
+		syslog.enqueue(entry)
+	}
 	
 	// Notify subscribers (non-blocking)
 	l.subMu.RLock()
@@ -219,59 +379,104 @@ func (l *Logger) addEntry(entry Entry) {
 	l.subMu.RUnlock()
 }
 
-// GetEntries returns log entries with optional filtering
+// GetEntries returns log entries with optional filtering, newest first.
+// With a store, entries no longer in memory are read from disk; a filter's
+// Before continues from the last entry of a previous page, and After stops
+// at entries already seen.
 func (l *Logger) GetEntries(filter *Filter) []Entry {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	
 	if filter == nil {
-		// Return all (newest first)
-		result := make([]Entry, len(l.entries))
-		for i, e := range l.entries {
-			result[len(l.entries)-1-i] = e
-		}
-		return result
+		filter = &Filter{}
 	}
-	
+	before := filter.Before
+	if before == 0 {
+		before = math.MaxUint64
+	}
+
+	l.mu.RLock()
 	var result []Entry
 	for i := len(l.entries) - 1; i >= 0; i-- {
 		e := l.entries[i]
-		
-		// Apply filters
-		if filter.Category != "" && e.Category != filter.Category {
-			continue
-		}
-		if filter.Level != "" && e.Level != filter.Level {
-			continue
-		}
-		if filter.Success != nil && e.Success != *filter.Success {
-			continue
-		}
-		if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
-			continue
+		if e.ID <= filter.After {
+			l.mu.RUnlock()
+			return result
 		}
-		if filter.Search != "" && !containsIgnoreCase(e, filter.Search) {
+		if e.ID >= before || !filter.Matches(e) {
 			continue
 		}
-		
 		result = append(result, e)
-		
 		if filter.Limit > 0 && len(result) >= filter.Limit {
-			break
+			l.mu.RUnlock()
+			return result
 		}
 	}
-	
+	if len(l.entries) > 0 && l.entries[0].ID < before {
+		before = l.entries[0].ID
+	}
+	store, redact := l.store, l.redact
+	l.mu.RUnlock()
+
+	if store == nil {
+		return result
+	}
+	store.scan(before, func(e Entry) bool {
+		if e.ID <= filter.After || (!filter.Since.IsZero() && e.Time.Before(filter.Since)) {
+			return false // older files only hold older entries
+		}
+		// Stored entries may predate the secrets and rules now in force
+		e = redact.entry(e)
+		if filter.Matches(e) {
+			result = append(result, e)
+		}
+		return filter.Limit <= 0 || len(result) < filter.Limit
+	})
 	return result
 }
 
-// Clear removes all log entries
-func (l *Logger) Clear() {
+// Matches reports whether an entry passes the filter, ignoring Limit,
+// Before and After
+func (f *Filter) Matches(e Entry) bool {
+	if f.Category != "" && e.Category != f.Category {
+		return false
+	}
+	if f.Level != "" && e.Level != f.Level {
+		return false
+	}
+	if f.Success != nil && e.Success != *f.Success {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.RequestID != "" && e.RequestID != f.RequestID {
+		return false
+	}
+	if f.Search != "" && !containsIgnoreCase(e, f.Search) {
+		return false
+	}
+	return true
+}
+
+// Clear removes all log entries, including stored ones. IDs carry on so
+// that old cursors stay valid.
+func (l *Logger) Clear() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.entries = make([]Entry, 0, l.settings.MaxEntries)
+	if l.store != nil {
+		return l.store.Clear()
+	}
+	return nil
 }
 
-// Subscribe returns a channel for real-time log updates
+// Subscribe returns a channel for real-time log updates. Entries are
+// dropped rather than block logging when it is full; the gap shows in
+// their IDs.
 func (l *Logger) Subscribe() chan Entry {
 	ch := make(chan Entry, 100)
 	l.subMu.Lock()
@@ -294,21 +499,37 @@ func (l *Logger) Unsubscribe(ch chan Entry) {
 	}
 }
 
+// EndSubscriptions closes every subscriber's channel, for shutdown
+func (l *Logger) EndSubscriptions() {
+	l.subMu.Lock()
+	defer l.subMu.Unlock()
+	for _, ch := range l.subscribers {
+		close(ch)
+	}
+	l.subscribers = nil
+}
+
 // Filter defines filtering options for log retrieval
 type Filter struct {
-	Category string     `json:"category,omitempty"`
-	Level    string     `json:"level,omitempty"`
-	Success  *bool      `json:"success,omitempty"`
-	Since    time.Time  `json:"since,omitempty"`
-	Limit    int        `json:"limit,omitempty"`
-	Search   string     `json:"search,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	Level     string    `json:"level,omitempty"`
+	Success   *bool     `json:"success,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Limit     int       `json:"limit,omitempty"`
+	Before    uint64    `json:"before,omitempty"` // only entries with a lower ID
+	After     uint64    `json:"after,omitempty"`  // only entries with a higher ID
+	Search    string    `json:"search,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
 }
 
 // EntryBuilder provides a fluent interface for building log entries
 type EntryBuilder struct {
-	logger *Logger
-	entry  Entry
-	start  time.Time
+	logger  *Logger
+	entry   Entry
+	start   time.Time
+	elapsed time.Duration // set by WithDuration
 }
 
 // WithCommand sets the command string
@@ -363,17 +584,55 @@ func (b *EntryBuilder) WithExtra(key string, value interface{}) *EntryBuilder {
 	return b
 }
 
+// WithActor sets the user responsible for the entry. An empty actor (no
+// authentication) keeps the default.
+func (b *EntryBuilder) WithActor(actor string) *EntryBuilder {
+	if actor != "" {
+		b.entry.Actor = actor
+	}
+	return b
+}
+
+// WithContext ties the entry to the API request of ctx, if any: its ID,
+// and its user unless WithActor names another
+func (b *EntryBuilder) WithContext(ctx context.Context) *EntryBuilder {
+	req := requestOf(ctx)
+	if req == nil {
+		return b
+	}
+	b.entry.RequestID = req.id
+	if b.entry.Actor == SystemActor {
+		b.WithActor(req.Actor())
+	}
+	return b
+}
+
+// WithTime sets when the entry happened, for entries collected from
+// elsewhere
+func (b *EntryBuilder) WithTime(t time.Time) *EntryBuilder {
+	if !t.IsZero() {
+		b.entry.Time = t
+	}
+	return b
+}
+
 // WithDuration sets the duration
 func (b *EntryBuilder) WithDuration(d time.Duration) *EntryBuilder {
+	b.elapsed = d
 	b.entry.Duration = d.Milliseconds()
 	return b
 }
 
 // Commit finalizes and stores the log entry
 func (b *EntryBuilder) Commit() {
-	// Calculate duration if start was set
-	if !b.start.IsZero() {
-		b.entry.Duration = time.Since(b.start).Milliseconds()
+	// Calculate duration if start was set and none was given
+	if b.elapsed == 0 && !b.start.IsZero() {
+		b.elapsed = time.Since(b.start)
+		b.entry.Duration = b.elapsed.Milliseconds()
+	}
+	// Commands are counted for /metrics even when their entries are not kept
+	if b.entry.Command != "" {
+		b.logger.commands.observe(b.entry.Category, b.elapsed, b.entry.Success)
 	}
 	b.logger.addEntry(b.entry)
 }
@@ -395,35 +654,11 @@ func levelFromString(s string) Level {
 	}
 }
 
-// maskSensitive masks passwords and other sensitive data in commands
-func maskSensitive(cmd string) string {
-	// Mask password arguments
-	sensitivePatterns := []string{
-		"password",
-		"secret",
-		"key",
-		"token",
-		"pass",
-	}
-	
-	words := strings.Fields(cmd)
-	for i, word := range words {
-		lower := strings.ToLower(word)
-		for _, pattern := range sensitivePatterns {
-			if strings.Contains(lower, pattern) && i+1 < len(words) {
-				// Mask the next word (the actual value)
-				words[i+1] = "****"
-			}
-		}
-	}
-	
-	return strings.Join(words, " ")
-}
-
 func containsIgnoreCase(e Entry, search string) bool {
 	search = strings.ToLower(search)
 	return strings.Contains(strings.ToLower(e.Category), search) ||
 		strings.Contains(strings.ToLower(e.Action), search) ||
+		strings.Contains(strings.ToLower(e.Actor), search) ||
 		strings.Contains(strings.ToLower(e.Command), search) ||
 		strings.Contains(strings.ToLower(e.Output), search) ||
 		strings.Contains(strings.ToLower(e.Error), search)
@@ -438,6 +673,13 @@ func (l *Logger) Stats() map[string]interface{} {
 		"total_entries": len(l.entries),
 		"max_entries":   l.settings.MaxEntries,
 		"enabled":       l.settings.Enabled,
+		"persisted":     l.store != nil,
+	}
+	if l.store != nil {
+		stats["disk_bytes"] = l.store.Size()
+	}
+	if l.syslog != nil {
+		stats["syslog"] = l.syslog.Status()
 	}
 	
 	// Count by category
@@ -455,6 +697,18 @@ func (l *Logger) Stats() map[string]interface{} {
 	return stats
 }
 
+// SyslogStatus reports on forwarding to a syslog collector, and false if
+// it was never enabled
+func (l *Logger) SyslogStatus() (SyslogStatus, bool) {
+	l.mu.RLock()
+	f := l.syslog
+	l.mu.RUnlock()
+	if f == nil {
+		return SyslogStatus{}, false
+	}
+	return f.Status(), true
+}
+
 // Msg is a simple helper for quick logging
 func (l *Logger) Msg(level Level, category, format string, args ...interface{}) {
 	l.Log(level, category, fmt.Sprintf(format, args...)).Commit()
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CommandBuckets are the upper bounds, in seconds, of the command duration
+// histogram
+var CommandBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// CommandStats counts the commands run in one category since startup,
+// whether or not their entries were kept
+type CommandStats struct {
+	Category string
+	Count    uint64
+	Failures uint64
+	Seconds  float64  // total duration
+	Buckets  []uint64 // commands no slower than each of CommandBuckets
+}
+
+// commandMetrics accumulates CommandStats by category
+type commandMetrics struct {
+	mu         sync.Mutex
+	byCategory map[string]*CommandStats
+}
+
+// observe counts a finished command
+func (m *commandMetrics) observe(category string, d time.Duration, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.byCategory == nil {
+		m.byCategory = make(map[string]*CommandStats)
+	}
+	s, ok := m.byCategory[category]
+	if !ok {
+		s = &CommandStats{Category: category, Buckets: make([]uint64, len(CommandBuckets))}
+		m.byCategory[category] = s
+	}
+	s.Count++
+	if !success {
+		s.Failures++
+	}
+	secs := d.Seconds()
+	s.Seconds += secs
+	for i, le := range CommandBuckets {
+		if secs <= le {
+			s.Buckets[i]++
+		}
+	}
+}
+
+// CommandStats returns the command counts and durations of each category
+func (l *Logger) CommandStats() []CommandStats {
+	m := &l.commands
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]CommandStats, 0, len(m.byCategory))
+	for _, s := range m.byCategory {
+		c := *s
+		c.Buckets = append([]uint64(nil), s.Buckets...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Category < out[j].Category })
+	return out
+}
@@ -0,0 +1,229 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// secretMask replaces redacted secrets
+const secretMask = "****"
+
+// minSecretLen is the shortest secret value replaced wherever it appears;
+// shorter ones would mangle unrelated text
+const minSecretLen = 4
+
+// secretProperties are the NetworkManager settings, by the name after the
+// last dot, and nmcli keywords whose next argument is a secret, e.g.
+// "wifi-sec.psk <psk>" or "wifi connect <ssid> password <password>"
+var secretProperties = map[string]bool{
+	"password":                    true,
+	"psk":                         true,
+	"wep-key0":                    true,
+	"wep-key1":                    true,
+	"wep-key2":                    true,
+	"wep-key3":                    true,
+	"leap-password":               true,
+	"pin":                         true,
+	"private-key":                 true,
+	"private-key-password":        true,
+	"phase2-private-key-password": true,
+	"preshared-key":               true,
+	"secrets":                     true, // vpn.secrets
+	"client-cert-password":        true,
+	"phase2-client-cert-password": true,
+	"ca-cert-password":            true,
+	"phase2-ca-cert-password":     true,
+	"password-raw":                true,
+	"pac-file-password":           true,
+}
+
+// secretKey matches names of Extra fields that hold secrets, such as
+// "password" or "private_key". Not "token", which names a token's ID.
+var secretKey = regexp.MustCompile(`(?i)pass|secret|psk|private[-_]?key|api[-_]?key|wep-?key|credential`)
+
+// builtinRules find secrets in free text. The first group of each, or the
+// whole match without groups, is masked.
+var builtinRules = []*regexp.Regexp{
+	// key=value, key = value and "key": "value", as in vpn.data
+	// "server=x, password=y" or env-secrets lines. The key must end in the
+	// secret's name, which leaves password-flags alone.
+	regexp.MustCompile(`(?i)["']?[\w.-]*(?:pass(?:word|wd|phrase)?|secrets?|psk|token|api[-_]?key|private[-_]?key|wep[-_]?key\d?|credentials?)["']?\s*[=:]\s*("[^"]*"|'[^']*'|[^\s,;&"']+)`),
+	// HTTP authorization
+	regexp.MustCompile(`(?i)\b(?:bearer|basic)\s+([\w.~+/=-]{8,})`),
+	// API tokens
+	regexp.MustCompile(`nmw_[A-Za-z0-9_-]+`),
+}
+
+// redactor hides secrets in entries. It is replaced, not changed, when
+// the rules change.
+type redactor struct {
+	rules  []*regexp.Regexp
+	values []string // literal secrets, longest first
+}
+
+// newRedactor builds a redactor from the built-in rules, extra patterns
+// and secret values
+func newRedactor(patterns []string, values []string) (*redactor, error) {
+	r := &redactor{rules: append([]*regexp.Regexp(nil), builtinRules...)}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", p, err)
+		}
+		r.rules = append(r.rules, re)
+	}
+	for _, v := range values {
+		if isSecretValue(v) {
+			r.values = append(r.values, v)
+		}
+	}
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r, nil
+}
+
+// isSecretValue reports whether a value is worth replacing wherever it
+// appears: not too short, and not a number or a yes/no
+func isSecretValue(v string) bool {
+	if len(v) < minSecretLen {
+		return false
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return false
+	}
+	switch strings.ToLower(v) {
+	case "true", "false", "yes", "no":
+		return false
+	}
+	return true
+}
+
+// text redacts free text
+func (r *redactor) text(s string) string {
+	if s == "" {
+		return s
+	}
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, secretMask)
+	}
+	for _, re := range r.rules {
+		s = maskMatches(re, s)
+	}
+	return s
+}
+
+// maskMatches masks the first group of every match of re, or the whole
+// match if re has no groups
+func maskMatches(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if start < last || s[start:end] == secretMask {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(secretMask)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// entry redacts every text field of an entry
+func (r *redactor) entry(e Entry) Entry {
+	e.Action = r.text(e.Action)
+	e.Command = r.text(e.Command)
+	e.Output = r.text(e.Output)
+	e.Error = r.text(e.Error)
+	if e.Extra != nil {
+		extra := make(map[string]interface{}, len(e.Extra))
+		for k, v := range e.Extra {
+			extra[k] = r.value(k, v)
+		}
+		e.Extra = extra
+	}
+	return e
+}
+
+// value redacts an Extra value of any type; all of it if its name is a
+// secret's
+func (r *redactor) value(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if secretKey.MatchString(key) && v != "" {
+			return secretMask
+		}
+		return r.text(v)
+	case []string:
+		out := make([]string, len(v))
+		for i, s := range v {
+			out[i] = r.value(key, s).(string)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, s := range v {
+			out[i] = r.value(key, s)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			out[k] = r.value(k, s)
+		}
+		return out
+	case []byte:
+		return r.value(key, string(v))
+	case error:
+		return r.text(v.Error())
+	case fmt.Stringer:
+		return r.text(v.String())
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+
+	// Anything else, such as a struct or a typed map, is redacted as the
+	// JSON it is stored as
+	data, err := json.Marshal(v)
+	if err != nil {
+		if secretKey.MatchString(key) {
+			return secretMask
+		}
+		return v
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return secretMask
+	}
+	return r.value(key, generic)
+}
+
+// redactArgs masks the arguments that follow secret properties and
+// keywords in a command line
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 0; i < len(out)-1; i++ {
+		name := strings.ToLower(strings.TrimLeft(out[i], "+-"))
+		leaf := name
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			leaf = name[dot+1:]
+		}
+		if secretProperties[name] || secretProperties[leaf] {
+			out[i+1] = secretMask
+			i++
+		}
+	}
+	return out
+}
@@ -0,0 +1,350 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const storeFile = "nm-webui.log"
+
+// StoreConfig configures the disk store
+type StoreConfig struct {
+	Dir           string
+	MaxSize       int64         // bytes per file before it is rotated
+	MaxFiles      int           // rotated files to keep
+	MaxAge        time.Duration // drop entries older than this (0 keeps them)
+	FlushInterval time.Duration // write entries in batches this often (0 writes each at once)
+}
+
+// Store keeps log entries on disk as JSON lines in nm-webui.log, rotated to
+// nm-webui.log.1 and so on. Entries are buffered and written in batches to
+// spare SD cards.
+type Store struct {
+	cfg StoreConfig
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	first   time.Time // time of the oldest entry in the current file
+	pending []Entry
+	lastID  uint64
+	pruned  time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenStore opens the store in cfg.Dir and starts its flush loop
+func OpenStore(cfg StoreConfig) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	s := &Store{cfg: cfg, stop: make(chan struct{}), done: make(chan struct{})}
+
+	// The newest entry is in the current file, or in .1 right after a
+	// rotation
+	for _, path := range []string{s.path(0), s.path(1)} {
+		if e, ok := lastLine(path); ok {
+			s.lastID = e.ID
+			break
+		}
+	}
+	if err := s.openCurrent(); err != nil {
+		return nil, err
+	}
+	s.prune()
+
+	go s.loop()
+	return s, nil
+}
+
+// path returns the current file for n = 0 and rotated files otherwise
+func (s *Store) path(n int) string {
+	if n == 0 {
+		return filepath.Join(s.cfg.Dir, storeFile)
+	}
+	return filepath.Join(s.cfg.Dir, storeFile+"."+strconv.Itoa(n))
+}
+
+// openCurrent opens the current file for appending; the caller holds mu
+func (s *Store) openCurrent() error {
+	f, err := os.OpenFile(s.path(0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size, s.first = f, fi.Size(), time.Time{}
+	if s.size > 0 {
+		if e, ok := firstLine(s.path(0)); ok {
+			s.first = e.Time
+		}
+		// Finish a line torn by a power cut
+		if last := make([]byte, 1); readAt(s.path(0), last, s.size-1) && last[0] != '\n' {
+			n, _ := f.Write([]byte("\n"))
+			s.size += int64(n)
+		}
+	}
+	return nil
+}
+
+// LastID returns the ID of the newest stored entry
+func (s *Store) LastID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastID
+}
+
+// add queues an entry, or writes it at once without a flush interval
+func (s *Store) add(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, e)
+	s.lastID = e.ID
+	if s.cfg.FlushInterval <= 0 {
+		s.flush()
+	}
+}
+
+// loop flushes pending entries every interval until Close
+func (s *Store) loop() {
+	defer close(s.done)
+	interval := s.cfg.FlushInterval
+	if interval <= 0 {
+		interval = time.Minute // only to prune old files
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Flush writes pending entries to disk
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush writes pending entries; the caller holds mu
+func (s *Store) flush() error {
+	if time.Since(s.pruned) > time.Hour {
+		s.prune()
+	}
+	if len(s.pending) == 0 || s.f == nil {
+		return nil
+	}
+
+	// Entries that fail to write are dropped; memory still has them
+	pending := s.pending
+	s.pending = nil
+
+	var buf bytes.Buffer
+	for _, e := range pending {
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		line = append(line, '\n')
+		if s.size+int64(buf.Len()) > 0 && s.size+int64(buf.Len()+len(line)) > s.cfg.MaxSize {
+			if err := s.write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		if s.first.IsZero() {
+			s.first = e.Time
+		}
+		buf.Write(line)
+	}
+	return s.write(buf.Bytes())
+}
+
+// write appends to the current file; the caller holds mu
+func (s *Store) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	n, err := s.f.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+	return s.f.Sync()
+}
+
+// rotate shifts the current file to .1 and so on, dropping the oldest; the
+// caller holds mu
+func (s *Store) rotate() error {
+	s.f.Close()
+	s.f = nil
+	os.Remove(s.path(s.cfg.MaxFiles))
+	for n := s.cfg.MaxFiles - 1; n >= 0; n-- {
+		if err := os.Rename(s.path(n), s.path(n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+	return s.openCurrent()
+}
+
+// prune removes rotated files whose newest entry is older than MaxAge, and
+// rotates the current file once its oldest entry is; the caller holds mu
+func (s *Store) prune() {
+	s.pruned = time.Now()
+	if s.cfg.MaxAge <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.cfg.MaxAge)
+	for n := 1; n <= s.cfg.MaxFiles; n++ {
+		if fi, err := os.Stat(s.path(n)); err == nil && fi.ModTime().Before(cutoff) {
+			os.Remove(s.path(n))
+		}
+	}
+	if !s.first.IsZero() && s.first.Before(cutoff) && s.f != nil {
+		s.rotate()
+	}
+}
+
+// Close flushes pending entries and stops the flush loop
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush()
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	return err
+}
+
+// Clear removes every stored entry
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+	for n := 1; n <= s.cfg.MaxFiles; n++ {
+		os.Remove(s.path(n))
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	if err := os.Truncate(s.path(0), 0); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.openCurrent()
+}
+
+// Size returns the bytes stored on disk
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for n := 0; n <= s.cfg.MaxFiles; n++ {
+		if fi, err := os.Stat(s.path(n)); err == nil {
+			total += fi.Size()
+		}
+	}
+	return total
+}
+
+// scan calls fn for stored entries with an ID below before, newest first,
+// until fn returns false. Pending entries are included; lowering before as
+// it goes skips any flushed while the files are read.
+func (s *Store) scan(before uint64, fn func(Entry) bool) {
+	s.mu.Lock()
+	pending := append([]Entry(nil), s.pending...)
+	s.mu.Unlock()
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		if pending[i].ID >= before {
+			continue
+		}
+		if !fn(pending[i]) {
+			return
+		}
+		before = pending[i].ID
+	}
+	for n := 0; n <= s.cfg.MaxFiles; n++ {
+		data, err := os.ReadFile(s.path(n))
+		if err != nil {
+			continue
+		}
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		for i := len(lines) - 1; i >= 0; i-- {
+			var e Entry
+			if json.Unmarshal(lines[i], &e) != nil || e.ID == 0 || e.ID >= before {
+				continue
+			}
+			if !fn(e) {
+				return
+			}
+			before = e.ID
+		}
+	}
+}
+
+// firstLine returns the first readable entry of a file
+func firstLine(path string) (Entry, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Entry{}, false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.ID != 0 {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// lastLine returns the last readable entry of a file
+func lastLine(path string) (Entry, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, false
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var e Entry
+		if json.Unmarshal(lines[i], &e) == nil && e.ID != 0 {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// readAt reads len(b) bytes of a file at off
+func readAt(path string, b []byte, off int64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = f.ReadAt(b, off)
+	return err == nil
+}
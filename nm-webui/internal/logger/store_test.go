@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newPersisted returns a logger keeping maxEntries in memory and the rest
+// in a store in dir
+func newPersisted(t *testing.T, dir string, maxEntries int, cfg StoreConfig) *Logger {
+	t.Helper()
+	settings := DefaultSettings()
+	settings.MaxEntries = maxEntries
+	l := New(settings)
+	cfg.Dir = dir
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = 2
+	}
+	if err := l.Persist(cfg); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	return l
+}
+
+// logN logs n entries numbered from 1 in their action
+func logN(l *Logger, n int) {
+	for i := 1; i <= n; i++ {
+		l.Info("test", "entry "+strconv.Itoa(i)).Commit()
+	}
+}
+
+// checkIDs fails unless entries have the IDs from first down to last
+func checkIDs(t *testing.T, entries []Entry, first, last uint64) {
+	t.Helper()
+	if len(entries) != int(first-last+1) {
+		t.Fatalf("got %d entries, want %d to %d", len(entries), first, last)
+	}
+	for i, e := range entries {
+		if e.ID != first-uint64(i) {
+			t.Fatalf("entry %d has ID %d, want %d", i, e.ID, first-uint64(i))
+		}
+	}
+}
+
+func TestStorePaging(t *testing.T) {
+	dir := t.TempDir()
+	l := newPersisted(t, dir, 5, StoreConfig{})
+	logN(l, 20)
+
+	// Entries no longer in memory come from disk
+	checkIDs(t, l.GetEntries(nil), 20, 1)
+	page := l.GetEntries(&Filter{Limit: 8})
+	checkIDs(t, page, 20, 13)
+	page = l.GetEntries(&Filter{Limit: 8, Before: page[len(page)-1].ID})
+	checkIDs(t, page, 12, 5)
+	checkIDs(t, l.GetEntries(&Filter{After: 16}), 20, 17)
+	if got := l.GetEntries(&Filter{Search: "entry 3", Limit: 1}); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("search found %+v", got)
+	}
+
+	// IDs continue after a restart
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l = newPersisted(t, dir, 5, StoreConfig{})
+	defer l.Close()
+	if id := l.LastID(); id != 20 {
+		t.Errorf("LastID after restart = %d, want 20", id)
+	}
+	logN(l, 1)
+	checkIDs(t, l.GetEntries(nil), 21, 1)
+}
+
+func TestStoreBuffered(t *testing.T) {
+	dir := t.TempDir()
+	l := newPersisted(t, dir, 2, StoreConfig{FlushInterval: time.Hour})
+	logN(l, 6)
+
+	// Pending entries are found before they are written
+	checkIDs(t, l.GetEntries(nil), 6, 1)
+	if fi, err := os.Stat(filepath.Join(dir, storeFile)); err != nil || fi.Size() != 0 {
+		t.Fatalf("entries written before the flush interval: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l = newPersisted(t, dir, 2, StoreConfig{})
+	defer l.Close()
+	checkIDs(t, l.GetEntries(nil), 6, 1)
+}
+
+func TestStoreRotation(t *testing.T) {
+	dir := t.TempDir()
+	const maxSize = 1000
+	l := newPersisted(t, dir, 1, StoreConfig{MaxSize: maxSize, MaxFiles: 2})
+	defer l.Close()
+	logN(l, 100)
+
+	for n, name := range []string{storeFile, storeFile + ".1", storeFile + ".2"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("file %d: %v", n, err)
+		}
+		if fi.Size() > maxSize {
+			t.Errorf("%s holds %d bytes, over %d", name, fi.Size(), maxSize)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, storeFile+".3")); !os.IsNotExist(err) {
+		t.Error("kept more rotated files than MaxFiles")
+	}
+
+	// The newest entries are kept, without gaps
+	entries := l.GetEntries(nil)
+	if len(entries) == 0 || len(entries) == 100 {
+		t.Fatalf("kept %d entries", len(entries))
+	}
+	checkIDs(t, entries, 100, 100-uint64(len(entries))+1)
+
+	if err := l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.GetEntries(nil); len(got) != 0 {
+		t.Errorf("%d entries after Clear", len(got))
+	}
+}
+
+func TestStoreTornLine(t *testing.T) {
+	dir := t.TempDir()
+	torn := `{"id":1,"time":"2024-05-01T12:00:00Z","level":"INFO","category":"test","action":"whole","actor":"system","success":true}` + "\n" +
+		`{"id":2,"time":"2024-05-01T12:00:01Z","lev`
+	if err := os.WriteFile(filepath.Join(dir, storeFile), []byte(torn), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newPersisted(t, dir, 1, StoreConfig{})
+	defer l.Close()
+	if id := l.LastID(); id != 1 {
+		t.Errorf("LastID = %d, want 1", id)
+	}
+	logN(l, 2)
+	checkIDs(t, l.GetEntries(nil), 3, 1)
+}
+
+func TestStoreMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, storeFile+".1")
+	line := `{"id":1,"time":"2020-01-01T00:00:00Z","level":"INFO","category":"test","action":"old","actor":"system","success":true}` + "\n"
+	if err := os.WriteFile(old, []byte(line), 0640); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newPersisted(t, dir, 1, StoreConfig{MaxAge: 24 * time.Hour})
+	defer l.Close()
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("rotated file older than MaxAge kept")
+	}
+	// IDs still continue from it
+	if id := l.LastID(); id != 1 {
+		t.Errorf("LastID = %d, want 1", id)
+	}
+}
@@ -0,0 +1,399 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog forwarding limits
+const (
+	syslogQueueMax = 5000 // entries kept while the collector is unreachable
+	syslogRetryMin = time.Second
+	syslogRetryMax = 2 * time.Minute
+	syslogTimeout  = 10 * time.Second
+	syslogUDPMax   = 2048            // bytes per datagram
+	syslogFacility = 3               // daemon
+	syslogSDID     = "nmwebui@32473" // 32473 is RFC 5612's example enterprise number
+)
+
+// Syslog transports
+const (
+	syslogProtoUDP = "udp"
+	syslogProtoTCP = "tcp"
+	syslogProtoTLS = "tls"
+)
+
+// SyslogSettings configures forwarding to a remote syslog collector
+type SyslogSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address"`  // host:port
+	Protocol string `json:"protocol"` // udp, tcp or tls
+	MinLevel Level  `json:"min_level"`
+}
+
+// syslogTLS is how a TLS collector's certificate is checked. It comes from
+// the command line rather than the settings, since it names a local file.
+type syslogTLS struct {
+	roots      *x509.CertPool // nil uses the system's
+	serverName string         // "" uses the address's host
+}
+
+// SetSyslogTLS sets the CA bundle that a TLS syslog collector's certificate
+// must chain to, instead of the system's CAs, and the name it must be
+// valid for, instead of the host of the collector's address. Empty values
+// keep the defaults.
+func (l *Logger) SetSyslogTLS(caFile, serverName string) error {
+	var t syslogTLS
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read syslog CA bundle: %w", err)
+		}
+		t.roots = x509.NewCertPool()
+		if !t.roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM certificates in syslog CA bundle %s", caFile)
+		}
+	}
+	t.serverName = serverName
+
+	l.mu.Lock()
+	l.syslogTLS = t
+	l.mu.Unlock()
+	return nil
+}
+
+// tlsConfig returns the client configuration for a TLS collector at addr
+func (t syslogTLS) tlsConfig(addr string) *tls.Config {
+	name := t.serverName
+	if name == "" {
+		name, _, _ = net.SplitHostPort(addr)
+	}
+	return &tls.Config{
+		ServerName: name,
+		RootCAs:    t.roots,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// Validate checks the settings of enabled forwarding
+func (s SyslogSettings) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	switch s.Protocol {
+	case syslogProtoUDP, syslogProtoTCP, syslogProtoTLS:
+	default:
+		return fmt.Errorf("unknown protocol %q (want udp, tcp or tls)", s.Protocol)
+	}
+	host, port, err := net.SplitHostPort(s.Address)
+	if err != nil || host == "" {
+		return fmt.Errorf("address %q: want host:port", s.Address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("address %q: invalid port", s.Address)
+	}
+	return nil
+}
+
+// SyslogStatus reports how forwarding is doing
+type SyslogStatus struct {
+	Enabled   bool   `json:"enabled"`
+	Connected bool   `json:"connected"`
+	Queued    int    `json:"queued"`
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"` // queue overflowed while unreachable
+	LastError string `json:"last_error,omitempty"`
+}
+
+// forwarder sends entries to a syslog collector in RFC 5424 format, over
+// UDP, or TCP and TLS with octet counting (RFC 6587, RFC 5425). Entries
+// queue while the collector is unreachable and are sent in order once it
+// is back; the oldest are dropped when the queue is full.
+type forwarder struct {
+	log      *Logger
+	hostname string
+
+	mu     sync.Mutex
+	cfg    SyslogSettings
+	gen    int // bumped when cfg changes
+	queue  []Entry
+	status SyslogStatus
+
+	wake    chan struct{} // entries queued
+	changed chan struct{} // settings changed
+}
+
+func newForwarder(l *Logger) *forwarder {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	f := &forwarder{
+		log:      l,
+		hostname: hostname,
+		wake:     make(chan struct{}, 1),
+		changed:  make(chan struct{}, 1),
+	}
+	go f.run()
+	return f
+}
+
+// configure applies new settings; the connection is remade
+func (f *forwarder) configure(cfg SyslogSettings) {
+	f.mu.Lock()
+	if cfg == f.cfg {
+		f.mu.Unlock()
+		return
+	}
+	f.cfg = cfg
+	f.gen++
+	f.status.Enabled = cfg.Enabled
+	f.status.LastError = ""
+	if !cfg.Enabled {
+		f.queue = nil
+	}
+	f.mu.Unlock()
+	notify(f.changed)
+}
+
+// enqueue queues an entry for sending, without blocking
+func (f *forwarder) enqueue(e Entry) {
+	f.mu.Lock()
+	if !f.cfg.Enabled || levelFromString(e.Level) < f.cfg.MinLevel {
+		f.mu.Unlock()
+		return
+	}
+	f.queue = append(f.queue, e)
+	if len(f.queue) > syslogQueueMax {
+		f.queue = f.queue[len(f.queue)-syslogQueueMax:]
+		f.status.Dropped++
+	}
+	f.mu.Unlock()
+	notify(f.wake)
+}
+
+// notify signals a channel without blocking
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the forwarding status
+func (f *forwarder) Status() SyslogStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.status
+	s.Queued = len(f.queue)
+	return s
+}
+
+// run connects and sends until the process ends, retrying with backoff
+func (f *forwarder) run() {
+	backoff := syslogRetryMin
+	for {
+		// Settings read below are current; drop the signal for them
+		select {
+		case <-f.changed:
+		default:
+		}
+		f.mu.Lock()
+		cfg, gen := f.cfg, f.gen
+		f.mu.Unlock()
+		if !cfg.Enabled {
+			<-f.changed
+			continue
+		}
+		f.log.mu.RLock()
+		tlsCfg := f.log.syslogTLS.tlsConfig(cfg.Address)
+		f.log.mu.RUnlock()
+
+		conn, err := dialSyslog(cfg, tlsCfg)
+		if err == nil {
+			f.setConnected(gen, true, nil)
+			backoff = syslogRetryMin
+			err = f.send(conn, cfg)
+			conn.Close()
+		}
+		if err == nil {
+			continue // the settings changed
+		}
+		f.setConnected(gen, false, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+			if backoff > syslogRetryMax {
+				backoff = syslogRetryMax
+			}
+		case <-f.changed:
+			backoff = syslogRetryMin
+		}
+	}
+}
+
+// send writes queued entries until the settings change (nil) or a write
+// fails
+func (f *forwarder) send(conn net.Conn, cfg SyslogSettings) error {
+	for {
+		f.mu.Lock()
+		if len(f.queue) == 0 {
+			f.mu.Unlock()
+			select {
+			case <-f.wake:
+				continue
+			case <-f.changed:
+				return nil
+			}
+		}
+		e := f.queue[0]
+		f.mu.Unlock()
+
+		msg := f.format(e)
+		if cfg.Protocol == syslogProtoUDP {
+			if len(msg) > syslogUDPMax {
+				msg = msg[:syslogUDPMax]
+			}
+		} else {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		// The queue may have been trimmed or cleared meanwhile
+		if len(f.queue) > 0 && f.queue[0].ID == e.ID {
+			f.queue = f.queue[1:]
+		}
+		f.status.Sent++
+		f.mu.Unlock()
+	}
+}
+
+// setConnected records a connection change, logging it once per change
+func (f *forwarder) setConnected(gen int, connected bool, err error) {
+	f.mu.Lock()
+	if f.gen != gen {
+		f.mu.Unlock()
+		return
+	}
+	changed := f.status.Connected != connected
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	repeated := errText != "" && errText == f.status.LastError
+	f.status.Connected = connected
+	if err != nil {
+		f.status.LastError = errText
+	}
+	addr := f.cfg.Address
+	f.mu.Unlock()
+
+	// Log outside the lock; these entries are forwarded too
+	switch {
+	case connected:
+		f.log.Info("syslog", "connect").WithExtra("address", addr).Commit()
+	case err != nil && (changed || !repeated):
+		f.log.Warn("syslog", "unreachable").
+			WithExtra("address", addr).
+			WithError(err).
+			Commit()
+	}
+}
+
+// dialSyslog connects to the collector, using tlsCfg for TLS
+func dialSyslog(cfg SyslogSettings, tlsCfg *tls.Config) (net.Conn, error) {
+	switch cfg.Protocol {
+	case syslogProtoTLS:
+		dialer := &net.Dialer{Timeout: syslogTimeout}
+		return tls.DialWithDialer(dialer, "tcp", cfg.Address, tlsCfg)
+	case syslogProtoTCP:
+		return net.DialTimeout("tcp", cfg.Address, syslogTimeout)
+	default:
+		return net.DialTimeout("udp", cfg.Address, syslogTimeout)
+	}
+}
+
+// format renders an entry as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+//
+// MSGID is the category; the structured data holds the entry's fields.
+func (f *forwarder) format(e Entry) string {
+	severity := 6 // informational
+	switch e.Level {
+	case "ERROR":
+		severity = 3
+	case "WARN":
+		severity = 4
+	case "DEBUG":
+		severity = 7
+	}
+
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	param := func(name, value string) {
+		sd.WriteString(" " + name + `="` + sdEscape(value) + `"`)
+	}
+	param("id", strconv.FormatUint(e.ID, 10))
+	param("category", e.Category)
+	param("action", e.Action)
+	param("actor", e.Actor)
+	param("success", strconv.FormatBool(e.Success))
+	if e.ExitCode != 0 {
+		param("exit_code", strconv.Itoa(e.ExitCode))
+	}
+	if e.Duration != 0 {
+		param("duration_ms", strconv.FormatInt(e.Duration, 10))
+	}
+	sd.WriteString("]")
+
+	msg := e.Action
+	if e.Command != "" {
+		msg += ": " + e.Command
+	}
+	if e.Error != "" {
+		msg += ": " + e.Error
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		syslogFacility*8+severity,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		f.hostname,
+		"nm-webui",
+		os.Getpid(),
+		msgID(e.Category),
+		sd.String(),
+		strings.ReplaceAll(msg, "\n", " "),
+	)
+}
+
+// msgID makes a category a valid MSGID: printable ASCII without spaces
+func msgID(category string) string {
+	var b strings.Builder
+	for _, r := range category {
+		if r > 32 && r < 127 && b.Len() < 32 {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+// sdEscape escapes a structured data parameter value
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyslogTLS(t *testing.T) {
+	// httptest's certificate is self-signed for example.com and 127.0.0.1
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+	cfg := SyslogSettings{Enabled: true, Address: addr, Protocol: syslogProtoTLS}
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	junk := filepath.Join(dir, "junk.pem")
+	if err := os.WriteFile(junk, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewDefault()
+	if err := l.SetSyslogTLS(junk, ""); err == nil {
+		t.Error("accepted a CA bundle without certificates")
+	}
+	if err := l.SetSyslogTLS(filepath.Join(dir, "missing.pem"), ""); err == nil {
+		t.Error("accepted a missing CA bundle")
+	}
+
+	tests := []struct {
+		name       string
+		caFile     string
+		serverName string
+		ok         bool
+	}{
+		{"system CAs", "", "", false},
+		{"CA bundle", caFile, "", true},
+		{"CA bundle and name", caFile, "example.com", true},
+		{"wrong name", caFile, "logs.example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.SetSyslogTLS(tt.caFile, tt.serverName); err != nil {
+				t.Fatal(err)
+			}
+			conn, err := dialSyslog(cfg, l.syslogTLS.tlsConfig(addr))
+			if err == nil {
+				conn.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("dial error = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+
+	if name := (syslogTLS{}).tlsConfig("logs.example.com:6514").ServerName; name != "logs.example.com" {
+		t.Errorf("default ServerName = %q, want the address's host", name)
+	}
+}
@@ -0,0 +1,101 @@
+// Package metrics writes metrics in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// Writer writes metric families: a HELP and TYPE header, then samples
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer; call Flush when done
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family starts a metric family
+func (m *Writer) Family(name, typ, help string) {
+	m.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	m.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a sample. labels are name, value pairs.
+func (m *Writer) Sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 1 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			m.w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(formatValue(value))
+	m.w.WriteByte('\n')
+}
+
+// Histogram writes the buckets, sum and count of one histogram. counts
+// are cumulative, one per bound; the +Inf bucket is count.
+func (m *Writer) Histogram(name string, bounds []float64, counts []uint64, count uint64, sum float64, labels ...string) {
+	labels = labels[:len(labels):len(labels)] // append copies, not into the caller's array
+	for i, le := range bounds {
+		m.Sample(name+"_bucket", float64(counts[i]), append(labels, "le", formatValue(le))...)
+	}
+	m.Sample(name+"_bucket", float64(count), append(labels, "le", "+Inf")...)
+	m.Sample(name+"_sum", sum, labels...)
+	m.Sample(name+"_count", float64(count), labels...)
+}
+
+// Flush writes out what is buffered
+func (m *Writer) Flush() error {
+	return m.w.Flush()
+}
+
+// Bool returns 1 for true and 0 for false
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return strconv.FormatFloat(v, 'f', -1, 64) // byte counts read better whole
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// render runs f against a Writer and returns what it wrote
+func render(t *testing.T, f func(m *Writer)) string {
+	t.Helper()
+	var b strings.Builder
+	m := NewWriter(&b)
+	f(m)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{-3, "-3"},
+		{1 << 40, "1099511627776"},
+		{0.25, "0.25"},
+		{1e15, "1e+15"},
+		{1.5e-7, "1.5e-07"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.v); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	got := render(t, func(m *Writer) {
+		m.Family("nmw_test", Gauge, "A \\ path\nand \"quotes\"")
+		m.Sample("nmw_test", 1, "ssid", "Cafe \"Free\"\nC:\\wifi", "iface", "wlan0")
+		m.Sample("nmw_test", 2)
+	})
+	want := `# HELP nmw_test A \\ path\nand "quotes"
+# TYPE nmw_test gauge
+nmw_test{ssid="Cafe \"Free\"\nC:\\wifi",iface="wlan0"} 1
+nmw_test 2
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	labels := []string{"route", "/api/status", "extra", "x"}
+	labels = labels[:2] // spare capacity the histogram must not write into
+	got := render(t, func(m *Writer) {
+		m.Histogram("nmw_seconds", []float64{0.005, 0.1, 1}, []uint64{3, 7, 9}, 10, 2.5, labels...)
+	})
+	want := `nmw_seconds_bucket{route="/api/status",le="0.005"} 3
+nmw_seconds_bucket{route="/api/status",le="0.1"} 7
+nmw_seconds_bucket{route="/api/status",le="1"} 9
+nmw_seconds_bucket{route="/api/status",le="+Inf"} 10
+nmw_seconds_sum{route="/api/status"} 2.5
+nmw_seconds_count{route="/api/status"} 10
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if labels[:4][3] != "x" {
+		t.Error("Histogram wrote into the caller's label array")
+	}
+
+	// Without labels only le is set
+	got = render(t, func(m *Writer) {
+		m.Histogram("nmw_bytes", []float64{1024}, []uint64{0}, 0, 0)
+	})
+	want = `nmw_bytes_bucket{le="1024"} 0
+nmw_bytes_bucket{le="+Inf"} 0
+nmw_bytes_sum 0
+nmw_bytes_count 0
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
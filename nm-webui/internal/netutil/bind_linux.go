@@ -0,0 +1,36 @@
+// Package netutil provides socket helpers shared by listeners and dialers
+package netutil
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// BindToDevice returns a dialer or listener Control function that pins the
+// socket to a network interface, so traffic only leaves or arrives through it
+func BindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// BoundDevice returns the interface a socket is pinned to, or "" if none
+func BoundDevice(c syscall.RawConn) (string, error) {
+	var iface string
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		iface, serr = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	})
+	if err != nil {
+		return "", err
+	}
+	return iface, serr
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+// Package netutil provides socket helpers shared by listeners and dialers
+package netutil
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// BindToDevice is only supported on Linux
+func BindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is not supported on this platform", iface)
+	}
+}
+
+// BoundDevice is only supported on Linux, where sockets can be pinned
+func BoundDevice(c syscall.RawConn) (string, error) {
+	return "", nil
+}
@@ -2,6 +2,7 @@
 package nmcli
 
 import (
+	"context"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -14,6 +15,7 @@ import (
 type Client struct {
 	nmcliBin string
 	log      *logger.Logger
+	ctx      context.Context // request the commands are run for
 }
 
 // New creates a new nmcli client
@@ -31,6 +33,15 @@ func (c *Client) SetLogger(log *logger.Logger) {
 	c.log = log
 }
 
+// WithContext returns a copy of the client whose commands are logged as
+// part of ctx's request. Commands are not cancelled with ctx: a change
+// NetworkManager has started is seen through even if the browser goes.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
 // run executes nmcli with the given arguments and returns output
 func (c *Client) run(args ...string) (string, error) {
 	start := time.Now()
@@ -50,6 +61,7 @@ func (c *Client) run(args ...string) (string, error) {
 		}
 		
 		c.log.Command("nmcli", c.nmcliBin, args).
+			WithContext(c.ctx).
 			WithOutput(string(output)).
 			WithError(err).
 			WithExitCode(exitCode).
@@ -0,0 +1,110 @@
+package nmcli
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"nm-webui/internal/types"
+)
+
+// Readings for the Prometheus endpoint, which is scraped every few seconds:
+// each takes at most one nmcli call, and counters come from /sys.
+
+// SystemInfo returns the load, memory, disk and uptime shown on the dashboard
+func SystemInfo() types.SystemInfo {
+	return getSystemInfo()
+}
+
+// DeviceStates returns every device but loopback with its type, state and
+// connection, without the details GetInterfaces looks up for each
+func (c *Client) DeviceStates() ([]types.Device, error) {
+	out, err := c.runTerse("DEVICE,TYPE,STATE,CONNECTION", "device", "status")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []types.Device
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		parts := parseEscapedLine(line)
+		if len(parts) < 4 || parts[1] == "loopback" {
+			continue
+		}
+		devices = append(devices, types.Device{
+			Device:     parts[0],
+			Type:       parts[1],
+			State:      parts[2],
+			Connection: parts[3],
+		})
+	}
+	return devices, nil
+}
+
+// InterfaceCounters reads an interface's traffic and error counters
+func InterfaceCounters(device string) (types.InterfaceCounters, error) {
+	var ic types.InterfaceCounters
+	dir := filepath.Join("/sys/class/net", filepath.Base(device), "statistics")
+	for _, f := range []struct {
+		name string
+		dst  *uint64
+	}{
+		{"rx_bytes", &ic.RxBytes},
+		{"tx_bytes", &ic.TxBytes},
+		{"rx_packets", &ic.RxPackets},
+		{"tx_packets", &ic.TxPackets},
+		{"rx_errors", &ic.RxErrors},
+		{"tx_errors", &ic.TxErrors},
+		{"rx_dropped", &ic.RxDropped},
+		{"tx_dropped", &ic.TxDropped},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			return ic, err
+		}
+		*f.dst, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return ic, nil
+}
+
+// ActiveWifi returns the access points WiFi devices are connected to, from
+// the last scan. A device's own hotspot is left out.
+func (c *Client) ActiveWifi() ([]types.WifiLink, error) {
+	out, err := c.run("-t", "--escape", "yes", "-f", "ACTIVE,SSID,BSSID,CHAN,FREQ,RATE,SIGNAL,DEVICE", "device", "wifi", "list", "--rescan", "no")
+	if err != nil {
+		return nil, err
+	}
+
+	var links []types.WifiLink
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		parts := parseEscapedLine(line)
+		if len(parts) < 8 || parts[0] != "yes" {
+			continue
+		}
+		link := types.WifiLink{
+			Device: parts[7],
+			SSID:   parts[1],
+			BSSID:  parts[2],
+		}
+		if hwaddr, err := os.ReadFile(filepath.Join("/sys/class/net", filepath.Base(link.Device), "address")); err == nil &&
+			strings.EqualFold(strings.TrimSpace(string(hwaddr)), link.BSSID) {
+			continue
+		}
+		link.Channel, _ = strconv.Atoi(parts[3])
+		link.FreqMHz, _ = strconv.Atoi(extractNumber(parts[4]))
+		link.RateMbps, _ = strconv.ParseFloat(extractNumber(parts[5]), 64)
+		link.Signal, _ = strconv.Atoi(parts[6])
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// Connectivity returns the result of NetworkManager's last connectivity
+// check: none, portal, limited, full or unknown
+func (c *Client) Connectivity() (string, error) {
+	out, err := c.run("networking", "connectivity")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
@@ -2,52 +2,331 @@
 package server
 
 import (
+	"crypto/rand"
 	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"nm-webui/internal/audit"
+	"nm-webui/internal/auth"
 	"nm-webui/internal/httputil"
+	"nm-webui/internal/listen"
+	"nm-webui/internal/logger"
+	"nm-webui/internal/tlscert"
 )
 
 // Middleware wraps handlers with common functionality
 type Middleware struct {
-	Username string
-	Password string
+	Users    *auth.UserStore // nil disables authentication
+	Tokens   *auth.TokenStore
+	Peers    *auth.PeerRoles // roles of local users on the Unix socket
+	Sessions *auth.SessionStore
+	Limiter  *auth.LoginLimiter
+	Certs    *tlscert.Manager // nil when serving plain HTTP
+	logger   *logger.Logger
 }
 
 // NewMiddleware creates a new middleware instance
-func NewMiddleware(username, password string) *Middleware {
+func NewMiddleware(users *auth.UserStore, tokens *auth.TokenStore, peers *auth.PeerRoles, sessions *auth.SessionStore, limiter *auth.LoginLimiter, certs *tlscert.Manager, log *logger.Logger) *Middleware {
 	return &Middleware{
-		Username: username,
-		Password: password,
+		Users:    users,
+		Tokens:   tokens,
+		Peers:    peers,
+		Sessions: sessions,
+		Limiter:  limiter,
+		Certs:    certs,
+		logger:   log,
 	}
 }
 
-// Auth wraps a handler with HTTP Basic Auth (if credentials configured)
+// AuthEnabled reports whether users are configured
+func (m *Middleware) AuthEnabled() bool {
+	return m.Users != nil
+}
+
+// Auth wraps a handler that any logged-in user may call
 func (m *Middleware) Auth(next http.HandlerFunc) http.HandlerFunc {
+	return m.Require(auth.RoleViewer, next)
+}
+
+// Require wraps a handler with session authentication (if users are
+// configured) and a minimum role. Browsers use the session cookie and must
+// send the CSRF token on state-changing requests; scripts use an API token
+// ("Authorization: Bearer nmw_...") limited to its scopes, or HTTP Basic
+// Auth, which is throttled like the login form. Local processes on the Unix
+// socket are logged in by their user or group. With client certificates
+// required, other callers must also present one issued to the same user.
+func (m *Middleware) Require(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return m.require(role, false, next)
+}
+
+// AllowEnrol wraps a handler that users who still have to enrol TOTP may
+// call, such as the enrolment itself and logging out
+func (m *Middleware) AllowEnrol(next http.HandlerFunc) http.HandlerFunc {
+	return m.require(auth.RoleViewer, true, next)
+}
+
+func (m *Middleware) require(role auth.Role, allowEnrol bool, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth if no credentials configured
-		if m.Username == "" && m.Password == "" {
+		// Skip auth if no users configured
+		if !m.AuthEnabled() {
+			audit.Keep(r)
 			next(w, r)
 			return
 		}
 
-		user, pass, ok := r.BasicAuth()
+		sess, ok := m.authenticate(w, r)
 		if !ok {
-			w.Header().Set("WWW-Authenticate", `Basic realm="nm-webui"`)
-			httputil.JSONError(w, http.StatusUnauthorized, "Authentication required", "")
 			return
 		}
+		audit.SetActor(r, sess.User)
+		logger.SetActor(r.Context(), sess.User)
+		if !sess.Peer && !m.checkClientCert(w, r, sess) {
+			return
+		}
+		if sess.EnrolTOTP && !allowEnrol {
+			httputil.JSONError(w, http.StatusForbidden, "Two-factor enrolment required", "Set up an authenticator app from the account menu")
+			return
+		}
+		if sess.Peer && accountPath(r.URL.Path) {
+			httputil.JSONError(w, http.StatusForbidden, "Permission denied", "Unix socket clients have no web UI account")
+			return
+		}
+		if sess.TokenID != "" && !auth.ScopeAllows(sess.Scopes, r) {
+			httputil.JSONError(w, http.StatusForbidden, "Permission denied", "The API token's scopes do not cover this endpoint")
+			return
+		}
+		if sess.Role < role {
+			httputil.JSONError(w, http.StatusForbidden, "Permission denied", "Requires the "+role.String()+" role")
+			return
+		}
+		next(w, r.WithContext(auth.WithSession(r.Context(), sess)))
+	}
+}
 
-		// Constant-time comparison to prevent timing attacks
-		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(m.Username)) == 1
-		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(m.Password)) == 1
+// authenticate resolves the caller's session, writing an error response
+// if there is none
+func (m *Middleware) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Session, bool) {
+	if sess, ok := m.authenticatePeer(r); ok {
+		return sess, true
+	}
+	if secret, ok := bearerToken(r); ok {
+		return m.authenticateToken(w, r, secret)
+	}
 
-		if !userMatch || !passMatch {
-			w.Header().Set("WWW-Authenticate", `Basic realm="nm-webui"`)
-			httputil.JSONError(w, http.StatusUnauthorized, "Invalid credentials", "")
-			return
+	if token := auth.Token(r); token != "" {
+		if sess, ok := m.Sessions.Get(token); ok {
+			// Role changes and deleted users take effect immediately
+			user, ok := m.Users.Get(sess.User)
+			if !ok {
+				m.Sessions.Delete(token)
+				unauthorized(w, "Bearer", "Authentication required", "")
+				return nil, false
+			}
+			sess.Role = user.Role
+			sess.EnrolTOTP = m.Users.NeedsEnrolment(user)
+
+			if !safeMethod(r.Method) {
+				token := r.Header.Get(auth.CSRFHeader)
+				if subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+					httputil.JSONError(w, http.StatusForbidden, "Invalid CSRF token", "Reload the page and try again")
+					return nil, false
+				}
+			}
+			return sess, true
+		}
+	}
+
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		unauthorized(w, "Bearer", "Authentication required", "")
+		return nil, false
+	}
+
+	addr := auth.ClientAddr(r)
+	if wait, locked := m.Limiter.Locked(addr); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httputil.JSONError(w, http.StatusTooManyRequests, "Too many failed logins", "Try again in "+wait.Round(1e9).String())
+		return nil, false
+	}
+	user, ok := m.Users.Authenticate(name, pass)
+	if !ok {
+		audit.Keep(r)
+		if m.Limiter.Fail(addr) {
+			m.logger.Warn("auth", "lockout").
+				WithContext(r.Context()).
+				WithExtra("remote", addr).
+				Commit()
 		}
+		unauthorized(w, "Basic", "Invalid credentials", "")
+		return nil, false
+	}
+	m.Limiter.Success(addr)
+
+	// Basic Auth cannot carry a second factor
+	if user.TOTPEnabled() || m.Users.NeedsEnrolment(user) {
+		unauthorized(w, "Basic", "Two-factor authentication required", "Log in through the web UI")
+		return nil, false
+	}
 
-		next(w, r)
+	return &auth.Session{User: user.Name, Role: user.Role, Remote: r.RemoteAddr}, true
+}
+
+// unauthorized writes a 401 with the challenge for scheme. Basic is only
+// offered to clients that sent Basic credentials, since browsers answer it
+// with their own login dialog over the web UI's login page.
+func unauthorized(w http.ResponseWriter, scheme, msg, detail string) {
+	challenge := scheme + ` realm="nm-webui"`
+	if scheme == "Basic" {
+		challenge += `, charset="UTF-8"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	httputil.JSONError(w, http.StatusUnauthorized, msg, detail)
+}
+
+// checkClientCert refuses revoked or unknown client certificates, and
+// when they are required, a certificate for a different user
+func (m *Middleware) checkClientCert(w http.ResponseWriter, r *http.Request, sess *auth.Session) bool {
+	certUser, present, err := m.Certs.ClientUser(r)
+	if err != nil {
+		httputil.JSONError(w, http.StatusUnauthorized, "Client certificate rejected", err.Error())
+		return false
+	}
+	if !m.Certs.ClientAuth().Required() {
+		return true
+	}
+	if !present {
+		httputil.JSONError(w, http.StatusUnauthorized, "Client certificate required", "")
+		return false
+	}
+	if certUser != sess.User {
+		httputil.JSONError(w, http.StatusForbidden, "Client certificate is for another user", "")
+		return false
+	}
+	return true
+}
+
+// authenticateToken resolves an API token to its user, whose current role
+// still applies on top of the token's scopes
+func (m *Middleware) authenticateToken(w http.ResponseWriter, r *http.Request, secret string) (*auth.Session, bool) {
+	addr := auth.ClientAddr(r)
+	if wait, locked := m.Limiter.Locked(addr); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httputil.JSONError(w, http.StatusTooManyRequests, "Too many failed logins", "Try again in "+wait.Round(1e9).String())
+		return nil, false
+	}
+
+	token, ok := m.Tokens.Use(secret, addr)
+	if !ok {
+		audit.Keep(r)
+		if m.Limiter.Fail(addr) {
+			m.logger.Warn("auth", "lockout").
+				WithContext(r.Context()).
+				WithExtra("remote", addr).
+				Commit()
+		}
+		unauthorized(w, "Bearer", "Invalid or expired API token", "")
+		return nil, false
+	}
+	user, ok := m.Users.Get(token.User)
+	if !ok || m.Users.NeedsEnrolment(user) {
+		unauthorized(w, "Bearer", "API token owner cannot log in", "")
+		return nil, false
+	}
+	m.Limiter.Success(addr)
+
+	return &auth.Session{
+		User:    user.Name,
+		Role:    user.Role,
+		Remote:  r.RemoteAddr,
+		TokenID: token.ID,
+		Scopes:  token.Scopes,
+	}, true
+}
+
+// authenticatePeer logs in a Unix socket client whose local user or group
+// has a role. Clients that send credentials use those instead, and others
+// fall back to the usual logins.
+func (m *Middleware) authenticatePeer(r *http.Request) (*auth.Session, bool) {
+	peer, ok := listen.PeerOf(r)
+	if !ok || r.Header.Get("Authorization") != "" || auth.Token(r) != "" {
+		return nil, false
+	}
+	role, ok := m.Peers.Role(peer.UID, peer.GID)
+	if !ok {
+		return nil, false
+	}
+	return &auth.Session{
+		User:   auth.PeerName(peer.UID),
+		Role:   role,
+		Remote: listen.UnixInterface + " pid " + strconv.Itoa(int(peer.PID)),
+		Peer:   true,
+	}, true
+}
+
+// accountPath reports whether a path manages the caller's own web UI
+// account
+func accountPath(path string) bool {
+	for _, prefix := range []string{"/api/auth/password", "/api/auth/totp", "/api/auth/tokens"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// safeMethod reports whether a method does not change state
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// RequestIDHeader carries a request's ID, in responses and from proxies
+const RequestIDHeader = "X-Request-ID"
+
+// withRequestID gives every request an ID, returned in X-Request-ID and
+// carried by the log entries the request causes, down to the commands it
+// runs. An ID set by a proxy in front is kept if it looks sane.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logger.WithRequest(r.Context(), id)))
+	})
+}
+
+// newRequestID returns a random ID of 16 hex digits
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts up to 64 letters, digits, dots, dashes and
+// underscores, which is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
 	}
+	return true
 }
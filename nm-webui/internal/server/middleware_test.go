@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nm-webui/internal/auth"
+	"nm-webui/internal/logger"
+)
+
+const testPassword = "Correct-Horse-42"
+
+// newTestMiddleware returns a middleware with an admin "alice" and a viewer
+// "victor", both using testPassword, and a limiter that locks out after
+// three failures
+func newTestMiddleware(t *testing.T) *Middleware {
+	t.Helper()
+	users, err := auth.LoadUsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Add("alice", testPassword, auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Add("victor", testPassword, auth.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.LoadTokens("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := auth.NewSessionStore(time.Hour, time.Hour)
+	limiter := auth.NewLoginLimiter(3, time.Minute)
+	return NewMiddleware(users, tokens, nil, sessions, limiter, nil, logger.NewDefault())
+}
+
+// okHandler answers 200 with the caller's user name
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(auth.Username(r)))
+}
+
+// serve runs one request through Require(role)
+func serve(m *Middleware, role auth.Role, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	m.Require(role, okHandler)(w, r)
+	return w
+}
+
+// withCookie adds a session cookie to a request
+func withCookie(r *http.Request, token string) *http.Request {
+	r.AddCookie(&http.Cookie{Name: auth.CookieName, Value: token})
+	return r
+}
+
+func TestRequireCSRF(t *testing.T) {
+	m := newTestMiddleware(t)
+	sess, token := m.Sessions.Create("alice", "192.0.2.1:1000", "test")
+
+	tests := []struct {
+		name   string
+		method string
+		csrf   string
+		want   int
+	}{
+		{"GET needs no token", http.MethodGet, "", http.StatusOK},
+		{"HEAD needs no token", http.MethodHead, "", http.StatusOK},
+		{"POST without token", http.MethodPost, "", http.StatusForbidden},
+		{"POST with wrong token", http.MethodPost, strings.Repeat("0", len(sess.CSRFToken)), http.StatusForbidden},
+		{"POST with token", http.MethodPost, sess.CSRFToken, http.StatusOK},
+		{"DELETE with token", http.MethodDelete, sess.CSRFToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withCookie(httptest.NewRequest(tt.method, "/api/test", nil), token)
+			if tt.csrf != "" {
+				r.Header.Set(auth.CSRFHeader, tt.csrf)
+			}
+			if w := serve(m, auth.RoleViewer, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	m := newTestMiddleware(t)
+	_, admin := m.Sessions.Create("alice", "192.0.2.1:1000", "test")
+	_, viewer := m.Sessions.Create("victor", "192.0.2.1:1001", "test")
+
+	get := func(token string, role auth.Role) int {
+		return serve(m, role, withCookie(httptest.NewRequest(http.MethodGet, "/api/test", nil), token)).Code
+	}
+	if code := get(admin, auth.RoleAdmin); code != http.StatusOK {
+		t.Errorf("admin: status = %d", code)
+	}
+	if code := get(viewer, auth.RoleOperator); code != http.StatusForbidden {
+		t.Errorf("viewer on an operator route: status = %d, want 403", code)
+	}
+	if code := get("not-a-session", auth.RoleViewer); code != http.StatusUnauthorized {
+		t.Errorf("unknown cookie: status = %d, want 401", code)
+	}
+
+	// Deleting the user ends its sessions at the next request
+	if err := m.Users.Delete("victor"); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(viewer, auth.RoleViewer); code != http.StatusUnauthorized {
+		t.Errorf("deleted user: status = %d, want 401", code)
+	}
+	if _, ok := m.Sessions.Get(viewer); ok {
+		t.Error("session of a deleted user survived")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	m := newTestMiddleware(t)
+	request := func(user, password string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+		r.RemoteAddr = "198.51.100.9:4000"
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		return r
+	}
+
+	w := serve(m, auth.RoleViewer, request("alice", testPassword))
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("valid credentials: status = %d, body %q", w.Code, w.Body.String())
+	}
+
+	// Browsers would answer a Basic challenge with their own dialog, so it
+	// is only sent to clients that tried Basic
+	w = serve(m, auth.RoleViewer, request("", ""))
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer ") {
+		t.Errorf("no credentials: status = %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	w = serve(m, auth.RoleViewer, request("alice", "wrong"))
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), `Basic realm="nm-webui"`) {
+		t.Errorf("wrong password: status = %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// The third failure locks the address out, even for the right password
+	serve(m, auth.RoleViewer, request("alice", "wrong"))
+	serve(m, auth.RoleViewer, request("alice", "wrong"))
+	w = serve(m, auth.RoleViewer, request("alice", testPassword))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("locked out: status = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	m := newTestMiddleware(t)
+	_, admin, err := m.Tokens.Create("alice", "script", []string{"tunnels", "status:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, viewer, err := m.Tokens.Create("victor", "monitor", []string{"*"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		method string
+		path   string
+		role   auth.Role
+		want   int
+	}{
+		{"in scope", admin, http.MethodPost, "/api/ssh/tunnels/start", auth.RoleOperator, http.StatusOK},
+		{"read scope", admin, http.MethodGet, "/api/status", auth.RoleViewer, http.StatusOK},
+		{"read scope write", admin, http.MethodPost, "/api/status", auth.RoleViewer, http.StatusForbidden},
+		{"out of scope", admin, http.MethodGet, "/api/wifi/scan", auth.RoleViewer, http.StatusForbidden},
+		{"no CSRF token needed", admin, http.MethodPost, "/api/ssh/tunnels/stop", auth.RoleOperator, http.StatusOK},
+		{"owner role still applies", viewer, http.MethodPost, "/api/ssh/tunnels/start", auth.RoleOperator, http.StatusForbidden},
+		{"metrics need an operator", viewer, http.MethodGet, "/metrics", auth.RoleOperator, http.StatusForbidden},
+		{"unknown token", "nmw_0000", http.MethodGet, "/api/status", auth.RoleViewer, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer "+tt.secret)
+			w := serve(m, tt.role, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = logger.RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"none", "", false},
+		{"from a proxy", "req-42.abc_DEF", true},
+		{"too long", strings.Repeat("a", 65), false},
+		{"longest kept", strings.Repeat("a", 64), true},
+		{"unsafe characters", "id\r\nX-Evil: 1", false},
+		{"spaces", "two words", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+			if tt.header != "" {
+				r.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if echoed := w.Header().Get(RequestIDHeader); echoed != got {
+				t.Errorf("X-Request-ID %q differs from the context's %q", echoed, got)
+			}
+			if tt.keep {
+				if got != tt.header {
+					t.Errorf("ID = %q, want %q kept", got, tt.header)
+				}
+				return
+			}
+			if len(got) != 16 || !validRequestID(got) || got == tt.header {
+				t.Errorf("generated ID = %q", got)
+			}
+		})
+	}
+}
+
+func TestRequestIDTagsEntries(t *testing.T) {
+	m := newTestMiddleware(t)
+	h := withRequestID(m.Require(auth.RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		m.logger.Info("test", "handled").WithContext(r.Context()).Commit()
+		m.logger.Info("test", "as_other").WithActor("bob").WithContext(r.Context()).Commit()
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	r.Header.Set(RequestIDHeader, "trace-1")
+	r.SetBasicAuth("alice", testPassword)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := m.logger.GetEntries(&logger.Filter{Category: "test"})
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	for _, e := range entries {
+		if e.RequestID != "trace-1" {
+			t.Errorf("%s: request ID = %q", e.Action, e.RequestID)
+		}
+	}
+	if entries[1].Actor != "alice" {
+		t.Errorf("actor = %q, want the authenticated user", entries[1].Actor)
+	}
+	if entries[0].Actor != "bob" {
+		t.Errorf("actor = %q, want the one named by WithActor", entries[0].Actor)
+	}
+
+	// Entries outside a request keep the system actor and no ID
+	m.logger.Info("test", "background").WithContext(context.Background()).Commit()
+	if e := m.logger.GetEntries(&logger.Filter{Limit: 1})[0]; e.RequestID != "" || e.Actor != logger.SystemActor {
+		t.Errorf("background entry = %+v", e)
+	}
+}
@@ -2,24 +2,72 @@ package server
 
 import (
 	"embed"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"nm-webui/internal/access"
+	"nm-webui/internal/audit"
+	"nm-webui/internal/auth"
+	"nm-webui/internal/collect"
 	"nm-webui/internal/handlers"
+	"nm-webui/internal/listen"
 	"nm-webui/internal/logger"
 	"nm-webui/internal/nmcli"
 	"nm-webui/internal/ssh"
+	"nm-webui/internal/terminal"
+	"nm-webui/internal/tlscert"
 	"nm-webui/internal/types"
 )
 
 // Config holds server configuration
 type Config struct {
-	Listen   string
-	Username string
-	Password string
+	Listen []listen.Spec
+	Access *access.Policy  // nil allows every interface
+	Users  *auth.UserStore // nil disables authentication
+	Tokens *auth.TokenStore
+	Peers  *auth.PeerRoles // roles of local users on Unix sockets
+
+	// HTTPS
+	TLS           bool
+	TLSNames      []string // added to tlscert.DefaultNames
+	TLSClientAuth tlscert.ClientAuth
+
+	// Login sessions
+	SessionIdleTimeout time.Duration
+	SessionMaxAge      time.Duration
+	LoginMaxFailures   int
+	LoginLockout       time.Duration
+
+	// Log persistence
+	LogPersist  bool
+	LogMaxSize  int64 // bytes per file
+	LogMaxFiles int   // rotated files to keep
+	LogMaxAge   time.Duration
+	LogFlush    time.Duration    // how often buffered entries are written
+	LogJournal  []collect.Source // journald units whose messages are logged
+	LogFiles    []collect.Source // log files of other services to follow
+
+	// TLS syslog collector checks
+	SyslogCA         string // PEM bundle of CAs; empty uses the system's
+	SyslogServerName string // name in the collector's certificate; empty uses its address
+
+	// Audit log
+	Audit         bool
+	AuditMaxSize  int64 // bytes per file
+	AuditMaxFiles int   // rotated files to keep
+
+	// Web terminal
+	TerminalUser        string
+	TerminalIdleTimeout time.Duration
+	TerminalMaxSessions int
+	TerminalRecord      bool
+	TerminalRecordAge   time.Duration
+	TerminalRecordSize  int64 // bytes
 }
 
 // Server is the main HTTP server
@@ -33,6 +81,11 @@ type Server struct {
 	// SSH managers
 	sshKeyMgr    *ssh.KeyManager
 	sshTunnelMgr *ssh.TunnelManager
+	sshPhoneHome *ssh.PhoneHome
+
+	terminals *terminal.Manager
+	audit     *audit.Log // nil when disabled
+	collector *collect.Collector
 	
 	// Activity log (legacy - kept for backwards compatibility with status handler)
 	logMu   sync.RWMutex
@@ -44,6 +97,11 @@ type Server struct {
 const (
 	sshKeyDir     = "/var/lib/nm-webui/ssh"
 	sshDataDir    = "/var/lib/nm-webui/data"
+	recordingDir  = "/var/lib/nm-webui/data/recordings"
+	auditDir      = "/var/lib/nm-webui/data/audit"
+	logDir        = "/var/lib/nm-webui/data/logs"
+	logSettings   = "/var/lib/nm-webui/data/logging.json"
+	tlsDir        = "/var/lib/nm-webui/tls"
 	configDataDir = "/etc/haxinator"
 )
 
@@ -51,6 +109,25 @@ const (
 func New(cfg *Config, staticFS embed.FS) (*Server, error) {
 	// Create the central logger
 	appLogger := logger.NewDefault()
+	if cfg.LogPersist {
+		err := appLogger.Persist(logger.StoreConfig{
+			Dir:           logDir,
+			MaxSize:       cfg.LogMaxSize,
+			MaxFiles:      cfg.LogMaxFiles,
+			MaxAge:        cfg.LogMaxAge,
+			FlushInterval: cfg.LogFlush,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log store: %w", err)
+		}
+	}
+	if err := appLogger.SetSyslogTLS(cfg.SyslogCA, cfg.SyslogServerName); err != nil {
+		return nil, err
+	}
+	if err := appLogger.LoadSettings(logSettings); err != nil {
+		// Keep running on defaults; saving fixes the file
+		appLogger.Warn("system", "log_settings").WithError(err).Commit()
+	}
 	
 	// Create nmcli client with logger
 	nmcliClient := nmcli.NewWithLogger(appLogger)
@@ -58,9 +135,44 @@ func New(cfg *Config, staticFS embed.FS) (*Server, error) {
 	// Create SSH managers
 	sshKeyMgr := ssh.NewKeyManager(sshKeyDir, appLogger)
 	sshTunnelMgr := ssh.NewTunnelManager(sshDataDir, sshKeyMgr, appLogger)
+	sshPhoneHome := ssh.NewPhoneHome(sshDataDir, sshTunnelMgr, listen.LocalAddr(cfg.Listen), appLogger)
+
+	termCfg := terminal.Config{
+		User:             cfg.TerminalUser,
+		IdleTimeout:      cfg.TerminalIdleTimeout,
+		MaxSessions:      cfg.TerminalMaxSessions,
+		RecordingMaxAge:  cfg.TerminalRecordAge,
+		RecordingMaxSize: cfg.TerminalRecordSize,
+	}
+	if cfg.TerminalRecord {
+		termCfg.RecordingDir = recordingDir
+	}
+	terminals := terminal.NewManager(termCfg, appLogger)
+
+	var certs *tlscert.Manager
+	if cfg.TLS {
+		var err error
+		certs, err = tlscert.NewManager(tlsDir, append(tlscert.DefaultNames(), cfg.TLSNames...), appLogger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up TLS certificate: %w", err)
+		}
+		certs.SetClientAuth(cfg.TLSClientAuth)
+	}
+
+	var auditLog *audit.Log
+	if cfg.Audit {
+		var err error
+		auditLog, err = audit.Open(auditDir, cfg.AuditMaxSize, cfg.AuditMaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+	}
 
 	// Create middleware
-	mw := NewMiddleware(cfg.Username, cfg.Password)
+	sessions := auth.NewSessionStore(cfg.SessionIdleTimeout, cfg.SessionMaxAge)
+	sessions.OnEnd(terminals.CloseAuthSession)
+	limiter := auth.NewLoginLimiter(cfg.LoginMaxFailures, cfg.LoginLockout)
+	mw := NewMiddleware(cfg.Users, cfg.Tokens, cfg.Peers, sessions, limiter, certs, appLogger)
 
 	s := &Server{
 		config:       cfg,
@@ -70,6 +182,10 @@ func New(cfg *Config, staticFS embed.FS) (*Server, error) {
 		logger:       appLogger,
 		sshKeyMgr:    sshKeyMgr,
 		sshTunnelMgr: sshTunnelMgr,
+		sshPhoneHome: sshPhoneHome,
+		terminals:    terminals,
+		audit:        auditLog,
+		collector:    collect.Start(appLogger, cfg.LogJournal, cfg.LogFiles),
 		logs:         make([]types.LogEntry, 0, 100),
 		maxLogs:      100,
 	}
@@ -79,17 +195,32 @@ func New(cfg *Config, staticFS embed.FS) (*Server, error) {
 	
 	// Log startup
 	appLogger.Info("system", "startup").
-		WithExtra("listen", cfg.Listen).
+		WithExtra("listen", listenList(cfg.Listen)).
 		Commit()
 
 	return s, nil
 }
 
+// listenList joins listen specs for logging
+func listenList(specs []listen.Spec) string {
+	list := make([]string, len(specs))
+	for i, spec := range specs {
+		list[i] = spec.String()
+	}
+	return strings.Join(list, ", ")
+}
+
 // Logger returns the server's logger instance (for external use)
 func (s *Server) Logger() *logger.Logger {
 	return s.logger
 }
 
+// Close stops collecting other services' logs and saves the system log
+func (s *Server) Close() error {
+	s.collector.Stop()
+	return s.logger.Close()
+}
+
 // setupRoutes configures all HTTP routes
 func (s *Server) setupRoutes(staticFS embed.FS) {
 	// Create handlers
@@ -98,8 +229,39 @@ func (s *Server) setupRoutes(staticFS embed.FS) {
 	statusHandler := handlers.NewStatusHandler(s.nmcli, s.GetLogs)
 	networkHandler := handlers.NewNetworkHandler(s.nmcli, s.AddLog)
 	logsHandler := handlers.NewLogsHandler(s.logger)
-	sshHandler := handlers.NewSSHHandler(s.sshKeyMgr, s.sshTunnelMgr, s.AddLog)
+	sshHandler := handlers.NewSSHHandler(s.sshKeyMgr, s.sshTunnelMgr, s.sshPhoneHome, s.AddLog)
 	systemHandler := handlers.NewSystemHandler(s.logger)
+	terminalHandler := handlers.NewTerminalHandler(s.terminals, s.AddLog)
+	tlsHandler := handlers.NewTLSHandler(s.middleware.Certs, s.middleware.Users, s.AddLog)
+	auditHandler := handlers.NewAuditHandler(s.audit)
+	// Scrapes run nmcli every few seconds, too often to log
+	metricsHandler := handlers.NewMetricsHandler(nmcli.New(), s.sshTunnelMgr, s.logger)
+	authHandler := handlers.NewAuthHandler(s.middleware.Users, s.middleware.Tokens, s.middleware.Sessions, s.middleware.Limiter, s.middleware.Certs, s.logger)
+
+	// Routes use Auth for anything a viewer may do (read status and logs),
+	// Require(auth.RoleOperator) for connecting WiFi and starting or
+	// stopping tunnels, and Require(auth.RoleAdmin) for configuration,
+	// keys, users, the terminal and power state.
+
+	// API routes - Auth (login is public)
+	s.mux.HandleFunc("/api/auth/login", authHandler.Login)
+	s.mux.HandleFunc("/api/auth/logout", s.middleware.AllowEnrol(authHandler.Logout))
+	s.mux.HandleFunc("/api/auth/session", s.middleware.AllowEnrol(authHandler.Session))
+	s.mux.HandleFunc("/api/auth/sessions", s.middleware.Auth(authHandler.ListSessions))
+	s.mux.HandleFunc("/api/auth/sessions/revoke", s.middleware.Auth(authHandler.RevokeSession))
+	s.mux.HandleFunc("/api/auth/password", s.middleware.Auth(authHandler.ChangePassword))
+	s.mux.HandleFunc("/api/auth/totp", s.middleware.AllowEnrol(authHandler.TOTPStatus))
+	s.mux.HandleFunc("/api/auth/totp/setup", s.middleware.AllowEnrol(authHandler.SetupTOTP))
+	s.mux.HandleFunc("/api/auth/totp/enable", s.middleware.AllowEnrol(authHandler.EnableTOTP))
+	s.mux.HandleFunc("/api/auth/totp/disable", s.middleware.Auth(authHandler.DisableTOTP))
+	s.mux.HandleFunc("/api/auth/totp/recovery", s.middleware.Auth(authHandler.RegenerateRecovery))
+	s.mux.HandleFunc("/api/auth/tokens", s.middleware.Auth(authHandler.ListTokens))
+	s.mux.HandleFunc("/api/auth/tokens/create", s.middleware.Auth(authHandler.CreateToken))
+	s.mux.HandleFunc("/api/auth/tokens/revoke", s.middleware.Auth(authHandler.RevokeToken))
+	s.mux.HandleFunc("/api/auth/users", s.middleware.Require(auth.RoleAdmin, authHandler.ListUsers))
+	s.mux.HandleFunc("/api/auth/users/add", s.middleware.Require(auth.RoleAdmin, authHandler.AddUser))
+	s.mux.HandleFunc("/api/auth/users/update", s.middleware.Require(auth.RoleAdmin, authHandler.UpdateUser))
+	s.mux.HandleFunc("/api/auth/users/delete", s.middleware.Require(auth.RoleAdmin, authHandler.DeleteUser))
 
 	// API routes - Status
 	s.mux.HandleFunc("/api/status", s.middleware.Auth(statusHandler.GetStatus))
@@ -109,64 +271,108 @@ func (s *Server) setupRoutes(staticFS embed.FS) {
 	s.mux.HandleFunc("/api/status/ping", s.middleware.Auth(statusHandler.GetPing))
 
 	// API routes - System
-	s.mux.HandleFunc("/api/system/shutdown", s.middleware.Auth(systemHandler.Shutdown))
-	s.mux.HandleFunc("/api/system/reboot", s.middleware.Auth(systemHandler.Reboot))
+	s.mux.HandleFunc("/api/system/shutdown", s.middleware.Require(auth.RoleAdmin, systemHandler.Shutdown))
+	s.mux.HandleFunc("/api/system/reboot", s.middleware.Require(auth.RoleAdmin, systemHandler.Reboot))
+
+	// API routes - HTTPS certificates (the device CA and CRL are public)
+	s.mux.HandleFunc("/api/tls", s.middleware.Auth(tlsHandler.Info))
+	s.mux.HandleFunc("/api/tls/ca", tlsHandler.DownloadCA)
+	s.mux.HandleFunc("/api/tls/upload", s.middleware.Require(auth.RoleAdmin, tlsHandler.Upload))
+	s.mux.HandleFunc("/api/tls/reset", s.middleware.Require(auth.RoleAdmin, tlsHandler.Reset))
+	s.mux.HandleFunc("/api/tls/crl", tlsHandler.DownloadCRL)
+	s.mux.HandleFunc("/api/tls/clients", s.middleware.Require(auth.RoleAdmin, tlsHandler.ListClients))
+	s.mux.HandleFunc("/api/tls/clients/issue", s.middleware.Require(auth.RoleAdmin, tlsHandler.IssueClient))
+	s.mux.HandleFunc("/api/tls/clients/revoke", s.middleware.Require(auth.RoleAdmin, tlsHandler.RevokeClient))
 
 	// API routes - WiFi
 	s.mux.HandleFunc("/api/wifi/scan", s.middleware.Auth(wifiHandler.Scan))
-	s.mux.HandleFunc("/api/wifi/connect", s.middleware.Auth(wifiHandler.Connect))
-	s.mux.HandleFunc("/api/wifi/disconnect", s.middleware.Auth(wifiHandler.Disconnect))
-	s.mux.HandleFunc("/api/wifi/forget", s.middleware.Auth(wifiHandler.Forget))
-	s.mux.HandleFunc("/api/wifi/priority", s.middleware.Auth(wifiHandler.SetPriority))
-	s.mux.HandleFunc("/api/wifi/hotspot", s.middleware.Auth(wifiHandler.Hotspot))
+	s.mux.HandleFunc("/api/wifi/connect", s.middleware.Require(auth.RoleOperator, wifiHandler.Connect))
+	s.mux.HandleFunc("/api/wifi/disconnect", s.middleware.Require(auth.RoleOperator, wifiHandler.Disconnect))
+	s.mux.HandleFunc("/api/wifi/forget", s.middleware.Require(auth.RoleOperator, wifiHandler.Forget))
+	s.mux.HandleFunc("/api/wifi/priority", s.middleware.Require(auth.RoleOperator, wifiHandler.SetPriority))
+	s.mux.HandleFunc("/api/wifi/hotspot", s.middleware.Require(auth.RoleOperator, wifiHandler.Hotspot))
 
 	// API routes - Connections
 	s.mux.HandleFunc("/api/connections", s.middleware.Auth(connHandler.List))
-	s.mux.HandleFunc("/api/connections/activate", s.middleware.Auth(connHandler.Activate))
-	s.mux.HandleFunc("/api/connections/deactivate", s.middleware.Auth(connHandler.Deactivate))
-	s.mux.HandleFunc("/api/connections/delete/", s.middleware.Auth(connHandler.Delete))
-	s.mux.HandleFunc("/api/connections/share", s.middleware.Auth(connHandler.Share))
+	s.mux.HandleFunc("/api/connections/activate", s.middleware.Require(auth.RoleOperator, connHandler.Activate))
+	s.mux.HandleFunc("/api/connections/deactivate", s.middleware.Require(auth.RoleOperator, connHandler.Deactivate))
+	s.mux.HandleFunc("/api/connections/delete/", s.middleware.Require(auth.RoleOperator, connHandler.Delete))
+	s.mux.HandleFunc("/api/connections/share", s.middleware.Require(auth.RoleOperator, connHandler.Share))
 
 	// API routes - Network
 	s.mux.HandleFunc("/api/network/interfaces", s.middleware.Auth(networkHandler.ListInterfaces))
-	s.mux.HandleFunc("/api/network/share", s.middleware.Auth(networkHandler.ToggleSharing))
+	s.mux.HandleFunc("/api/network/share", s.middleware.Require(auth.RoleOperator, networkHandler.ToggleSharing))
 
 	// API routes - SSH Keys
 	s.mux.HandleFunc("/api/ssh/keys", s.middleware.Auth(sshHandler.ListKeys))
-	s.mux.HandleFunc("/api/ssh/keys/upload", s.middleware.Auth(sshHandler.UploadKey))
-	s.mux.HandleFunc("/api/ssh/keys/delete", s.middleware.Auth(sshHandler.DeleteKey))
-	s.mux.HandleFunc("/api/ssh/keys/generate", s.middleware.Auth(sshHandler.GenerateKey))
+	s.mux.HandleFunc("/api/ssh/keys/upload", s.middleware.Require(auth.RoleAdmin, sshHandler.UploadKey))
+	s.mux.HandleFunc("/api/ssh/keys/delete", s.middleware.Require(auth.RoleAdmin, sshHandler.DeleteKey))
+	s.mux.HandleFunc("/api/ssh/keys/generate", s.middleware.Require(auth.RoleAdmin, sshHandler.GenerateKey))
 	s.mux.HandleFunc("/api/ssh/keys/public", s.middleware.Auth(sshHandler.GetPublicKey))
 	s.mux.HandleFunc("/api/ssh/keys/download", s.middleware.Auth(sshHandler.DownloadPublicKey))
+	s.mux.HandleFunc("/api/ssh/keys/unlock", s.middleware.Require(auth.RoleOperator, sshHandler.UnlockKey))
+	s.mux.HandleFunc("/api/ssh/keys/lock", s.middleware.Require(auth.RoleOperator, sshHandler.LockKey))
+	s.mux.HandleFunc("/api/ssh/keys/cert/upload", s.middleware.Require(auth.RoleAdmin, sshHandler.UploadCert))
+	s.mux.HandleFunc("/api/ssh/keys/cert/delete", s.middleware.Require(auth.RoleAdmin, sshHandler.DeleteCert))
 
 	// API routes - SSH Tunnels
 	s.mux.HandleFunc("/api/ssh/tunnels", s.middleware.Auth(sshHandler.ListTunnels))
-	s.mux.HandleFunc("/api/ssh/tunnels/create", s.middleware.Auth(sshHandler.CreateTunnel))
-	s.mux.HandleFunc("/api/ssh/tunnels/start", s.middleware.Auth(sshHandler.StartTunnel))
-	s.mux.HandleFunc("/api/ssh/tunnels/stop", s.middleware.Auth(sshHandler.StopTunnel))
-	s.mux.HandleFunc("/api/ssh/tunnels/delete", s.middleware.Auth(sshHandler.DeleteTunnel))
+	s.mux.HandleFunc("/api/ssh/tunnels/create", s.middleware.Require(auth.RoleAdmin, sshHandler.CreateTunnel))
+	s.mux.HandleFunc("/api/ssh/tunnels/start", s.middleware.Require(auth.RoleOperator, sshHandler.StartTunnel))
+	s.mux.HandleFunc("/api/ssh/tunnels/stop", s.middleware.Require(auth.RoleOperator, sshHandler.StopTunnel))
+	s.mux.HandleFunc("/api/ssh/tunnels/delete", s.middleware.Require(auth.RoleAdmin, sshHandler.DeleteTunnel))
+	s.mux.HandleFunc("/api/ssh/tunnels/history", s.middleware.Auth(sshHandler.TunnelHistory))
+
+	// API routes - SSH Phone Home
+	// The rendezvous server, user and key are not for viewers
+	s.mux.HandleFunc("/api/ssh/phonehome", s.middleware.Require(auth.RoleOperator, sshHandler.GetPhoneHome))
+	s.mux.HandleFunc("/api/ssh/phonehome/config", s.middleware.Require(auth.RoleAdmin, sshHandler.ConfigurePhoneHome))
+	s.mux.HandleFunc("/api/ssh/phonehome/reconnect", s.middleware.Require(auth.RoleOperator, sshHandler.ReconnectPhoneHome))
 
 	// API routes - Logs (new comprehensive logging)
 	s.mux.HandleFunc("/api/logs", s.middleware.Auth(logsHandler.GetLogs))
-	s.mux.HandleFunc("/api/logs/settings", s.middleware.Auth(func(w http.ResponseWriter, r *http.Request) {
+	getLogSettings := s.middleware.Auth(logsHandler.GetSettings)
+	updateLogSettings := s.middleware.Require(auth.RoleAdmin, logsHandler.UpdateSettings)
+	s.mux.HandleFunc("/api/logs/settings", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
-			logsHandler.GetSettings(w, r)
+			getLogSettings(w, r)
 		} else {
-			logsHandler.UpdateSettings(w, r)
+			updateLogSettings(w, r)
 		}
-	}))
-	s.mux.HandleFunc("/api/logs/toggle", s.middleware.Auth(logsHandler.Toggle))
-	s.mux.HandleFunc("/api/logs/clear", s.middleware.Auth(logsHandler.Clear))
+	})
+	s.mux.HandleFunc("/api/logs/toggle", s.middleware.Require(auth.RoleAdmin, logsHandler.Toggle))
+	s.mux.HandleFunc("/api/logs/clear", s.middleware.Require(auth.RoleAdmin, logsHandler.Clear))
 	s.mux.HandleFunc("/api/logs/stats", s.middleware.Auth(logsHandler.Stats))
+	s.mux.HandleFunc("/api/logs/export", s.middleware.Auth(logsHandler.Export))
+	s.mux.HandleFunc("/api/logs/stream", s.middleware.Auth(logsHandler.Stream))
+
+	// API routes - Audit log
+	s.mux.HandleFunc("/api/audit", s.middleware.Require(auth.RoleAdmin, auditHandler.List))
+	s.mux.HandleFunc("/api/audit/download", s.middleware.Require(auth.RoleAdmin, auditHandler.Download))
+	s.mux.HandleFunc("/api/audit/verify", s.middleware.Require(auth.RoleAdmin, auditHandler.Verify))
 
 	// API routes - Configure
-	configHandler := handlers.NewConfigureHandler(configDataDir, s.AddLogWithCategory)
-	s.mux.HandleFunc("/api/configure/files", s.middleware.Auth(configHandler.GetFileStatus))
-	s.mux.HandleFunc("/api/configure/view", s.middleware.Auth(configHandler.ViewFile))
-	s.mux.HandleFunc("/api/configure/upload", s.middleware.Auth(configHandler.UploadFile))
-	s.mux.HandleFunc("/api/configure/delete", s.middleware.Auth(configHandler.DeleteFile))
-	s.mux.HandleFunc("/api/configure/networks", s.middleware.Auth(configHandler.GetNetworkConfigs))
-	s.mux.HandleFunc("/api/configure/apply", s.middleware.Auth(configHandler.ApplyNetworkConfig))
+	configHandler := handlers.NewConfigureHandler(configDataDir, s.logger, s.AddLogWithCategory)
+	s.mux.HandleFunc("/api/configure/files", s.middleware.Require(auth.RoleAdmin, configHandler.GetFileStatus))
+	s.mux.HandleFunc("/api/configure/view", s.middleware.Require(auth.RoleAdmin, configHandler.ViewFile))
+	s.mux.HandleFunc("/api/configure/upload", s.middleware.Require(auth.RoleAdmin, configHandler.UploadFile))
+	s.mux.HandleFunc("/api/configure/delete", s.middleware.Require(auth.RoleAdmin, configHandler.DeleteFile))
+	s.mux.HandleFunc("/api/configure/authorized-keys", s.middleware.Require(auth.RoleAdmin, configHandler.ListAuthorizedKeys))
+	s.mux.HandleFunc("/api/configure/authorized-keys/add", s.middleware.Require(auth.RoleAdmin, configHandler.AddAuthorizedKey))
+	s.mux.HandleFunc("/api/configure/authorized-keys/update", s.middleware.Require(auth.RoleAdmin, configHandler.UpdateAuthorizedKey))
+	s.mux.HandleFunc("/api/configure/authorized-keys/delete", s.middleware.Require(auth.RoleAdmin, configHandler.DeleteAuthorizedKey))
+	s.mux.HandleFunc("/api/configure/networks", s.middleware.Require(auth.RoleAdmin, configHandler.GetNetworkConfigs))
+	s.mux.HandleFunc("/api/configure/apply", s.middleware.Require(auth.RoleAdmin, configHandler.ApplyNetworkConfig))
+
+	// API routes - Terminal
+	s.mux.HandleFunc("/api/terminal/ws", s.middleware.Require(auth.RoleAdmin, terminalHandler.Connect))
+	s.mux.HandleFunc("/api/terminal/sessions", s.middleware.Require(auth.RoleAdmin, terminalHandler.ListSessions))
+	s.mux.HandleFunc("/api/terminal/close", s.middleware.Require(auth.RoleAdmin, terminalHandler.CloseSession))
+	s.mux.HandleFunc("/api/terminal/recordings", s.middleware.Require(auth.RoleAdmin, terminalHandler.ListRecordings))
+	s.mux.HandleFunc("/api/terminal/recordings/download", s.middleware.Require(auth.RoleAdmin, terminalHandler.DownloadRecording))
+
+	// Prometheus metrics
+	s.mux.HandleFunc("/metrics", s.middleware.Require(auth.RoleOperator, metricsHandler.Metrics))
 
 	// Static files
 	staticSubFS, err := fs.Sub(staticFS, "static")
@@ -176,18 +382,38 @@ func (s *Server) setupRoutes(staticFS embed.FS) {
 	s.mux.Handle("/", http.FileServer(http.FS(staticSubFS)))
 }
 
-// Handler returns the HTTP handler
+// Handler returns the HTTP handler, behind the access policy if there is
+// one and recording state changes in the audit log
 func (s *Server) Handler() http.Handler {
-	return s.mux
+	var h http.Handler = s.mux
+	if s.config.Access != nil {
+		h = s.config.Access.Wrap(h, s.logger)
+	}
+	if s.audit != nil {
+		h = s.audit.Wrap(h, s.logger)
+	}
+	return withRequestID(h)
 }
 
-// AddLog adds an entry to the activity log (legacy method)
-func (s *Server) AddLog(action, detail string, success bool) {
+// AuditHead returns the sequence number and hash of the newest audit
+// entry, and false when auditing is disabled
+func (s *Server) AuditHead() (uint64, string, bool) {
+	if s.audit == nil {
+		return 0, "", false
+	}
+	seq, head := s.audit.Head()
+	return seq, head, true
+}
+
+// AddLog adds an entry to the activity log (legacy method), attributed
+// to the user making the request
+func (s *Server) AddLog(r *http.Request, action, detail string, success bool) {
 	s.logMu.Lock()
 	defer s.logMu.Unlock()
 
 	entry := types.LogEntry{
 		Time:    time.Now().Format(time.RFC3339),
+		Actor:   actorOf(r),
 		Action:  action,
 		Detail:  detail,
 		Success: success,
@@ -204,11 +430,20 @@ func (s *Server) AddLog(action, detail string, success bool) {
 		lvl = logger.ERROR
 	}
 	s.logger.Log(lvl, "action", action).
+		WithContext(r.Context()).
 		WithExtra("detail", detail).
 		WithSuccess(success).
 		Commit()
 
-	log.Printf("[%s] %s: %s (success=%v)", entry.Time, action, detail, success)
+	log.Printf("[%s] %s %s: %s (success=%v)", entry.Time, entry.Actor, action, detail, success)
+}
+
+// actorOf names the user responsible for a legacy log entry
+func actorOf(r *http.Request) string {
+	if user := auth.Username(r); user != "" {
+		return user
+	}
+	return logger.SystemActor
 }
 
 // GetLogs returns a copy of the activity log (newest first) - legacy method
@@ -228,12 +463,13 @@ func (s *Server) GetLogs() []types.LogEntry {
 }
 
 // AddLogWithCategory adds an entry to the activity log with a category
-func (s *Server) AddLogWithCategory(category, action, detail string, success bool) {
+func (s *Server) AddLogWithCategory(r *http.Request, category, action, detail string, success bool) {
 	s.logMu.Lock()
 	defer s.logMu.Unlock()
 
 	entry := types.LogEntry{
 		Time:    time.Now().Format(time.RFC3339),
+		Actor:   actorOf(r),
 		Action:  category + ":" + action,
 		Detail:  detail,
 		Success: success,
@@ -250,9 +486,10 @@ func (s *Server) AddLogWithCategory(category, action, detail string, success boo
 		lvl = logger.ERROR
 	}
 	s.logger.Log(lvl, category, action).
+		WithContext(r.Context()).
 		WithExtra("detail", detail).
 		WithSuccess(success).
 		Commit()
 
-	log.Printf("[%s] %s:%s: %s (success=%v)", entry.Time, category, action, detail, success)
+	log.Printf("[%s] %s %s:%s: %s (success=%v)", entry.Time, entry.Actor, category, action, detail, success)
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"nm-webui/internal/types"
+)
+
+// TLSConfig returns the HTTPS configuration, or nil when serving plain HTTP
+func (s *Server) TLSConfig() *tls.Config {
+	if s.middleware.Certs == nil {
+		return nil
+	}
+	return s.middleware.Certs.TLSConfig()
+}
+
+// TLSInfo describes the certificate in use
+func (s *Server) TLSInfo() types.TLSInfo {
+	if s.middleware.Certs == nil {
+		return types.TLSInfo{}
+	}
+	return s.middleware.Certs.Info()
+}
+
+// RedirectHandler sends plain HTTP requests to the same host and path over
+// HTTPS on the given port. It uses a temporary redirect so browsers do not
+// remember it if HTTPS is turned off again.
+func RedirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		url := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusFound)
+	})
+}
@@ -0,0 +1,179 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/types"
+)
+
+// certSuffix is the OpenSSH naming convention for a key's certificate
+const certSuffix = "-cert.pub"
+
+// certExpiryWarning is the longest warning period before a certificate
+// expires; short-lived certificates warn for the last quarter of their life
+const certExpiryWarning = 24 * time.Hour
+
+// certPath returns the certificate file for a key
+func (km *KeyManager) certPath(keyName string) string {
+	return km.GetKeyPath(keyName) + certSuffix
+}
+
+// loadCert reads and parses the certificate stored for a key, if any
+func (km *KeyManager) loadCert(keyName string) (*gossh.Certificate, error) {
+	content, err := os.ReadFile(km.certPath(keyName))
+	if err != nil {
+		return nil, err
+	}
+	return parseUserCert(content)
+}
+
+// parseUserCert parses a -cert.pub file and checks it is a user certificate
+func parseUserCert(content []byte) (*gossh.Certificate, error) {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(bytes.TrimSpace(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+	cert, ok := pub.(*gossh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not an OpenSSH certificate")
+	}
+	if cert.CertType != gossh.UserCert {
+		return nil, fmt.Errorf("host certificates cannot be used for login")
+	}
+	return cert, nil
+}
+
+// UploadCert stores a user certificate for an existing key as
+// <name>-cert.pub. The certificate must be issued for that key.
+func (km *KeyManager) UploadCert(keyName string, content []byte) (*types.SSHCertificate, error) {
+	keyName = filepath.Base(keyName)
+	keyContent, err := os.ReadFile(km.GetKeyPath(keyName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("key file not found")
+		}
+		return nil, err
+	}
+	pubContent, _ := os.ReadFile(km.GetKeyPath(keyName) + ".pub")
+
+	cert, err := parseUserCert(content)
+	if err != nil {
+		return nil, err
+	}
+
+	ki, ok := inspectKey(keyContent, pubContent)
+	if !ok || ki.public == nil {
+		return nil, fmt.Errorf("public key of %s is unknown - unlock it or upload its .pub first", keyName)
+	}
+	if !bytes.Equal(cert.Key.Marshal(), ki.public.Marshal()) {
+		return nil, fmt.Errorf("certificate was issued for %s, not %s", gossh.FingerprintSHA256(cert.Key), ki.fingerprint)
+	}
+
+	if err := os.WriteFile(km.certPath(keyName), append(bytes.TrimSpace(content), '\n'), 0644); err != nil {
+		return nil, err
+	}
+
+	info := certInfo(cert, time.Now())
+	km.logger.Info("ssh", "upload_cert").
+		WithExtra("name", keyName).
+		WithExtra("key_id", cert.KeyId).
+		WithExtra("valid_before", info.ValidBefore).
+		Commit()
+	return info, nil
+}
+
+// DeleteCert removes the certificate stored for a key
+func (km *KeyManager) DeleteCert(keyName string) error {
+	keyName = filepath.Base(keyName)
+	if err := os.Remove(km.certPath(keyName)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("certificate not found")
+		}
+		return err
+	}
+
+	km.logger.Info("ssh", "delete_cert").
+		WithExtra("name", keyName).
+		Commit()
+	return nil
+}
+
+// certSigner wraps signer with the key's certificate when one is stored.
+// An expired or not yet valid certificate is an error rather than a silent
+// fallback to the plain key.
+func (km *KeyManager) certSigner(keyName string, signer gossh.Signer) (gossh.Signer, error) {
+	cert, err := km.loadCert(keyName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("certificate for key %s: %v", keyName, err)
+	}
+
+	info := certInfo(cert, time.Now())
+	switch info.Status {
+	case "expired":
+		return nil, fmt.Errorf("certificate for key %s expired at %s", keyName, info.ValidBefore)
+	case "not_yet_valid":
+		return nil, fmt.Errorf("certificate for key %s is not valid before %s", keyName, info.ValidAfter)
+	case "expiring":
+		km.logger.Warn("ssh", "cert_expiring").
+			WithExtra("name", keyName).
+			WithExtra("valid_before", info.ValidBefore).
+			Commit()
+	}
+
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate for key %s: %v", keyName, err)
+	}
+	return certSigner, nil
+}
+
+// certInfo summarizes a certificate for the API
+func certInfo(cert *gossh.Certificate, now time.Time) *types.SSHCertificate {
+	info := &types.SSHCertificate{
+		KeyID:         cert.KeyId,
+		Serial:        cert.Serial,
+		Principals:    cert.ValidPrincipals,
+		CAFingerprint: gossh.FingerprintSHA256(cert.SignatureKey),
+		Status:        "valid",
+	}
+	if info.Principals == nil {
+		info.Principals = []string{}
+	}
+
+	var after, before time.Time
+	if cert.ValidAfter != 0 {
+		after = time.Unix(int64(cert.ValidAfter), 0)
+		info.ValidAfter = after.Format(time.RFC3339)
+	}
+	if cert.ValidBefore != gossh.CertTimeInfinity {
+		before = time.Unix(int64(cert.ValidBefore), 0)
+		info.ValidBefore = before.Format(time.RFC3339)
+	}
+
+	switch {
+	case !after.IsZero() && now.Before(after):
+		info.Status = "not_yet_valid"
+	case !before.IsZero() && !now.Before(before):
+		info.Status = "expired"
+	case !before.IsZero():
+		remaining := before.Sub(now)
+		info.ExpiresIn = int64(remaining.Seconds())
+		warn := certExpiryWarning
+		if !after.IsZero() && before.Sub(after)/4 < warn {
+			warn = before.Sub(after) / 4
+		}
+		if remaining < warn {
+			info.Status = "expiring"
+		}
+	}
+	return info
+}
@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/logger"
+)
+
+// testSigner generates an ed25519 signer
+func testSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testCert signs a certificate for key with ca
+func testCert(t *testing.T, ca gossh.Signer, key gossh.PublicKey, certType uint32, after, before uint64) []byte {
+	t.Helper()
+	cert := &gossh.Certificate{
+		Key:             key,
+		Serial:          7,
+		CertType:        certType,
+		KeyId:           "pi@test",
+		ValidPrincipals: []string{"pi"},
+		ValidAfter:      after,
+		ValidBefore:     before,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return gossh.MarshalAuthorizedKey(cert)
+}
+
+// newTestKeyManager returns a key manager holding one unencrypted key, "id_test"
+func newTestKeyManager(t *testing.T) (*KeyManager, gossh.PublicKey) {
+	t.Helper()
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "id_test"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewKeyManager(dir, logger.NewDefault()), signer.PublicKey()
+}
+
+func TestCertInfoStatus(t *testing.T) {
+	ca := testSigner(t)
+	key := testSigner(t).PublicKey()
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) uint64 { return uint64(now.Add(d).Unix()) }
+
+	tests := []struct {
+		name          string
+		after, before uint64
+		want          string
+	}{
+		{"forever", 0, gossh.CertTimeInfinity, "valid"},
+		{"valid", at(-time.Hour), at(30 * 24 * time.Hour), "valid"},
+		{"expiring within a day", at(-30 * 24 * time.Hour), at(time.Hour), "expiring"},
+		{"short-lived in its last quarter", at(-210 * time.Minute), at(30 * time.Minute), "expiring"},
+		{"short-lived but fresh", at(-time.Minute), at(time.Hour), "valid"},
+		{"expired", at(-2 * time.Hour), at(-time.Hour), "expired"},
+		{"not yet valid", at(time.Hour), at(2 * time.Hour), "not_yet_valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := parseUserCert(testCert(t, ca, key, gossh.UserCert, tt.after, tt.before))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info := certInfo(cert, now); info.Status != tt.want {
+				t.Errorf("status = %q, want %q", info.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadCert(t *testing.T) {
+	km, pub := newTestKeyManager(t)
+	ca := testSigner(t)
+
+	if _, err := km.UploadCert("id_test", testCert(t, ca, pub, gossh.HostCert, 0, gossh.CertTimeInfinity)); err == nil {
+		t.Error("accepted a host certificate")
+	}
+	if _, err := km.UploadCert("id_test", testCert(t, ca, testSigner(t).PublicKey(), gossh.UserCert, 0, gossh.CertTimeInfinity)); err == nil {
+		t.Error("accepted a certificate issued for another key")
+	}
+	if _, err := km.UploadCert("id_missing", testCert(t, ca, pub, gossh.UserCert, 0, gossh.CertTimeInfinity)); err == nil {
+		t.Error("accepted a certificate for a missing key")
+	}
+
+	info, err := km.UploadCert("id_test", testCert(t, ca, pub, gossh.UserCert, 0, gossh.CertTimeInfinity))
+	if err != nil {
+		t.Fatalf("UploadCert: %v", err)
+	}
+	if info.KeyID != "pi@test" || info.CAFingerprint != gossh.FingerprintSHA256(ca.PublicKey()) {
+		t.Errorf("info = %+v", info)
+	}
+
+	// Logins now present the certificate
+	signer, err := km.Signer("id_test")
+	if err != nil {
+		t.Fatalf("Signer: %v", err)
+	}
+	if _, ok := signer.PublicKey().(*gossh.Certificate); !ok {
+		t.Error("signer does not present the certificate")
+	}
+
+	if err := km.DeleteCert("id_test"); err != nil {
+		t.Fatalf("DeleteCert: %v", err)
+	}
+	signer, err = km.Signer("id_test")
+	if err != nil {
+		t.Fatalf("Signer: %v", err)
+	}
+	if _, ok := signer.PublicKey().(*gossh.Certificate); ok {
+		t.Error("signer still presents the deleted certificate")
+	}
+}
+
+func TestCertSignerExpired(t *testing.T) {
+	km, pub := newTestKeyManager(t)
+	ca := testSigner(t)
+
+	now := time.Now()
+	expired := testCert(t, ca, pub, gossh.UserCert, uint64(now.Add(-2*time.Hour).Unix()), uint64(now.Add(-time.Hour).Unix()))
+	if _, err := km.UploadCert("id_test", expired); err != nil {
+		t.Fatalf("UploadCert: %v", err)
+	}
+
+	// An expired certificate is an error, not a fallback to the plain key
+	if _, err := km.Signer("id_test"); err == nil {
+		t.Fatal("Signer succeeded with an expired certificate")
+	}
+}
@@ -0,0 +1,321 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/types"
+)
+
+// Connection tuning (mirrors the ServerAlive options previously passed to ssh)
+const (
+	dialTimeout       = 20 * time.Second
+	keepaliveInterval = 60 * time.Second
+	keepaliveCountMax = 3
+)
+
+// session is one established connection of a tunnel: the SSH client chain
+// through any jump hosts plus the listener accepting forwarded connections
+type session struct {
+	clients  []*gossh.Client
+	listener net.Listener
+}
+
+// target returns the client connected to the final tunnel host
+func (s *session) target() *gossh.Client {
+	return s.clients[len(s.clients)-1]
+}
+
+// Close tears down the listener and the client chain, innermost first
+func (s *session) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for i := len(s.clients) - 1; i >= 0; i-- {
+		s.clients[i].Close()
+	}
+}
+
+// hops returns the SSH servers to connect through, ending with the tunnel host
+func hops(t *types.SSHTunnel) []types.SSHJumpHost {
+	chain := make([]types.SSHJumpHost, 0, len(t.Jumps)+1)
+	chain = append(chain, t.Jumps...)
+	return append(chain, types.SSHJumpHost{
+		Host:     t.Host,
+		Port:     t.Port,
+		User:     t.User,
+		AuthType: t.AuthType,
+		KeyFile:  t.KeyFile,
+		Password: t.Password,
+	})
+}
+
+// open connects the whole chain and sets up the forwarding listener
+func (tm *TunnelManager) open(ctx context.Context, t *types.SSHTunnel) (*session, error) {
+	s := &session{}
+
+	for i, hop := range hops(t) {
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+
+		cfg, err := tm.clientConfig(hop)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		var conn net.Conn
+		if i == 0 {
+			conn, err = dialUpstream(ctx, t.Proxy, addr, dialTimeout)
+		} else {
+			conn, err = dialThrough(ctx, s.target(), addr)
+		}
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("connect to %s failed: %v", addr, err)
+		}
+
+		client, err := handshake(ctx, conn, addr, cfg)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("SSH handshake with %s@%s failed: %v", hop.User, addr, err)
+		}
+		s.clients = append(s.clients, client)
+
+		tm.logger.Debug("ssh", "connect_hop").
+			WithExtra("hop", i+1).
+			WithExtra("host", hop.User+"@"+addr).
+			Commit()
+	}
+
+	var err error
+	switch t.FwdType {
+	case "L", "D":
+		s.listener, err = net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", t.LPort))
+	case "R":
+		s.listener, err = s.target().Listen("tcp", fmt.Sprintf("0.0.0.0:%d", t.RPort))
+	default:
+		err = fmt.Errorf("invalid forward type")
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("port forwarding failed: %v", err)
+	}
+
+	return s, nil
+}
+
+// dialThrough opens a TCP connection to addr from the far side of client,
+// giving up after dialTimeout or when ctx is cancelled
+func dialThrough(ctx context.Context, client *gossh.Client, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	return client.DialContext(ctx, "tcp", addr)
+}
+
+// handshake runs the SSH client handshake over conn. A peer that accepts the
+// connection but never answers is cut off after dialTimeout, and cancelling
+// ctx closes conn so a handshake still in progress returns immediately.
+// conn is closed on failure.
+func handshake(ctx context.Context, conn net.Conn, addr string, cfg *gossh.ClientConfig) (*gossh.Client, error) {
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	c, chans, reqs, err := gossh.NewClientConn(conn, addr, cfg)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return gossh.NewClient(c, chans, reqs), nil
+}
+
+// clientConfig builds the SSH client configuration for one hop
+func (tm *TunnelManager) clientConfig(hop types.SSHJumpHost) (*gossh.ClientConfig, error) {
+	var auth []gossh.AuthMethod
+
+	switch hop.AuthType {
+	case "key":
+		signer, err := tm.keyManager.Signer(hop.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, gossh.PublicKeys(signer))
+	case "password":
+		if hop.Password == "" {
+			return nil, fmt.Errorf("password for %s@%s is not available - recreate the tunnel", hop.User, hop.Host)
+		}
+		password := hop.Password
+		auth = append(auth,
+			gossh.Password(password),
+			gossh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}),
+		)
+	default:
+		return nil, fmt.Errorf("invalid auth type")
+	}
+
+	return &gossh.ClientConfig{
+		User: hop.User,
+		Auth: auth,
+		// Host keys are not pinned, matching the previous StrictHostKeyChecking=no
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// serve forwards connections until the session fails or ctx is cancelled.
+// A nil return means the tunnel was stopped on purpose.
+func (tm *TunnelManager) serve(ctx context.Context, t *types.SSHTunnel, s *session, ts *tunnelStats) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, 3)
+	go func() {
+		err := s.target().Wait()
+		if err == nil {
+			err = fmt.Errorf("connection closed by server")
+		}
+		errc <- err
+	}()
+	go func() {
+		errc <- keepalive(ctx, s.target(), ts)
+	}()
+	go func() {
+		errc <- tm.acceptLoop(t, s, ts)
+	}()
+
+	select {
+	case <-ctx.Done():
+		s.Close()
+		return nil
+	case err := <-errc:
+		s.Close()
+		return err
+	}
+}
+
+// acceptLoop accepts connections on the session listener and forwards each one
+func (tm *TunnelManager) acceptLoop(t *types.SSHTunnel, s *session, ts *tunnelStats) error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return fmt.Errorf("listener closed: %v", err)
+		}
+		go tm.forward(t, s, ts, conn)
+	}
+}
+
+// forward connects an accepted connection to its destination
+func (tm *TunnelManager) forward(t *types.SSHTunnel, s *session, ts *tunnelStats, conn net.Conn) {
+	var dest string
+	var remote net.Conn
+	var err error
+
+	switch t.FwdType {
+	case "L":
+		dest = net.JoinHostPort(t.RHost, strconv.Itoa(t.RPort))
+		remote, err = s.target().Dial("tcp", dest)
+	case "R":
+		dest = net.JoinHostPort(t.RHost, strconv.Itoa(t.LPort))
+		remote, err = net.DialTimeout("tcp", dest, dialTimeout)
+	case "D":
+		conn.SetDeadline(time.Now().Add(dialTimeout))
+		dest, err = socks5Accept(conn)
+		conn.SetDeadline(time.Time{})
+		if err == nil {
+			remote, err = s.target().Dial("tcp", dest)
+			if err != nil {
+				socks5Reply(conn, socks5ReplyFailure)
+			} else {
+				err = socks5Reply(conn, socks5ReplyOK)
+			}
+		}
+	}
+	if err != nil {
+		tm.logger.Debug("ssh", "forward").
+			WithExtra("id", t.ID).
+			WithExtra("dest", dest).
+			WithError(err).
+			Commit()
+		if remote != nil {
+			remote.Close()
+		}
+		conn.Close()
+		return
+	}
+
+	cs, untrack := ts.track(conn.RemoteAddr().String(), dest)
+	defer untrack()
+
+	if t.FwdType == "R" {
+		// The accepted connection arrived over SSH; the dialed one is local
+		pipeCounted(remote, conn, ts, cs)
+	} else {
+		pipeCounted(conn, remote, ts, cs)
+	}
+}
+
+// keepalive probes the server periodically, recording the round-trip time,
+// and fails after too many missed replies
+func keepalive(ctx context.Context, client *gossh.Client, ts *tunnelStats) error {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	// At most one probe is in flight; an unanswered one counts as missed on
+	// every tick rather than stacking another goroutine behind it. It ends
+	// when the caller closes the client.
+	var reply chan error
+	var start time.Time
+	probe := func() {
+		start = time.Now()
+		reply = make(chan error, 1)
+		go func(reply chan<- error) {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}(reply)
+	}
+
+	// Probe immediately on connect so the RTT is known right away
+	probe()
+	missed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-reply:
+			reply = nil
+			if err != nil {
+				return fmt.Errorf("keepalive failed: %v", err)
+			}
+			ts.recordKeepalive(time.Since(start))
+			missed = 0
+		case <-ticker.C:
+			if reply == nil {
+				probe()
+				continue
+			}
+			missed++
+			if missed >= keepaliveCountMax {
+				return fmt.Errorf("server not responding to keepalives")
+			}
+		}
+	}
+}
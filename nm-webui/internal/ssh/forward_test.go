@@ -0,0 +1,407 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/types"
+)
+
+// testServer is an in-process SSH server that accepts one password and
+// relays direct-tcpip channels, which is all a jump host has to do
+type testServer struct {
+	addr string
+
+	mu      sync.Mutex
+	dialed  []string // destinations of direct-tcpip channels
+	open    int      // connections currently established
+	conns   []gossh.Conn
+	closing bool
+}
+
+func newTestServer(t *testing.T, password string) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &gossh.ServerConfig{
+		PasswordCallback: func(c gossh.ConnMetadata, pass []byte) (*gossh.Permissions, error) {
+			if string(pass) != password {
+				return nil, fmt.Errorf("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{addr: l.Addr().String()}
+	t.Cleanup(func() {
+		l.Close()
+		s.mu.Lock()
+		s.closing = true
+		for _, c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+	})
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(nc net.Conn, cfg *gossh.ServerConfig) {
+	conn, chans, reqs, err := gossh.NewServerConn(nc, cfg)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.open++
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.open--
+		s.mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			req.Reply(req.Type == "keepalive@openssh.com", nil)
+		}
+	}()
+
+	for nch := range chans {
+		if nch.ChannelType() != "direct-tcpip" {
+			nch.Reject(gossh.UnknownChannelType, "unsupported")
+			continue
+		}
+		dest, err := parseDirectTCPIP(nch.ExtraData())
+		if err != nil {
+			nch.Reject(gossh.ConnectionFailed, err.Error())
+			continue
+		}
+		s.mu.Lock()
+		s.dialed = append(s.dialed, dest)
+		s.mu.Unlock()
+
+		remote, err := net.Dial("tcp", dest)
+		if err != nil {
+			nch.Reject(gossh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go gossh.DiscardRequests(chReqs)
+		go func() {
+			io.Copy(ch, remote)
+			ch.CloseWrite()
+		}()
+		go func() {
+			io.Copy(remote, ch)
+			remote.Close()
+		}()
+	}
+	conn.Wait()
+}
+
+// parseDirectTCPIP returns the destination of a direct-tcpip channel request
+func parseDirectTCPIP(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", fmt.Errorf("short request")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < n+4 {
+		return "", fmt.Errorf("short request")
+	}
+	host := string(data[4 : 4+n])
+	port := binary.BigEndian.Uint32(data[4+n:])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+func (s *testServer) state() (open int, dialed []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open, append([]string(nil), s.dialed...)
+}
+
+// hostPort splits a listener address into a host and numeric port
+func hostPort(t *testing.T, addr string) (string, int) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// freePort returns a TCP port that was free a moment ago
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// echoServer answers every connection by echoing what it reads
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newTestTunnelManager() *TunnelManager {
+	return &TunnelManager{
+		logger:  logger.NewDefault(),
+		tunnels: make(map[string]*types.SSHTunnel),
+		running: make(map[string]*tunnelRun),
+		stats:   make(map[string]*tunnelStats),
+	}
+}
+
+func TestOpenJumpChain(t *testing.T) {
+	jump := newTestServer(t, "jump-pw")
+	target := newTestServer(t, "target-pw")
+	echo := echoServer(t)
+
+	jumpHost, jumpPort := hostPort(t, jump.addr)
+	targetHost, targetPort := hostPort(t, target.addr)
+	echoHost, echoPort := hostPort(t, echo)
+
+	tunnel := &types.SSHTunnel{
+		ID:       "test",
+		Host:     targetHost,
+		Port:     targetPort,
+		User:     "bob",
+		AuthType: "password",
+		Password: "target-pw",
+		Jumps: []types.SSHJumpHost{{
+			Host:     jumpHost,
+			Port:     jumpPort,
+			User:     "alice",
+			AuthType: "password",
+			Password: "jump-pw",
+		}},
+		FwdType: "L",
+		LPort:   freePort(t),
+		RHost:   echoHost,
+		RPort:   echoPort,
+	}
+
+	tm := newTestTunnelManager()
+	sess, err := tm.open(context.Background(), tunnel)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if len(sess.clients) != 2 {
+		t.Fatalf("got %d clients, want 2", len(sess.clients))
+	}
+
+	// The target was reached through the jump host
+	if open, dialed := jump.state(); open != 1 || len(dialed) != 1 || dialed[0] != target.addr {
+		t.Fatalf("jump host: open=%d dialed=%v, want 1 connection to %s", open, dialed, target.addr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- tm.serve(ctx, tunnel, sess, newTunnelStats()) }()
+
+	// A local connection comes out at the echo server through the chain
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.LPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	conn.Close()
+	if _, dialed := target.state(); len(dialed) != 1 || dialed[0] != echo {
+		t.Fatalf("target dialed %v, want %s", dialed, echo)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatalf("serve returned %v after stop", err)
+	}
+
+	// Stopping closes the listener and both connections of the chain
+	if _, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.LPort))); err == nil {
+		t.Error("listener still accepting after stop")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jumpOpen, _ := jump.state()
+		targetOpen, _ := target.state()
+		if jumpOpen == 0 && targetOpen == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connections left open: jump=%d target=%d", jumpOpen, targetOpen)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenJumpFailureClosesChain(t *testing.T) {
+	jump := newTestServer(t, "jump-pw")
+	target := newTestServer(t, "target-pw")
+
+	jumpHost, jumpPort := hostPort(t, jump.addr)
+	targetHost, targetPort := hostPort(t, target.addr)
+
+	tunnel := &types.SSHTunnel{
+		Host:     targetHost,
+		Port:     targetPort,
+		User:     "bob",
+		AuthType: "password",
+		Password: "wrong",
+		Jumps: []types.SSHJumpHost{{
+			Host:     jumpHost,
+			Port:     jumpPort,
+			User:     "alice",
+			AuthType: "password",
+			Password: "jump-pw",
+		}},
+		FwdType: "L",
+		LPort:   freePort(t),
+		RHost:   "127.0.0.1",
+		RPort:   22,
+	}
+
+	tm := newTestTunnelManager()
+	if _, err := tm.open(context.Background(), tunnel); err == nil {
+		t.Fatal("open succeeded with a wrong target password")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if open, _ := jump.state(); open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("jump host connection left open after a failed open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenCancelSilentServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Accepts TCP but never sends an SSH banner
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port := hostPort(t, l.Addr().String())
+	tunnel := &types.SSHTunnel{
+		Host:     host,
+		Port:     port,
+		User:     "bob",
+		AuthType: "password",
+		Password: "pw",
+		FwdType:  "L",
+		LPort:    freePort(t),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := newTestTunnelManager().open(ctx, tunnel); err == nil {
+		t.Fatal("open succeeded against a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("open took %v after cancellation", elapsed)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	req := withDefaults(types.SSHTunnelCreateRequest{
+		Host:    "example.com",
+		FwdType: "L",
+		Jumps:   []types.SSHJumpHost{{Host: "jump.example.com"}, {Host: "jump2.example.com", Port: 2222}},
+	})
+	if req.Port != 22 {
+		t.Errorf("Port = %d, want 22", req.Port)
+	}
+	if req.Jumps[0].Port != 22 || req.Jumps[1].Port != 2222 {
+		t.Errorf("jump ports = %d, %d, want 22, 2222", req.Jumps[0].Port, req.Jumps[1].Port)
+	}
+	if req.RHost != "127.0.0.1" {
+		t.Errorf("RHost = %q, want 127.0.0.1", req.RHost)
+	}
+}
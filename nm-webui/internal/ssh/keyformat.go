@@ -0,0 +1,468 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrPassphraseRequired is returned when an encrypted key is uploaded or
+// converted without its passphrase
+var ErrPassphraseRequired = errors.New("passphrase required")
+
+// keyInfo is what can be learned about a stored private key
+type keyInfo struct {
+	keyType     string
+	bits        int
+	fingerprint string
+	comment     string
+	encrypted   bool
+	public      gossh.PublicKey
+}
+
+// inspectKey parses a private key, falling back to its public half when the
+// key is encrypted. ok is false if the data is not a private key at all.
+func inspectKey(content, pubContent []byte) (keyInfo, bool) {
+	var info keyInfo
+
+	raw, err := gossh.ParseRawPrivateKey(content)
+	if err == nil {
+		signer, err := gossh.NewSignerFromKey(raw)
+		if err != nil {
+			return info, false
+		}
+		info.public = signer.PublicKey()
+	} else {
+		var missing *gossh.PassphraseMissingError
+		if !errors.As(err, &missing) {
+			return info, false
+		}
+		info.encrypted = true
+		info.public = missing.PublicKey
+	}
+
+	// Legacy encrypted PEM keys carry no public key; use the .pub file if any
+	if info.public == nil && len(pubContent) > 0 {
+		if pub, comment, _, _, err := gossh.ParseAuthorizedKey(pubContent); err == nil {
+			info.public = pub
+			info.comment = comment
+		}
+	}
+
+	info.keyType = "unknown"
+	if info.public != nil {
+		info.keyType, info.bits = publicKeyTypeBits(info.public)
+		info.fingerprint = gossh.FingerprintSHA256(info.public)
+	}
+	return info, true
+}
+
+// publicKeyTypeBits returns the short algorithm name and key size of a public key
+func publicKeyTypeBits(pub gossh.PublicKey) (string, int) {
+	cpk, ok := pub.(gossh.CryptoPublicKey)
+	if !ok {
+		return strings.TrimPrefix(pub.Type(), "ssh-"), 0
+	}
+
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return "rsa", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ecdsa", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ed25519", 256
+	case *dsa.PublicKey:
+		return "dsa", k.P.BitLen()
+	}
+	return strings.TrimPrefix(pub.Type(), "ssh-"), 0
+}
+
+// normalizeKey converts PuTTY .ppk and PKCS#8 PEM keys to OpenSSH format.
+// Keys already usable by SSH are returned unchanged with converted=false.
+// A converted key is re-encrypted with the passphrase it was protected by.
+func normalizeKey(content []byte, passphrase string) (out []byte, comment string, converted bool, err error) {
+	trimmed := bytes.TrimSpace(content)
+
+	if bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File-")) {
+		key, comment, encrypted, err := parsePPK(trimmed, passphrase)
+		if err != nil {
+			return nil, "", false, err
+		}
+		out, err := marshalOpenSSH(key, comment, encrypted, passphrase)
+		return out, comment, true, err
+	}
+
+	block, _ := pem.Decode(trimmed)
+	if block != nil {
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, "", false, fmt.Errorf("invalid PKCS#8 key: %v", err)
+			}
+			out, err := marshalOpenSSH(key, "", false, "")
+			return out, "", true, err
+		case "ENCRYPTED PRIVATE KEY":
+			if passphrase == "" {
+				return nil, "", false, ErrPassphraseRequired
+			}
+			key, err := decryptPKCS8(trimmed, passphrase)
+			if err != nil {
+				return nil, "", false, err
+			}
+			out, err := marshalOpenSSH(key, "", true, passphrase)
+			return out, "", true, err
+		}
+	}
+
+	// Anything else must already be a key SSH understands
+	if _, err := gossh.ParseRawPrivateKey(trimmed); err != nil {
+		var missing *gossh.PassphraseMissingError
+		if !errors.As(err, &missing) {
+			return nil, "", false, fmt.Errorf("not a supported private key: %v", err)
+		}
+		if passphrase != "" {
+			if _, err := gossh.ParseRawPrivateKeyWithPassphrase(trimmed, []byte(passphrase)); err != nil {
+				return nil, "", false, fmt.Errorf("wrong passphrase: %v", err)
+			}
+		}
+	}
+	return content, "", false, nil
+}
+
+// marshalOpenSSH encodes a private key in OpenSSH format
+func marshalOpenSSH(key crypto.PrivateKey, comment string, encrypt bool, passphrase string) ([]byte, error) {
+	var block *pem.Block
+	var err error
+	if encrypt {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	} else {
+		block, err = gossh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// decryptPKCS8 decrypts an encrypted PKCS#8 key with openssl, which
+// supports the PBES2 schemes the Go standard library lacks
+func decryptPKCS8(content []byte, passphrase string) (crypto.PrivateKey, error) {
+	args := []string{"pkcs8", "-topk8", "-nocrypt", "-passin", "env:NMWEBUI_KEY_PASS"}
+	cmd := exec.Command("openssl", args...)
+	cmd.Env = append(os.Environ(), "NMWEBUI_KEY_PASS="+passphrase)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("openssl is required to decrypt PKCS#8 keys")
+		}
+		return nil, fmt.Errorf("failed to decrypt key (wrong passphrase?): %s", strings.TrimSpace(stderr.String()))
+	}
+
+	block, _ := pem.Decode(out)
+	if block == nil {
+		return nil, fmt.Errorf("openssl returned no key")
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// ppkFile holds the fields of a PuTTY private key file
+type ppkFile struct {
+	version    int
+	algorithm  string
+	encryption string
+	comment    string
+	public     []byte
+	private    []byte
+	mac        []byte
+	headers    map[string]string
+}
+
+// parsePPKFile splits a .ppk file into its fields
+func parsePPKFile(content []byte) (*ppkFile, error) {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	f := &ppkFile{headers: make(map[string]string)}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("invalid PPK line %d", i+1)
+		}
+
+		switch {
+		case strings.HasPrefix(name, "PuTTY-User-Key-File-"):
+			v, err := strconv.Atoi(strings.TrimPrefix(name, "PuTTY-User-Key-File-"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid PPK version")
+			}
+			f.version = v
+			f.algorithm = value
+		case name == "Public-Lines" || name == "Private-Lines":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || i+n >= len(lines) {
+				return nil, fmt.Errorf("invalid %s", name)
+			}
+			data, err := base64.StdEncoding.DecodeString(strings.Join(lines[i+1:i+1+n], ""))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s data: %v", name, err)
+			}
+			if name == "Public-Lines" {
+				f.public = data
+			} else {
+				f.private = data
+			}
+			i += n
+		case name == "Private-MAC":
+			mac, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Private-MAC")
+			}
+			f.mac = mac
+		case name == "Encryption":
+			f.encryption = value
+		case name == "Comment":
+			f.comment = value
+		default:
+			f.headers[name] = value
+		}
+	}
+
+	if f.version != 2 && f.version != 3 {
+		return nil, fmt.Errorf("unsupported PPK version %d", f.version)
+	}
+	if f.public == nil || f.private == nil || f.mac == nil {
+		return nil, fmt.Errorf("incomplete PPK file")
+	}
+	return f, nil
+}
+
+// parsePPK decrypts and verifies a PuTTY key and returns the private key
+func parsePPK(content []byte, passphrase string) (crypto.PrivateKey, string, bool, error) {
+	f, err := parsePPKFile(content)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	encrypted := f.encryption != "none"
+	if encrypted && f.encryption != "aes256-cbc" {
+		return nil, "", false, fmt.Errorf("unsupported PPK encryption %q", f.encryption)
+	}
+	if encrypted && passphrase == "" {
+		return nil, "", false, ErrPassphraseRequired
+	}
+
+	var cipherKey, iv, macKey []byte
+	var newMAC func() hash.Hash
+	if f.version == 2 {
+		if encrypted {
+			h0 := sha1.Sum(append([]byte{0, 0, 0, 0}, passphrase...))
+			h1 := sha1.Sum(append([]byte{0, 0, 0, 1}, passphrase...))
+			cipherKey = append(h0[:], h1[:]...)[:32]
+			iv = make([]byte, aes.BlockSize)
+		}
+		mk := sha1.Sum([]byte("putty-private-key-file-mac-key" + passphrase))
+		macKey = mk[:]
+		newMAC = sha1.New
+	} else {
+		if encrypted {
+			derived, err := ppkArgon2(f.headers, passphrase)
+			if err != nil {
+				return nil, "", false, err
+			}
+			cipherKey, iv, macKey = derived[:32], derived[32:48], derived[48:]
+		}
+		newMAC = sha256.New
+	}
+
+	private := f.private
+	if encrypted {
+		if len(private)%aes.BlockSize != 0 {
+			return nil, "", false, fmt.Errorf("invalid encrypted key length")
+		}
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			return nil, "", false, err
+		}
+		private = make([]byte, len(f.private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(private, f.private)
+	}
+
+	mac := hmac.New(newMAC, macKey)
+	for _, field := range [][]byte{[]byte(f.algorithm), []byte(f.encryption), []byte(f.comment), f.public, private} {
+		binary.Write(mac, binary.BigEndian, uint32(len(field)))
+		mac.Write(field)
+	}
+	if !hmac.Equal(mac.Sum(nil), f.mac) {
+		if encrypted {
+			return nil, "", false, fmt.Errorf("wrong passphrase")
+		}
+		return nil, "", false, fmt.Errorf("PPK integrity check failed")
+	}
+
+	key, err := ppkPrivateKey(f.algorithm, f.public, private)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return key, f.comment, encrypted, nil
+}
+
+// ppkMaxArgon2Memory is the most memory, in KiB, a PPK file may make key
+// derivation use: 64 MiB, well within a Raspberry Pi's
+const ppkMaxArgon2Memory = 64 << 10
+
+// ppkArgon2 derives the cipher key, IV and MAC key of a version 3 PPK file
+func ppkArgon2(headers map[string]string, passphrase string) ([]byte, error) {
+	memory, err1 := strconv.ParseUint(headers["Argon2-Memory"], 10, 32)
+	passes, err2 := strconv.ParseUint(headers["Argon2-Passes"], 10, 32)
+	parallel, err3 := strconv.ParseUint(headers["Argon2-Parallelism"], 10, 8)
+	salt, err4 := hex.DecodeString(headers["Argon2-Salt"])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return nil, fmt.Errorf("invalid PPK key derivation parameters")
+	}
+	// argon2 panics on zero passes or parallelism
+	if passes < 1 || parallel < 1 {
+		return nil, fmt.Errorf("invalid PPK key derivation parameters")
+	}
+	// Bound the work an uploaded file can ask for (PuTTY defaults to 8 MiB)
+	if memory > ppkMaxArgon2Memory || passes > 64 {
+		return nil, fmt.Errorf("PPK key derivation parameters too large")
+	}
+
+	switch headers["Key-Derivation"] {
+	case "Argon2id":
+		return argon2.IDKey([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallel), 80), nil
+	case "Argon2i":
+		return argon2.Key([]byte(passphrase), salt, uint32(passes), uint32(memory), uint8(parallel), 80), nil
+	}
+	return nil, fmt.Errorf("unsupported PPK key derivation %q", headers["Key-Derivation"])
+}
+
+// ppkPrivateKey assembles a private key from PPK public and private blobs
+func ppkPrivateKey(algorithm string, public, private []byte) (crypto.PrivateKey, error) {
+	switch algorithm {
+	case "ssh-rsa":
+		var pub struct {
+			Type string
+			E    *big.Int
+			N    *big.Int
+		}
+		var priv struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(public, &pub); err != nil {
+			return nil, fmt.Errorf("invalid RSA public key: %v", err)
+		}
+		if err := gossh.Unmarshal(private, &priv); err != nil {
+			return nil, fmt.Errorf("invalid RSA private key: %v", err)
+		}
+		if !pub.E.IsInt64() || pub.E.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: pub.N, E: int(pub.E.Int64())},
+			D:         priv.D,
+			Primes:    []*big.Int{priv.P, priv.Q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid RSA key: %v", err)
+		}
+		key.Precompute()
+		return key, nil
+
+	case "ssh-ed25519":
+		var pub struct {
+			Type string
+			Key  []byte
+		}
+		var priv struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(public, &pub); err != nil {
+			return nil, fmt.Errorf("invalid Ed25519 public key: %v", err)
+		}
+		if err := gossh.Unmarshal(private, &priv); err != nil || len(priv.Key) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid Ed25519 private key")
+		}
+		key := ed25519.NewKeyFromSeed(priv.Key)
+		if !bytes.Equal(key.Public().(ed25519.PublicKey), pub.Key) {
+			return nil, fmt.Errorf("Ed25519 public and private keys do not match")
+		}
+		return key, nil
+
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		var pub struct {
+			Type  string
+			Curve string
+			Q     []byte
+		}
+		var priv struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := gossh.Unmarshal(public, &pub); err != nil {
+			return nil, fmt.Errorf("invalid ECDSA public key: %v", err)
+		}
+		if err := gossh.Unmarshal(private, &priv); err != nil {
+			return nil, fmt.Errorf("invalid ECDSA private key: %v", err)
+		}
+		curve := map[string]elliptic.Curve{
+			"nistp256": elliptic.P256(),
+			"nistp384": elliptic.P384(),
+			"nistp521": elliptic.P521(),
+		}[pub.Curve]
+		if curve == nil {
+			return nil, fmt.Errorf("unsupported ECDSA curve %q", pub.Curve)
+		}
+		x, y := elliptic.Unmarshal(curve, pub.Q)
+		if x == nil {
+			return nil, fmt.Errorf("invalid ECDSA public point")
+		}
+		return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: priv.D}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PPK key type %q", algorithm)
+}
+
+// keyComment builds the comment used for keys created by nm-webui
+func keyComment() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("nm-webui@%s %s", hostname, time.Now().Format("2006-01-02"))
+}
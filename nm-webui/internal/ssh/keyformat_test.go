@@ -0,0 +1,270 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// The PPK fixtures in testdata are written by testdata/genppk.go
+const fixturePassphrase = "correct-horse"
+
+// readFixture returns a file from testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkConverted parses an OpenSSH key produced by normalizeKey and checks
+// it is the key in wantPub
+func checkConverted(t *testing.T, out []byte, passphrase string, wantPub []byte) {
+	t.Helper()
+	var key interface{}
+	var err error
+	if passphrase != "" {
+		if _, err := gossh.ParseRawPrivateKey(out); err == nil {
+			t.Fatal("converted key is not encrypted")
+		}
+		key, err = gossh.ParseRawPrivateKeyWithPassphrase(out, []byte(passphrase))
+	} else {
+		key, err = gossh.ParseRawPrivateKey(out)
+	}
+	if err != nil {
+		t.Fatalf("converted key does not parse: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, _, _, err := gossh.ParseAuthorizedKey(wantPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+		t.Errorf("converted key is %s, want %s", gossh.FingerprintSHA256(signer.PublicKey()), gossh.FingerprintSHA256(want))
+	}
+}
+
+func TestNormalizePPK(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		passphrase string
+		wantErr    string
+	}{
+		{"ppk-v2-ed25519", "", ""},
+		{"ppk-v2-ecdsa", "", ""},
+		{"ppk-v2-ed25519-enc", fixturePassphrase, ""},
+		{"ppk-v2-ed25519-enc", "", ErrPassphraseRequired.Error()},
+		{"ppk-v2-ed25519-enc", "wrong", "wrong passphrase"},
+		{"ppk-v3-ed25519", "", ""},
+		{"ppk-v3-ed25519-enc", fixturePassphrase, ""},
+		{"ppk-v3-ed25519-enc", "", ErrPassphraseRequired.Error()},
+		{"ppk-v3-ed25519-enc", "wrong", "wrong passphrase"},
+		{"ppk-v3-rsa-enc", fixturePassphrase, ""},
+	}
+	for _, tt := range tests {
+		name := tt.fixture
+		if tt.passphrase != "" {
+			name += "/" + tt.passphrase
+		}
+		t.Run(name, func(t *testing.T) {
+			out, comment, converted, err := normalizeKey(readFixture(t, tt.fixture+".ppk"), tt.passphrase)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeKey: %v", err)
+			}
+			if !converted || comment != tt.fixture {
+				t.Errorf("converted = %v, comment = %q", converted, comment)
+			}
+			checkConverted(t, out, tt.passphrase, readFixture(t, tt.fixture+".pub"))
+		})
+	}
+}
+
+func TestParsePPKMalformed(t *testing.T) {
+	valid := string(readFixture(t, "ppk-v3-ed25519.ppk"))
+	lines := strings.Split(strings.TrimSpace(valid), "\n")
+	without := func(prefix string) string {
+		var kept []string
+		for _, l := range lines {
+			if !strings.HasPrefix(l, prefix) {
+				kept = append(kept, l)
+			}
+		}
+		return strings.Join(kept, "\n")
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"tampered MAC", strings.Replace(valid, "Private-MAC: ", "Private-MAC: 00", 1), "integrity check failed"},
+		{"tampered comment", strings.Replace(valid, "Comment: ppk-v3-ed25519", "Comment: someone-else", 1), "integrity check failed"},
+		{"missing MAC", without("Private-MAC"), "incomplete PPK file"},
+		{"bad MAC hex", strings.Replace(valid, "Private-MAC: ", "Private-MAC: zz", 1), "invalid Private-MAC"},
+		{"unknown version", strings.Replace(valid, "PuTTY-User-Key-File-3", "PuTTY-User-Key-File-4", 1), "unsupported PPK version 4"},
+		{"line count past end", strings.Replace(valid, "Private-Lines: 1", "Private-Lines: 9", 1), "invalid Private-Lines"},
+		{"negative line count", strings.Replace(valid, "Public-Lines: 2", "Public-Lines: -1", 1), "invalid Public-Lines"},
+		{"bad base64", strings.Replace(valid, "Private-Lines: 1\n", "Private-Lines: 1\n!!!!", 1), "invalid Private-Lines data"},
+		{"garbage line", valid + "not a header\n", "invalid PPK line"},
+		{"unsupported cipher", strings.Replace(valid, "Encryption: none", "Encryption: aes128-ctr", 1), "unsupported PPK encryption"},
+		{"renamed algorithm", strings.Replace(valid, ": ssh-ed25519", ": ssh-dss", 1), "integrity check failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := parsePPK([]byte(tt.content), "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPPKPrivateKeyUnsupported(t *testing.T) {
+	if _, err := ppkPrivateKey("ssh-dss", nil, nil); err == nil || !strings.Contains(err.Error(), "unsupported PPK key type") {
+		t.Fatalf("err = %v, want unsupported key type", err)
+	}
+}
+
+func TestPPKArgon2Bounds(t *testing.T) {
+	base := map[string]string{
+		"Key-Derivation":     "Argon2id",
+		"Argon2-Memory":      "64",
+		"Argon2-Passes":      "1",
+		"Argon2-Parallelism": "1",
+		"Argon2-Salt":        "00112233445566778899aabbccddeeff",
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		wantErr string
+	}{
+		{"valid", "", "", ""},
+		{"Argon2i", "Key-Derivation", "Argon2i", ""},
+		{"largest memory", "Argon2-Memory", "65536", ""},
+		{"memory too large", "Argon2-Memory", "65537", "too large"},
+		{"memory overflows", "Argon2-Memory", "4294967296", "invalid"},
+		{"zero passes", "Argon2-Passes", "0", "invalid"},
+		{"largest passes", "Argon2-Passes", "64", ""},
+		{"too many passes", "Argon2-Passes", "65", "too large"},
+		{"zero parallelism", "Argon2-Parallelism", "0", "invalid"},
+		{"parallelism overflows", "Argon2-Parallelism", "256", "invalid"},
+		{"missing memory", "Argon2-Memory", "", "invalid"},
+		{"bad salt", "Argon2-Salt", "xyz", "invalid"},
+		{"Argon2d", "Key-Derivation", "Argon2d", "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := make(map[string]string)
+			for k, v := range base {
+				headers[k] = v
+			}
+			if tt.header != "" {
+				headers[tt.header] = tt.value
+			}
+			derived, err := ppkArgon2(headers, "pass")
+			if tt.wantErr == "" {
+				if err != nil || len(derived) != 80 {
+					t.Fatalf("ppkArgon2 = %d bytes, %v", len(derived), err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizePKCS8(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, converted, err := normalizeKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "")
+	if err != nil || !converted {
+		t.Fatalf("normalizeKey = %v, %v", converted, err)
+	}
+	checkConverted(t, out, "", gossh.MarshalAuthorizedKey(sshPub))
+
+	bad := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der[:len(der)-4]})
+	if _, _, _, err := normalizeKey(bad, ""); err == nil {
+		t.Error("accepted a truncated PKCS#8 key")
+	}
+}
+
+func TestNormalizeEncryptedPKCS8(t *testing.T) {
+	enc := readFixture(t, "pkcs8-ed25519-enc.pem")
+
+	if _, _, _, err := normalizeKey(enc, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("err = %v, want ErrPassphraseRequired", err)
+	}
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not installed")
+	}
+	if _, _, _, err := normalizeKey(enc, "wrong"); err == nil {
+		t.Error("accepted a wrong passphrase")
+	}
+
+	out, _, converted, err := normalizeKey(enc, fixturePassphrase)
+	if err != nil || !converted {
+		t.Fatalf("normalizeKey = %v, %v", converted, err)
+	}
+	if _, err := gossh.ParseRawPrivateKeyWithPassphrase(out, []byte(fixturePassphrase)); err != nil {
+		t.Fatalf("converted key does not parse with the passphrase: %v", err)
+	}
+}
+
+func TestNormalizeOpenSSHUnchanged(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := pem.EncodeToMemory(block)
+
+	out, _, converted, err := normalizeKey(key, "")
+	if err != nil || converted || !bytes.Equal(out, key) {
+		t.Fatalf("normalizeKey changed an OpenSSH key: converted=%v err=%v", converted, err)
+	}
+	if _, _, _, err := normalizeKey(key, "wrong"); err == nil {
+		t.Error("accepted a wrong passphrase for an OpenSSH key")
+	}
+	if _, _, _, err := normalizeKey([]byte("not a key"), ""); err == nil {
+		t.Error("accepted garbage")
+	}
+}
@@ -2,29 +2,42 @@
 package ssh
 
 import (
+	"bytes"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	gossh "golang.org/x/crypto/ssh"
+
 	"nm-webui/internal/logger"
 	"nm-webui/internal/types"
 )
 
+// maxKeySize bounds the files considered when listing keys
+const maxKeySize = 64 << 10
+
 // KeyManager handles SSH key operations
 type KeyManager struct {
 	sshDir string
 	logger *logger.Logger
+
+	// Decrypted passphrase-protected keys, held in memory only
+	mu       sync.RWMutex
+	unlocked map[string]gossh.Signer
 }
 
 // NewKeyManager creates a new key manager
 func NewKeyManager(sshDir string, log *logger.Logger) *KeyManager {
 	// Ensure directory exists with proper permissions
 	os.MkdirAll(sshDir, 0700)
-	return &KeyManager{sshDir: sshDir, logger: log}
+	return &KeyManager{sshDir: sshDir, logger: log, unlocked: make(map[string]gossh.Signer)}
 }
 
 // List returns all SSH private keys in the directory
@@ -63,29 +76,36 @@ func (km *KeyManager) List() ([]types.SSHKey, error) {
 			continue
 		}
 
-		// Check if it's likely a private key
-		if !isPrivateKeyFile(name) {
+		info, err := entry.Info()
+		if err != nil || info.Size() > maxKeySize {
 			continue
 		}
 
-		info, err := entry.Info()
+		// Only files that parse as private keys are listed
+		content, err := os.ReadFile(filepath.Join(km.sshDir, name))
 		if err != nil {
 			continue
 		}
-
-		// Check if corresponding .pub file exists
-		pubPath := filepath.Join(km.sshDir, name+".pub")
-		hasPub := false
-		if _, err := os.Stat(pubPath); err == nil {
-			hasPub = true
+		pubContent, pubErr := os.ReadFile(filepath.Join(km.sshDir, name+".pub"))
+		ki, ok := inspectKey(content, pubContent)
+		if !ok {
+			continue
 		}
 
-		keys = append(keys, types.SSHKey{
-			Name:      name,
-			Type:      detectKeyType(name),
-			HasPubKey: hasPub,
-			ModTime:   info.ModTime().Format(time.RFC3339),
-		})
+		key := types.SSHKey{
+			Name:        name,
+			Type:        ki.keyType,
+			Bits:        ki.bits,
+			Fingerprint: ki.fingerprint,
+			Encrypted:   ki.encrypted,
+			Unlocked:    km.isUnlocked(name),
+			HasPubKey:   pubErr == nil,
+			ModTime:     info.ModTime().Format(time.RFC3339),
+		}
+		if cert, err := km.loadCert(name); err == nil {
+			key.Certificate = certInfo(cert, time.Now())
+		}
+		keys = append(keys, key)
 	}
 
 	km.logger.Debug("ssh", "list_keys").
@@ -94,35 +114,149 @@ func (km *KeyManager) List() ([]types.SSHKey, error) {
 	return keys, nil
 }
 
-// Upload saves an uploaded key file
-func (km *KeyManager) Upload(filename string, content []byte) error {
+// Upload validates and saves an uploaded private key. PuTTY .ppk and PKCS#8
+// keys are converted to OpenSSH format, and a .pub file is written alongside.
+// A passphrase is only needed for encrypted keys; when given, the key is
+// also unlocked for this session.
+func (km *KeyManager) Upload(filename string, content []byte, passphrase string) (*types.SSHKey, error) {
 	// Sanitize filename
 	filename = filepath.Base(filename)
-	if filename == "" || filename == "." || filename == ".." {
-		return fmt.Errorf("invalid filename")
+	if filename == "" || filename == "." || filename == ".." || strings.HasSuffix(filename, ".pub") {
+		return nil, fmt.Errorf("invalid filename")
 	}
 
-	destPath := filepath.Join(km.sshDir, filename)
-
 	km.logger.Info("ssh", "upload_key").
 		WithExtra("filename", filename).
 		Commit()
 
+	content, comment, converted, err := normalizeKey(content, passphrase)
+	if err != nil {
+		km.logger.Warn("ssh", "upload_key").
+			WithExtra("filename", filename).
+			WithError(err).
+			Commit()
+		return nil, err
+	}
+	if converted {
+		// The key is no longer in its original format; drop the extension
+		for _, ext := range []string{".ppk", ".pem", ".key"} {
+			filename = strings.TrimSuffix(filename, ext)
+		}
+	}
+
+	ki, ok := inspectKey(content, nil)
+	if !ok {
+		return nil, fmt.Errorf("not a supported private key")
+	}
+
+	destPath := filepath.Join(km.sshDir, filename)
+
 	// Write file with restricted permissions
 	if err := os.WriteFile(destPath, content, 0600); err != nil {
 		km.logger.Error("ssh", "upload_key").
 			WithExtra("filename", filename).
 			WithError(err).
 			Commit()
-		return err
+		return nil, err
+	}
+
+	hasPub := false
+	os.Remove(destPath + ".pub")
+	if ki.public != nil {
+		if comment == "" {
+			comment = keyComment()
+		}
+		pub := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(ki.public))) + " " + comment + "\n"
+		hasPub = os.WriteFile(destPath+".pub", []byte(pub), 0644) == nil
+	}
+
+	// A certificate left from a previous key of the same name no longer applies
+	if cert, err := km.loadCert(filename); err == nil && ki.public != nil &&
+		!bytes.Equal(cert.Key.Marshal(), ki.public.Marshal()) {
+		os.Remove(km.certPath(filename))
+	}
+
+	// Replace any previously unlocked key of the same name
+	km.Lock(filename)
+	if ki.encrypted && passphrase != "" {
+		if err := km.Unlock(filename, passphrase); err != nil {
+			return nil, err
+		}
 	}
 
 	km.logger.Info("ssh", "upload_key_success").
 		WithExtra("filename", filename).
+		WithExtra("type", ki.keyType).
+		WithExtra("converted", converted).
+		WithExtra("fingerprint", ki.fingerprint).
+		Commit()
+
+	return &types.SSHKey{
+		Name:        filename,
+		Type:        ki.keyType,
+		Bits:        ki.bits,
+		Fingerprint: ki.fingerprint,
+		Encrypted:   ki.encrypted,
+		Unlocked:    km.isUnlocked(filename),
+		HasPubKey:   hasPub,
+		ModTime:     time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// Unlock decrypts a passphrase-protected key and keeps it in memory until
+// Lock is called or the service restarts
+func (km *KeyManager) Unlock(keyName, passphrase string) error {
+	keyName = filepath.Base(keyName)
+	content, err := os.ReadFile(km.GetKeyPath(keyName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("key file not found")
+		}
+		return err
+	}
+
+	signer, err := gossh.ParsePrivateKeyWithPassphrase(content, []byte(passphrase))
+	if err != nil {
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return fmt.Errorf("wrong passphrase")
+		}
+		return fmt.Errorf("failed to unlock key %s: %v", keyName, err)
+	}
+
+	km.mu.Lock()
+	km.unlocked[keyName] = signer
+	km.mu.Unlock()
+
+	km.logger.Info("ssh", "unlock_key").
+		WithExtra("name", keyName).
 		Commit()
 	return nil
 }
 
+// Lock forgets the decrypted copy of a key
+func (km *KeyManager) Lock(keyName string) {
+	keyName = filepath.Base(keyName)
+
+	km.mu.Lock()
+	_, ok := km.unlocked[keyName]
+	delete(km.unlocked, keyName)
+	km.mu.Unlock()
+
+	if ok {
+		km.logger.Info("ssh", "lock_key").
+			WithExtra("name", keyName).
+			Commit()
+	}
+}
+
+// isUnlocked reports whether a decrypted copy of a key is held in memory
+func (km *KeyManager) isUnlocked(keyName string) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	_, ok := km.unlocked[keyName]
+	return ok
+}
+
 // Delete removes a key file (and its .pub if exists)
 func (km *KeyManager) Delete(filename string) error {
 	filename = filepath.Base(filename)
@@ -151,8 +285,10 @@ func (km *KeyManager) Delete(filename string) error {
 		return err
 	}
 
-	// Delete public key if exists (ignore errors)
+	// Delete public key and certificate if they exist (ignore errors)
 	os.Remove(pubPath)
+	os.Remove(km.certPath(filename))
+	km.Lock(filename)
 
 	km.logger.Info("ssh", "delete_key_success").
 		WithExtra("filename", filename).
@@ -192,8 +328,7 @@ func (km *KeyManager) Generate(req types.SSHKeyGenerateRequest) (*types.SSHKeyGe
 	}
 
 	// Build ssh-keygen command
-	hostname, _ := os.Hostname()
-	comment := fmt.Sprintf("nm-webui@%s %s", hostname, time.Now().Format("2006-01-02"))
+	comment := keyComment()
 
 	args := []string{"-t", req.KeyType}
 	if req.KeyType == "rsa" {
@@ -275,42 +410,33 @@ func (km *KeyManager) KeyExists(keyName string) bool {
 	return err == nil
 }
 
-// isPrivateKeyFile checks if a filename looks like a private key
-func isPrivateKeyFile(name string) bool {
-	// Common private key extensions
-	if strings.HasSuffix(name, ".pem") ||
-		strings.HasSuffix(name, ".key") ||
-		strings.HasSuffix(name, ".ppk") {
-		return true
+// Signer loads a stored private key for SSH authentication, using the
+// in-memory copy of passphrase-protected keys that have been unlocked.
+// Keys with a <name>-cert.pub authenticate with the certificate.
+func (km *KeyManager) Signer(keyName string) (gossh.Signer, error) {
+	km.mu.RLock()
+	signer, ok := km.unlocked[filepath.Base(keyName)]
+	km.mu.RUnlock()
+	if ok {
+		return km.certSigner(filepath.Base(keyName), signer)
 	}
 
-	// Files without extension but not system files
-	if !strings.Contains(name, ".") {
-		return true
+	content, err := os.ReadFile(km.GetKeyPath(keyName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("key file not found")
+		}
+		return nil, err
 	}
 
-	// Check for id_* pattern
-	if strings.HasPrefix(name, "id_") && !strings.HasSuffix(name, ".pub") {
-		return true
+	signer, err = gossh.ParsePrivateKey(content)
+	if err != nil {
+		var missing *gossh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("key %s is passphrase-protected - unlock it first", filepath.Base(keyName))
+		}
+		return nil, fmt.Errorf("failed to parse key %s: %v", filepath.Base(keyName), err)
 	}
 
-	return false
-}
-
-// detectKeyType tries to determine the key type from filename
-func detectKeyType(name string) string {
-	nameLower := strings.ToLower(name)
-	if strings.Contains(nameLower, "ed25519") {
-		return "ed25519"
-	}
-	if strings.Contains(nameLower, "ecdsa") {
-		return "ecdsa"
-	}
-	if strings.Contains(nameLower, "rsa") {
-		return "rsa"
-	}
-	if strings.Contains(nameLower, "dsa") {
-		return "dsa"
-	}
-	return "unknown"
+	return km.certSigner(filepath.Base(keyName), signer)
 }
@@ -0,0 +1,608 @@
+package ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/netutil"
+	"nm-webui/internal/types"
+)
+
+// defaultUplink lets the routing table pick the interface
+const defaultUplink = "default"
+
+var ifaceNameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,15}$`)
+
+// PhoneHome keeps a reverse SSH channel open to a rendezvous server so the
+// web UI and sshd stay reachable from outside. Every uplink is tried in turn,
+// including iodine/hans tunnel interfaces, until one gets through.
+type PhoneHome struct {
+	tm      *TunnelManager
+	path    string
+	webAddr string // local address of the web UI
+	logger  *logger.Logger
+
+	ctl sync.Mutex // serialises Configure and Reconnect
+
+	mu      sync.RWMutex
+	config  types.PhoneHomeConfig
+	status  types.PhoneHomeStatus
+	uplinks map[string]*types.PhoneHomeUplink
+	run     *tunnelRun
+}
+
+// phoneSession is one established phone-home connection
+type phoneSession struct {
+	client   *gossh.Client
+	forwards []phoneForward
+}
+
+// phoneForward is a port listening on the rendezvous server
+type phoneForward struct {
+	listener net.Listener
+	local    string
+}
+
+// Close tears down the remote listeners and the connection
+func (s *phoneSession) Close() {
+	for _, f := range s.forwards {
+		f.listener.Close()
+	}
+	s.client.Close()
+}
+
+// NewPhoneHome loads the phone-home configuration and starts it if enabled
+func NewPhoneHome(dataDir string, tm *TunnelManager, webAddr string, log *logger.Logger) *PhoneHome {
+	ph := &PhoneHome{
+		tm:      tm,
+		path:    filepath.Join(dataDir, "phonehome.json"),
+		webAddr: webAddr,
+		logger:  log,
+		status:  types.PhoneHomeStatus{State: "disabled"},
+		uplinks: make(map[string]*types.PhoneHomeUplink),
+	}
+
+	ph.load()
+	if ph.config.Enabled {
+		ph.start(nil)
+	}
+
+	return ph
+}
+
+// load reads the configuration from disk
+func (ph *PhoneHome) load() {
+	data, err := os.ReadFile(ph.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ph.logger.Error("ssh", "load_phonehome").
+				WithError(err).
+				Commit()
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &ph.config); err != nil {
+		ph.logger.Error("ssh", "load_phonehome").
+			WithError(err).
+			Commit()
+	}
+}
+
+// save writes the configuration to disk (caller holds ph.mu)
+func (ph *PhoneHome) save() error {
+	data, err := json.MarshalIndent(ph.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ph.path, data, 0600)
+}
+
+// Get returns the configuration and current health of the channel
+func (ph *PhoneHome) Get() types.PhoneHomeResult {
+	ph.mu.RLock()
+	defer ph.mu.RUnlock()
+
+	status := ph.status
+	status.Forwards = ph.describeForwards()
+	status.Uplinks = make([]types.PhoneHomeUplink, 0, len(ph.uplinks))
+	for _, u := range ph.uplinks {
+		status.Uplinks = append(status.Uplinks, *u)
+	}
+	sort.Slice(status.Uplinks, func(i, j int) bool {
+		a, b := status.Uplinks[i].Name, status.Uplinks[j].Name
+		if a == defaultUplink || b == defaultUplink {
+			return a == defaultUplink && b != defaultUplink
+		}
+		return a < b
+	})
+	if ph.run != nil {
+		status.Stats = ph.run.stats.snapshot(status.Since, status.State == "connected")
+	}
+
+	return types.PhoneHomeResult{Config: ph.config, Status: status}
+}
+
+// Configure validates and stores a new configuration, restarting the channel
+func (ph *PhoneHome) Configure(cfg types.PhoneHomeConfig) error {
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.RemoteBind == "" {
+		cfg.RemoteBind = "127.0.0.1"
+	}
+	if cfg.LocalSSHPort == 0 {
+		cfg.LocalSSHPort = 22
+	}
+	if err := ph.validate(cfg); err != nil {
+		return err
+	}
+
+	ph.ctl.Lock()
+	defer ph.ctl.Unlock()
+
+	prev := ph.stop()
+
+	ph.mu.Lock()
+	// A different server has a different host key; forget the old pin
+	if (cfg.Host != ph.config.Host || cfg.Port != ph.config.Port) && cfg.HostKey == ph.config.HostKey {
+		cfg.HostKey = ""
+	}
+	ph.config = cfg
+	err := ph.save()
+	ph.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+
+	ph.logger.Info("ssh", "configure_phonehome").
+		WithExtra("enabled", cfg.Enabled).
+		WithExtra("server", cfg.User+"@"+cfg.Host).
+		Commit()
+
+	if cfg.Enabled {
+		ph.start(prev)
+	}
+	return nil
+}
+
+// Reconnect drops the current connection and starts over with the first uplink
+func (ph *PhoneHome) Reconnect() error {
+	ph.ctl.Lock()
+	defer ph.ctl.Unlock()
+
+	ph.mu.RLock()
+	enabled := ph.config.Enabled
+	ph.mu.RUnlock()
+	if !enabled {
+		return fmt.Errorf("phone-home is disabled")
+	}
+
+	ph.start(ph.stop())
+	return nil
+}
+
+// start launches the supervisor for the current configuration. The new run
+// waits for prev, the previous run's done channel, before dialing so the old
+// session has released its remote ports.
+func (ph *PhoneHome) start(prev <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &tunnelRun{ctx: ctx, cancel: cancel, done: make(chan struct{}), stats: newTunnelStats()}
+
+	ph.mu.Lock()
+	ph.run = run
+	ph.status = types.PhoneHomeStatus{State: "connecting"}
+	ph.uplinks = make(map[string]*types.PhoneHomeUplink)
+	cfg := ph.config
+	ph.mu.Unlock()
+
+	go ph.supervise(cfg, run, prev)
+}
+
+// stop cancels the supervisor and returns a channel closed once it has
+// exited. It does not wait, so a run stuck on a dead uplink cannot hold up
+// Configure or Reconnect.
+func (ph *PhoneHome) stop() <-chan struct{} {
+	ph.mu.Lock()
+	run := ph.run
+	ph.run = nil
+	ph.status = types.PhoneHomeStatus{State: "disabled"}
+	ph.uplinks = make(map[string]*types.PhoneHomeUplink)
+	ph.mu.Unlock()
+
+	if run == nil {
+		return nil
+	}
+	run.cancel()
+	return run.done
+}
+
+// supervise keeps the channel connected, cycling through uplinks with backoff
+func (ph *PhoneHome) supervise(cfg types.PhoneHomeConfig, run *tunnelRun, prev <-chan struct{}) {
+	defer close(run.done)
+
+	if prev != nil {
+		select {
+		case <-run.ctx.Done():
+			return
+		case <-prev:
+		}
+	}
+
+	backoff := reconnectMin
+	lost := false
+	for {
+		sess, uplink, err := ph.connect(run.ctx, &cfg)
+		if err != nil {
+			if run.ctx.Err() != nil {
+				return
+			}
+			ph.setState(run, "connecting", "", err)
+			ph.logger.Warn("ssh", "phonehome_retry").
+				WithExtra("retry_in", backoff.String()).
+				WithError(err).
+				Commit()
+
+			select {
+			case <-run.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > reconnectMax {
+				backoff = reconnectMax
+			}
+			continue
+		}
+
+		backoff = reconnectMin
+		if lost {
+			run.stats.reconnects.Add(1)
+		}
+		ph.setState(run, "connected", uplink, nil)
+		ph.logger.Info("ssh", "phonehome_connect").
+			WithExtra("server", cfg.User+"@"+cfg.Host).
+			WithExtra("uplink", uplink).
+			Commit()
+
+		err = ph.serve(run, sess)
+		lost = true
+		if run.ctx.Err() != nil {
+			return
+		}
+
+		ph.setState(run, "connecting", "", err)
+		ph.logger.Warn("ssh", "phonehome_lost").
+			WithExtra("uplink", uplink).
+			WithError(err).
+			Commit()
+	}
+}
+
+// setState records the channel state, ignoring updates from a superseded run
+func (ph *PhoneHome) setState(run *tunnelRun, state, uplink string, err error) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	if ph.run != run {
+		return
+	}
+	if state == "connected" {
+		ph.status.Since = time.Now().Unix()
+	} else {
+		ph.status.Since = 0
+	}
+	ph.status.State = state
+	ph.status.Uplink = uplink
+	ph.status.LastError = ""
+	if err != nil {
+		ph.status.LastError = err.Error()
+	}
+}
+
+// connect tries each uplink in order and returns the first working session
+func (ph *PhoneHome) connect(ctx context.Context, cfg *types.PhoneHomeConfig) (*phoneSession, string, error) {
+	candidates := cfg.Uplinks
+	if len(candidates) == 0 {
+		candidates = append([]string{defaultUplink}, systemUplinks()...)
+	}
+
+	var failures []string
+	for _, uplink := range candidates {
+		sess, learned, err := ph.dial(ctx, cfg, uplink)
+		ph.recordUplink(uplink, err)
+		if err == nil {
+			if learned != "" {
+				ph.pinHostKey(cfg, learned)
+			}
+			return sess, uplink, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		failures = append(failures, uplink+": "+err.Error())
+	}
+
+	if len(failures) == 0 {
+		return nil, "", fmt.Errorf("no uplink available")
+	}
+	return nil, "", fmt.Errorf("all uplinks failed: %s", strings.Join(failures, "; "))
+}
+
+// dial connects to the rendezvous server over one uplink and opens the
+// remote forwards. It also returns the server's host key fingerprint when
+// none was pinned yet.
+func (ph *PhoneHome) dial(ctx context.Context, cfg *types.PhoneHomeConfig, uplink string) (*phoneSession, string, error) {
+	clientCfg, err := ph.tm.clientConfig(types.SSHJumpHost{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		User:     cfg.User,
+		AuthType: "key",
+		KeyFile:  cfg.KeyFile,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var learned string
+	clientCfg.HostKeyCallback = func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		fp := gossh.FingerprintSHA256(key)
+		if cfg.HostKey == "" {
+			learned = fp
+			return nil
+		}
+		if fp != cfg.HostKey {
+			return fmt.Errorf("host key mismatch: server offered %s, pinned %s", fp, cfg.HostKey)
+		}
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	if uplink != defaultUplink {
+		dialer.Control = netutil.BindToDevice(uplink)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := handshake(ctx, conn, addr, clientCfg)
+	if err != nil {
+		return nil, "", fmt.Errorf("SSH handshake failed: %v", err)
+	}
+	sess := &phoneSession{client: client}
+
+	// Stopping the run also aborts a forward request the server never answers
+	abort := context.AfterFunc(ctx, func() { client.Close() })
+	defer abort()
+
+	for _, f := range ph.forwardTargets(cfg) {
+		l, err := sess.client.Listen("tcp", net.JoinHostPort(cfg.RemoteBind, strconv.Itoa(f.port)))
+		if err != nil {
+			sess.Close()
+			return nil, "", fmt.Errorf("remote port %d: %v", f.port, err)
+		}
+		sess.forwards = append(sess.forwards, phoneForward{listener: l, local: f.local})
+	}
+
+	return sess, learned, nil
+}
+
+// serve relays connections until the session fails or the run is cancelled
+func (ph *PhoneHome) serve(run *tunnelRun, sess *phoneSession) error {
+	ctx, cancel := context.WithCancel(run.ctx)
+	defer cancel()
+
+	errc := make(chan error, 2+len(sess.forwards))
+	go func() {
+		err := sess.client.Wait()
+		if err == nil {
+			err = fmt.Errorf("connection closed by server")
+		}
+		errc <- err
+	}()
+	go func() {
+		errc <- keepalive(ctx, sess.client, run.stats)
+	}()
+	for _, f := range sess.forwards {
+		go func(f phoneForward) {
+			errc <- ph.acceptLoop(f, run.stats)
+		}(f)
+	}
+
+	select {
+	case <-ctx.Done():
+		sess.Close()
+		return nil
+	case err := <-errc:
+		sess.Close()
+		return err
+	}
+}
+
+// acceptLoop relays connections arriving on a remote port to the local service
+func (ph *PhoneHome) acceptLoop(f phoneForward, ts *tunnelStats) error {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return fmt.Errorf("remote listener closed: %v", err)
+		}
+
+		go func() {
+			local, err := net.DialTimeout("tcp", f.local, dialTimeout)
+			if err != nil {
+				ph.logger.Debug("ssh", "phonehome_forward").
+					WithExtra("dest", f.local).
+					WithError(err).
+					Commit()
+				conn.Close()
+				return
+			}
+
+			cs, untrack := ts.track(conn.RemoteAddr().String(), f.local)
+			defer untrack()
+			pipeCounted(local, conn, ts, cs)
+		}()
+	}
+}
+
+// recordUplink stores the outcome of a connection attempt over an uplink
+func (ph *PhoneHome) recordUplink(name string, err error) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	u, ok := ph.uplinks[name]
+	if !ok {
+		u = &types.PhoneHomeUplink{Name: name}
+		ph.uplinks[name] = u
+	}
+
+	now := time.Now().Unix()
+	u.LastAttempt = now
+	ph.status.LastAttempt = now
+	if err != nil {
+		u.LastError = err.Error()
+	} else {
+		u.LastSuccess = now
+		u.LastError = ""
+	}
+}
+
+// pinHostKey remembers the server's host key after the first successful connect
+func (ph *PhoneHome) pinHostKey(cfg *types.PhoneHomeConfig, fingerprint string) {
+	cfg.HostKey = fingerprint
+
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	ph.config.HostKey = fingerprint
+	if err := ph.save(); err != nil {
+		ph.logger.Warn("ssh", "pin_host_key").
+			WithError(err).
+			Commit()
+	}
+
+	ph.logger.Info("ssh", "pin_host_key").
+		WithExtra("host", cfg.Host).
+		WithExtra("fingerprint", fingerprint).
+		Commit()
+}
+
+// forwardTarget maps a port on the rendezvous server to a local service
+type forwardTarget struct {
+	name  string
+	port  int
+	local string
+}
+
+// forwardTargets lists the enabled forwards of a configuration
+func (ph *PhoneHome) forwardTargets(cfg *types.PhoneHomeConfig) []forwardTarget {
+	var targets []forwardTarget
+	if cfg.WebPort > 0 {
+		targets = append(targets, forwardTarget{"web UI", cfg.WebPort, ph.webAddr})
+	}
+	if cfg.SSHPort > 0 {
+		targets = append(targets, forwardTarget{"sshd", cfg.SSHPort, net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.LocalSSHPort))})
+	}
+	return targets
+}
+
+// describeForwards renders the configured forwards for display (caller holds ph.mu)
+func (ph *PhoneHome) describeForwards() []string {
+	out := []string{}
+	for _, f := range ph.forwardTargets(&ph.config) {
+		out = append(out, fmt.Sprintf("%s on %s → %s (%s)",
+			net.JoinHostPort(ph.config.RemoteBind, strconv.Itoa(f.port)), ph.config.Host, f.name, f.local))
+	}
+	return out
+}
+
+// validate checks a phone-home configuration
+func (ph *PhoneHome) validate(cfg types.PhoneHomeConfig) error {
+	// An empty, disabled configuration clears the service
+	if !cfg.Enabled && cfg.Host == "" {
+		return nil
+	}
+
+	if err := ph.tm.validateHop(types.SSHJumpHost{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		User:     cfg.User,
+		AuthType: "key",
+		KeyFile:  cfg.KeyFile,
+	}); err != nil {
+		return err
+	}
+
+	if !isValidHostname(cfg.RemoteBind) && net.ParseIP(cfg.RemoteBind) == nil {
+		return fmt.Errorf("invalid remote bind address")
+	}
+	if cfg.WebPort < 0 || cfg.WebPort > 65535 {
+		return fmt.Errorf("invalid web UI port")
+	}
+	if cfg.SSHPort < 0 || cfg.SSHPort > 65535 {
+		return fmt.Errorf("invalid SSH port")
+	}
+	if cfg.WebPort == 0 && cfg.SSHPort == 0 {
+		return fmt.Errorf("at least one of web UI port or SSH port is required")
+	}
+	if cfg.WebPort == cfg.SSHPort {
+		return fmt.Errorf("web UI and SSH ports must differ")
+	}
+	if cfg.LocalSSHPort <= 0 || cfg.LocalSSHPort > 65535 {
+		return fmt.Errorf("invalid local SSH port")
+	}
+	for _, uplink := range cfg.Uplinks {
+		if uplink != defaultUplink && !ifaceNameRe.MatchString(uplink) {
+			return fmt.Errorf("invalid uplink interface %q", uplink)
+		}
+	}
+	if cfg.HostKey != "" && !strings.HasPrefix(cfg.HostKey, "SHA256:") {
+		return fmt.Errorf("host key must be a SHA256 fingerprint")
+	}
+
+	return nil
+}
+
+// systemUplinks lists interfaces that are up and have a routable address,
+// which includes iodine (dns0) and hans (tun*) tunnel interfaces
+func systemUplinks() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok && ipnet.IP.IsGlobalUnicast() {
+				names = append(names, iface.Name)
+				break
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/types"
+)
+
+// newTestPhoneHome returns a disabled phone-home with one usable key, "id_test"
+func newTestPhoneHome(t *testing.T) *PhoneHome {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "id_test"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logger.NewDefault()
+	tm := newTestTunnelManager()
+	tm.keyManager = NewKeyManager(dir, log)
+	return NewPhoneHome(dir, tm, "127.0.0.1:8080", log)
+}
+
+func TestPhoneHomeValidate(t *testing.T) {
+	ph := newTestPhoneHome(t)
+	valid := types.PhoneHomeConfig{
+		Enabled:      true,
+		Host:         "rendezvous.example.com",
+		Port:         22,
+		User:         "pi",
+		KeyFile:      "id_test",
+		RemoteBind:   "127.0.0.1",
+		WebPort:      8443,
+		SSHPort:      2222,
+		LocalSSHPort: 22,
+		Uplinks:      []string{"default", "wlan0", "dns0"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*types.PhoneHomeConfig)
+		ok     bool
+	}{
+		{"valid", func(c *types.PhoneHomeConfig) {}, true},
+		{"empty disabled", func(c *types.PhoneHomeConfig) { *c = types.PhoneHomeConfig{} }, true},
+		{"missing key", func(c *types.PhoneHomeConfig) { c.KeyFile = "id_missing" }, false},
+		{"bad host", func(c *types.PhoneHomeConfig) { c.Host = "bad host" }, false},
+		{"no forwards", func(c *types.PhoneHomeConfig) { c.WebPort, c.SSHPort = 0, 0 }, false},
+		{"same ports", func(c *types.PhoneHomeConfig) { c.SSHPort = c.WebPort }, false},
+		{"port out of range", func(c *types.PhoneHomeConfig) { c.WebPort = 70000 }, false},
+		{"bad uplink", func(c *types.PhoneHomeConfig) { c.Uplinks = []string{"wlan0; reboot"} }, false},
+		{"bad host key", func(c *types.PhoneHomeConfig) { c.HostKey = "MD5:aa:bb" }, false},
+		{"pinned host key", func(c *types.PhoneHomeConfig) { c.HostKey = "SHA256:abc" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := ph.validate(cfg)
+			if tt.ok && err != nil {
+				t.Errorf("validate: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("validate accepted an invalid configuration")
+			}
+		})
+	}
+}
+
+func TestPhoneHomeStopSilentServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Accepts TCP but never answers, like a blackholed tunnel uplink
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ph := newTestPhoneHome(t)
+	host, port := hostPort(t, l.Addr().String())
+	cfg := types.PhoneHomeConfig{
+		Enabled:      true,
+		Host:         host,
+		Port:         port,
+		User:         "pi",
+		KeyFile:      "id_test",
+		WebPort:      8443,
+		Uplinks:      []string{defaultUplink},
+		LocalSSHPort: 22,
+	}
+	if err := ph.Configure(cfg); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Reconnecting and disabling return at once rather than waiting for the
+	// stuck handshake
+	start := time.Now()
+	if err := ph.Reconnect(); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	cfg.Enabled = false
+	if err := ph.Configure(cfg); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Reconnect and Configure took %v", elapsed)
+	}
+	if state := ph.Get().Status.State; state != "disabled" {
+		t.Errorf("state = %q, want disabled", state)
+	}
+}
@@ -0,0 +1,277 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nm-webui/internal/types"
+)
+
+// SOCKS5 protocol constants (RFC 1928 / RFC 1929)
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+
+	socks5ReplyOK             = 0x00
+	socks5ReplyFailure        = 0x01
+	socks5ReplyCmdUnsupported = 0x07
+)
+
+// dialUpstream opens a TCP connection to addr, through the proxy if one is configured
+func dialUpstream(ctx context.Context, p *types.SSHProxy, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	if p == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	proxyAddr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %v", proxyAddr, err)
+	}
+
+	// Bound the proxy handshake so a silent proxy cannot hang the tunnel,
+	// and abort it if the tunnel is stopped meanwhile
+	conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	switch p.Type {
+	case "http":
+		conn, err = httpConnect(conn, addr, p.User, p.Password)
+	case "socks5":
+		err = socks5Connect(conn, addr, p.User, p.Password)
+	default:
+		err = fmt.Errorf("unsupported proxy type %q", p.Type)
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", proxyAddr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// httpConnect asks an HTTP proxy to open a CONNECT tunnel to addr
+func httpConnect(conn net.Conn, addr, user, pass string) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if user != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+		req += "Proxy-Authorization: Basic " + cred + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := io.WriteString(conn, req); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, fmt.Errorf("invalid CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+
+	// The SSH server may already have sent its banner; keep what was buffered
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn is a net.Conn whose reads drain a bufio.Reader first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// socks5Connect performs a SOCKS5 client handshake and CONNECT to addr
+func socks5Connect(conn net.Conn, addr, user, pass string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	method := byte(socks5AuthNone)
+	if user != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return fmt.Errorf("SOCKS5 server rejected authentication method")
+	}
+
+	if method == socks5AuthPassword {
+		if len(user) > 255 || len(pass) > 255 {
+			return fmt.Errorf("SOCKS5 credentials too long")
+		}
+		msg := []byte{0x01, byte(len(user))}
+		msg = append(msg, user...)
+		msg = append(msg, byte(len(pass)))
+		msg = append(msg, pass...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("SOCKS5 authentication failed")
+		}
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname too long")
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err := readSOCKS5Addr(conn, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// socks5Accept performs the server side of a SOCKS5 handshake and returns the
+// requested destination. Only unauthenticated CONNECT is supported.
+func socks5Accept(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	hasNone := false
+	for _, m := range methods {
+		if m == socks5AuthNone {
+			hasNone = true
+		}
+	}
+	if !hasNone {
+		conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return "", fmt.Errorf("client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5AuthNone}); err != nil {
+		return "", err
+	}
+
+	addr, err := readSOCKS5Addr(conn, false)
+	if err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// socks5Reply sends a SOCKS5 reply with a zero bind address
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// readSOCKS5Addr reads a SOCKS5 request (server side) or reply (client side)
+// and returns the address it carries
+func readSOCKS5Addr(conn net.Conn, isReply bool) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if isReply && header[1] != socks5ReplyOK {
+		return "", fmt.Errorf("SOCKS5 connect failed (code %d)", header[1])
+	}
+	if !isReply && header[1] != socks5CmdConnect {
+		socks5Reply(conn, socks5ReplyCmdUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS5 command %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unsupported SOCKS5 address type %d", header[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
@@ -0,0 +1,221 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"nm-webui/internal/types"
+)
+
+func TestSOCKS5Connect(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{"ipv4", "192.0.2.10:22"},
+		{"ipv6", "[2001:db8::1]:2222"},
+		{"domain", "example.com:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			got := make(chan string, 1)
+			go func() {
+				addr, err := socks5Accept(server)
+				if err != nil {
+					t.Errorf("socks5Accept: %v", err)
+				}
+				socks5Reply(server, socks5ReplyOK)
+				got <- addr
+			}()
+
+			if err := socks5Connect(client, tt.addr, "", ""); err != nil {
+				t.Fatalf("socks5Connect: %v", err)
+			}
+			if addr := <-got; addr != tt.addr {
+				t.Errorf("server saw %q, want %q", addr, tt.addr)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Password(t *testing.T) {
+	tests := []struct {
+		name   string
+		status byte
+		ok     bool
+	}{
+		{"accepted", 0x00, true},
+		{"rejected", 0x01, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			go func() {
+				greeting := make([]byte, 3)
+				io.ReadFull(server, greeting)
+				if !bytes.Equal(greeting, []byte{socks5Version, 1, socks5AuthPassword}) {
+					t.Errorf("greeting = %v", greeting)
+				}
+				server.Write([]byte{socks5Version, socks5AuthPassword})
+
+				auth := make([]byte, 2+len("alice")+1+len("s3cret"))
+				io.ReadFull(server, auth)
+				want := append(append([]byte{0x01, 5}, "alice"...), append([]byte{6}, "s3cret"...)...)
+				if !bytes.Equal(auth, want) {
+					t.Errorf("auth = %q, want %q", auth, want)
+				}
+				server.Write([]byte{0x01, tt.status})
+				if tt.ok {
+					readSOCKS5Addr(server, false)
+					socks5Reply(server, socks5ReplyOK)
+				}
+			}()
+
+			err := socks5Connect(client, "example.com:22", "alice", "s3cret")
+			if tt.ok && err != nil {
+				t.Fatalf("socks5Connect: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("socks5Connect succeeded after authentication failure")
+			}
+		})
+	}
+}
+
+func TestSOCKS5ConnectRefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		socks5Accept(server)
+		socks5Reply(server, 0x05)
+	}()
+
+	err := socks5Connect(client, "192.0.2.10:22", "", "")
+	if err == nil || !strings.Contains(err.Error(), "code 5") {
+		t.Fatalf("err = %v, want connect failure with code 5", err)
+	}
+}
+
+func TestSOCKS5AcceptRequiresNoAuth(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte{socks5Version, 1, socks5AuthPassword})
+		io.Copy(io.Discard, client)
+	}()
+
+	if _, err := socks5Accept(server); err == nil {
+		t.Fatal("socks5Accept accepted a client without the no-auth method")
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		response string
+		ok       bool
+	}{
+		{"established", "", "HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-test\r\n", true},
+		{"with credentials", "alice", "HTTP/1.1 200 OK\r\n\r\nSSH-2.0-test\r\n", true},
+		{"refused", "", "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n", false},
+		{"not http", "", "SSH-2.0-test\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(server))
+				if err != nil {
+					t.Errorf("ReadRequest: %v", err)
+					return
+				}
+				if req.Method != http.MethodConnect || req.Host != "example.com:22" {
+					t.Errorf("request = %s %s, want CONNECT example.com:22", req.Method, req.Host)
+				}
+				auth := req.Header.Get("Proxy-Authorization")
+				if tt.user != "" {
+					want := "Basic " + base64.StdEncoding.EncodeToString([]byte(tt.user+":pw"))
+					if auth != want {
+						t.Errorf("Proxy-Authorization = %q, want %q", auth, want)
+					}
+				} else if auth != "" {
+					t.Errorf("unexpected Proxy-Authorization %q", auth)
+				}
+				io.WriteString(server, tt.response)
+			}()
+
+			conn, err := httpConnect(client, "example.com:22", tt.user, "pw")
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("httpConnect succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("httpConnect: %v", err)
+			}
+
+			// Bytes the proxy sent after its response belong to the SSH server
+			banner, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || banner != "SSH-2.0-test\r\n" {
+				t.Fatalf("banner = %q, %v", banner, err)
+			}
+		})
+	}
+}
+
+func TestDialUpstreamCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A proxy that accepts the connection but never answers
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	proxy := &types.SSHProxy{Type: "socks5", Host: "127.0.0.1", Port: addr.Port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := dialUpstream(ctx, proxy, "example.com:22", time.Minute); err == nil {
+		t.Fatal("dialUpstream succeeded through a silent proxy")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("dialUpstream took %v after cancellation", elapsed)
+	}
+}
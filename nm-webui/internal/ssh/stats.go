@@ -0,0 +1,197 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nm-webui/internal/types"
+)
+
+// Metrics history: one sample every statsInterval, one hour retained
+const (
+	statsInterval   = 10 * time.Second
+	statsMaxSamples = 360
+)
+
+// tunnelStats accumulates traffic and health counters for one tunnel
+type tunnelStats struct {
+	bytesIn       atomic.Uint64
+	bytesOut      atomic.Uint64
+	totalConns    atomic.Uint64
+	reconnects    atomic.Int64
+	rtt           atomic.Int64 // nanoseconds
+	lastKeepalive atomic.Int64 // unix timestamp
+
+	mu      sync.Mutex
+	nextID  uint64
+	conns   map[uint64]*connStats
+	history []types.SSHTunnelSample
+}
+
+// connStats tracks one forwarded connection
+type connStats struct {
+	peer     string
+	dest     string
+	since    time.Time
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func newTunnelStats() *tunnelStats {
+	return &tunnelStats{conns: make(map[uint64]*connStats)}
+}
+
+// track registers a forwarded connection and returns a function to unregister it
+func (ts *tunnelStats) track(peer, dest string) (*connStats, func()) {
+	cs := &connStats{peer: peer, dest: dest, since: time.Now()}
+	ts.totalConns.Add(1)
+
+	ts.mu.Lock()
+	ts.nextID++
+	id := ts.nextID
+	ts.conns[id] = cs
+	ts.mu.Unlock()
+
+	return cs, func() {
+		ts.mu.Lock()
+		delete(ts.conns, id)
+		ts.mu.Unlock()
+	}
+}
+
+// recordKeepalive stores the round-trip time of a successful keepalive
+func (ts *tunnelStats) recordKeepalive(rtt time.Duration) {
+	ts.rtt.Store(int64(rtt))
+	ts.lastKeepalive.Store(time.Now().Unix())
+}
+
+// snapshot returns the current metrics; since is the tunnel's connect time
+func (ts *tunnelStats) snapshot(since int64, running bool) *types.SSHTunnelStats {
+	s := &types.SSHTunnelStats{
+		BytesIn:        ts.bytesIn.Load(),
+		BytesOut:       ts.bytesOut.Load(),
+		TotalConns:     ts.totalConns.Load(),
+		KeepaliveRTTMs: float64(ts.rtt.Load()) / float64(time.Millisecond),
+		LastKeepalive:  ts.lastKeepalive.Load(),
+		Reconnects:     ts.reconnects.Load(),
+		Connections:    []types.SSHTunnelConnStat{},
+	}
+	if running && since > 0 {
+		s.UptimeSeconds = time.Now().Unix() - since
+	}
+
+	ts.mu.Lock()
+	for _, cs := range ts.conns {
+		s.Connections = append(s.Connections, types.SSHTunnelConnStat{
+			Peer:     cs.peer,
+			Dest:     cs.dest,
+			Since:    cs.since.Unix(),
+			BytesIn:  cs.bytesIn.Load(),
+			BytesOut: cs.bytesOut.Load(),
+		})
+	}
+	ts.mu.Unlock()
+
+	sort.Slice(s.Connections, func(i, j int) bool {
+		return s.Connections[i].Since < s.Connections[j].Since
+	})
+	s.ActiveConns = len(s.Connections)
+
+	return s
+}
+
+// sample appends a history point, computing rates against the previous one
+func (ts *tunnelStats) sample(status string) {
+	now := time.Now()
+	point := types.SSHTunnelSample{
+		Time:           now.Unix(),
+		Status:         status,
+		BytesIn:        ts.bytesIn.Load(),
+		BytesOut:       ts.bytesOut.Load(),
+		KeepaliveRTTMs: float64(ts.rtt.Load()) / float64(time.Millisecond),
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	point.ActiveConns = len(ts.conns)
+	if n := len(ts.history); n > 0 {
+		prev := ts.history[n-1]
+		if elapsed := float64(point.Time - prev.Time); elapsed > 0 {
+			point.RateIn = float64(point.BytesIn-prev.BytesIn) / elapsed
+			point.RateOut = float64(point.BytesOut-prev.BytesOut) / elapsed
+		}
+	}
+
+	ts.history = append(ts.history, point)
+	if len(ts.history) > statsMaxSamples {
+		ts.history = ts.history[len(ts.history)-statsMaxSamples:]
+	}
+}
+
+// samples returns a copy of the history, oldest first
+func (ts *tunnelStats) samples() []types.SSHTunnelSample {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	out := make([]types.SSHTunnelSample, len(ts.history))
+	copy(out, ts.history)
+	return out
+}
+
+// countingWriter adds every write to a pair of counters
+type countingWriter struct {
+	w           io.Writer
+	conn, total *atomic.Uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.conn.Add(uint64(n))
+	cw.total.Add(uint64(n))
+	return n, err
+}
+
+// pipeCounted copies data between a local and a remote (SSH-side) connection,
+// counting bytes in each direction
+func pipeCounted(local, remote net.Conn, ts *tunnelStats, cs *connStats) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst io.Writer, src, a, b net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// Unblock the other direction
+		a.Close()
+		b.Close()
+	}
+	go copyHalf(&countingWriter{w: local, conn: &cs.bytesIn, total: &ts.bytesIn}, remote, local, remote)
+	go copyHalf(&countingWriter{w: remote, conn: &cs.bytesOut, total: &ts.bytesOut}, local, local, remote)
+	wg.Wait()
+}
+
+// SampleInterval returns the spacing of history samples in seconds
+func (tm *TunnelManager) SampleInterval() int {
+	return int(statsInterval / time.Second)
+}
+
+// sampleLoop records a history point for every tunnel each statsInterval
+func (tm *TunnelManager) sampleLoop() {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tm.mu.RLock()
+		for id, ts := range tm.stats {
+			status := "stopped"
+			if tunnel, ok := tm.tunnels[id]; ok {
+				status = tunnel.Status
+			}
+			ts.sample(status)
+		}
+		tm.mu.RUnlock()
+	}
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPipeCounted(t *testing.T) {
+	ts := newTunnelStats()
+	cs, untrack := ts.track("198.51.100.7:5000", "127.0.0.1:80")
+
+	local, localPeer := net.Pipe()
+	remote, remotePeer := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		pipeCounted(local, remote, ts, cs)
+		untrack()
+		close(done)
+	}()
+
+	// 5 bytes out to the remote side, 11 bytes back in
+	go io.WriteString(localPeer, "hello")
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(remotePeer, buf); err != nil {
+		t.Fatal(err)
+	}
+	go io.WriteString(remotePeer, "hello there")
+	buf = make([]byte, 11)
+	if _, err := io.ReadFull(localPeer, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := ts.snapshot(0, true); s.ActiveConns != 1 || s.Connections[0].Peer != "198.51.100.7:5000" {
+		t.Fatalf("snapshot while open = %+v", s)
+	}
+
+	localPeer.Close()
+	<-done
+
+	s := ts.snapshot(0, true)
+	if s.BytesOut != 5 || s.BytesIn != 11 {
+		t.Errorf("bytes out/in = %d/%d, want 5/11", s.BytesOut, s.BytesIn)
+	}
+	if cs.bytesOut.Load() != 5 || cs.bytesIn.Load() != 11 {
+		t.Errorf("connection bytes out/in = %d/%d, want 5/11", cs.bytesOut.Load(), cs.bytesIn.Load())
+	}
+	if s.TotalConns != 1 || s.ActiveConns != 0 {
+		t.Errorf("total/active = %d/%d, want 1/0", s.TotalConns, s.ActiveConns)
+	}
+}
+
+func TestSampleHistory(t *testing.T) {
+	ts := newTunnelStats()
+	for i := 0; i < statsMaxSamples+10; i++ {
+		ts.bytesIn.Add(100)
+		ts.sample("running")
+	}
+
+	history := ts.samples()
+	if len(history) != statsMaxSamples {
+		t.Fatalf("kept %d samples, want %d", len(history), statsMaxSamples)
+	}
+	last := history[len(history)-1]
+	if last.BytesIn != uint64(100*(statsMaxSamples+10)) || last.Status != "running" {
+		t.Errorf("last sample = %+v", last)
+	}
+}
@@ -0,0 +1,208 @@
+//go:build ignore
+
+// genppk writes the PuTTY key fixtures used by keyformat_test.go, following
+// the PPK v2 and v3 layout of PuTTY's sshpubk.c. Each key is written with a
+// matching OpenSSH .pub file for the tests to compare against.
+//
+//	go run testdata/genppk.go
+//
+// The encrypted PKCS#8 fixture comes from openssl:
+//
+//	openssl genpkey -algorithm ed25519 | openssl pkcs8 -topk8 -v2 aes-256-cbc \
+//	    -passout pass:correct-horse -out testdata/pkcs8-ed25519-enc.pem
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const passphrase = "correct-horse"
+
+func main() {
+	seed := sha256.Sum256([]byte("nm-webui ppk fixture"))
+	edKey := ed25519.NewKeyFromSeed(seed[:])
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	write("ppk-v2-ed25519", edKey, 2, "")
+	write("ppk-v2-ed25519-enc", edKey, 2, passphrase)
+	write("ppk-v3-ed25519", edKey, 3, "")
+	write("ppk-v3-ed25519-enc", edKey, 3, passphrase)
+	write("ppk-v3-rsa-enc", rsaKey, 3, passphrase)
+	write("ppk-v2-ecdsa", ecKey, 2, "")
+}
+
+// write stores name.ppk and name.pub
+func write(name string, key crypto.Signer, version int, pass string) {
+	pub, err := gossh.NewPublicKey(key.Public())
+	if err != nil {
+		log.Fatal(err)
+	}
+	comment := name
+	ppk := encode(pub, privateBlob(key), comment, version, pass)
+
+	if err := os.WriteFile(filepath.Join("testdata", name+".ppk"), ppk, 0644); err != nil {
+		log.Fatal(err)
+	}
+	line := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub))) + " " + comment + "\n"
+	if err := os.WriteFile(filepath.Join("testdata", name+".pub"), []byte(line), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// privateBlob returns the algorithm-specific private fields of a PPK file
+func privateBlob(key crypto.Signer) []byte {
+	var b bytes.Buffer
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		putString(&b, k.Seed())
+	case *rsa.PrivateKey:
+		iqmp := new(big.Int).ModInverse(k.Primes[1], k.Primes[0])
+		for _, n := range []*big.Int{k.D, k.Primes[0], k.Primes[1], iqmp} {
+			putMPInt(&b, n)
+		}
+	case *ecdsa.PrivateKey:
+		putMPInt(&b, k.D)
+	default:
+		log.Fatalf("unsupported key %T", key)
+	}
+	return b.Bytes()
+}
+
+// encode lays out a PPK file, encrypting it with aes256-cbc when pass is set
+func encode(pub gossh.PublicKey, private []byte, comment string, version int, pass string) []byte {
+	encryption := "none"
+	var headers []string
+	var cipherKey, iv, macKey []byte
+	var newMAC func() hash.Hash
+
+	if pass != "" {
+		encryption = "aes256-cbc"
+		// Pad to the cipher block size as PuTTY does
+		if pad := aes.BlockSize - len(private)%aes.BlockSize; pad != aes.BlockSize {
+			sum := sha1.Sum(private)
+			private = append(private, sum[:pad]...)
+		}
+	}
+
+	if version == 2 {
+		newMAC = sha1.New
+		if pass != "" {
+			h0 := sha1.Sum(append([]byte{0, 0, 0, 0}, pass...))
+			h1 := sha1.Sum(append([]byte{0, 0, 0, 1}, pass...))
+			cipherKey = append(h0[:], h1[:]...)[:32]
+			iv = make([]byte, aes.BlockSize)
+		}
+		mk := sha1.Sum([]byte("putty-private-key-file-mac-key" + pass))
+		macKey = mk[:]
+	} else {
+		newMAC = sha256.New
+		if pass != "" {
+			salt := make([]byte, 16)
+			rand.Read(salt)
+			const memory, passes, parallel = 8192, 8, 1
+			derived := argon2.IDKey([]byte(pass), salt, passes, memory, parallel, 80)
+			cipherKey, iv, macKey = derived[:32], derived[32:48], derived[48:]
+			headers = []string{
+				"Key-Derivation: Argon2id",
+				"Argon2-Memory: 8192",
+				"Argon2-Passes: 8",
+				"Argon2-Parallelism: 1",
+				"Argon2-Salt: " + hex.EncodeToString(salt),
+			}
+		}
+	}
+
+	mac := hmac.New(newMAC, macKey)
+	for _, field := range [][]byte{[]byte(pub.Type()), []byte(encryption), []byte(comment), pub.Marshal(), private} {
+		binary.Write(mac, binary.BigEndian, uint32(len(field)))
+		mac.Write(field)
+	}
+
+	stored := private
+	if pass != "" {
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stored = make([]byte, len(private))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(stored, private)
+	}
+
+	var out strings.Builder
+	out.WriteString("PuTTY-User-Key-File-" + strconv.Itoa(version) + ": " + pub.Type() + "\n")
+	out.WriteString("Encryption: " + encryption + "\n")
+	out.WriteString("Comment: " + comment + "\n")
+	writeLines(&out, "Public-Lines", pub.Marshal())
+	for _, h := range headers {
+		out.WriteString(h + "\n")
+	}
+	writeLines(&out, "Private-Lines", stored)
+	out.WriteString("Private-MAC: " + hex.EncodeToString(mac.Sum(nil)) + "\n")
+	if version == 2 {
+		// As saved by PuTTYgen on Windows
+		return []byte(strings.ReplaceAll(out.String(), "\n", "\r\n"))
+	}
+	return []byte(out.String())
+}
+
+// writeLines writes a base64 blob in 64-character lines after its count
+func writeLines(out *strings.Builder, name string, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var lines []string
+	for len(enc) > 64 {
+		lines = append(lines, enc[:64])
+		enc = enc[64:]
+	}
+	lines = append(lines, enc)
+	out.WriteString(name + ": " + strconv.Itoa(len(lines)) + "\n")
+	for _, l := range lines {
+		out.WriteString(l + "\n")
+	}
+}
+
+func putString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+// putMPInt writes an SSH mpint: big-endian, with a leading zero byte when
+// the top bit is set
+func putMPInt(b *bytes.Buffer, n *big.Int) {
+	raw := n.Bytes()
+	if len(raw) > 0 && raw[0]&0x80 != 0 {
+		raw = append([]byte{0}, raw...)
+	}
+	putString(b, raw)
+}
@@ -1,23 +1,29 @@
 package ssh
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
-	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"nm-webui/internal/logger"
 	"nm-webui/internal/types"
 )
 
+// Reconnect backoff bounds for tunnels whose connection dropped
+const (
+	reconnectMin = 5 * time.Second
+	reconnectMax = 2 * time.Minute
+	maxJumpHosts = 8
+
+	defaultSSHPort = 22
+)
+
 // TunnelManager handles SSH tunnel operations
 type TunnelManager struct {
 	dataDir    string
@@ -25,6 +31,16 @@ type TunnelManager struct {
 	logger     *logger.Logger
 	mu         sync.RWMutex
 	tunnels    map[string]*types.SSHTunnel
+	running    map[string]*tunnelRun
+	stats      map[string]*tunnelStats
+}
+
+// tunnelRun tracks the supervisor goroutine of a started tunnel
+type tunnelRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	stats  *tunnelStats
 }
 
 // NewTunnelManager creates a new tunnel manager
@@ -36,11 +52,18 @@ func NewTunnelManager(dataDir string, km *KeyManager, log *logger.Logger) *Tunne
 		keyManager: km,
 		logger:     log,
 		tunnels:    make(map[string]*types.SSHTunnel),
+		running:    make(map[string]*tunnelRun),
+		stats:      make(map[string]*tunnelStats),
 	}
 
 	// Load existing tunnels from storage
 	tm.loadRegistry()
 
+	// Bring back tunnels that were running when we last exited
+	tm.resume()
+
+	go tm.sampleLoop()
+
 	return tm
 }
 
@@ -69,179 +92,245 @@ func (tm *TunnelManager) loadRegistry() {
 		return
 	}
 
+	for id, tunnel := range tunnels {
+		tunnel.ID = id
+		// Tunnels saved without a port were always started on 22
+		if tunnel.Port == 0 {
+			tunnel.Port = defaultSSHPort
+		}
+		for i := range tunnel.Jumps {
+			if tunnel.Jumps[i].Port == 0 {
+				tunnel.Jumps[i].Port = defaultSSHPort
+			}
+		}
+	}
+
 	tm.tunnels = tunnels
 	tm.logger.Info("ssh", "load_registry").
 		WithExtra("count", len(tunnels)).
 		Commit()
 }
 
-// saveRegistry saves tunnel configurations to disk
+// saveRegistry saves tunnel configurations to disk (caller holds tm.mu).
+// Passwords are never written; they only live in memory.
 func (tm *TunnelManager) saveRegistry() error {
-	data, err := json.MarshalIndent(tm.tunnels, "", "  ")
+	stored := make(map[string]types.SSHTunnel, len(tm.tunnels))
+	for id, tunnel := range tm.tunnels {
+		stored[id] = redactTunnel(tunnel)
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(tm.registryPath(), data, 0600)
 }
 
-// List returns all tunnels with updated status
-func (tm *TunnelManager) List() []types.SSHTunnel {
+// resume restarts tunnels that were active when the registry was saved
+func (tm *TunnelManager) resume() {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	tm.logger.Debug("ssh", "list_tunnels").Commit()
-
-	// Update status based on actual process state
 	for id, tunnel := range tm.tunnels {
-		if tunnel.PID > 0 && tm.isPIDAlive(tunnel.PID) {
-			tunnel.Status = "running"
-		} else {
+		if tunnel.Status == "stopped" {
+			continue
+		}
+		if requiresPassword(tunnel) {
+			// Passwords are not persisted; the user has to recreate the tunnel
 			tunnel.Status = "stopped"
-			tunnel.PID = 0
+			tunnel.LastError = "password not available after restart"
+			continue
 		}
-		tunnel.ID = id
-	}
 
-	// Save updated status
-	tm.saveRegistry()
+		tunnel.Status = "connecting"
+		run := tm.newRun(id)
+		go tm.supervise(id, tunnel, nil, run)
 
-	// Convert map to slice
-	result := make([]types.SSHTunnel, 0, len(tm.tunnels))
-	for _, tunnel := range tm.tunnels {
-		result = append(result, *tunnel)
+		tm.logger.Info("ssh", "resume_tunnel").
+			WithExtra("id", id).
+			Commit()
 	}
+	tm.saveRegistry()
+}
+
+// List returns all tunnels with their current status
+func (tm *TunnelManager) List(ctx context.Context) []types.SSHTunnel {
+	tm.logger.Debug("ssh", "list_tunnels").Commit()
+
+	result := tm.Snapshot()
 
 	tm.logger.Debug("ssh", "list_tunnels").
+		WithContext(ctx).
 		WithExtra("count", len(result)).
 		Commit()
 	return result
 }
 
+// Snapshot returns all tunnels with their status and stats, without
+// logging, for metrics scrapes
+func (tm *TunnelManager) Snapshot() []types.SSHTunnel {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	result := make([]types.SSHTunnel, 0, len(tm.tunnels))
+	for id, tunnel := range tm.tunnels {
+		t := redactTunnel(tunnel)
+		if ts, ok := tm.stats[id]; ok {
+			t.Stats = ts.snapshot(tunnel.Since, tunnel.Status == "running")
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
 // Create creates and starts a new tunnel
-func (tm *TunnelManager) Create(req types.SSHTunnelCreateRequest) (*types.SSHTunnel, error) {
+func (tm *TunnelManager) Create(ctx context.Context, req types.SSHTunnelCreateRequest) (*types.SSHTunnel, error) {
 	// Validate inputs
+	req = withDefaults(req)
 	if err := tm.validateTunnelRequest(req); err != nil {
 		return nil, err
 	}
 
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
-	// Start the SSH process
-	pid, err := tm.spawnSSH(req)
-	if err != nil {
-		return nil, err
-	}
-
 	// Generate unique ID and create tunnel record
 	tunnelID := generateID()
 	tunnel := &types.SSHTunnel{
 		ID:       tunnelID,
-		Status:   "running",
-		PID:      pid,
+		Status:   "connecting",
 		Host:     req.Host,
 		Port:     req.Port,
 		User:     req.User,
 		AuthType: req.AuthType,
 		KeyFile:  req.KeyFile,
+		Password: req.Password,
+		Jumps:    req.Jumps,
+		Proxy:    req.Proxy,
 		FwdType:  req.FwdType,
 		LPort:    req.LPort,
 		RHost:    req.RHost,
 		RPort:    req.RPort,
-		Since:    time.Now().Unix(),
 	}
 
+	tm.mu.Lock()
+	run := tm.newRun(tunnelID)
+	tm.mu.Unlock()
+
+	// Connect before registering so bad credentials or hosts fail the request
+	sess, err := tm.open(run.ctx, tunnel)
+	if err != nil {
+		tm.mu.Lock()
+		delete(tm.running, tunnelID)
+		delete(tm.stats, tunnelID)
+		tm.mu.Unlock()
+		close(run.done)
+
+		tm.logger.Error("ssh", "create_tunnel").
+			WithContext(ctx).
+			WithExtra("host", req.User+"@"+req.Host).
+			WithError(err).
+			Commit()
+		return nil, err
+	}
+
+	tm.mu.Lock()
+	tunnel.Status = "running"
+	tunnel.Since = time.Now().Unix()
 	tm.tunnels[tunnelID] = tunnel
 	if err := tm.saveRegistry(); err != nil {
 		tm.logger.Warn("ssh", "save_registry").
+			WithContext(ctx).
 			WithError(err).
 			Commit()
 	}
+	result := redactTunnel(tunnel)
+	tm.mu.Unlock()
+
+	go tm.supervise(tunnelID, tunnel, sess, run)
 
 	tm.logger.Info("ssh", "create_tunnel").
+		WithContext(ctx).
 		WithExtra("id", tunnelID).
-		WithExtra("pid", pid).
 		WithExtra("host", req.User+"@"+req.Host).
+		WithExtra("jumps", len(req.Jumps)).
 		WithExtra("type", req.FwdType).
 		Commit()
 
-	return tunnel, nil
+	return &result, nil
 }
 
 // Start starts an existing stopped tunnel
-func (tm *TunnelManager) Start(tunnelID string) error {
+func (tm *TunnelManager) Start(ctx context.Context, tunnelID string) error {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
 	tunnel, exists := tm.tunnels[tunnelID]
 	if !exists {
+		tm.mu.Unlock()
 		return fmt.Errorf("tunnel not found")
 	}
-
-	if tunnel.Status == "running" && tunnel.PID > 0 && tm.isPIDAlive(tunnel.PID) {
+	if _, running := tm.running[tunnelID]; running {
+		tm.mu.Unlock()
 		return fmt.Errorf("tunnel already running")
 	}
+	run := tm.newRun(tunnelID)
+	tunnel.Status = "connecting"
+	tm.mu.Unlock()
 
-	// Reconstruct request from tunnel config
-	req := types.SSHTunnelCreateRequest{
-		Host:     tunnel.Host,
-		Port:     tunnel.Port,
-		User:     tunnel.User,
-		AuthType: tunnel.AuthType,
-		KeyFile:  tunnel.KeyFile,
-		FwdType:  tunnel.FwdType,
-		LPort:    tunnel.LPort,
-		RHost:    tunnel.RHost,
-		RPort:    tunnel.RPort,
-	}
-
-	pid, err := tm.spawnSSH(req)
+	sess, err := tm.open(run.ctx, tunnel)
 	if err != nil {
+		tm.mu.Lock()
+		delete(tm.running, tunnelID)
+		tunnel.Status = "stopped"
+		tunnel.LastError = err.Error()
+		tm.saveRegistry()
+		tm.mu.Unlock()
+		close(run.done)
+
+		tm.logger.Error("ssh", "start_tunnel").
+			WithContext(ctx).
+			WithExtra("id", tunnelID).
+			WithError(err).
+			Commit()
 		return err
 	}
 
-	tunnel.PID = pid
+	tm.mu.Lock()
 	tunnel.Status = "running"
+	tunnel.LastError = ""
 	tunnel.Since = time.Now().Unix()
 	tm.saveRegistry()
+	tm.mu.Unlock()
+
+	go tm.supervise(tunnelID, tunnel, sess, run)
 
 	tm.logger.Info("ssh", "start_tunnel").
+		WithContext(ctx).
 		WithExtra("id", tunnelID).
-		WithExtra("pid", pid).
 		Commit()
 
 	return nil
 }
 
 // Stop stops a running tunnel
-func (tm *TunnelManager) Stop(tunnelID string) error {
+func (tm *TunnelManager) Stop(ctx context.Context, tunnelID string) error {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
 	tunnel, exists := tm.tunnels[tunnelID]
+	tm.mu.Unlock()
 	if !exists {
 		return fmt.Errorf("tunnel not found")
 	}
 
-	if tunnel.PID > 0 {
-		tm.logger.Info("ssh", "stop_tunnel").
-			WithExtra("id", tunnelID).
-			WithExtra("pid", tunnel.PID).
-			Commit()
+	tm.logger.Info("ssh", "stop_tunnel").
+		WithContext(ctx).
+		WithExtra("id", tunnelID).
+		Commit()
 
-		if err := tm.killPID(tunnel.PID); err != nil {
-			tm.logger.Warn("ssh", "stop_tunnel_kill").
-				WithExtra("pid", tunnel.PID).
-				WithError(err).
-				Commit()
-		}
-	}
+	tm.halt(tunnelID)
 
+	tm.mu.Lock()
 	tunnel.Status = "stopped"
-	tunnel.PID = 0
 	tm.saveRegistry()
+	tm.mu.Unlock()
 
 	tm.logger.Info("ssh", "stop_tunnel_success").
+		WithContext(ctx).
 		WithExtra("id", tunnelID).
 		Commit()
 
@@ -249,54 +338,209 @@ func (tm *TunnelManager) Stop(tunnelID string) error {
 }
 
 // Delete stops and removes a tunnel configuration
-func (tm *TunnelManager) Delete(tunnelID string) error {
+func (tm *TunnelManager) Delete(ctx context.Context, tunnelID string) error {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
-	tunnel, exists := tm.tunnels[tunnelID]
+	_, exists := tm.tunnels[tunnelID]
+	tm.mu.Unlock()
 	if !exists {
 		return fmt.Errorf("tunnel not found")
 	}
 
 	// Stop if running
-	if tunnel.PID > 0 {
-		tm.killPID(tunnel.PID)
-	}
+	tm.halt(tunnelID)
 
+	tm.mu.Lock()
 	delete(tm.tunnels, tunnelID)
+	delete(tm.stats, tunnelID)
 	tm.saveRegistry()
+	tm.mu.Unlock()
 
 	tm.logger.Info("ssh", "delete_tunnel").
+		WithContext(ctx).
 		WithExtra("id", tunnelID).
 		Commit()
 
 	return nil
 }
 
-// validateTunnelRequest validates the tunnel creation request
-func (tm *TunnelManager) validateTunnelRequest(req types.SSHTunnelCreateRequest) error {
-	// Validate host
-	if req.Host == "" {
-		return fmt.Errorf("host is required")
+// History returns the metrics history of a tunnel, oldest first
+func (tm *TunnelManager) History(tunnelID string) ([]types.SSHTunnelSample, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	if _, exists := tm.tunnels[tunnelID]; !exists {
+		return nil, fmt.Errorf("tunnel not found")
 	}
-	if !isValidHostname(req.Host) && net.ParseIP(req.Host) == nil {
-		return fmt.Errorf("invalid host")
+	ts, ok := tm.stats[tunnelID]
+	if !ok {
+		return []types.SSHTunnelSample{}, nil
 	}
+	return ts.samples(), nil
+}
 
-	// Validate user
-	if req.User == "" {
-		return fmt.Errorf("user is required")
+// newRun registers a supervisor for a tunnel with fresh metrics (caller holds tm.mu)
+func (tm *TunnelManager) newRun(tunnelID string) *tunnelRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &tunnelRun{ctx: ctx, cancel: cancel, done: make(chan struct{}), stats: newTunnelStats()}
+	tm.running[tunnelID] = run
+	tm.stats[tunnelID] = run.stats
+	return run
+}
+
+// halt cancels a tunnel's supervisor and waits for it to exit
+func (tm *TunnelManager) halt(tunnelID string) {
+	tm.mu.Lock()
+	run, ok := tm.running[tunnelID]
+	delete(tm.running, tunnelID)
+	tm.mu.Unlock()
+
+	if ok {
+		run.cancel()
+		<-run.done
 	}
-	if !regexp.MustCompile(`^[a-zA-Z0-9._-]+$`).MatchString(req.User) {
-		return fmt.Errorf("invalid SSH user")
+}
+
+// supervise serves a tunnel and reconnects it with backoff until stopped.
+// sess may be nil, in which case the first connection is made here.
+func (tm *TunnelManager) supervise(tunnelID string, tunnel *types.SSHTunnel, sess *session, run *tunnelRun) {
+	defer close(run.done)
+
+	backoff := reconnectMin
+	lost := false
+	for {
+		if sess == nil {
+			var err error
+			sess, err = tm.open(run.ctx, tunnel)
+			if err != nil {
+				if run.ctx.Err() != nil {
+					return
+				}
+				tm.setState(tunnel, "connecting", err)
+				tm.logger.Warn("ssh", "reconnect_tunnel").
+					WithExtra("id", tunnelID).
+					WithExtra("retry_in", backoff.String()).
+					WithError(err).
+					Commit()
+
+				select {
+				case <-run.ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > reconnectMax {
+					backoff = reconnectMax
+				}
+				continue
+			}
+
+			backoff = reconnectMin
+			if lost {
+				run.stats.reconnects.Add(1)
+			}
+			tm.setState(tunnel, "running", nil)
+			tm.logger.Info("ssh", "reconnect_tunnel_success").
+				WithExtra("id", tunnelID).
+				WithExtra("reconnects", run.stats.reconnects.Load()).
+				Commit()
+		}
+
+		err := tm.serve(run.ctx, tunnel, sess, run.stats)
+		sess = nil
+		lost = true
+		if run.ctx.Err() != nil {
+			return
+		}
+
+		tm.setState(tunnel, "connecting", err)
+		tm.logger.Warn("ssh", "tunnel_lost").
+			WithExtra("id", tunnelID).
+			WithError(err).
+			Commit()
 	}
+}
 
-	// Validate port
-	if req.Port <= 0 {
-		req.Port = 22
+// setState records a tunnel's connection state
+func (tm *TunnelManager) setState(tunnel *types.SSHTunnel, status string, err error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if status == "running" && tunnel.Status != "running" {
+		tunnel.Since = time.Now().Unix()
 	}
-	if req.Port > 65535 {
-		return fmt.Errorf("invalid SSH port")
+	tunnel.Status = status
+	tunnel.LastError = ""
+	if err != nil {
+		tunnel.LastError = err.Error()
+	}
+}
+
+// withDefaults fills in the SSH port of the server and jump hosts and the
+// forward target host when they were left out
+func withDefaults(req types.SSHTunnelCreateRequest) types.SSHTunnelCreateRequest {
+	if req.Port == 0 {
+		req.Port = defaultSSHPort
+	}
+	if len(req.Jumps) > 0 {
+		jumps := make([]types.SSHJumpHost, len(req.Jumps))
+		copy(jumps, req.Jumps)
+		for i := range jumps {
+			if jumps[i].Port == 0 {
+				jumps[i].Port = defaultSSHPort
+			}
+		}
+		req.Jumps = jumps
+	}
+	if req.FwdType != "D" && req.RHost == "" {
+		req.RHost = "127.0.0.1"
+	}
+	return req
+}
+
+// validateTunnelRequest validates the tunnel creation request
+func (tm *TunnelManager) validateTunnelRequest(req types.SSHTunnelCreateRequest) error {
+	// Validate host, user, port and auth of the tunnel server
+	if err := tm.validateHop(types.SSHJumpHost{
+		Host:     req.Host,
+		Port:     req.Port,
+		User:     req.User,
+		AuthType: req.AuthType,
+		KeyFile:  req.KeyFile,
+		Password: req.Password,
+	}); err != nil {
+		return err
+	}
+
+	// Validate jump hosts
+	if len(req.Jumps) > maxJumpHosts {
+		return fmt.Errorf("too many jump hosts (max %d)", maxJumpHosts)
+	}
+	for i, jump := range req.Jumps {
+		if err := tm.validateHop(jump); err != nil {
+			return fmt.Errorf("jump host %d: %v", i+1, err)
+		}
+	}
+
+	// Validate upstream proxy
+	if req.Proxy != nil {
+		if req.Proxy.Type != "http" && req.Proxy.Type != "socks5" {
+			return fmt.Errorf("invalid proxy type: must be http or socks5")
+		}
+		if req.Proxy.Host == "" {
+			return fmt.Errorf("proxy host is required")
+		}
+		if !isValidHostname(req.Proxy.Host) && net.ParseIP(req.Proxy.Host) == nil {
+			return fmt.Errorf("invalid proxy host")
+		}
+		if req.Proxy.Port <= 0 || req.Proxy.Port > 65535 {
+			return fmt.Errorf("invalid proxy port")
+		}
+		if req.Proxy.Password != "" && req.Proxy.User == "" {
+			return fmt.Errorf("proxy user is required when a proxy password is set")
+		}
+		if len(req.Proxy.User) > 255 || len(req.Proxy.Password) > 255 {
+			return fmt.Errorf("proxy credentials too long")
+		}
 	}
 
 	// Validate forward type
@@ -314,24 +558,47 @@ func (tm *TunnelManager) validateTunnelRequest(req types.SSHTunnelCreateRequest)
 		if req.RPort <= 0 || req.RPort > 65535 {
 			return fmt.Errorf("invalid target port")
 		}
-		if req.RHost == "" {
-			req.RHost = "127.0.0.1"
-		}
 		if !isValidHostname(req.RHost) && net.ParseIP(req.RHost) == nil {
 			return fmt.Errorf("invalid target host")
 		}
 	}
 
+	return nil
+}
+
+// validateHop validates the address and credentials of one SSH server
+func (tm *TunnelManager) validateHop(hop types.SSHJumpHost) error {
+	// Validate host
+	if hop.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if !isValidHostname(hop.Host) && net.ParseIP(hop.Host) == nil {
+		return fmt.Errorf("invalid host")
+	}
+
+	// Validate user
+	if hop.User == "" {
+		return fmt.Errorf("user is required")
+	}
+	if !regexp.MustCompile(`^[a-zA-Z0-9._-]+$`).MatchString(hop.User) {
+		return fmt.Errorf("invalid SSH user")
+	}
+
+	// Validate port
+	if hop.Port <= 0 || hop.Port > 65535 {
+		return fmt.Errorf("invalid SSH port")
+	}
+
 	// Validate auth
-	if req.AuthType == "key" {
-		if req.KeyFile == "" {
+	if hop.AuthType == "key" {
+		if hop.KeyFile == "" {
 			return fmt.Errorf("key file is required for key auth")
 		}
-		if !tm.keyManager.KeyExists(req.KeyFile) {
+		if !tm.keyManager.KeyExists(hop.KeyFile) {
 			return fmt.Errorf("key file not found")
 		}
-	} else if req.AuthType == "password" {
-		if req.Password == "" {
+	} else if hop.AuthType == "password" {
+		if hop.Password == "" {
 			return fmt.Errorf("password is required for password auth")
 		}
 	} else {
@@ -341,116 +608,33 @@ func (tm *TunnelManager) validateTunnelRequest(req types.SSHTunnelCreateRequest)
 	return nil
 }
 
-// spawnSSH spawns an SSH process and returns its PID
-func (tm *TunnelManager) spawnSSH(req types.SSHTunnelCreateRequest) (int, error) {
-	// Build port forwarding flags
-	var fwdFlag string
-	switch req.FwdType {
-	case "L":
-		fwdFlag = fmt.Sprintf("-L 0.0.0.0:%d:%s:%d", req.LPort, req.RHost, req.RPort)
-	case "R":
-		fwdFlag = fmt.Sprintf("-R 0.0.0.0:%d:%s:%d", req.RPort, req.RHost, req.LPort)
-	case "D":
-		fwdFlag = fmt.Sprintf("-D 0.0.0.0:%d", req.LPort)
-	}
-
-	// Build SSH command
-	var cmd *exec.Cmd
-	var cmdStr string
-
-	if req.AuthType == "key" {
-		keyPath := tm.keyManager.GetKeyPath(req.KeyFile)
-		args := []string{
-			"-N", "-T",
-			"-o", "ExitOnForwardFailure=yes",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "ServerAliveInterval=60",
-			"-o", "ServerAliveCountMax=3",
-			"-i", keyPath,
-			"-p", strconv.Itoa(req.Port),
-		}
-		// Add forwarding flag (split by space since it contains multiple parts)
-		args = append(args, strings.Fields(fwdFlag)...)
-		args = append(args, fmt.Sprintf("%s@%s", req.User, req.Host))
-
-		cmd = exec.Command("ssh", args...)
-		cmdStr = fmt.Sprintf("ssh %s", strings.Join(args, " "))
-	} else {
-		// Use sshpass for password auth
-		args := []string{
-			"-p", req.Password,
-			"ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-N", "-T",
-			"-o", "ExitOnForwardFailure=yes",
-			"-o", "ServerAliveInterval=60",
-			"-o", "ServerAliveCountMax=3",
-			"-p", strconv.Itoa(req.Port),
+// requiresPassword reports whether any hop or the proxy authenticates with a password
+func requiresPassword(t *types.SSHTunnel) bool {
+	for _, hop := range hops(t) {
+		if hop.AuthType == "password" {
+			return true
 		}
-		args = append(args, strings.Fields(fwdFlag)...)
-		args = append(args, fmt.Sprintf("%s@%s", req.User, req.Host))
-
-		cmd = exec.Command("sshpass", args...)
-		cmdStr = fmt.Sprintf("sshpass -p <masked> ssh %s", strings.Join(args[3:], " "))
-	}
-
-	// Start process in background
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	start := time.Now()
-	err := cmd.Start()
-	duration := time.Since(start)
-
-	if err != nil {
-		tm.logger.Error("ssh", "spawn_ssh").
-			WithCommand(cmdStr).
-			WithError(err).
-			WithDuration(duration).
-			Commit()
-		return 0, fmt.Errorf("failed to spawn SSH: %v", err)
 	}
-
-	pid := cmd.Process.Pid
-	tm.logger.Debug("ssh", "spawn_ssh").
-		WithCommand(cmdStr).
-		WithExtra("pid", pid).
-		WithDuration(duration).
-		Commit()
-
-	// Wait a moment to check if it failed immediately
-	time.Sleep(500 * time.Millisecond)
-	if !tm.isPIDAlive(pid) {
-		return 0, fmt.Errorf("SSH process exited immediately - check credentials and connectivity")
-	}
-
-	// Detach from the process so it continues running after we return
-	go cmd.Wait()
-
-	return pid, nil
+	return t.Proxy != nil && t.Proxy.User != ""
 }
 
-// isPIDAlive checks if a process is still running
-func (tm *TunnelManager) isPIDAlive(pid int) bool {
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
+// redactTunnel returns a copy of a tunnel with all credentials removed
+func redactTunnel(t *types.SSHTunnel) types.SSHTunnel {
+	out := *t
+	out.Password = ""
+	if len(t.Jumps) > 0 {
+		out.Jumps = make([]types.SSHJumpHost, len(t.Jumps))
+		for i, jump := range t.Jumps {
+			jump.Password = ""
+			out.Jumps[i] = jump
+		}
 	}
-	// Signal 0 doesn't send a signal but checks if process exists
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
-}
-
-// killPID terminates a process
-func (tm *TunnelManager) killPID(pid int) error {
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return err
+	if t.Proxy != nil {
+		proxy := *t.Proxy
+		proxy.Password = ""
+		out.Proxy = &proxy
 	}
-	return process.Signal(syscall.SIGTERM)
+	return out
 }
 
 // isValidHostname validates a hostname string
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// startPTY is only supported on Linux
+func startPTY(cmd *exec.Cmd, rows, cols uint16) (*os.File, error) {
+	return nil, fmt.Errorf("terminal sessions are not supported on this platform")
+}
+
+// setSize is only supported on Linux
+func setSize(ptmx *os.File, rows, cols uint16) error {
+	return fmt.Errorf("terminal sessions are not supported on this platform")
+}
+
+// hangup stops the shell
+func hangup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
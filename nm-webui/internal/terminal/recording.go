@@ -0,0 +1,376 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"nm-webui/internal/types"
+)
+
+const (
+	recordingExt = ".cast"
+
+	// recordingHeaderMax bounds how much of a file is read to parse its header
+	recordingHeaderMax = 64 << 10
+)
+
+// errRecordingFull is returned once an event no longer fits a recording
+var errRecordingFull = errors.New("recording size limit reached")
+
+// castHeader is the first line of an asciicast v2 file. Session details are
+// kept under an extra key, which players ignore.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Session   castSession       `json:"nm_webui"`
+}
+
+// castSession records who opened a session and from where
+type castSession struct {
+	ID       string `json:"session"`
+	User     string `json:"user"`      // login user of the shell
+	AuthUser string `json:"auth_user"` // web UI user who opened it
+	Remote   string `json:"remote"`
+}
+
+// recorder appends terminal output to an asciicast v2 file
+type recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	started time.Time
+	partial []byte // trailing bytes of an incomplete UTF-8 sequence
+	size    int64  // bytes written
+	limit   int64  // most bytes the file may hold; 0 is unlimited
+	err     error  // first write failure or errRecordingFull; later events are refused
+	closed  bool
+}
+
+// startRecording creates the recording file for a session
+func (m *Manager) startRecording(s *session) (*recorder, error) {
+	if err := os.MkdirAll(m.cfg.RecordingDir, 0700); err != nil {
+		return nil, err
+	}
+
+	// Retention only runs when a session opens, so the new recording is
+	// held to the budget left beside the files kept and the open recordings
+	m.recMu.Lock()
+	defer m.recMu.Unlock()
+	limit, err := m.pruneRecordings()
+	if err != nil {
+		return nil, err
+	}
+
+	name := s.started.UTC().Format("20060102-150405") + "-" + s.id + recordingExt
+	f, err := os.OpenFile(filepath.Join(m.cfg.RecordingDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{file: f, w: bufio.NewWriter(f), started: s.started, limit: limit}
+	header := castHeader{
+		Version:   2,
+		Width:     int(s.cols.Load()),
+		Height:    int(s.rows.Load()),
+		Timestamp: s.started.Unix(),
+		Title:     fmt.Sprintf("%s@%s from %s", s.user, hostname(), s.remote),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+		Session: castSession{
+			ID:       s.id,
+			User:     s.user,
+			AuthUser: s.authUser,
+			Remote:   s.remote,
+		},
+	}
+	if err := rec.writeLine(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := rec.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.recLimits[s.id] = limit
+	return rec, nil
+}
+
+// output records data written to the terminal, and returns an error once
+// the recording is full or cannot be written. Multi-byte characters split
+// across reads are held back so every event is valid UTF-8.
+func (r *recorder) output(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := append(r.partial, data...)
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte(nil), buf[cut:]...)
+	if cut > 0 {
+		return r.event("o", string(buf[:cut]))
+	}
+	return r.err
+}
+
+// resize records a terminal size change, and returns an error once the
+// recording is full or cannot be written
+func (r *recorder) resize(cols, rows int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event writes one [time, code, data] line, unless it would take the file
+// past its limit. The first failure sticks, so nothing is recorded after a
+// gap.
+func (r *recorder) event(code, data string) error {
+	if r.closed || r.err != nil {
+		return r.err
+	}
+	elapsed := float64(time.Since(r.started).Microseconds()) / 1e6
+	line, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err != nil {
+		return nil
+	}
+	if r.limit > 0 && r.size+int64(len(line))+1 > r.limit {
+		r.err = errRecordingFull
+		return r.err
+	}
+	if err := r.writeRaw(line); err != nil {
+		r.err = err
+		return err
+	}
+	if err := r.w.Flush(); err != nil {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
+func (r *recorder) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.writeRaw(data)
+}
+
+func (r *recorder) writeRaw(line []byte) error {
+	r.size += int64(len(line)) + 1
+	if _, err := r.w.Write(line); err != nil {
+		return err
+	}
+	return r.w.WriteByte('\n')
+}
+
+// close flushes and closes the recording
+func (r *recorder) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 {
+		r.event("o", string(r.partial))
+		r.partial = nil
+	}
+	r.w.Flush()
+	r.file.Close()
+	r.closed = true
+}
+
+// ListRecordings returns the stored recordings, newest first
+func (m *Manager) ListRecordings() ([]types.TerminalRecording, error) {
+	if m.cfg.RecordingDir == "" {
+		return []types.TerminalRecording{}, nil
+	}
+	entries, err := os.ReadDir(m.cfg.RecordingDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []types.TerminalRecording{}, nil
+		}
+		return nil, err
+	}
+
+	open := m.openSessionIDs()
+
+	list := make([]types.TerminalRecording, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), recordingExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		header, err := readCastHeader(filepath.Join(m.cfg.RecordingDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		started := time.Unix(header.Timestamp, 0)
+		list = append(list, types.TerminalRecording{
+			ID:        strings.TrimSuffix(e.Name(), recordingExt),
+			SessionID: header.Session.ID,
+			User:      header.Session.User,
+			AuthUser:  header.Session.AuthUser,
+			Remote:    header.Session.Remote,
+			Started:   started.Format(time.RFC3339),
+			Duration:  int64(info.ModTime().Sub(started).Seconds()),
+			Size:      info.Size(),
+			Active:    open[header.Session.ID],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+// RecordingPath returns the file of a recording, checking that it exists
+func (m *Manager) RecordingPath(id string) (string, error) {
+	if m.cfg.RecordingDir == "" {
+		return "", fmt.Errorf("recording is disabled")
+	}
+	id = filepath.Base(id)
+	if id == "." || id == string(filepath.Separator) {
+		return "", fmt.Errorf("recording not found")
+	}
+	path := filepath.Join(m.cfg.RecordingDir, id+recordingExt)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("recording not found")
+	}
+	return path, nil
+}
+
+// pruneRecordings applies the retention limits and returns the byte limit
+// for a new recording, 0 when unlimited. Recordings older than
+// RecordingMaxAge are removed, then the oldest finished ones until a
+// session's share of RecordingMaxSize is free. Open recordings count at
+// their limit, since they may still grow to it. Called with recMu held.
+func (m *Manager) pruneRecordings() (int64, error) {
+	entries, err := os.ReadDir(m.cfg.RecordingDir)
+	if err != nil {
+		return 0, err
+	}
+
+	open := m.openSessionIDs()
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), recordingExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		id := recordingSessionID(e.Name())
+		if open[id] {
+			if limit, ok := m.recLimits[id]; ok && limit > 0 {
+				total += limit
+			} else {
+				total += info.Size()
+			}
+			continue
+		}
+		total += info.Size()
+		files = append(files, file{e.Name(), info.Size(), info.ModTime()})
+	}
+	// Names start with the UTC start time, so they sort oldest first
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	// With every session at its share, concurrent recordings always fit
+	share := m.cfg.RecordingMaxSize / int64(m.cfg.MaxSessions)
+
+	removed := 0
+	for _, f := range files {
+		expired := m.cfg.RecordingMaxAge > 0 && time.Since(f.modTime) > m.cfg.RecordingMaxAge
+		if !expired && (m.cfg.RecordingMaxSize <= 0 || total+share <= m.cfg.RecordingMaxSize) {
+			continue
+		}
+		if os.Remove(filepath.Join(m.cfg.RecordingDir, f.name)) == nil {
+			total -= f.size
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		m.logger.Info("terminal", "prune_recordings").
+			WithExtra("removed", removed).
+			Commit()
+	}
+
+	if m.cfg.RecordingMaxSize <= 0 {
+		return 0, nil
+	}
+	limit := min(share, m.cfg.RecordingMaxSize-total)
+	if limit <= 0 {
+		return 0, fmt.Errorf("no recording space left")
+	}
+	return limit, nil
+}
+
+// recordingSessionID returns the session ID from a recording file name
+func recordingSessionID(name string) string {
+	name = strings.TrimSuffix(name, recordingExt)
+	return name[strings.LastIndex(name, "-")+1:]
+}
+
+// readCastHeader parses the header line of a recording
+func readCastHeader(path string) (*castHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReaderSize(f, recordingHeaderMax).ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("invalid recording header")
+	}
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != 2 {
+		return nil, fmt.Errorf("invalid recording header")
+	}
+	return &header, nil
+}
+
+// openSessionIDs returns the IDs of the open sessions
+func (m *Manager) openSessionIDs() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	open := make(map[string]bool, len(m.sessions))
+	for id := range m.sessions {
+		open[id] = true
+	}
+	return open
+}
+
+// hostname returns the system hostname for recording titles
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
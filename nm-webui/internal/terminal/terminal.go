@@ -0,0 +1,505 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/types"
+)
+
+const (
+	defaultUser        = "root"
+	defaultIdleTimeout = 30 * time.Minute
+	defaultMaxSessions = 4
+
+	defaultCols = 80
+	defaultRows = 24
+
+	// reapTimeout is how long a hung-up shell gets to exit before it is killed
+	reapTimeout = 5 * time.Second
+)
+
+// ErrTooManySessions is returned when MaxSessions are already open
+var ErrTooManySessions = errors.New("too many terminal sessions")
+
+// Config controls the shells started for web terminal sessions
+type Config struct {
+	User        string        // login user (default root)
+	IdleTimeout time.Duration // close sessions without input for this long; 0 disables
+	MaxSessions int           // concurrent sessions (default 4)
+
+	// Recording: every session is saved as an asciicast file in
+	// RecordingDir unless it is empty
+	RecordingDir     string
+	RecordingMaxAge  time.Duration // remove older recordings; 0 keeps them
+	RecordingMaxSize int64         // total bytes kept, each session gets a MaxSessions share; 0 is unlimited
+}
+
+// Manager tracks open terminal sessions
+type Manager struct {
+	cfg    Config
+	logger *logger.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	starting int // sessions holding a slot while their shell starts
+
+	recMu     sync.Mutex       // serialises recording budget allocation
+	recLimits map[string]int64 // byte limits of open recordings, by session ID
+}
+
+// session is one shell attached to one WebSocket
+type session struct {
+	id       string
+	user     string
+	authUser string // web UI user who opened the session
+	authID   string // login session it was opened from; empty without a cookie session
+	remote   string
+	started  time.Time
+
+	lastActive atomic.Int64 // unix nanoseconds
+	cols, rows atomic.Int32
+
+	cmd       *exec.Cmd
+	pty       *os.File
+	ws        *wsConn
+	rec       *recorder // nil when recording is disabled
+	closeOnce sync.Once
+}
+
+// controlMessage is a JSON text frame exchanged with the browser
+type controlMessage struct {
+	Type   string `json:"type"` // resize (client); exit, closed (server)
+	Cols   int    `json:"cols,omitempty"`
+	Rows   int    `json:"rows,omitempty"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewManager creates a terminal session manager
+func NewManager(cfg Config, log *logger.Logger) *Manager {
+	if cfg.User == "" {
+		cfg.User = defaultUser
+	}
+	if cfg.IdleTimeout < 0 {
+		cfg.IdleTimeout = 0
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = defaultMaxSessions
+	}
+	if cfg.RecordingMaxAge < 0 {
+		cfg.RecordingMaxAge = 0
+	}
+	if cfg.RecordingMaxSize < 0 {
+		cfg.RecordingMaxSize = 0
+	}
+	return &Manager{
+		cfg:       cfg,
+		logger:    log,
+		sessions:  make(map[string]*session),
+		recLimits: make(map[string]int64),
+	}
+}
+
+// CheckRequest validates a WebSocket request before it is upgraded, so
+// errors can still be reported as JSON
+func (m *Manager) CheckRequest(r *http.Request) error {
+	if err := checkHandshake(r); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sessions)+m.starting >= m.cfg.MaxSessions {
+		return ErrTooManySessions
+	}
+	return nil
+}
+
+// reserve takes a session slot for a shell about to start. CheckRequest
+// only looks, so concurrent requests can all pass it.
+func (m *Manager) reserve() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sessions)+m.starting >= m.cfg.MaxSessions {
+		return false
+	}
+	m.starting++
+	return true
+}
+
+// release gives back a slot taken by reserve
+func (m *Manager) release() {
+	m.mu.Lock()
+	m.starting--
+	m.mu.Unlock()
+}
+
+// Serve upgrades the request and runs a shell until either side closes.
+// The initial size is taken from the cols and rows query parameters;
+// authUser is the web UI user, recorded with the session, and authID the
+// login session that CloseAuthSession ends it with.
+func (m *Manager) Serve(w http.ResponseWriter, r *http.Request, authUser, authID string) error {
+	cols := queryInt(r, "cols", defaultCols)
+	rows := queryInt(r, "rows", defaultRows)
+
+	cmd, err := shellCommand(m.cfg.User)
+	if err != nil {
+		return err
+	}
+
+	if !m.reserve() {
+		return ErrTooManySessions
+	}
+	ws, err := upgrade(w, r)
+	if err != nil {
+		m.release()
+		return err
+	}
+
+	s := &session{
+		id:       newSessionID(),
+		user:     m.cfg.User,
+		authUser: authUser,
+		authID:   authID,
+		remote:   r.RemoteAddr,
+		started:  time.Now(),
+		cmd:      cmd,
+		ws:       ws,
+	}
+	s.lastActive.Store(s.started.UnixNano())
+	s.cols.Store(int32(cols))
+	s.rows.Store(int32(rows))
+
+	s.pty, err = startPTY(cmd, uint16(rows), uint16(cols))
+	if err != nil {
+		m.release()
+		m.logger.Error("terminal", "open").
+			WithExtra("user", s.user).
+			WithError(err).
+			Commit()
+		ws.close(1011, err.Error())
+		return nil
+	}
+
+	m.mu.Lock()
+	m.starting--
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+
+	if m.cfg.RecordingDir != "" {
+		if s.rec, err = m.startRecording(s); err != nil {
+			// An unrecorded session would defeat the audit trail
+			m.logger.Error("terminal", "record").
+				WithExtra("id", s.id).
+				WithError(err).
+				Commit()
+			m.sendControl(s, controlMessage{Type: "closed", Reason: "recording failed"})
+			m.end(s, "recording failed")
+			m.reap(s)
+			return nil
+		}
+	}
+
+	m.logger.Info("terminal", "open").
+		WithExtra("id", s.id).
+		WithExtra("user", s.user).
+		WithExtra("auth_user", s.authUser).
+		WithExtra("remote", s.remote).
+		Commit()
+
+	go m.output(s)
+	go m.watchIdle(s)
+	m.input(s)
+	return nil
+}
+
+// input copies keystrokes and handles resize requests until the client
+// goes away
+func (m *Manager) input(s *session) {
+	for {
+		op, data, err := s.ws.readMessage()
+		if err != nil {
+			m.end(s, "client disconnected")
+			return
+		}
+		s.lastActive.Store(time.Now().UnixNano())
+
+		if op == opBinary {
+			if _, err := s.pty.Write(data); err != nil {
+				m.end(s, "shell exited")
+				return
+			}
+			continue
+		}
+
+		var msg controlMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		if msg.Type == "resize" && msg.Cols > 0 && msg.Rows > 0 && msg.Cols <= 1000 && msg.Rows <= 500 {
+			if err := setSize(s.pty, uint16(msg.Rows), uint16(msg.Cols)); err == nil {
+				s.cols.Store(int32(msg.Cols))
+				s.rows.Store(int32(msg.Rows))
+				if s.rec != nil {
+					if err := s.rec.resize(msg.Cols, msg.Rows); err != nil {
+						m.recordingFailed(s, err)
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// output streams shell output to the client and reports the exit status
+func (m *Manager) output(s *session) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := s.pty.Read(buf)
+		if n > 0 {
+			// Output that cannot be recorded is not shown
+			if s.rec != nil {
+				if err := s.rec.output(buf[:n]); err != nil {
+					m.recordingFailed(s, err)
+					m.reap(s)
+					return
+				}
+			}
+			if werr := s.ws.writeFrame(opBinary, buf[:n]); werr != nil {
+				m.end(s, "client disconnected")
+				m.reap(s)
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	m.reap(s)
+	code := -1
+	if s.cmd.ProcessState != nil {
+		code = s.cmd.ProcessState.ExitCode()
+	}
+	m.sendControl(s, controlMessage{Type: "exit", Code: code})
+	m.end(s, "shell exited")
+}
+
+// watchIdle closes the session once no input has arrived for IdleTimeout
+func (m *Manager) watchIdle(s *session) {
+	if m.cfg.IdleTimeout == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		_, open := m.sessions[s.id]
+		m.mu.Unlock()
+		if !open {
+			return
+		}
+		idle := time.Since(time.Unix(0, s.lastActive.Load()))
+		if idle >= m.cfg.IdleTimeout {
+			m.sendControl(s, controlMessage{Type: "closed", Reason: "idle timeout"})
+			m.end(s, "idle timeout")
+			return
+		}
+	}
+}
+
+// recordingFailed ends a session whose recording reached its size limit or
+// could not be written, since the rest of it would go unrecorded
+func (m *Manager) recordingFailed(s *session, err error) {
+	reason := "recording failed"
+	entry := m.logger.Error("terminal", "record")
+	if errors.Is(err, errRecordingFull) {
+		reason = err.Error()
+		entry = m.logger.Warn("terminal", "record")
+	}
+	entry.WithExtra("id", s.id).
+		WithError(err).
+		Commit()
+	m.sendControl(s, controlMessage{Type: "closed", Reason: reason})
+	m.end(s, reason)
+}
+
+// reap waits for the shell to exit after it was hung up, killing it if it
+// ignores the hangup, so it does not linger as a zombie
+func (m *Manager) reap(s *session) {
+	done := make(chan struct{})
+	go func() {
+		s.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(reapTimeout):
+		s.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// sendControl writes a JSON control message, ignoring a gone client
+func (m *Manager) sendControl(s *session, msg controlMessage) {
+	if data, err := json.Marshal(msg); err == nil {
+		s.ws.writeFrame(opText, data)
+	}
+}
+
+// end tears a session down once: the shell's process group is hung up and
+// the WebSocket closed
+func (m *Manager) end(s *session, reason string) {
+	s.closeOnce.Do(func() {
+		m.mu.Lock()
+		delete(m.sessions, s.id)
+		m.mu.Unlock()
+
+		hangup(s.cmd)
+		s.pty.Close()
+		s.ws.close(1000, reason)
+		if s.rec != nil {
+			s.rec.close()
+			m.recMu.Lock()
+			delete(m.recLimits, s.id)
+			m.recMu.Unlock()
+		}
+
+		m.logger.Info("terminal", "close").
+			WithExtra("id", s.id).
+			WithExtra("reason", reason).
+			WithExtra("duration", time.Since(s.started).Round(time.Second).String()).
+			Commit()
+	})
+}
+
+// List returns the open sessions, oldest first
+func (m *Manager) List() []types.TerminalSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]types.TerminalSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		list = append(list, types.TerminalSession{
+			ID:         s.id,
+			User:       s.user,
+			AuthUser:   s.authUser,
+			Remote:     s.remote,
+			Started:    s.started.Format(time.RFC3339),
+			LastActive: time.Unix(0, s.lastActive.Load()).Format(time.RFC3339),
+			Cols:       int(s.cols.Load()),
+			Rows:       int(s.rows.Load()),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started < list[j].Started })
+	return list
+}
+
+// Close ends a session by ID
+func (m *Manager) Close(id string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("session not found")
+	}
+	m.sendControl(s, controlMessage{Type: "closed", Reason: "closed by administrator"})
+	m.end(s, "closed by administrator")
+	return nil
+}
+
+// CloseAuthSession ends the sessions opened from a login session that was
+// logged out or revoked. An empty id ends every session of user.
+func (m *Manager) CloseAuthSession(id, user string) {
+	m.mu.Lock()
+	var ended []*session
+	for _, s := range m.sessions {
+		if s.authUser == user && (id == "" || s.authID == id) {
+			ended = append(ended, s)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, s := range ended {
+		m.sendControl(s, controlMessage{Type: "closed", Reason: "signed out"})
+		m.end(s, "signed out")
+	}
+}
+
+// shellCommand builds a login shell for the configured user. The service's
+// own user gets its shell directly; any other user goes through su.
+func shellCommand(name string) (*exec.Cmd, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("terminal user %q: %w", name, err)
+	}
+
+	env := []string{"TERM=xterm-256color", "LANG=C.UTF-8"}
+	if u.Uid != strconv.Itoa(os.Getuid()) {
+		cmd := exec.Command("su", "-", u.Username)
+		cmd.Env = append(env, "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+		return cmd, nil
+	}
+
+	shell := loginShell(u.Username)
+	cmd := &exec.Cmd{
+		Path: shell,
+		Args: []string{"-" + filepath.Base(shell)},
+		Dir:  u.HomeDir,
+		Env: append(env,
+			"HOME="+u.HomeDir,
+			"USER="+u.Username,
+			"LOGNAME="+u.Username,
+			"SHELL="+shell,
+			"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		),
+	}
+	return cmd, nil
+}
+
+// loginShell returns the user's shell from /etc/passwd, or /bin/sh
+func loginShell(name string) string {
+	data, err := os.ReadFile("/etc/passwd")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) == 7 && fields[0] == name && fields[6] != "" {
+				if _, err := os.Stat(fields[6]); err == nil {
+					return fields[6]
+				}
+			}
+		}
+	}
+	return "/bin/sh"
+}
+
+// queryInt parses a positive integer query parameter within terminal limits
+func queryInt(r *http.Request, name string, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || n <= 0 || n > 1000 {
+		return def
+	}
+	return n
+}
+
+// newSessionID returns a random session identifier
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,68 @@
+package terminal
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"nm-webui/internal/logger"
+)
+
+// addTestSession registers a session without a shell whose client side
+// discards everything sent to it
+func addTestSession(t *testing.T, m *Manager, id, authUser, authID string) {
+	t.Helper()
+	conn, peer := net.Pipe()
+	go io.Copy(io.Discard, peer)
+	t.Cleanup(func() { peer.Close() })
+
+	s := &session{
+		id:       id,
+		authUser: authUser,
+		authID:   authID,
+		started:  time.Now(),
+		cmd:      &exec.Cmd{},
+		ws:       &wsConn{conn: conn, br: bufio.NewReader(conn)},
+	}
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+}
+
+// openIDs returns the IDs of the open sessions
+func openIDs(m *Manager) map[string]bool {
+	ids := make(map[string]bool)
+	for _, s := range m.List() {
+		ids[s.ID] = true
+	}
+	return ids
+}
+
+func TestCloseAuthSession(t *testing.T) {
+	m := NewManager(Config{}, logger.NewDefault())
+	addTestSession(t, m, "t1", "alice", "s1")
+	addTestSession(t, m, "t2", "alice", "s2")
+	addTestSession(t, m, "t3", "alice", "")
+	addTestSession(t, m, "t4", "bob", "s3")
+
+	// Logging out one login session leaves the others
+	m.CloseAuthSession("s1", "alice")
+	if open := openIDs(m); open["t1"] || !open["t2"] || !open["t3"] || !open["t4"] {
+		t.Fatalf("after logout, open = %v", open)
+	}
+
+	// A session ID of another user does not match
+	m.CloseAuthSession("s3", "alice")
+	if open := openIDs(m); !open["t4"] {
+		t.Fatalf("closed another user's session, open = %v", open)
+	}
+
+	// Revoking every session of a user also ends token-opened terminals
+	m.CloseAuthSession("", "alice")
+	if open := openIDs(m); len(open) != 1 || !open["t4"] {
+		t.Fatalf("after revoking alice, open = %v", open)
+	}
+}
@@ -0,0 +1,231 @@
+// Package terminal serves PTY-backed shells over WebSocket
+package terminal
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455)
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxMessageSize bounds a client message; input and resize requests
+	// are small
+	maxMessageSize = 1 << 20
+)
+
+var (
+	errNotWebSocket = errors.New("not a WebSocket handshake")
+	errBadOrigin    = errors.New("cross-origin WebSocket request refused")
+)
+
+// wsConn is a minimal server-side WebSocket connection
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// checkHandshake validates a WebSocket upgrade request. Browsers attach
+// cached credentials to WebSocket requests from any site, so the Origin
+// must match the host.
+func checkHandshake(r *http.Request) error {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		return errNotWebSocket
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return errBadOrigin
+		}
+	}
+	return nil
+}
+
+// upgrade completes the handshake and takes over the connection
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if err := checkHandshake(r); err != nil {
+		return nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read/write timeouts do not apply to a long-lived session
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header contains token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// reassembling fragments. A close frame is echoed and reported as io.EOF.
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var (
+		msgOp int
+		msg   []byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if msg != nil {
+				return 0, nil, fmt.Errorf("websocket: unexpected new message in fragmented message")
+			}
+			msgOp = op
+			msg = payload
+		case opContinuation:
+			if msg == nil {
+				return 0, nil, fmt.Errorf("websocket: continuation without a message")
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+		if len(msg) > maxMessageSize {
+			return 0, nil, fmt.Errorf("websocket: message too large")
+		}
+		if fin {
+			return msgOp, msg, nil
+		}
+	}
+}
+
+// readFrame reads a single masked client frame
+func (c *wsConn) readFrame() (bool, int, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	op := int(hdr[0] & 0x0F)
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("websocket: unmasked client frame")
+	}
+
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, fmt.Errorf("websocket: frame too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame sends a single unfragmented frame
+func (c *wsConn) writeFrame(op int, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | byte(op)
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := (&net.Buffers{hdr, payload}).WriteTo(c.conn)
+	return err
+}
+
+// close sends a close frame with a reason and drops the connection
+func (c *wsConn) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	if len(reason) > 120 {
+		reason = reason[:120]
+	}
+	c.writeFrame(opClose, append(payload, reason...))
+	c.conn.Close()
+}
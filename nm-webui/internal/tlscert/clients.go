@@ -0,0 +1,338 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+
+	"nm-webui/internal/types"
+)
+
+const (
+	clientsFile       = "clients.json"
+	maxClientValidity = 10 * 365 * 24 * time.Hour
+	minP12Password    = 8
+	crlValidity       = 7 * 24 * time.Hour
+)
+
+// ClientAuth is how client certificates are used
+type ClientAuth int
+
+const (
+	// ClientAuthOff ignores client certificates
+	ClientAuthOff ClientAuth = iota
+	// ClientAuthOptional lets a valid certificate log in without a password
+	ClientAuthOptional
+	// ClientAuthRequired refuses connections without a valid certificate,
+	// which then logs in without a password
+	ClientAuthRequired
+	// ClientAuthRequiredPassword refuses connections without a valid
+	// certificate and still asks for its user's password
+	ClientAuthRequiredPassword
+)
+
+var clientAuthNames = []string{"off", "optional", "required", "required+password"}
+
+// ParseClientAuth parses a -tls-client-auth value
+func ParseClientAuth(s string) (ClientAuth, error) {
+	for i, name := range clientAuthNames {
+		if s == name {
+			return ClientAuth(i), nil
+		}
+	}
+	return ClientAuthOff, fmt.Errorf("unknown client auth mode %q (want off, optional, required or required+password)", s)
+}
+
+func (c ClientAuth) String() string {
+	return clientAuthNames[c]
+}
+
+// Required reports whether every connection must present a certificate
+func (c ClientAuth) Required() bool {
+	return c >= ClientAuthRequired
+}
+
+// clientCert records an issued client certificate. The certificate's
+// subject common name is the user it logs in as.
+type clientCert struct {
+	Serial      string    `json:"serial"`
+	User        string    `json:"user"`
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	IssuedBy    string    `json:"issued_by"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+	Revoked     time.Time `json:"revoked,omitempty"` // zero while valid
+}
+
+// SetClientAuth sets how client certificates are used. Call it before
+// TLSConfig.
+func (m *Manager) SetClientAuth(mode ClientAuth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientAuth = mode
+}
+
+// ClientAuth returns how client certificates are used
+func (m *Manager) ClientAuth() ClientAuth {
+	if m == nil {
+		return ClientAuthOff
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clientAuth
+}
+
+// clientTLS fills in client certificate verification; the caller holds mu
+func (m *Manager) clientTLS(cfg *tls.Config) {
+	switch m.clientAuth {
+	case ClientAuthOff:
+		return
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(m.ca)
+	cfg.ClientCAs = pool
+}
+
+// ClientUser returns the user a request's client certificate logs in as.
+// present is false when no certificate was sent; a certificate that is
+// unknown, revoked or names another user gives an error.
+func (m *Manager) ClientUser(r *http.Request) (user string, present bool, err error) {
+	if m == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false, nil
+	}
+	leaf := r.TLS.PeerCertificates[0]
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.clientAuth == ClientAuthOff {
+		return "", false, nil
+	}
+	c, ok := m.clients[leaf.SerialNumber.Text(16)]
+	if !ok || c.Fingerprint != Fingerprint(leaf) {
+		return "", true, fmt.Errorf("certificate was not issued by this device")
+	}
+	if !c.Revoked.IsZero() {
+		return "", true, fmt.Errorf("certificate was revoked")
+	}
+	if leaf.Subject.CommonName != c.User {
+		return "", true, fmt.Errorf("certificate subject does not match its user")
+	}
+	return c.User, true, nil
+}
+
+// IssueClient creates a client certificate for a user, signed by the
+// device CA, and returns it as a password-protected PKCS#12 bundle.
+// legacy uses 3DES instead of AES for older operating systems.
+func (m *Manager) IssueClient(user, issuedBy string, validity time.Duration, password string, legacy bool) (types.ClientCert, []byte, error) {
+	if validity <= 0 || validity > maxClientValidity {
+		return types.ClientCert{}, nil, fmt.Errorf("validity must be between 1 day and 10 years")
+	}
+	if len(password) < minP12Password {
+		return types.ClientCert{}, nil, fmt.Errorf("bundle password must be at least %d characters", minP12Password)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return types.ClientCert{}, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{Organization: []string{"Haxinator"}, OrganizationalUnit: []string{"nm-webui client"}, CommonName: user},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.ca, &key.PublicKey, m.caKey)
+	if err != nil {
+		return types.ClientCert{}, nil, fmt.Errorf("failed to create client certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return types.ClientCert{}, nil, err
+	}
+
+	encoder := pkcs12.Modern
+	if legacy {
+		encoder = pkcs12.LegacyDES
+	}
+	p12, err := encoder.Encode(key, leaf, []*x509.Certificate{m.ca}, password)
+	if err != nil {
+		return types.ClientCert{}, nil, fmt.Errorf("failed to encode PKCS#12 bundle: %w", err)
+	}
+
+	c := &clientCert{
+		Serial:      leaf.SerialNumber.Text(16),
+		User:        user,
+		Subject:     leaf.Subject.String(),
+		Fingerprint: Fingerprint(leaf),
+		IssuedBy:    issuedBy,
+		Created:     now,
+		Expires:     leaf.NotAfter,
+	}
+	m.clients[c.Serial] = c
+	if err := m.saveClients(); err != nil {
+		delete(m.clients, c.Serial)
+		return types.ClientCert{}, nil, err
+	}
+	return c.info(), p12, nil
+}
+
+// ClientCerts lists issued client certificates, newest first. A non-empty
+// user limits the list to that user's certificates.
+func (m *Manager) ClientCerts(user string) []types.ClientCert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]types.ClientCert, 0, len(m.clients))
+	for _, c := range m.clients {
+		if user == "" || c.User == user {
+			list = append(list, c.info())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created > list[j].Created })
+	return list
+}
+
+// RevokeClient adds a client certificate to the revocation list
+func (m *Manager) RevokeClient(serial string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.clients[serial]
+	if !ok {
+		return fmt.Errorf("certificate not found")
+	}
+	if !c.Revoked.IsZero() {
+		return fmt.Errorf("certificate is already revoked")
+	}
+	c.Revoked = time.Now()
+	if err := m.saveClients(); err != nil {
+		c.Revoked = time.Time{}
+		return err
+	}
+	return nil
+}
+
+// RevokeClientUser revokes all of a user's client certificates, so they do
+// not log in as a new user given the same name
+func (m *Manager) RevokeClientUser(user string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for _, c := range m.clients {
+		if c.User == user && c.Revoked.IsZero() {
+			c.Revoked = now
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return m.saveClients()
+}
+
+// CRL returns a DER certificate revocation list of the revoked client
+// certificates, signed by the device CA
+func (m *Manager) CRL() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(crlValidity),
+	}
+	for _, c := range m.clients {
+		if c.Revoked.IsZero() {
+			continue
+		}
+		n, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok {
+			continue
+		}
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   n,
+			RevocationTime: c.Revoked,
+		})
+	}
+	return x509.CreateRevocationList(rand.Reader, tmpl, m.ca, m.caKey)
+}
+
+// loadClients reads the issued client certificates; the caller holds mu
+func (m *Manager) loadClients() error {
+	m.clients = make(map[string]*clientCert)
+	data, err := os.ReadFile(m.path(clientsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read client certificates: %w", err)
+	}
+	var list []*clientCert
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse client certificates: %w", err)
+	}
+	for _, c := range list {
+		m.clients[c.Serial] = c
+	}
+	return nil
+}
+
+// saveClients writes the issued client certificates; the caller holds mu
+func (m *Manager) saveClients() error {
+	list := make([]*clientCert, 0, len(m.clients))
+	for _, c := range m.clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(m.path(clientsFile), data)
+}
+
+func (c *clientCert) info() types.ClientCert {
+	info := types.ClientCert{
+		Serial:      c.Serial,
+		User:        c.User,
+		Subject:     c.Subject,
+		Fingerprint: c.Fingerprint,
+		IssuedBy:    c.IssuedBy,
+		Created:     c.Created.Format(time.RFC3339),
+		Expires:     c.Expires.Format(time.RFC3339),
+	}
+	if !c.Revoked.IsZero() {
+		info.Revoked = c.Revoked.Format(time.RFC3339)
+	}
+	return info
+}
@@ -0,0 +1,217 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+
+	"nm-webui/internal/logger"
+)
+
+const testBundlePassword = "bundle-pass-1"
+
+// issueTestClient issues a certificate for user and returns its serial and
+// decoded leaf
+func issueTestClient(t *testing.T, m *Manager, user string, legacy bool) (string, *x509.Certificate) {
+	t.Helper()
+	info, p12, err := m.IssueClient(user, "alice", 30*24*time.Hour, testBundlePassword, legacy)
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+	_, leaf, chain, err := pkcs12.DecodeChain(p12, testBundlePassword)
+	if err != nil {
+		t.Fatalf("decoding bundle: %v", err)
+	}
+	if len(chain) != 1 || !chain[0].Equal(m.ca) {
+		t.Error("bundle does not carry the device CA")
+	}
+	if info.Serial != leaf.SerialNumber.Text(16) || info.Fingerprint != Fingerprint(leaf) {
+		t.Errorf("info %+v does not match the bundled certificate", info)
+	}
+	return info.Serial, leaf
+}
+
+// clientRequest builds a request presenting leaf as its client certificate
+func clientRequest(leaf *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	return r
+}
+
+func TestParseClientAuth(t *testing.T) {
+	for i, name := range clientAuthNames {
+		mode, err := ParseClientAuth(name)
+		if err != nil || mode != ClientAuth(i) || mode.String() != name {
+			t.Errorf("ParseClientAuth(%q) = %v, %v", name, mode, err)
+		}
+	}
+	if _, err := ParseClientAuth("on"); err == nil {
+		t.Error("accepted an unknown mode")
+	}
+	if ClientAuthOptional.Required() || !ClientAuthRequiredPassword.Required() {
+		t.Error("Required is wrong")
+	}
+}
+
+func TestIssueClient(t *testing.T) {
+	m, dir := newTestManager(t)
+
+	tests := []struct {
+		name     string
+		validity time.Duration
+		password string
+	}{
+		{"no validity", 0, testBundlePassword},
+		{"too long", maxClientValidity + time.Hour, testBundlePassword},
+		{"short password", 24 * time.Hour, "short"},
+	}
+	for _, tt := range tests {
+		if _, _, err := m.IssueClient("bob", "alice", tt.validity, tt.password, false); err == nil {
+			t.Errorf("%s: issued a certificate", tt.name)
+		}
+	}
+
+	for _, legacy := range []bool{false, true} {
+		_, leaf := issueTestClient(t, m, "bob", legacy)
+		if leaf.Subject.CommonName != "bob" {
+			t.Errorf("subject = %s", leaf.Subject)
+		}
+		if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+			t.Errorf("ExtKeyUsage = %v", leaf.ExtKeyUsage)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(m.ca)
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			t.Errorf("client certificate does not verify against the device CA: %v", err)
+		}
+	}
+	issueTestClient(t, m, "carol", false)
+
+	if list := m.ClientCerts("bob"); len(list) != 2 {
+		t.Errorf("bob has %d certificates, want 2", len(list))
+	}
+	list := m.ClientCerts("")
+	if len(list) != 3 {
+		t.Errorf("ClientCerts = %+v, want three", list)
+	}
+	for _, c := range list {
+		if c.IssuedBy != "alice" || c.Revoked != "" {
+			t.Errorf("certificate %+v", c)
+		}
+	}
+
+	// Issued certificates survive a restart
+	again, err := NewManager(dir, testNames, logger.NewDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(again.ClientCerts("")); n != 3 {
+		t.Errorf("%d certificates after restart, want 3", n)
+	}
+}
+
+func TestClientUser(t *testing.T) {
+	m, _ := newTestManager(t)
+	_, leaf := issueTestClient(t, m, "bob", false)
+
+	// Certificates are ignored while client auth is off
+	if user, present, err := m.ClientUser(clientRequest(leaf)); user != "" || present || err != nil {
+		t.Errorf("off: ClientUser = %q, %v, %v", user, present, err)
+	}
+	m.SetClientAuth(ClientAuthOptional)
+
+	user, present, err := m.ClientUser(clientRequest(leaf))
+	if user != "bob" || !present || err != nil {
+		t.Fatalf("ClientUser = %q, %v, %v", user, present, err)
+	}
+	if _, present, err := m.ClientUser(httptest.NewRequest(http.MethodGet, "/", nil)); present || err != nil {
+		t.Errorf("no certificate: present %v, err %v", present, err)
+	}
+
+	// A certificate from another CA with a known serial is refused
+	other, _ := newTestManager(t)
+	_, foreign := issueTestClient(t, other, "bob", false)
+	m.clients[foreign.SerialNumber.Text(16)] = m.clients[leaf.SerialNumber.Text(16)]
+	if _, present, err := m.ClientUser(clientRequest(foreign)); !present || err == nil {
+		t.Error("accepted a certificate not issued by this device")
+	}
+}
+
+func TestRevokeClient(t *testing.T) {
+	m, dir := newTestManager(t)
+	m.SetClientAuth(ClientAuthRequired)
+	bobSerial, bob := issueTestClient(t, m, "bob", false)
+	_, bob2 := issueTestClient(t, m, "bob", false)
+	_, carol := issueTestClient(t, m, "carol", false)
+
+	if err := m.RevokeClient("ffff"); err == nil {
+		t.Error("revoked an unknown serial")
+	}
+	if err := m.RevokeClient(bobSerial); err != nil {
+		t.Fatalf("RevokeClient: %v", err)
+	}
+	if err := m.RevokeClient(bobSerial); err == nil {
+		t.Error("revoked a certificate twice")
+	}
+	if _, present, err := m.ClientUser(clientRequest(bob)); !present || err == nil {
+		t.Error("revoked certificate accepted")
+	}
+	if user, _, err := m.ClientUser(clientRequest(bob2)); user != "bob" || err != nil {
+		t.Errorf("bob's other certificate: %q, %v", user, err)
+	}
+
+	// Deleting a user revokes the rest of its certificates
+	if err := m.RevokeClientUser("bob"); err != nil {
+		t.Fatalf("RevokeClientUser: %v", err)
+	}
+	if _, _, err := m.ClientUser(clientRequest(bob2)); err == nil {
+		t.Error("certificate of a deleted user accepted")
+	}
+	if user, _, err := m.ClientUser(clientRequest(carol)); user != "carol" || err != nil {
+		t.Errorf("another user's certificate: %q, %v", user, err)
+	}
+	for _, c := range m.ClientCerts("bob") {
+		if c.Revoked == "" {
+			t.Errorf("certificate %s not marked revoked", c.Serial)
+		}
+	}
+
+	// The CRL is signed by the device CA and lists exactly the revoked
+	// serials
+	der, err := m.CRL()
+	if err != nil {
+		t.Fatalf("CRL: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatalf("parsing CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(m.ca); err != nil {
+		t.Errorf("CRL signature: %v", err)
+	}
+	if !crl.NextUpdate.After(time.Now()) {
+		t.Errorf("CRL NextUpdate = %v", crl.NextUpdate)
+	}
+	revoked := make(map[string]bool)
+	for _, e := range crl.RevokedCertificateEntries {
+		revoked[e.SerialNumber.Text(16)] = true
+	}
+	if len(revoked) != 2 || !revoked[bob.SerialNumber.Text(16)] || !revoked[bob2.SerialNumber.Text(16)] {
+		t.Errorf("CRL lists %v, want bob's two certificates", revoked)
+	}
+
+	// Revocation survives a restart
+	again, err := NewManager(dir, testNames, logger.NewDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.SetClientAuth(ClientAuthRequired)
+	if _, _, err := again.ClientUser(clientRequest(bob)); err == nil {
+		t.Error("revoked certificate accepted after restart")
+	}
+}
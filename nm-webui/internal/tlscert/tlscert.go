@@ -0,0 +1,447 @@
+// Package tlscert manages the web UI's TLS certificate: a generated device
+// CA and server certificate, or one uploaded by an admin
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"nm-webui/internal/logger"
+	"nm-webui/internal/types"
+)
+
+// Certificate lifetimes. Browsers reject server certificates valid for much
+// over a year, even from a private CA.
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 397 * 24 * time.Hour
+	renewBefore    = 30 * 24 * time.Hour
+)
+
+// Files in the certificate directory
+const (
+	caCertFile     = "ca.crt"
+	caKeyFile      = "ca.key"
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+	customCertFile = "custom.crt"
+	customKeyFile  = "custom.key"
+)
+
+// Sources of the certificate in use
+const (
+	SourceGenerated = "generated"
+	SourceUploaded  = "uploaded"
+)
+
+// defaultIPs are the addresses the device is usually reached at: USB
+// gadget, configured hotspot, NetworkManager's default shared network and
+// loopback
+var defaultIPs = []string{"192.168.8.1", "192.168.4.1", "10.42.0.1", "127.0.0.1", "::1"}
+
+// DefaultNames returns the host names and IPs a generated certificate
+// covers: the hostname, its mDNS name, localhost and defaultIPs
+func DefaultNames() []string {
+	var names []string
+	if host, err := os.Hostname(); err == nil && host != "" {
+		host = strings.ToLower(strings.TrimSuffix(host, ".local"))
+		names = append(names, host, host+".local")
+	}
+	names = append(names, "localhost")
+	return append(names, defaultIPs...)
+}
+
+// Manager serves the current certificate and replaces it on upload or
+// renewal without a restart
+type Manager struct {
+	dir    string
+	names  []string
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	source string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	clientAuth ClientAuth
+	clients    map[string]*clientCert // by hex serial
+}
+
+// NewManager loads the certificate from dir, creating the CA and server
+// certificate if needed. A generated certificate is reissued when it is
+// close to expiry or does not cover all names.
+func NewManager(dir string, names []string, log *logger.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	m := &Manager{dir: dir, names: dedupe(names), logger: log}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadCA(); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if err := m.loadClients(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TLSConfig returns a server configuration using the managed certificate
+// and, if enabled, verifying client certificates against the device CA
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.getCertificate,
+	}
+	m.mu.Lock()
+	m.clientTLS(cfg)
+	m.mu.Unlock()
+	return cfg
+}
+
+func (m *Manager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.source == SourceGenerated && time.Until(m.cert.Leaf.NotAfter) < renewBefore {
+		if err := m.issue(); err != nil {
+			m.logger.Error("tls", "renew").WithError(err).Commit()
+		}
+	}
+	return m.cert, nil
+}
+
+// Info describes the certificate in use
+func (m *Manager) Info() types.TLSInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	leaf := m.cert.Leaf
+	info := types.TLSInfo{
+		Enabled:     true,
+		Source:      m.source,
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		Names:       certNames(leaf),
+		NotBefore:   leaf.NotBefore.Format(time.RFC3339),
+		NotAfter:    leaf.NotAfter.Format(time.RFC3339),
+		Fingerprint: Fingerprint(leaf),
+		ClientAuth:  m.clientAuth.String(),
+	}
+	if m.source == SourceGenerated {
+		info.CAFingerprint = Fingerprint(m.ca)
+	}
+	return info
+}
+
+// CACertPEM returns the device CA certificate, for installing in browsers
+func (m *Manager) CACertPEM() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return pemEncode("CERTIFICATE", m.ca.Raw)
+}
+
+// Upload replaces the certificate with an admin-supplied one. certPEM may
+// include the intermediate chain after the server certificate.
+func (m *Manager) Upload(certPEM, keyPEM []byte) (types.TLSInfo, error) {
+	cert, err := keyPair(certPEM, keyPEM)
+	if err != nil {
+		return types.TLSInfo{}, fmt.Errorf("invalid certificate or key: %v", err)
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return types.TLSInfo{}, fmt.Errorf("certificate expired on %s", cert.Leaf.NotAfter.Format("2006-01-02"))
+	}
+
+	m.mu.Lock()
+	if err := writeFile(m.path(customKeyFile), keyPEM); err != nil {
+		m.mu.Unlock()
+		return types.TLSInfo{}, err
+	}
+	if err := writeFile(m.path(customCertFile), certPEM); err != nil {
+		m.mu.Unlock()
+		return types.TLSInfo{}, err
+	}
+	m.cert, m.source = &cert, SourceUploaded
+	m.mu.Unlock()
+
+	return m.Info(), nil
+}
+
+// Reset removes an uploaded certificate and goes back to the generated one
+func (m *Manager) Reset() (types.TLSInfo, error) {
+	m.mu.Lock()
+	for _, name := range []string{customCertFile, customKeyFile} {
+		if err := os.Remove(m.path(name)); err != nil && !os.IsNotExist(err) {
+			m.mu.Unlock()
+			return types.TLSInfo{}, fmt.Errorf("failed to remove uploaded certificate: %w", err)
+		}
+	}
+	err := m.load()
+	m.mu.Unlock()
+	if err != nil {
+		return types.TLSInfo{}, err
+	}
+	return m.Info(), nil
+}
+
+// load picks the uploaded certificate if there is one, otherwise the
+// generated one, reissuing it if needed; the caller holds mu
+func (m *Manager) load() error {
+	if cert, err := loadKeyPair(m.path(customCertFile), m.path(customKeyFile)); err == nil {
+		m.cert, m.source = &cert, SourceUploaded
+		return nil
+	} else if !os.IsNotExist(err) {
+		m.logger.Warn("tls", "load_uploaded").
+			WithError(err).
+			WithExtra("fallback", SourceGenerated).
+			Commit()
+	}
+
+	m.source = SourceGenerated
+	cert, err := loadKeyPair(m.path(serverCertFile), m.path(serverKeyFile))
+	if err == nil && m.covers(cert.Leaf) && time.Until(cert.Leaf.NotAfter) > renewBefore &&
+		cert.Leaf.CheckSignatureFrom(m.ca) == nil {
+		m.cert = &cert
+		return nil
+	}
+	return m.issue()
+}
+
+// covers reports whether a certificate is valid for every configured name
+func (m *Manager) covers(leaf *x509.Certificate) bool {
+	for _, name := range m.names {
+		if leaf.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCA reads the device CA, creating it on first start; the caller
+// holds mu
+func (m *Manager) loadCA() error {
+	certPEM, certErr := os.ReadFile(m.path(caCertFile))
+	keyPEM, keyErr := os.ReadFile(m.path(caKeyFile))
+	if certErr == nil && keyErr == nil {
+		pair, err := keyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("invalid device CA: %w", err)
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("invalid device CA: unsupported key type")
+		}
+		m.ca, m.caKey = pair.Leaf, key
+		return nil
+	}
+	if !os.IsNotExist(certErr) && certErr != nil {
+		return fmt.Errorf("failed to read device CA: %w", certErr)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial(),
+		Subject:               pkix.Name{Organization: []string{"Haxinator"}, CommonName: "Haxinator device CA " + host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create device CA: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(m.path(caKeyFile), pemEncode("EC PRIVATE KEY", keyDER)); err != nil {
+		return err
+	}
+	if err := writeFile(m.path(caCertFile), pemEncode("CERTIFICATE", der)); err != nil {
+		return err
+	}
+	m.ca, m.caKey = ca, key
+
+	m.logger.Info("tls", "create_ca").
+		WithExtra("fingerprint", Fingerprint(ca)).
+		Commit()
+	return nil
+}
+
+// issue signs a new server certificate for the configured names; the
+// caller holds mu
+func (m *Manager) issue() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{Organization: []string{"Haxinator"}, CommonName: m.names[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range m.names {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.ca, &key.PublicKey, m.caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create server certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := append(pemEncode("CERTIFICATE", der), pemEncode("CERTIFICATE", m.ca.Raw)...)
+	keyPEM := pemEncode("EC PRIVATE KEY", keyDER)
+
+	cert, err := keyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(m.path(serverKeyFile), keyPEM); err != nil {
+		return err
+	}
+	if err := writeFile(m.path(serverCertFile), certPEM); err != nil {
+		return err
+	}
+	m.cert = &cert
+
+	m.logger.Info("tls", "issue").
+		WithExtra("names", m.names).
+		WithExtra("expires", cert.Leaf.NotAfter.Format(time.RFC3339)).
+		WithExtra("fingerprint", Fingerprint(cert.Leaf)).
+		Commit()
+	return nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.dir, name)
+}
+
+// Fingerprint returns a certificate's SHA-256 fingerprint in the
+// colon-separated form browsers show
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// certNames lists a certificate's DNS names and IPs
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// dedupe lowercases names and drops blanks and repeats, keeping order
+func dedupe(names []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	if len(out) == 0 {
+		out = []string{"localhost"}
+	}
+	return out
+}
+
+// keyPair parses a certificate and key, filling in the parsed leaf
+func keyPair(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// loadKeyPair reads a certificate and key file pair
+func loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return keyPair(certPEM, keyPEM)
+}
+
+func serial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		panic("tlscert: crypto/rand failed: " + err.Error())
+	}
+	return n
+}
+
+func pemEncode(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+// writeFile writes a file atomically with mode 0600
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"testing"
+	"time"
+
+	"nm-webui/internal/logger"
+)
+
+var testNames = []string{"haxinator", "haxinator.local", "localhost", "192.168.8.1", "::1"}
+
+// newTestManager creates a manager with a fresh CA in a temporary directory
+func newTestManager(t *testing.T) (*Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := NewManager(dir, testNames, logger.NewDefault())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m, dir
+}
+
+// selfSigned returns a PEM certificate and key for name, valid until notAfter
+func selfSigned(t *testing.T, name string, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pemEncode("CERTIFICATE", der), pemEncode("EC PRIVATE KEY", keyDER)
+}
+
+func TestGeneratedCertificate(t *testing.T) {
+	m, dir := newTestManager(t)
+
+	info := m.Info()
+	if !info.Enabled || info.Source != SourceGenerated || info.CAFingerprint != Fingerprint(m.ca) {
+		t.Fatalf("Info = %+v", info)
+	}
+	leaf := m.cert.Leaf
+	if err := leaf.CheckSignatureFrom(m.ca); err != nil {
+		t.Errorf("server certificate not signed by the device CA: %v", err)
+	}
+	for _, name := range testNames {
+		if err := leaf.VerifyHostname(name); err != nil {
+			t.Errorf("certificate does not cover %s: %v", name, err)
+		}
+	}
+	if validity := leaf.NotAfter.Sub(leaf.NotBefore); validity > serverValidity+2*time.Hour {
+		t.Errorf("server certificate valid for %v", validity)
+	}
+	if !m.ca.IsCA || m.ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Error("device CA cannot sign certificates")
+	}
+	for _, name := range []string{caKeyFile, serverKeyFile} {
+		st, err := os.Stat(m.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", name, st.Mode().Perm())
+		}
+	}
+
+	// A restart keeps the CA and certificate
+	again, err := NewManager(dir, testNames, logger.NewDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Info().Fingerprint != info.Fingerprint || again.Info().CAFingerprint != info.CAFingerprint {
+		t.Error("certificate reissued on restart")
+	}
+
+	// A new name reissues the certificate from the same CA
+	wider, err := NewManager(dir, append(testNames, "10.0.0.1"), logger.NewDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wider.Info().Fingerprint == info.Fingerprint {
+		t.Error("certificate not reissued for a new name")
+	}
+	if wider.Info().CAFingerprint != info.CAFingerprint {
+		t.Error("device CA replaced")
+	}
+	if err := wider.cert.Leaf.VerifyHostname("10.0.0.1"); err != nil {
+		t.Errorf("reissued certificate: %v", err)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	m, _ := newTestManager(t)
+	cfg := m.TLSConfig()
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x", cfg.MinVersion)
+	}
+	if cfg.ClientAuth != tls.NoClientCert || cfg.ClientCAs != nil {
+		t.Error("client certificates requested while off")
+	}
+	cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "haxinator.local"})
+	if err != nil || cert != m.cert {
+		t.Fatalf("GetCertificate = %v, %v", cert, err)
+	}
+
+	// A generated certificate close to expiry is renewed on the next
+	// handshake
+	m.cert.Leaf.NotAfter = time.Now().Add(renewBefore / 2)
+	renewed, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed == cert || time.Until(renewed.Leaf.NotAfter) < renewBefore {
+		t.Error("certificate close to expiry not renewed")
+	}
+
+	tests := []struct {
+		mode ClientAuth
+		want tls.ClientAuthType
+	}{
+		{ClientAuthOptional, tls.VerifyClientCertIfGiven},
+		{ClientAuthRequired, tls.RequireAndVerifyClientCert},
+		{ClientAuthRequiredPassword, tls.RequireAndVerifyClientCert},
+	}
+	for _, tt := range tests {
+		m.SetClientAuth(tt.mode)
+		cfg := m.TLSConfig()
+		if cfg.ClientAuth != tt.want || cfg.ClientCAs == nil {
+			t.Errorf("%s: ClientAuth = %v, want %v", tt.mode, cfg.ClientAuth, tt.want)
+		}
+	}
+}
+
+func TestUploadAndReset(t *testing.T) {
+	m, dir := newTestManager(t)
+	generated := m.Info().Fingerprint
+
+	certPEM, keyPEM := selfSigned(t, "webui.example.com", time.Now().Add(90*24*time.Hour))
+	_, otherKey := selfSigned(t, "webui.example.com", time.Now().Add(90*24*time.Hour))
+	expiredCert, expiredKey := selfSigned(t, "old.example.com", time.Now().Add(-time.Hour))
+
+	if _, err := m.Upload(certPEM, otherKey); err == nil {
+		t.Error("accepted a key that does not match the certificate")
+	}
+	if _, err := m.Upload(expiredCert, expiredKey); err == nil {
+		t.Error("accepted an expired certificate")
+	}
+	if _, err := m.Upload([]byte("not pem"), keyPEM); err == nil {
+		t.Error("accepted an invalid certificate")
+	}
+	if m.Info().Source != SourceGenerated {
+		t.Fatal("a refused upload replaced the certificate")
+	}
+
+	info, err := m.Upload(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if info.Source != SourceUploaded || info.CAFingerprint != "" || len(info.Names) != 1 || info.Names[0] != "webui.example.com" {
+		t.Fatalf("Info after upload = %+v", info)
+	}
+
+	// The upload survives a restart, even though it does not cover the
+	// configured names
+	again, err := NewManager(dir, testNames, logger.NewDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Info().Fingerprint != info.Fingerprint {
+		t.Error("uploaded certificate not loaded on restart")
+	}
+
+	info, err = again.Reset()
+	if err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if info.Source != SourceGenerated || info.Fingerprint != generated {
+		t.Errorf("Info after reset = %+v", info)
+	}
+	if _, err := os.Stat(again.path(customCertFile)); !os.IsNotExist(err) {
+		t.Error("uploaded certificate left on disk")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	got := dedupe([]string{"Haxinator", " haxinator ", "", "LOCALHOST", "localhost", "10.42.0.1"})
+	want := []string{"haxinator", "localhost", "10.42.0.1"}
+	if len(got) != len(want) {
+		t.Fatalf("dedupe = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("dedupe = %v, want %v", got, want)
+			break
+		}
+	}
+	if got := dedupe(nil); len(got) != 1 || got[0] != "localhost" {
+		t.Errorf("dedupe(nil) = %v", got)
+	}
+}
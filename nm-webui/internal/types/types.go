@@ -13,6 +13,7 @@ type APIResponse struct {
 type LogEntry struct {
 	Time    string `json:"time"`
 	Action  string `json:"action"`
+	Actor   string `json:"actor"`
 	Detail  string `json:"detail"`
 	Success bool   `json:"success"`
 }
@@ -172,6 +173,29 @@ type NetworkInterface struct {
 	SharingTo   string `json:"sharing_to,omitempty"` // device sharing to
 }
 
+// InterfaceCounters holds an interface's traffic counters since boot
+type InterfaceCounters struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxErrors  uint64 `json:"tx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// WifiLink describes the access point a WiFi device is connected to
+type WifiLink struct {
+	Device   string  `json:"device"`
+	SSID     string  `json:"ssid"`
+	BSSID    string  `json:"bssid"`
+	Channel  int     `json:"chan"`
+	FreqMHz  int     `json:"freq_mhz"`
+	RateMbps float64 `json:"rate_mbps"`
+	Signal   int     `json:"signal"` // percent
+}
+
 // NetworkInterfacesResult contains the list of interfaces
 type NetworkInterfacesResult struct {
 	Interfaces []NetworkInterface `json:"interfaces"`
@@ -196,27 +220,151 @@ type ActionResult struct {
 
 // SSHKey represents an SSH private key file
 type SSHKey struct {
-	Name       string `json:"name"`        // filename
-	Type       string `json:"type"`        // rsa, ed25519, ecdsa, unknown
-	HasPubKey  bool   `json:"has_pub_key"` // whether .pub file exists
-	ModTime    string `json:"mod_time"`    // last modified time
+	Name        string `json:"name"`                  // filename
+	Type        string `json:"type"`                  // rsa, ed25519, ecdsa, dsa, unknown
+	Bits        int    `json:"bits,omitempty"`        // key size
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA256 fingerprint of the public key
+	Encrypted   bool   `json:"encrypted"`             // passphrase-protected
+	Unlocked    bool   `json:"unlocked"`              // decrypted copy held in memory
+	HasPubKey   bool   `json:"has_pub_key"`           // whether .pub file exists
+	ModTime     string `json:"mod_time"`              // last modified time
+
+	Certificate *SSHCertificate `json:"certificate,omitempty"` // <name>-cert.pub, if present
+}
+
+// SSHCertificate describes an OpenSSH user certificate stored next to a key
+type SSHCertificate struct {
+	KeyID         string   `json:"key_id"`
+	Serial        uint64   `json:"serial"`
+	Principals    []string `json:"principals"`
+	ValidAfter    string   `json:"valid_after,omitempty"`  // RFC3339, empty if always valid
+	ValidBefore   string   `json:"valid_before,omitempty"` // RFC3339, empty if it never expires
+	CAFingerprint string   `json:"ca_fingerprint"`
+	Status        string   `json:"status"` // valid, expiring, expired, not_yet_valid
+	ExpiresIn     int64    `json:"expires_in,omitempty"` // seconds until expiry
 }
 
 // SSHTunnel represents an SSH tunnel configuration and status
 type SSHTunnel struct {
-	ID       string `json:"id"`
-	Status   string `json:"status"` // running, stopped
-	PID      int    `json:"pid,omitempty"`
+	ID        string        `json:"id"`
+	Status    string        `json:"status"` // running, connecting, stopped
+	Host      string        `json:"host"`
+	Port      int           `json:"port"`     // SSH port (default 22)
+	User      string        `json:"user"`
+	AuthType  string        `json:"auth"`     // key, password
+	KeyFile   string        `json:"key,omitempty"`
+	Password  string        `json:"-"`        // held in memory only
+	Jumps     []SSHJumpHost `json:"jumps,omitempty"` // bastions, in connection order
+	Proxy     *SSHProxy     `json:"proxy,omitempty"` // upstream proxy for the first hop
+	FwdType   string        `json:"fwd"`      // L, R, D
+	LPort     int           `json:"lport"`    // local port
+	RHost     string        `json:"rhost"`    // remote/target host
+	RPort     int           `json:"rport"`    // remote/target port
+	Since     int64         `json:"since,omitempty"` // unix timestamp when started
+	LastError string        `json:"last_error,omitempty"`
+	Stats     *SSHTunnelStats `json:"stats,omitempty"` // live metrics, not persisted
+}
+
+// SSHTunnelStats holds live traffic and health metrics for a tunnel.
+// "In" is data received from the SSH side, "out" is data sent into the tunnel.
+type SSHTunnelStats struct {
+	BytesIn        uint64              `json:"bytes_in"`
+	BytesOut       uint64              `json:"bytes_out"`
+	ActiveConns    int                 `json:"active_conns"`
+	TotalConns     uint64              `json:"total_conns"`
+	Connections    []SSHTunnelConnStat `json:"connections"`
+	UptimeSeconds  int64               `json:"uptime_seconds"`
+	KeepaliveRTTMs float64             `json:"keepalive_rtt_ms"`
+	LastKeepalive  int64               `json:"last_keepalive,omitempty"` // unix timestamp of last reply
+	Reconnects     int64               `json:"reconnects"`
+}
+
+// SSHTunnelConnStat describes one active forwarded connection
+type SSHTunnelConnStat struct {
+	Peer     string `json:"peer"`  // address of the connecting client
+	Dest     string `json:"dest"`  // forwarding destination
+	Since    int64  `json:"since"` // unix timestamp when accepted
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// SSHTunnelSample is one point of a tunnel's metrics history
+type SSHTunnelSample struct {
+	Time           int64   `json:"time"` // unix timestamp
+	Status         string  `json:"status"`
+	BytesIn        uint64  `json:"bytes_in"`
+	BytesOut       uint64  `json:"bytes_out"`
+	RateIn         float64 `json:"rate_in"`  // bytes/s since previous sample
+	RateOut        float64 `json:"rate_out"` // bytes/s since previous sample
+	ActiveConns    int     `json:"active_conns"`
+	KeepaliveRTTMs float64 `json:"keepalive_rtt_ms"`
+}
+
+// SSHTunnelHistoryResult is the API response for a tunnel's metrics history
+type SSHTunnelHistoryResult struct {
+	ID       string            `json:"id"`
+	Interval int               `json:"interval_seconds"`
+	Samples  []SSHTunnelSample `json:"samples"`
+}
+
+// SSHJumpHost is an intermediate SSH server (bastion) on the way to the tunnel host
+type SSHJumpHost struct {
 	Host     string `json:"host"`
 	Port     int    `json:"port"`     // SSH port (default 22)
 	User     string `json:"user"`
 	AuthType string `json:"auth"`     // key, password
 	KeyFile  string `json:"key,omitempty"`
-	FwdType  string `json:"fwd"`      // L, R, D
-	LPort    int    `json:"lport"`    // local port
-	RHost    string `json:"rhost"`    // remote/target host
-	RPort    int    `json:"rport"`    // remote/target port
-	Since    int64  `json:"since,omitempty"` // unix timestamp when started
+	Password string `json:"password,omitempty"`
+}
+
+// SSHProxy is an upstream proxy used to reach the first SSH hop
+type SSHProxy struct {
+	Type     string `json:"type"` // http, socks5
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// PhoneHomeConfig configures the persistent reverse channel to a rendezvous server
+type PhoneHomeConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Host         string   `json:"host"`
+	Port         int      `json:"port"` // SSH port (default 22)
+	User         string   `json:"user"`
+	KeyFile      string   `json:"key"`                 // key auth only, the channel must survive restarts
+	HostKey      string   `json:"host_key,omitempty"`  // pinned SHA256 fingerprint, learned on first connect
+	RemoteBind   string   `json:"remote_bind"`         // listen address on the server (default 127.0.0.1)
+	WebPort      int      `json:"web_port"`            // server port forwarded to the web UI (0 = off)
+	SSHPort      int      `json:"ssh_port"`            // server port forwarded to the local sshd (0 = off)
+	LocalSSHPort int      `json:"local_ssh_port"`      // local sshd port (default 22)
+	Uplinks      []string `json:"uplinks,omitempty"`   // interfaces to try in order, empty = all
+}
+
+// PhoneHomeStatus reports the health of the phone-home channel
+type PhoneHomeStatus struct {
+	State       string            `json:"state"`            // disabled, connecting, connected
+	Uplink      string            `json:"uplink,omitempty"` // interface the channel is using
+	Since       int64             `json:"since,omitempty"`  // unix timestamp when connected
+	LastAttempt int64             `json:"last_attempt,omitempty"`
+	LastError   string            `json:"last_error,omitempty"`
+	Forwards    []string          `json:"forwards"`
+	Uplinks     []PhoneHomeUplink `json:"uplinks"`
+	Stats       *SSHTunnelStats   `json:"stats,omitempty"`
+}
+
+// PhoneHomeUplink is the connection history of one uplink interface
+type PhoneHomeUplink struct {
+	Name        string `json:"name"` // interface, or "default" for the routing table's choice
+	LastAttempt int64  `json:"last_attempt,omitempty"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// PhoneHomeResult is the API response for the phone-home service
+type PhoneHomeResult struct {
+	Config PhoneHomeConfig `json:"config"`
+	Status PhoneHomeStatus `json:"status"`
 }
 
 // SSHKeyListResult is the API response for listing keys
@@ -253,6 +401,8 @@ type SSHTunnelCreateRequest struct {
 	AuthType string `json:"auth"`     // key, password
 	KeyFile  string `json:"key,omitempty"`
 	Password string `json:"password,omitempty"`
+	Jumps    []SSHJumpHost `json:"jumps,omitempty"` // optional chain of jump hosts
+	Proxy    *SSHProxy     `json:"proxy,omitempty"` // optional HTTP CONNECT / SOCKS5 proxy
 	FwdType  string `json:"fwd"`      // L, R, D
 	LPort    int    `json:"lport"`    // local port
 	RHost    string `json:"rhost"`    // target host (for L/R)
@@ -264,7 +414,189 @@ type SSHTunnelIDRequest struct {
 	ID string `json:"id"`
 }
 
+// SSHKeyUnlockRequest is a request to unlock or lock a passphrase-protected key
+type SSHKeyUnlockRequest struct {
+	Name       string `json:"name"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
 // SSHKeyDeleteRequest is a request to delete a key
 type SSHKeyDeleteRequest struct {
 	Name string `json:"name"`
 }
+
+// --- Terminal types ---
+
+// TerminalSession describes an open web terminal session
+type TerminalSession struct {
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	AuthUser   string `json:"auth_user"`   // web UI user who opened it
+	Remote     string `json:"remote"`      // client address
+	Started    string `json:"started"`     // RFC3339
+	LastActive string `json:"last_active"` // last input from the client, RFC3339
+	Cols       int    `json:"cols"`
+	Rows       int    `json:"rows"`
+}
+
+// TerminalCloseRequest is a request to end a terminal session
+type TerminalCloseRequest struct {
+	ID string `json:"id"`
+}
+
+// TerminalRecording describes a recorded terminal session (asciicast v2)
+type TerminalRecording struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	User      string `json:"user"`      // login user of the shell
+	AuthUser  string `json:"auth_user"` // web UI user who opened it
+	Remote    string `json:"remote"`
+	Started   string `json:"started"`  // RFC3339
+	Duration  int64  `json:"duration"` // seconds
+	Size      int64  `json:"size"`     // bytes
+	Active    bool   `json:"active"`   // session still open
+}
+
+// LoginRequest is a web UI login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"` // TOTP or recovery code
+
+	// Certificate logs in with the connection's client certificate
+	// instead of a password
+	Certificate bool `json:"certificate,omitempty"`
+}
+
+// AuthInfo describes the caller's login
+type AuthInfo struct {
+	User         string `json:"user"`
+	Role         string `json:"role"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
+	Expires      string `json:"expires,omitempty"` // RFC3339, if idle
+	AuthDisabled bool   `json:"auth_disabled,omitempty"`
+	TOTPRequired bool   `json:"totp_required,omitempty"` // login needs a code
+	TOTPEnrol    bool   `json:"totp_enrol,omitempty"`    // must enrol before anything else
+}
+
+// AuthSession describes a logged-in browser session
+type AuthSession struct {
+	ID        string `json:"id"`
+	User      string `json:"user"`
+	Remote    string `json:"remote"`
+	UserAgent string `json:"user_agent"`
+	Created   string `json:"created"`   // RFC3339
+	LastSeen  string `json:"last_seen"` // RFC3339
+	Expires   string `json:"expires"`   // RFC3339, if idle
+	Current   bool   `json:"current"`   // the caller's session
+}
+
+// AuthRevokeRequest identifies a session to revoke
+type AuthRevokeRequest struct {
+	ID string `json:"id"`
+}
+
+// PasswordChangeRequest changes the caller's own password
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// TOTPRequest confirms the caller's identity for two-factor changes
+type TOTPRequest struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// TOTPStatus describes the caller's two-factor enrolment
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"` // by the user's role
+	RecoveryRemaining int  `json:"recovery_remaining"`
+}
+
+// TOTPRecoveryCodes are shown once after enrolment or regeneration
+type TOTPRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// APIToken describes an API token; the secret is only shown on creation
+type APIToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	User       string   `json:"user"`
+	Scopes     []string `json:"scopes"`
+	Created    string   `json:"created"`             // RFC3339
+	Expires    string   `json:"expires,omitempty"`   // RFC3339, empty never expires
+	LastUsed   string   `json:"last_used,omitempty"` // RFC3339
+	LastRemote string   `json:"last_remote,omitempty"`
+}
+
+// APITokenRequest creates an API token
+type APITokenRequest struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	ExpiresDays int      `json:"expires_days"` // 0 never expires
+}
+
+// APITokenCreated returns a new token with its secret
+type APITokenCreated struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// WebUser is a web UI account
+type WebUser struct {
+	Name string `json:"name"`
+	Role string `json:"role"` // viewer, operator or admin
+	Env  bool   `json:"env"`  // set by environment, read-only
+	TOTP bool   `json:"totp"` // two-factor enrolled
+}
+
+// WebUserRequest creates or updates a web UI account. An empty password
+// leaves it unchanged on update.
+type WebUserRequest struct {
+	Name      string `json:"name"`
+	Role      string `json:"role,omitempty"`
+	Password  string `json:"password,omitempty"`
+	ResetTOTP bool   `json:"reset_totp,omitempty"`
+}
+
+// TLSInfo describes the web UI's HTTPS certificate
+type TLSInfo struct {
+	Enabled       bool     `json:"enabled"`
+	Source        string   `json:"source,omitempty"` // generated or uploaded
+	Subject       string   `json:"subject,omitempty"`
+	Issuer        string   `json:"issuer,omitempty"`
+	Names         []string `json:"names,omitempty"`
+	NotBefore     string   `json:"not_before,omitempty"`
+	NotAfter      string   `json:"not_after,omitempty"`
+	Fingerprint   string   `json:"fingerprint,omitempty"`    // SHA-256
+	CAFingerprint string   `json:"ca_fingerprint,omitempty"` // device CA, when generated
+	ClientAuth    string   `json:"client_auth,omitempty"`    // off, optional, required or required+password
+}
+
+// ClientCert is a client certificate issued by the device CA
+type ClientCert struct {
+	Serial      string `json:"serial"` // hex
+	User        string `json:"user"`
+	Subject     string `json:"subject"`
+	Fingerprint string `json:"fingerprint"`
+	IssuedBy    string `json:"issued_by"`
+	Created     string `json:"created"`
+	Expires     string `json:"expires"`
+	Revoked     string `json:"revoked,omitempty"`
+}
+
+// ClientCertRequest issues a client certificate as a PKCS#12 bundle
+type ClientCertRequest struct {
+	User     string `json:"user"`
+	Days     int    `json:"days"`
+	Password string `json:"password"`         // protects the bundle
+	Legacy   bool   `json:"legacy,omitempty"` // 3DES for older systems
+}
+
+// ClientCertRevokeRequest revokes a client certificate
+type ClientCertRevokeRequest struct {
+	Serial string `json:"serial"`
+}